@@ -0,0 +1,214 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Database  Database  `yaml:"database"`
+	Server    Server    `yaml:"server"`
+	Auth      Auth      `yaml:"auth"`
+	Money     Money     `yaml:"money"`
+	Inventory Inventory `yaml:"inventory"`
+}
+
+type Database struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	SSLMode  string `yaml:"sslmode"`
+}
+
+type Server struct {
+	Addr           string   `yaml:"addr"`
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+}
+
+type Auth struct {
+	JWTSecret  string        `yaml:"jwtSecret"`
+	TokenTTL   time.Duration `yaml:"tokenTTL"`
+	RefreshTTL time.Duration `yaml:"refreshTTL"`
+	BcryptCost int           `yaml:"bcryptCost"`
+	// PINBcryptCost is lower than BcryptCost because PIN login is checked
+	// on every handover at the till; guessing is held off by the lockout
+	PINBcryptCost int `yaml:"pinBcryptCost"`
+	// PINMaxAttempts wrong PINs in a row lock PIN login for PINLockout
+	PINMaxAttempts int           `yaml:"pinMaxAttempts"`
+	PINLockout     time.Duration `yaml:"pinLockout"`
+}
+
+type Money struct {
+	Currency string `yaml:"currency"`
+}
+
+type Inventory struct {
+	// Costing values stock leaving the warehouse: "average" at the moving
+	// average cost, "fifo" at the cost of the oldest lots
+	Costing string `yaml:"costing"`
+}
+
+// Default returns the configuration used when nothing else is set
+func Default() Config {
+	return Config{
+		Database: Database{
+			Host:    "localhost",
+			Port:    5432,
+			SSLMode: "disable",
+		},
+		Server: Server{
+			Addr:           ":8090",
+			AllowedOrigins: []string{"https://localhost:5173"},
+		},
+		Auth: Auth{
+			TokenTTL:   15 * time.Minute,
+			RefreshTTL: 30 * 24 * time.Hour,
+			BcryptCost: 14,
+
+			PINBcryptCost:  10,
+			PINMaxAttempts: 5,
+			PINLockout:     15 * time.Minute,
+		},
+		Money: Money{
+			Currency: "RUB",
+		},
+		Inventory: Inventory{
+			Costing: "average",
+		},
+	}
+}
+
+// Load builds the configuration from defaults, the optional file named by
+// CONFIG_FILE and environment variables, in that order, and validates it
+func Load() (Config, error) {
+	cfg, err := read()
+	if err != nil {
+		return cfg, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// LoadDatabase reads the configuration the same way as Load but validates
+// only the database settings, which is all the migrate subcommand needs
+func LoadDatabase() (Database, error) {
+	cfg, err := read()
+	if err != nil {
+		return cfg.Database, err
+	}
+	if err := cfg.Database.Validate(); err != nil {
+		return cfg.Database, err
+	}
+	return cfg.Database, nil
+}
+
+func read() (Config, error) {
+	cfg := Default()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	if err := loadEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("config: parse %s: %w", path, err)
+	}
+	return nil
+}
+
+func loadEnv(cfg *Config) error {
+	setString("DB_HOST", &cfg.Database.Host)
+	setString("DB_USER", &cfg.Database.User)
+	setString("DB_PASSWORD", &cfg.Database.Password)
+	setString("DB_NAME", &cfg.Database.Name)
+	setString("DB_SSLMODE", &cfg.Database.SSLMode)
+	setString("LISTEN_ADDR", &cfg.Server.Addr)
+	setString("JWT_SECRET", &cfg.Auth.JWTSecret)
+	setString("CURRENCY", &cfg.Money.Currency)
+	setString("COSTING_METHOD", &cfg.Inventory.Costing)
+
+	if err := setInt("DB_PORT", &cfg.Database.Port); err != nil {
+		return err
+	}
+	if err := setInt("BCRYPT_COST", &cfg.Auth.BcryptCost); err != nil {
+		return err
+	}
+	if err := setDuration("TOKEN_TTL", &cfg.Auth.TokenTTL); err != nil {
+		return err
+	}
+	if err := setDuration("REFRESH_TOKEN_TTL", &cfg.Auth.RefreshTTL); err != nil {
+		return err
+	}
+	if err := setInt("PIN_BCRYPT_COST", &cfg.Auth.PINBcryptCost); err != nil {
+		return err
+	}
+	if err := setInt("PIN_MAX_ATTEMPTS", &cfg.Auth.PINMaxAttempts); err != nil {
+		return err
+	}
+	if err := setDuration("PIN_LOCKOUT", &cfg.Auth.PINLockout); err != nil {
+		return err
+	}
+
+	if v, ok := os.LookupEnv("ALLOWED_ORIGINS"); ok {
+		cfg.Server.AllowedOrigins = nil
+		for _, origin := range strings.Split(v, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				cfg.Server.AllowedOrigins = append(cfg.Server.AllowedOrigins, origin)
+			}
+		}
+	}
+	return nil
+}
+
+func setString(key string, dst *string) {
+	if v, ok := os.LookupEnv(key); ok {
+		*dst = v
+	}
+}
+
+func setInt(key string, dst *int) error {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", key, err)
+	}
+	*dst = n
+	return nil
+}
+
+func setDuration(key string, dst *time.Duration) error {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", key, err)
+	}
+	*dst = d
+	return nil
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Validate reports every invalid setting at once
+func (c Config) Validate() error {
+	problems := c.Database.problems()
+
+	if c.Server.Addr == "" {
+		problems = append(problems, "listen address is required (LISTEN_ADDR)")
+	}
+	if len(c.Server.AllowedOrigins) == 0 {
+		problems = append(problems, "at least one allowed origin is required (ALLOWED_ORIGINS)")
+	}
+	for _, origin := range c.Server.AllowedOrigins {
+		if origin == "*" {
+			// Responses carry credentials, so every origin has to be listed
+			problems = append(problems, "allowed origins must be listed explicitly, \"*\" is not accepted (ALLOWED_ORIGINS)")
+			break
+		}
+	}
+	if len(c.Auth.JWTSecret) < 32 {
+		problems = append(problems, "JWT secret must be at least 32 characters (JWT_SECRET)")
+	}
+	if c.Auth.TokenTTL <= 0 {
+		problems = append(problems, "token TTL must be positive (TOKEN_TTL)")
+	}
+	if c.Auth.RefreshTTL < c.Auth.TokenTTL {
+		problems = append(problems, "refresh token TTL must not be shorter than the token TTL (REFRESH_TOKEN_TTL)")
+	}
+	if c.Auth.BcryptCost < bcrypt.MinCost || c.Auth.BcryptCost > bcrypt.MaxCost {
+		problems = append(problems, fmt.Sprintf("bcrypt cost must be between %d and %d (BCRYPT_COST)", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	if c.Auth.PINBcryptCost < bcrypt.MinCost || c.Auth.PINBcryptCost > bcrypt.MaxCost {
+		problems = append(problems, fmt.Sprintf("PIN bcrypt cost must be between %d and %d (PIN_BCRYPT_COST)", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	if c.Auth.PINMaxAttempts < 1 {
+		problems = append(problems, "PIN max attempts must be at least 1 (PIN_MAX_ATTEMPTS)")
+	}
+	if c.Auth.PINLockout <= 0 {
+		problems = append(problems, "PIN lockout must be positive (PIN_LOCKOUT)")
+	}
+	if !isCurrencyCode(c.Money.Currency) {
+		problems = append(problems, "currency must be an ISO 4217 code such as RUB (CURRENCY)")
+	}
+	if c.Inventory.Costing != "average" && c.Inventory.Costing != "fifo" {
+		problems = append(problems, "costing method must be average or fifo (COSTING_METHOD)")
+	}
+
+	return joinProblems(problems)
+}
+
+// Validate reports every invalid database setting at once
+func (d Database) Validate() error {
+	return joinProblems(d.problems())
+}
+
+func (d Database) problems() []string {
+	var problems []string
+
+	if d.Host == "" {
+		problems = append(problems, "database host is required (DB_HOST)")
+	}
+	if d.Port <= 0 || d.Port > 65535 {
+		problems = append(problems, "database port must be between 1 and 65535 (DB_PORT)")
+	}
+	if d.User == "" {
+		problems = append(problems, "database user is required (DB_USER)")
+	}
+	if d.Name == "" {
+		problems = append(problems, "database name is required (DB_NAME)")
+	}
+	return problems
+}
+
+func joinProblems(problems []string) error {
+	if len(problems) > 0 {
+		return errors.New("config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// DSN returns the lib/pq connection string
+func (d Database) DSN() string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		quote(d.Host), d.Port, quote(d.User), quote(d.Password), quote(d.Name), quote(d.SSLMode))
+}
+
+func isCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func quote(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
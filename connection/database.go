@@ -6,13 +6,12 @@ import (
 
 	_ "github.com/lib/pq"
 
-	"randevu-shawarma-server/supply"
-	"randevu-shawarma-server/users"
+	"randevu-shawarma-server/config"
 )
 
-func initDB() *sql.DB {
-	connStr := "user=kostia password=foDfyf-vufvim-muvwy9 dbname=randevu_database host=localhost port=5432 sslmode=disable"
-	db, err := sql.Open("postgres", connStr)
+// OpenDatabase opens the Postgres connection described by cfg and checks it is reachable
+func OpenDatabase(cfg config.Database) *sql.DB {
+	db, err := sql.Open("postgres", cfg.DSN())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -22,11 +21,3 @@ func initDB() *sql.DB {
 	}
 	return db
 }
-
-func СonnectToDatabase() {
-	db := initDB()
-	defer db.Close()
-
-	users.SetDatabase(db)
-	supply.SetDatabase(db)
-}
@@ -0,0 +1,193 @@
+package dishes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"randevu-shawarma-server/money"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// costing rolls ingredient costs up through preparations. A preparation
+// costs what its recipe costs divided by its yield, and its recipe may
+// use other preparations.
+type costing struct {
+	products     map[int]ProductCost
+	preparations map[int]PreparationRecipe // by preparation id
+	byProduct    map[int]PreparationRecipe // by the product it is kept under
+	memo         map[int]unitCost
+	visiting     map[int]bool
+}
+
+// unitCost is the cost of one unit of a product and the products whose
+// cost was unknown on the way
+type unitCost struct {
+	amount  money.UnitCost
+	missing []int
+}
+
+func newCosting(in CostInputs) *costing {
+	c := &costing{
+		products:     in.Products,
+		preparations: map[int]PreparationRecipe{},
+		byProduct:    map[int]PreparationRecipe{},
+		memo:         map[int]unitCost{},
+		visiting:     map[int]bool{},
+	}
+	for _, prep := range in.Preparations {
+		c.preparations[prep.ID] = prep
+		c.byProduct[prep.ProductID] = prep
+	}
+	return c
+}
+
+// product returns the cost of one unit of the product. A product that is
+// a preparation is costed from its recipe rather than its stock.
+func (c *costing) product(productID int) unitCost {
+	if cost, ok := c.memo[productID]; ok {
+		return cost
+	}
+
+	var cost unitCost
+	prep, isPreparation := c.byProduct[productID]
+	switch {
+	case isPreparation && !c.visiting[productID]:
+		c.visiting[productID] = true
+		cost = c.preparation(prep)
+		delete(c.visiting, productID)
+	case c.products[productID].AverageCost != nil && !c.products[productID].AverageCost.IsZero():
+		cost.amount = *c.products[productID].AverageCost
+	default:
+		// Never received, or a preparation whose recipe leads back to it
+		cost = unitCost{amount: money.ZeroCost(), missing: []int{productID}}
+	}
+	c.memo[productID] = cost
+	return cost
+}
+
+func (c *costing) preparation(prep PreparationRecipe) unitCost {
+	total := unitCost{amount: money.ZeroCost()}
+	for _, line := range prep.Recipe {
+		cost := c.product(line.ProductID)
+		total.amount = total.amount.Add(cost.amount.Scale(line.Quantity))
+		total.missing = append(total.missing, cost.missing...)
+	}
+	if prep.YieldPercent > 0 {
+		total.amount = total.amount.Scale(100 / prep.YieldPercent)
+	}
+	return total
+}
+
+// dish costs one unit of the dish, with its lines when withLines is set
+func (c *costing) dish(dish DishItem, withLines bool) DishCost {
+	result := DishCost{
+		DishID:          dish.ID,
+		Name:            dish.Name,
+		Price:           dish.Price,
+		FoodCost:        money.Zero(),
+		MissingProducts: []CostProduct{},
+	}
+	missing := map[int]bool{}
+	add := func(line CostLine, cost unitCost) {
+		line.UnitCost = cost.amount
+		line.Cost = cost.amount.Mul(line.Quantity, money.HalfEven)
+		line.MissingCost = len(cost.missing) > 0
+		result.FoodCost = result.FoodCost.Add(line.Cost)
+		for _, id := range cost.missing {
+			missing[id] = true
+		}
+		if withLines {
+			result.Lines = append(result.Lines, line)
+		}
+	}
+
+	for _, line := range dish.Recipe {
+		add(CostLine{ProductID: line.ProductID, Name: c.products[line.ProductID].Name, Quantity: line.Quantity}, c.product(line.ProductID))
+	}
+	for _, link := range dish.Preparations {
+		prep, ok := c.preparations[link.PreparationID]
+		if !ok {
+			continue
+		}
+		add(CostLine{PreparationID: prep.ID, Name: prep.Name, Quantity: link.Quantity}, c.product(prep.ProductID))
+	}
+
+	result.GrossMargin = dish.Price.Sub(result.FoodCost)
+	if !dish.Price.IsZero() {
+		percent := float64(result.FoodCost.Minor()) / float64(dish.Price.Minor()) * 100
+		percent = math.Round(percent*100) / 100
+		result.FoodCostPercent = &percent
+	}
+	for id := range missing {
+		result.MissingProducts = append(result.MissingProducts, CostProduct{ProductID: id, Name: c.products[id].Name})
+	}
+	sort.Slice(result.MissingProducts, func(i, j int) bool {
+		return result.MissingProducts[i].ProductID < result.MissingProducts[j].ProductID
+	})
+	// A dish without a recipe costs nothing only on paper
+	result.MissingCosts = len(result.MissingProducts) > 0 || len(dish.Recipe)+len(dish.Preparations) == 0
+	return result
+}
+
+// dishCosts costs the dishes of the menu; a zero id costs every active
+// dish and a non-zero one just that dish, with its lines
+func (s *Service) dishCosts(ctx context.Context, dishID int) ([]DishCost, error) {
+	in, err := s.store.CostInputs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newCosting(in)
+	costs := []DishCost{}
+	for _, dish := range in.Dishes {
+		switch {
+		case dishID == 0 && dish.Active:
+			costs = append(costs, c.dish(dish, false))
+		case dishID != 0 && dish.ID == dishID:
+			return []DishCost{c.dish(dish, true)}, nil
+		}
+	}
+	if dishID != 0 {
+		return nil, ErrNotFound
+	}
+	return costs, nil
+}
+
+// GetDishCosts returns food cost and margin of every dish on sale
+func (s *Service) GetDishCosts(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	costs, err := s.dishCosts(r.Context(), 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(costs)
+}
+
+// GetDishCost returns the cost of one dish line by line
+func (s *Service) GetDishCost(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid dish id", http.StatusBadRequest)
+		return
+	}
+
+	costs, err := s.dishCosts(r.Context(), id)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(costs[0])
+}
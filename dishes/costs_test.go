@@ -0,0 +1,64 @@
+package dishes
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"randevu-shawarma-server/money"
+)
+
+func TestDishCostsRollUpPreparations(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	store.SetProductCost(1, "chicken", money.MustParseUnitCost("11.00"))
+	store.AddProduct(2)
+	store.SetProductCost(3, "garlic", money.MustParseUnitCost("2.00"))
+	store.SetProductCost(4, "yogurt", money.MustParseUnitCost("0.50"))
+	// 0.95 of ingredients at 80% yield is 1.1875 a unit
+	store.SetPreparationRecipe(PreparationRecipe{
+		ID: 1, Name: "garlic sauce", ProductID: 10, YieldPercent: 80,
+		Recipe: []RecipeLine{{ProductID: 3, Quantity: 0.1}, {ProductID: 4, Quantity: 1.5}},
+	})
+	store.PutDish(DishItem{
+		ID: 1, Name: "shawarma", Price: money.MustParse("5.00"),
+		Recipe:       []RecipeLine{{ProductID: 1, Quantity: 0.2}, {ProductID: 2, Quantity: 1}},
+		Preparations: []DishPreparation{{PreparationID: 1, Quantity: 0.5}},
+	}, true)
+	store.PutDish(DishItem{ID: 2, Name: "old plate", Price: money.MustParse("4.00")}, false)
+	s := NewService(store, nil, nil)
+
+	costs, err := s.dishCosts(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(costs) != 1 {
+		t.Fatalf("costed %d dishes, want only the active one", len(costs))
+	}
+	cost := costs[0]
+	// 2.20 chicken, no cost for the lavash and 0.59 of sauce
+	if cost.FoodCost.String() != "2.79" || cost.GrossMargin.String() != "2.21" {
+		t.Errorf("food cost %s and margin %s, want 2.79 and 2.21", cost.FoodCost, cost.GrossMargin)
+	}
+	if cost.FoodCostPercent == nil || *cost.FoodCostPercent != 55.8 {
+		t.Errorf("food cost percent %v, want 55.8", cost.FoodCostPercent)
+	}
+	if !cost.MissingCosts || len(cost.MissingProducts) != 1 || cost.MissingProducts[0].ProductID != 2 {
+		t.Errorf("missing %v, want the lavash", cost.MissingProducts)
+	}
+
+	costs, err = s.dishCosts(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := costs[0].Lines
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3", len(lines))
+	}
+	if sauce := lines[2]; sauce.PreparationID != 1 || sauce.UnitCost.String() != "1.1875" || sauce.Cost.String() != "0.59" {
+		t.Errorf("sauce line %+v, want preparation 1 at 1.1875 costing 0.59", sauce)
+	}
+	if _, err := s.dishCosts(ctx, 99); !errors.Is(err, ErrNotFound) {
+		t.Errorf("costing an unknown dish: got %v, want %v", err, ErrNotFound)
+	}
+}
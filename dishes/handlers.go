@@ -1,51 +1,423 @@
 package dishes
 
 import (
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"randevu-shawarma-server/money"
 	"randevu-shawarma-server/users"
 
 	"github.com/julienschmidt/httprouter"
 )
 
-var db *sql.DB
+type Service struct {
+	store Store
+	auth  *users.Service
+	units Units
+}
 
-// SetDatabase sets the database connection
-func SetDatabase(database *sql.DB) {
-	db = database
+func NewService(store Store, auth *users.Service, units Units) *Service {
+	return &Service{store: store, auth: auth, units: units}
 }
 
 // RegisterRoutes registers all dishes routes
-func RegisterRoutes(router *httprouter.Router) {
-	router.GET("/dishes", users.Authenticate(GetDishes))
+func (s *Service) RegisterRoutes(router *httprouter.Router) {
+	router.GET("/dishes", s.auth.Authorize(users.PermDishesRead)(s.GetDishes))
+	router.POST("/dishes", s.auth.Authorize(users.PermMenuManage)(s.SaveDish))
+	router.GET("/dishes/:id", s.getDishOrCosts())
+	router.GET("/dishes/:id/cost", s.auth.Authorize(users.PermCostsRead)(s.GetDishCost))
+	router.PUT("/dishes/:id", s.auth.Authorize(users.PermMenuManage)(s.SaveDish))
+	router.DELETE("/dishes/:id", s.auth.Authorize(users.PermMenuManage)(s.DeactivateDish))
+	router.PUT("/dishes/:id/modifier-groups", s.auth.Authorize(users.PermMenuManage)(s.SetDishModifierGroups))
+	router.GET("/modifier-groups", s.auth.Authorize(users.PermDishesRead)(s.GetModifierGroups))
+	router.POST("/modifier-groups", s.auth.Authorize(users.PermMenuManage)(s.SaveModifierGroup))
+	router.PUT("/modifier-groups/:id", s.auth.Authorize(users.PermMenuManage)(s.SaveModifierGroup))
+	router.GET("/menu-categories", s.auth.Authorize(users.PermDishesRead)(s.GetCategories))
+	router.POST("/menu-categories", s.auth.Authorize(users.PermMenuManage)(s.SaveCategory))
+	router.PUT("/menu-categories/:id", s.auth.Authorize(users.PermMenuManage)(s.SaveCategory))
+	router.DELETE("/menu-categories/:id", s.auth.Authorize(users.PermMenuManage)(s.DeleteCategory))
+}
+
+// getDishOrCosts serves GET /dishes/costs next to GET /dishes/:id, which
+// httprouter cannot register as a route of its own; no dish has the id
+// "costs", so it never hides a dish
+func (s *Service) getDishOrCosts() httprouter.Handle {
+	dish := s.auth.Authorize(users.PermDishesRead)(s.GetDish)
+	costs := s.auth.Authorize(users.PermCostsRead)(s.GetDishCosts)
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if ps.ByName("id") == "costs" {
+			costs(w, r, ps)
+			return
+		}
+		dish(w, r, ps)
+	}
 }
 
-func GetDishes(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	rows, err := db.Query("SELECT id, name, price FROM public.\"Dishes\" WHERE is_active = true")
+// GetDishes lists the menu. ?includeInactive=true also lists dishes and
+// modifiers taken off sale, for those who manage the menu.
+func (s *Service) GetDishes(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	includeInactive := r.URL.Query().Get("includeInactive") == "true"
+	if includeInactive {
+		claims, ok := users.ClaimsFromContext(r.Context())
+		if !ok || !claims.Can(users.PermMenuManage) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	dishes, err := s.store.ListDishes(r.Context(), includeInactive)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
+	if dishes == nil {
+		dishes = []DishItem{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dishes)
+}
+
+// GetDish returns one dish with its recipe and linked preparations
+func (s *Service) GetDish(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid dish id", http.StatusBadRequest)
+		return
+	}
 
-	var dishes []DishItem
-	for rows.Next() {
-		var item DishItem
-		err := rows.Scan(&item.ID, &item.Name, &item.Price)
+	dish, err := s.store.Dish(r.Context(), id)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dish)
+}
+
+// dishRequest is the body of POST and PUT /dishes. A missing isActive
+// means on sale for a new dish and unchanged for an existing one.
+type dishRequest struct {
+	Name         string            `json:"name"`
+	Price        *money.Amount     `json:"price"`
+	CategoryID   *int              `json:"categoryId"`
+	SortOrder    int               `json:"sortOrder"`
+	Active       *bool             `json:"isActive"`
+	Recipe       []RecipeLine      `json:"recipe"`
+	Preparations []DishPreparation `json:"preparations"`
+}
+
+// SaveDish creates a dish (POST) or replaces one (PUT) together with its
+// recipe and linked preparations
+func (s *Service) SaveDish(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req dishRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dish := DishItem{
+		Name:         strings.TrimSpace(req.Name),
+		CategoryID:   req.CategoryID,
+		SortOrder:    req.SortOrder,
+		Active:       true,
+		Recipe:       req.Recipe,
+		Preparations: req.Preparations,
+	}
+	if idParam := ps.ByName("id"); idParam != "" {
+		dish.ID, err = strconv.Atoi(idParam)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Invalid dish id", http.StatusBadRequest)
 			return
 		}
-		dishes = append(dishes, item)
+		if req.Active == nil {
+			existing, err := s.store.Dish(r.Context(), dish.ID)
+			if errors.Is(err, ErrNotFound) {
+				http.NotFound(w, r)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			dish.Active = existing.Active
+		}
+	}
+	if req.Active != nil {
+		dish.Active = *req.Active
+	}
+	if req.Price != nil {
+		dish.Price = *req.Price
+	}
+	if msg := normalizeDish(&dish, req.Price != nil); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
 	}
 
-	if err := rows.Err(); err != nil {
+	created := dish.ID == 0
+	err = ConvertRecipe(r.Context(), s.units, dish.Recipe)
+	if err == nil {
+		err = s.store.SaveDish(r.Context(), &dish)
+	}
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, ErrUnknownProduct):
+		http.Error(w, "Recipe refers to an unknown product", http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrUnknownPreparation):
+		http.Error(w, "Unknown preparation", http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrUnknownCategory):
+		http.Error(w, "Unknown category", http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrUnknownUnit):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	saved, err := s.store.Dish(r.Context(), dish.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(dishes)
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(saved)
+}
+
+// normalizeDish checks a dish from a request and returns what is wrong
+// with it, if anything. A preparation without a quantity uses one portion.
+func normalizeDish(dish *DishItem, hasPrice bool) string {
+	if dish.Name == "" {
+		return "Name is required"
+	}
+	if !hasPrice {
+		return "Price is required"
+	}
+	if dish.Price.IsNegative() {
+		return "Price must not be negative"
+	}
+
+	products := map[int]bool{}
+	for _, line := range dish.Recipe {
+		if line.Quantity <= 0 {
+			return "Recipe quantities must be positive"
+		}
+		if products[line.ProductID] {
+			return "Recipe lists a product twice"
+		}
+		products[line.ProductID] = true
+	}
+
+	preparations := map[int]bool{}
+	for i := range dish.Preparations {
+		prep := &dish.Preparations[i]
+		if prep.Quantity == 0 {
+			prep.Quantity = 1
+		}
+		if prep.Quantity < 0 {
+			return "Preparation quantities must be positive"
+		}
+		if preparations[prep.PreparationID] {
+			return "Preparation listed twice"
+		}
+		preparations[prep.PreparationID] = true
+	}
+	return ""
+}
+
+// DeactivateDish takes a dish off sale. It stays on past orders and can be
+// put back with PUT /dishes/:id and isActive.
+func (s *Service) DeactivateDish(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid dish id", http.StatusBadRequest)
+		return
+	}
+
+	err = s.store.SetDishActive(r.Context(), id, false)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetModifierGroups lists every modifier group, inactive modifiers included
+func (s *Service) GetModifierGroups(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	groups, err := s.store.ModifierGroups(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// SaveModifierGroup creates a group (POST) or replaces one (PUT) together
+// with its modifiers. Modifiers left out of a PUT are deactivated.
+func (s *Service) SaveModifierGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var group ModifierGroup
+	err := json.NewDecoder(r.Body).Decode(&group)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	group.ID = 0
+	if idParam := ps.ByName("id"); idParam != "" {
+		group.ID, err = strconv.Atoi(idParam)
+		if err != nil {
+			http.Error(w, "Invalid modifier group id", http.StatusBadRequest)
+			return
+		}
+	}
+	if msg := normalizeGroup(&group); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	created := group.ID == 0
+	for i := range group.Modifiers {
+		if err = ConvertRecipe(r.Context(), s.units, group.Modifiers[i].Recipe); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = s.store.SaveModifierGroup(r.Context(), &group)
+	}
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, ErrUnknownProduct):
+		http.Error(w, "Recipe refers to an unknown product", http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrUnknownUnit):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(group)
+}
+
+// normalizeGroup checks a modifier group from a request and returns what
+// is wrong with it, if anything. Required with no minimum means one.
+func normalizeGroup(group *ModifierGroup) string {
+	group.Name = strings.TrimSpace(group.Name)
+	if group.Name == "" {
+		return "Name is required"
+	}
+	if group.Required && group.MinSelect == 0 {
+		group.MinSelect = 1
+	}
+	group.Required = group.MinSelect > 0
+	if group.MinSelect < 0 {
+		return "minSelect must not be negative"
+	}
+	if group.MaxSelect != nil && (*group.MaxSelect < 1 || *group.MaxSelect < group.MinSelect) {
+		return "maxSelect must be at least 1 and at least minSelect"
+	}
+	if len(group.Modifiers) == 0 {
+		return "At least one modifier is required"
+	}
+
+	seen := map[int]bool{}
+	for i := range group.Modifiers {
+		m := &group.Modifiers[i]
+		m.Name = strings.TrimSpace(m.Name)
+		if m.Name == "" {
+			return "Every modifier needs a name"
+		}
+		if m.ID != 0 {
+			if seen[m.ID] {
+				return "Modifier listed twice"
+			}
+			seen[m.ID] = true
+		}
+		// Leaving a modifier out is how it is taken off sale
+		m.Active = true
+
+		products := map[int]bool{}
+		for _, line := range m.Recipe {
+			if line.Quantity == 0 {
+				return "Recipe quantities must not be zero"
+			}
+			if products[line.ProductID] {
+				return "Recipe lists a product twice"
+			}
+			products[line.ProductID] = true
+		}
+		if m.Recipe == nil {
+			m.Recipe = []RecipeLine{}
+		}
+	}
+	return ""
+}
+
+func (s *Service) SetDishModifierGroups(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	dishID, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid dish id", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		GroupIDs []int `json:"groupIds"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	seen := map[int]bool{}
+	for _, id := range body.GroupIDs {
+		if seen[id] {
+			http.Error(w, "Modifier group listed twice", http.StatusBadRequest)
+			return
+		}
+		seen[id] = true
+	}
+
+	err = s.store.SetDishModifierGroups(r.Context(), dishID, body.GroupIDs)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	dishes, err := s.store.DishesByID(r.Context(), []int{dishID})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dishes[dishID])
 }
@@ -0,0 +1,410 @@
+package dishes
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"randevu-shawarma-server/money"
+)
+
+type MemoryStore struct {
+	mu             sync.Mutex
+	dishes         map[int]DishItem
+	products       map[int]bool
+	preparations   map[int]string
+	prepRecipes    map[int]PreparationRecipe
+	costs          map[int]ProductCost
+	categories     map[int]Category
+	groups         map[int]ModifierGroup
+	dishGroups     map[int][]int
+	nextDishID     int
+	nextCategoryID int
+	nextGroupID    int
+	nextModID      int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		dishes:         map[int]DishItem{},
+		products:       map[int]bool{},
+		preparations:   map[int]string{},
+		prepRecipes:    map[int]PreparationRecipe{},
+		costs:          map[int]ProductCost{},
+		categories:     map[int]Category{},
+		groups:         map[int]ModifierGroup{},
+		dishGroups:     map[int][]int{},
+		nextDishID:     1,
+		nextCategoryID: 1,
+		nextGroupID:    1,
+		nextModID:      1,
+	}
+}
+
+// PutDish adds or replaces a dish
+func (m *MemoryStore) PutDish(item DishItem, active bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item.Active = active
+	item.ModifierGroups = nil
+	m.dishes[item.ID] = item
+	if item.ID >= m.nextDishID {
+		m.nextDishID = item.ID + 1
+	}
+}
+
+// AddPreparation registers a preparation dishes may use
+func (m *MemoryStore) AddPreparation(id int, name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.preparations[id] = name
+}
+
+// SetPreparationRecipe registers a preparation with the recipe costing
+// rolls up through
+func (m *MemoryStore) SetPreparationRecipe(prep PreparationRecipe) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.preparations[prep.ID] = prep.Name
+	m.prepRecipes[prep.ID] = prep
+}
+
+// SetProductCost registers a product with its warehouse average cost
+func (m *MemoryStore) SetProductCost(id int, name string, cost money.UnitCost) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.products[id] = true
+	m.costs[id] = ProductCost{Name: name, AverageCost: &cost}
+}
+
+// AddProduct registers a product recipes may use
+func (m *MemoryStore) AddProduct(id int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.products[id] = true
+}
+
+// UsesProduct reports whether a dish, modifier or preparation recipe
+// takes the product, for warehouse.MemoryStore.SetRecipeLookup
+func (m *MemoryStore) UsesProduct(productID int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	uses := func(recipe []RecipeLine) bool {
+		for _, line := range recipe {
+			if line.ProductID == productID {
+				return true
+			}
+		}
+		return false
+	}
+	for _, dish := range m.dishes {
+		if uses(dish.Recipe) {
+			return true
+		}
+	}
+	for _, group := range m.groups {
+		for _, mod := range group.Modifiers {
+			if uses(mod.Recipe) {
+				return true
+			}
+		}
+	}
+	for _, prep := range m.prepRecipes {
+		if uses(prep.Recipe) {
+			return true
+		}
+	}
+	return false
+}
+
+// withGroups returns the dish with its modifier groups attached
+func (m *MemoryStore) withGroups(dish DishItem, activeOnly bool) DishItem {
+	dish.Recipe = nil
+	dish.Preparations = nil
+	dish.ModifierGroups = []ModifierGroup{}
+	for _, groupID := range m.dishGroups[dish.ID] {
+		group := m.groups[groupID]
+		if activeOnly {
+			var active []Modifier
+			for _, mod := range group.Modifiers {
+				if mod.Active {
+					active = append(active, mod)
+				}
+			}
+			group.Modifiers = active
+		}
+		dish.ModifierGroups = append(dish.ModifierGroups, group)
+	}
+	return dish
+}
+
+func (m *MemoryStore) ListDishes(ctx context.Context, includeInactive bool) ([]DishItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var dishes []DishItem
+	for _, d := range m.dishes {
+		if d.Active || includeInactive {
+			dishes = append(dishes, m.withGroups(d, !includeInactive))
+		}
+	}
+	// Same order as the SQL: category order with uncategorised dishes last
+	key := func(d DishItem) (bool, int, int) {
+		if d.CategoryID == nil {
+			return true, 0, 0
+		}
+		c := m.categories[*d.CategoryID]
+		return false, c.SortOrder, c.ID
+	}
+	sort.Slice(dishes, func(i, j int) bool {
+		ni, si, ci := key(dishes[i])
+		nj, sj, cj := key(dishes[j])
+		switch {
+		case ni != nj:
+			return nj
+		case si != sj:
+			return si < sj
+		case ci != cj:
+			return ci < cj
+		case dishes[i].SortOrder != dishes[j].SortOrder:
+			return dishes[i].SortOrder < dishes[j].SortOrder
+		}
+		return dishes[i].ID < dishes[j].ID
+	})
+	return dishes, nil
+}
+
+func (m *MemoryStore) Dish(ctx context.Context, id int) (DishItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.dishes[id]
+	if !ok {
+		return DishItem{}, ErrNotFound
+	}
+	dish := m.withGroups(stored, false)
+	dish.Recipe = append([]RecipeLine{}, stored.Recipe...)
+	dish.Preparations = []DishPreparation{}
+	for _, prep := range stored.Preparations {
+		prep.Name = m.preparations[prep.PreparationID]
+		dish.Preparations = append(dish.Preparations, prep)
+	}
+	return dish, nil
+}
+
+func (m *MemoryStore) SaveDish(ctx context.Context, dish *DishItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if dish.CategoryID != nil {
+		if _, ok := m.categories[*dish.CategoryID]; !ok {
+			return ErrUnknownCategory
+		}
+	}
+	for _, line := range dish.Recipe {
+		if !m.products[line.ProductID] {
+			return ErrUnknownProduct
+		}
+	}
+	for _, prep := range dish.Preparations {
+		if _, ok := m.preparations[prep.PreparationID]; !ok {
+			return ErrUnknownPreparation
+		}
+	}
+
+	if dish.ID == 0 {
+		dish.ID = m.nextDishID
+		m.nextDishID++
+	} else if _, ok := m.dishes[dish.ID]; !ok {
+		return ErrNotFound
+	}
+	saved := *dish
+	saved.ModifierGroups = nil
+	saved.Recipe = append([]RecipeLine(nil), dish.Recipe...)
+	saved.Preparations = append([]DishPreparation(nil), dish.Preparations...)
+	m.dishes[dish.ID] = saved
+	return nil
+}
+
+func (m *MemoryStore) SetDishActive(ctx context.Context, id int, active bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dish, ok := m.dishes[id]
+	if !ok {
+		return ErrNotFound
+	}
+	dish.Active = active
+	m.dishes[id] = dish
+	return nil
+}
+
+func (m *MemoryStore) Categories(ctx context.Context) ([]Category, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	categories := []Category{}
+	for _, c := range m.categories {
+		categories = append(categories, c)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].SortOrder != categories[j].SortOrder {
+			return categories[i].SortOrder < categories[j].SortOrder
+		}
+		return categories[i].ID < categories[j].ID
+	})
+	return categories, nil
+}
+
+func (m *MemoryStore) SaveCategory(ctx context.Context, c *Category) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c.ID == 0 {
+		c.ID = m.nextCategoryID
+		m.nextCategoryID++
+	} else if _, ok := m.categories[c.ID]; !ok {
+		return ErrNotFound
+	}
+	m.categories[c.ID] = *c
+	return nil
+}
+
+func (m *MemoryStore) DeleteCategory(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.categories[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.categories, id)
+	for dishID, dish := range m.dishes {
+		if dish.CategoryID != nil && *dish.CategoryID == id {
+			dish.CategoryID = nil
+			m.dishes[dishID] = dish
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) DishesByID(ctx context.Context, ids []int) (map[int]DishItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	byID := map[int]DishItem{}
+	for _, id := range ids {
+		if d, ok := m.dishes[id]; ok {
+			byID[id] = m.withGroups(d, false)
+		}
+	}
+	return byID, nil
+}
+
+func (m *MemoryStore) ModifierGroups(ctx context.Context) ([]ModifierGroup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var list []ModifierGroup
+	for _, group := range m.groups {
+		list = append(list, group)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (m *MemoryStore) SaveModifierGroup(ctx context.Context, group *ModifierGroup) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, mod := range group.Modifiers {
+		for _, line := range mod.Recipe {
+			if !m.products[line.ProductID] {
+				return ErrUnknownProduct
+			}
+		}
+	}
+
+	var previous []Modifier
+	if group.ID != 0 {
+		existing, ok := m.groups[group.ID]
+		if !ok {
+			return ErrNotFound
+		}
+		previous = existing.Modifiers
+		for _, mod := range group.Modifiers {
+			if _, ok := existing.Modifier(mod.ID); mod.ID != 0 && !ok {
+				return ErrNotFound
+			}
+		}
+	} else {
+		group.ID = m.nextGroupID
+		m.nextGroupID++
+	}
+
+	saved := *group
+	saved.Required = saved.MinSelect > 0
+	saved.Modifiers = nil
+	for i := range group.Modifiers {
+		if group.Modifiers[i].ID == 0 {
+			group.Modifiers[i].ID = m.nextModID
+			m.nextModID++
+		}
+		saved.Modifiers = append(saved.Modifiers, group.Modifiers[i])
+	}
+	for _, old := range previous {
+		if _, ok := group.Modifier(old.ID); !ok {
+			old.Active = false
+			saved.Modifiers = append(saved.Modifiers, old)
+		}
+	}
+	m.groups[group.ID] = saved
+	return nil
+}
+
+func (m *MemoryStore) SetDishModifierGroups(ctx context.Context, dishID int, groupIDs []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.dishes[dishID]; !ok {
+		return ErrNotFound
+	}
+	for _, id := range groupIDs {
+		if _, ok := m.groups[id]; !ok {
+			return ErrNotFound
+		}
+	}
+	m.dishGroups[dishID] = append([]int(nil), groupIDs...)
+	return nil
+}
+
+func (m *MemoryStore) CostInputs(ctx context.Context) (CostInputs, error) {
+	dishes, err := m.ListDishes(ctx, true)
+	if err != nil {
+		return CostInputs{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	in := CostInputs{Products: map[int]ProductCost{}}
+	for _, dish := range dishes {
+		stored := m.dishes[dish.ID]
+		dish.Recipe = append([]RecipeLine{}, stored.Recipe...)
+		dish.Preparations = append([]DishPreparation{}, stored.Preparations...)
+		in.Dishes = append(in.Dishes, dish)
+	}
+	for _, prep := range m.prepRecipes {
+		in.Preparations = append(in.Preparations, prep)
+	}
+	sort.Slice(in.Preparations, func(i, j int) bool { return in.Preparations[i].ID < in.Preparations[j].ID })
+	for id := range m.products {
+		in.Products[id] = m.costs[id]
+	}
+	return in, nil
+}
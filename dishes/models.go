@@ -1,7 +1,131 @@
 package dishes
 
+import (
+	"randevu-shawarma-server/money"
+)
+
 type DishItem struct {
-	ID    int    `json:"id"`
-	Name  string `json:"name"`
-	Price string `json:"price"`
+	ID             int             `json:"id"`
+	Name           string          `json:"name"`
+	Price          money.Amount    `json:"price"`
+	CategoryID     *int            `json:"categoryId"`
+	SortOrder      int             `json:"sortOrder"`
+	Active         bool            `json:"isActive"`
+	ModifierGroups []ModifierGroup `json:"modifierGroups"`
+
+	// Recipe and Preparations are only loaded for a single dish
+	Recipe       []RecipeLine      `json:"recipe,omitempty"`
+	Preparations []DishPreparation `json:"preparations,omitempty"`
+}
+
+// DishPreparation links a preparation to a dish. Quantity is how many
+// units of the preparation one unit of the dish uses.
+type DishPreparation struct {
+	PreparationID int     `json:"preparationId"`
+	Name          string  `json:"name"`
+	Quantity      float64 `json:"quantity"`
+}
+
+// Category groups dishes on the menu; lower SortOrder comes first
+type Category struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	SortOrder int    `json:"sortOrder"`
+}
+
+// ModifierGroup is a choice offered with dishes, e.g. "Sauce" or "Extras".
+// A MinSelect of one or more makes it required; a nil MaxSelect is unlimited.
+type ModifierGroup struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Required  bool       `json:"required"`
+	MinSelect int        `json:"minSelect"`
+	MaxSelect *int       `json:"maxSelect"`
+	Modifiers []Modifier `json:"modifiers"`
+}
+
+// Modifier changes the price of one unit of a dish by PriceDelta and its
+// recipe by Recipe, where a negative quantity removes the product
+type Modifier struct {
+	ID         int          `json:"id"`
+	Name       string       `json:"name"`
+	PriceDelta money.Amount `json:"priceDelta"`
+	Active     bool         `json:"isActive"`
+	Recipe     []RecipeLine `json:"recipe"`
+}
+
+// RecipeLine is how much of a product a recipe takes, in the unit the
+// product is kept in. Unit is only read from requests, for quantities
+// given in another unit of the product.
+type RecipeLine struct {
+	ProductID int     `json:"productId"`
+	Quantity  float64 `json:"quantity"`
+	Unit      string  `json:"unit,omitempty"`
+}
+
+// Modifier returns the modifier of the group with the given id
+func (g ModifierGroup) Modifier(id int) (Modifier, bool) {
+	for _, m := range g.Modifiers {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return Modifier{}, false
+}
+
+// CostInputs is everything dish costing reads: the whole menu with
+// recipes, every preparation and what each product costs
+type CostInputs struct {
+	// Dishes come with Recipe and Preparations filled in
+	Dishes       []DishItem
+	Preparations []PreparationRecipe
+	Products     map[int]ProductCost
+}
+
+// PreparationRecipe is what one unit of a preparation, kept under
+// ProductID, takes at full yield
+type PreparationRecipe struct {
+	ID           int
+	Name         string
+	ProductID    int
+	YieldPercent float64
+	Recipe       []RecipeLine
+}
+
+// ProductCost is the average cost of a product; nil when it has never
+// been received into the warehouse
+type ProductCost struct {
+	Name        string
+	AverageCost *money.UnitCost
+}
+
+// DishCost is what the ingredients of one unit of a dish cost against its
+// price. MissingCosts is set when products have no cost yet, or the dish
+// has no recipe, so FoodCost is too low.
+type DishCost struct {
+	DishID          int           `json:"dishId"`
+	Name            string        `json:"name"`
+	Price           money.Amount  `json:"price"`
+	FoodCost        money.Amount  `json:"foodCost"`
+	GrossMargin     money.Amount  `json:"grossMargin"`
+	FoodCostPercent *float64      `json:"foodCostPercent"`
+	MissingCosts    bool          `json:"missingCosts"`
+	MissingProducts []CostProduct `json:"missingProducts"`
+	Lines           []CostLine    `json:"lines,omitempty"`
+}
+
+type CostProduct struct {
+	ProductID int    `json:"productId"`
+	Name      string `json:"name"`
+}
+
+// CostLine is one recipe line or linked preparation of a dish
+type CostLine struct {
+	ProductID     int            `json:"productId,omitempty"`
+	PreparationID int            `json:"preparationId,omitempty"`
+	Name          string         `json:"name"`
+	Quantity      float64        `json:"quantity"`
+	UnitCost      money.UnitCost `json:"unitCost"`
+	Cost          money.Amount   `json:"cost"`
+	MissingCost   bool           `json:"missingCost"`
 }
@@ -0,0 +1,676 @@
+package dishes
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+
+	"randevu-shawarma-server/money"
+)
+
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// queryer is what *sql.DB and *sql.Tx have in common
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (s *PostgresStore) ListDishes(ctx context.Context, includeInactive bool) ([]DishItem, error) {
+	where := "d.is_active = true"
+	if includeInactive {
+		where = "true"
+	}
+	dishes, err := s.dishesWhere(ctx, where)
+	if err != nil {
+		return nil, err
+	}
+	err = s.attachGroups(ctx, dishes, !includeInactive)
+	return dishes, err
+}
+
+func (s *PostgresStore) Dish(ctx context.Context, id int) (DishItem, error) {
+	dishes, err := s.DishesByID(ctx, []int{id})
+	if err != nil {
+		return DishItem{}, err
+	}
+	dish, ok := dishes[id]
+	if !ok {
+		return DishItem{}, ErrNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT product_id, quantity
+		FROM public."Dish_recipe"
+		WHERE dish_id = $1
+		ORDER BY id`, id)
+	if err != nil {
+		return dish, err
+	}
+	defer rows.Close()
+
+	dish.Recipe = []RecipeLine{}
+	for rows.Next() {
+		var line RecipeLine
+		if err := rows.Scan(&line.ProductID, &line.Quantity); err != nil {
+			return dish, err
+		}
+		dish.Recipe = append(dish.Recipe, line)
+	}
+	if err := rows.Err(); err != nil {
+		return dish, err
+	}
+
+	prepRows, err := s.db.QueryContext(ctx, `
+		SELECT p.id, p.name, dp.quantity
+		FROM public."Dishes_Preparations" dp
+		JOIN public."Preparations" p ON p.id = dp.preparations_id
+		WHERE dp.dishes_id = $1
+		ORDER BY dp.id`, id)
+	if err != nil {
+		return dish, err
+	}
+	defer prepRows.Close()
+
+	dish.Preparations = []DishPreparation{}
+	for prepRows.Next() {
+		var prep DishPreparation
+		if err := prepRows.Scan(&prep.PreparationID, &prep.Name, &prep.Quantity); err != nil {
+			return dish, err
+		}
+		dish.Preparations = append(dish.Preparations, prep)
+	}
+	return dish, prepRows.Err()
+}
+
+func (s *PostgresStore) DishesByID(ctx context.Context, ids []int) (map[int]DishItem, error) {
+	dishes, err := s.dishesWhere(ctx, "d.id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.attachGroups(ctx, dishes, false); err != nil {
+		return nil, err
+	}
+
+	byID := map[int]DishItem{}
+	for _, dish := range dishes {
+		byID[dish.ID] = dish
+	}
+	return byID, nil
+}
+
+func (s *PostgresStore) dishesWhere(ctx context.Context, where string, args ...interface{}) ([]DishItem, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT d.id, d.name, d.price, d.category_id, d.sort_order, d.is_active
+		FROM public."Dishes" d
+		LEFT JOIN public."Menu_categories" c ON c.id = d.category_id
+		WHERE `+where+`
+		ORDER BY c.sort_order NULLS LAST, c.id, d.sort_order, d.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dishes []DishItem
+	for rows.Next() {
+		var item DishItem
+		err := rows.Scan(&item.ID, &item.Name, &item.Price, &item.CategoryID, &item.SortOrder, &item.Active)
+		if err != nil {
+			return nil, err
+		}
+		dishes = append(dishes, item)
+	}
+	return dishes, rows.Err()
+}
+
+// attachGroups loads the modifier groups of all dishes in a fixed number
+// of queries
+func (s *PostgresStore) attachGroups(ctx context.Context, dishes []DishItem, activeOnly bool) error {
+	if len(dishes) == 0 {
+		return nil
+	}
+	index := map[int]int{}
+	ids := make([]int, len(dishes))
+	for i, dish := range dishes {
+		index[dish.ID] = i
+		ids[i] = dish.ID
+		dishes[i].ModifierGroups = []ModifierGroup{}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT dmg.dish_id, g.id, g.name, g.min_select, g.max_select
+		FROM public."Dish_modifier_groups" dmg
+		JOIN public."Modifier_groups" g ON g.id = dmg.group_id
+		WHERE dmg.dish_id = ANY($1)
+		ORDER BY dmg.dish_id, dmg.sort_order, g.id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type link struct{ dish, group int }
+	var links []link
+	groups := map[int]*ModifierGroup{}
+	for rows.Next() {
+		var dishID int
+		var group ModifierGroup
+		if err := rows.Scan(&dishID, &group.ID, &group.Name, &group.MinSelect, &group.MaxSelect); err != nil {
+			return err
+		}
+		links = append(links, link{dishID, group.ID})
+		groups[group.ID] = &group
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := loadModifiers(ctx, s.db, groups, activeOnly); err != nil {
+		return err
+	}
+	for _, l := range links {
+		dish := &dishes[index[l.dish]]
+		dish.ModifierGroups = append(dish.ModifierGroups, *groups[l.group])
+	}
+	return nil
+}
+
+// loadModifiers fills in the modifiers and their recipes of the groups
+func loadModifiers(ctx context.Context, q queryer, groups map[int]*ModifierGroup, activeOnly bool) error {
+	groupIDs := make([]int, 0, len(groups))
+	for id, group := range groups {
+		groupIDs = append(groupIDs, id)
+		group.Required = group.MinSelect > 0
+		group.Modifiers = []Modifier{}
+	}
+	if len(groupIDs) == 0 {
+		return nil
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT m.group_id, m.id, m.name, m.price_delta, m.is_active, mr.product_id, mr.quantity
+		FROM public."Modifiers" m
+		LEFT JOIN public."Modifier_recipe" mr ON mr.modifier_id = m.id
+		WHERE m.group_id = ANY($1) AND (m.is_active OR NOT $2)
+		ORDER BY m.group_id, m.sort_order, m.id, mr.id`, pq.Array(groupIDs), activeOnly)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var groupID int
+		var m Modifier
+		var productID sql.NullInt64
+		var quantity sql.NullFloat64
+		if err := rows.Scan(&groupID, &m.ID, &m.Name, &m.PriceDelta, &m.Active, &productID, &quantity); err != nil {
+			return err
+		}
+
+		group := groups[groupID]
+		n := len(group.Modifiers)
+		if n == 0 || group.Modifiers[n-1].ID != m.ID {
+			m.Recipe = []RecipeLine{}
+			group.Modifiers = append(group.Modifiers, m)
+			n++
+		}
+		if productID.Valid {
+			last := &group.Modifiers[n-1]
+			last.Recipe = append(last.Recipe, RecipeLine{ProductID: int(productID.Int64), Quantity: quantity.Float64})
+		}
+	}
+	return rows.Err()
+}
+
+func (s *PostgresStore) ModifierGroups(ctx context.Context) ([]ModifierGroup, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, min_select, max_select FROM public.\"Modifier_groups\" ORDER BY name, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	groups := map[int]*ModifierGroup{}
+	for rows.Next() {
+		var group ModifierGroup
+		if err := rows.Scan(&group.ID, &group.Name, &group.MinSelect, &group.MaxSelect); err != nil {
+			return nil, err
+		}
+		ids = append(ids, group.ID)
+		groups[group.ID] = &group
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := loadModifiers(ctx, s.db, groups, false); err != nil {
+		return nil, err
+	}
+	list := make([]ModifierGroup, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, *groups[id])
+	}
+	return list, nil
+}
+
+func (s *PostgresStore) SaveModifierGroup(ctx context.Context, group *ModifierGroup) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var products []int
+	for _, m := range group.Modifiers {
+		for _, line := range m.Recipe {
+			products = append(products, line.ProductID)
+		}
+	}
+	if err := checkProducts(ctx, tx, products); err != nil {
+		return err
+	}
+
+	if group.ID == 0 {
+		err = tx.QueryRowContext(ctx,
+			"INSERT INTO public.\"Modifier_groups\" (name, min_select, max_select) VALUES ($1, $2, $3) RETURNING id",
+			group.Name, group.MinSelect, group.MaxSelect,
+		).Scan(&group.ID)
+		if err != nil {
+			return err
+		}
+	} else {
+		res, err := tx.ExecContext(ctx,
+			"UPDATE public.\"Modifier_groups\" SET name = $1, min_select = $2, max_select = $3 WHERE id = $4",
+			group.Name, group.MinSelect, group.MaxSelect, group.ID,
+		)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+	}
+
+	keep := []int{}
+	for i := range group.Modifiers {
+		m := &group.Modifiers[i]
+		if m.ID == 0 {
+			err = tx.QueryRowContext(ctx,
+				"INSERT INTO public.\"Modifiers\" (group_id, name, price_delta, sort_order, is_active) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+				group.ID, m.Name, m.PriceDelta, i, m.Active,
+			).Scan(&m.ID)
+		} else {
+			var res sql.Result
+			res, err = tx.ExecContext(ctx,
+				"UPDATE public.\"Modifiers\" SET name = $1, price_delta = $2, sort_order = $3, is_active = $4 WHERE id = $5 AND group_id = $6",
+				m.Name, m.PriceDelta, i, m.Active, m.ID, group.ID,
+			)
+			if err == nil {
+				if n, _ := res.RowsAffected(); n == 0 {
+					return ErrNotFound
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		keep = append(keep, m.ID)
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM public.\"Modifier_recipe\" WHERE modifier_id = $1", m.ID); err != nil {
+			return err
+		}
+		for _, line := range m.Recipe {
+			_, err := tx.ExecContext(ctx,
+				"INSERT INTO public.\"Modifier_recipe\" (modifier_id, product_id, quantity) VALUES ($1, $2, $3)",
+				m.ID, line.ProductID, line.Quantity,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE public.\"Modifiers\" SET is_active = false WHERE group_id = $1 AND NOT (id = ANY($2))",
+		group.ID, pq.Array(keep),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkProducts returns ErrUnknownProduct unless every product exists
+func checkProducts(ctx context.Context, q queryer, ids []int) error {
+	ok, err := allExist(ctx, q, "Products", ids)
+	if err == nil && !ok {
+		err = ErrUnknownProduct
+	}
+	return err
+}
+
+// allExist reports whether the table has a row for every id; ids may repeat
+func allExist(ctx context.Context, q queryer, table string, ids []int) (bool, error) {
+	distinct := map[int]bool{}
+	for _, id := range ids {
+		distinct[id] = true
+	}
+	if len(distinct) == 0 {
+		return true, nil
+	}
+
+	var found int
+	err := q.QueryRowContext(ctx, `SELECT COUNT(*) FROM public."`+table+`" WHERE id = ANY($1)`, pq.Array(ids)).Scan(&found)
+	return found == len(distinct), err
+}
+
+func (s *PostgresStore) SetDishModifierGroups(ctx context.Context, dishID int, groupIDs []int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM public.\"Dishes\" WHERE id = $1)", dishID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+
+	ok, err := allExist(ctx, tx, "Modifier_groups", groupIDs)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM public.\"Dish_modifier_groups\" WHERE dish_id = $1", dishID); err != nil {
+		return err
+	}
+	for i, groupID := range groupIDs {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO public.\"Dish_modifier_groups\" (dish_id, group_id, sort_order) VALUES ($1, $2, $3)",
+			dishID, groupID, i,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) SaveDish(ctx context.Context, dish *DishItem) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if dish.CategoryID != nil {
+		ok, err := allExist(ctx, tx, "Menu_categories", []int{*dish.CategoryID})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrUnknownCategory
+		}
+	}
+	var products []int
+	for _, line := range dish.Recipe {
+		products = append(products, line.ProductID)
+	}
+	if err := checkProducts(ctx, tx, products); err != nil {
+		return err
+	}
+	var preparations []int
+	for _, prep := range dish.Preparations {
+		preparations = append(preparations, prep.PreparationID)
+	}
+	ok, err := allExist(ctx, tx, "Preparations", preparations)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrUnknownPreparation
+	}
+
+	if dish.ID == 0 {
+		err = tx.QueryRowContext(ctx,
+			"INSERT INTO public.\"Dishes\" (name, price, category_id, sort_order, is_active) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+			dish.Name, dish.Price, dish.CategoryID, dish.SortOrder, dish.Active,
+		).Scan(&dish.ID)
+		if err != nil {
+			return err
+		}
+	} else {
+		res, err := tx.ExecContext(ctx,
+			"UPDATE public.\"Dishes\" SET name = $1, price = $2, category_id = $3, sort_order = $4, is_active = $5 WHERE id = $6",
+			dish.Name, dish.Price, dish.CategoryID, dish.SortOrder, dish.Active, dish.ID,
+		)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM public.\"Dish_recipe\" WHERE dish_id = $1", dish.ID); err != nil {
+		return err
+	}
+	for _, line := range dish.Recipe {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO public.\"Dish_recipe\" (dish_id, product_id, quantity) VALUES ($1, $2, $3)",
+			dish.ID, line.ProductID, line.Quantity,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM public.\"Dishes_Preparations\" WHERE dishes_id = $1", dish.ID); err != nil {
+		return err
+	}
+	for _, prep := range dish.Preparations {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO public.\"Dishes_Preparations\" (dishes_id, preparations_id, quantity) VALUES ($1, $2, $3)",
+			dish.ID, prep.PreparationID, prep.Quantity,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) SetDishActive(ctx context.Context, id int, active bool) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE public.\"Dishes\" SET is_active = $1 WHERE id = $2", active, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) Categories(ctx context.Context) ([]Category, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, sort_order FROM public.\"Menu_categories\" ORDER BY sort_order, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.ID, &c.Name, &c.SortOrder); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+func (s *PostgresStore) SaveCategory(ctx context.Context, c *Category) error {
+	if c.ID == 0 {
+		return s.db.QueryRowContext(ctx,
+			"INSERT INTO public.\"Menu_categories\" (name, sort_order) VALUES ($1, $2) RETURNING id",
+			c.Name, c.SortOrder,
+		).Scan(&c.ID)
+	}
+
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE public.\"Menu_categories\" SET name = $1, sort_order = $2 WHERE id = $3",
+		c.Name, c.SortOrder, c.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) DeleteCategory(ctx context.Context, id int) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM public.\"Menu_categories\" WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) CostInputs(ctx context.Context) (CostInputs, error) {
+	var in CostInputs
+	dishes, err := s.dishesWhere(ctx, "true")
+	if err != nil {
+		return in, err
+	}
+	index := map[int]int{}
+	for i := range dishes {
+		index[dishes[i].ID] = i
+		dishes[i].Recipe = []RecipeLine{}
+		dishes[i].Preparations = []DishPreparation{}
+	}
+	in.Dishes = dishes
+
+	rows, err := s.db.QueryContext(ctx, "SELECT dish_id, product_id, quantity FROM public.\"Dish_recipe\" ORDER BY id")
+	if err != nil {
+		return in, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var dishID int
+		var line RecipeLine
+		if err := rows.Scan(&dishID, &line.ProductID, &line.Quantity); err != nil {
+			return in, err
+		}
+		if i, ok := index[dishID]; ok {
+			dishes[i].Recipe = append(dishes[i].Recipe, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return in, err
+	}
+
+	linkRows, err := s.db.QueryContext(ctx, "SELECT dishes_id, preparations_id, quantity FROM public.\"Dishes_Preparations\" ORDER BY id")
+	if err != nil {
+		return in, err
+	}
+	defer linkRows.Close()
+	for linkRows.Next() {
+		var dishID int
+		var link DishPreparation
+		if err := linkRows.Scan(&dishID, &link.PreparationID, &link.Quantity); err != nil {
+			return in, err
+		}
+		if i, ok := index[dishID]; ok {
+			dishes[i].Preparations = append(dishes[i].Preparations, link)
+		}
+	}
+	if err := linkRows.Err(); err != nil {
+		return in, err
+	}
+
+	in.Preparations, err = s.preparationRecipes(ctx)
+	if err != nil {
+		return in, err
+	}
+	in.Products, err = s.productCosts(ctx)
+	return in, err
+}
+
+func (s *PostgresStore) preparationRecipes(ctx context.Context) ([]PreparationRecipe, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.id, p.name, p.product_id, p.yield_percent, pr.product_id, pr.quantity
+		FROM public."Preparations" p
+		LEFT JOIN public."Preparation_recipe" pr ON pr.preparation_id = p.id
+		ORDER BY p.id, pr.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var preps []PreparationRecipe
+	for rows.Next() {
+		var prep PreparationRecipe
+		var productID sql.NullInt64
+		var quantity sql.NullFloat64
+		if err := rows.Scan(&prep.ID, &prep.Name, &prep.ProductID, &prep.YieldPercent, &productID, &quantity); err != nil {
+			return nil, err
+		}
+		if n := len(preps); n == 0 || preps[n-1].ID != prep.ID {
+			preps = append(preps, prep)
+		}
+		if productID.Valid {
+			last := &preps[len(preps)-1]
+			last.Recipe = append(last.Recipe, RecipeLine{ProductID: int(productID.Int64), Quantity: quantity.Float64})
+		}
+	}
+	return preps, rows.Err()
+}
+
+func (s *PostgresStore) productCosts(ctx context.Context) (map[int]ProductCost, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.id, p.name, w.average_cost
+		FROM public."Products" p
+		LEFT JOIN public."Warehouse" w ON w.product_id = p.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := map[int]ProductCost{}
+	for rows.Next() {
+		var id int
+		var product ProductCost
+		var cost sql.NullString
+		if err := rows.Scan(&id, &product.Name, &cost); err != nil {
+			return nil, err
+		}
+		if cost.Valid {
+			unitCost, err := money.ParseUnitCost(cost.String)
+			if err != nil {
+				return nil, err
+			}
+			product.AverageCost = &unitCost
+		}
+		products[id] = product
+	}
+	return products, rows.Err()
+}
@@ -0,0 +1,54 @@
+package dishes
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrNotFound           = errors.New("not found")
+	ErrUnknownProduct     = errors.New("unknown product")
+	ErrUnknownPreparation = errors.New("unknown preparation")
+	ErrUnknownCategory    = errors.New("unknown category")
+	ErrUnknownUnit        = errors.New("unknown unit")
+)
+
+// Store reads and edits the menu
+type Store interface {
+	// ListDishes returns the dishes in menu order. Unless includeInactive
+	// is set only dishes and modifiers on sale are returned.
+	ListDishes(ctx context.Context, includeInactive bool) ([]DishItem, error)
+	// Dish returns the dish with its recipe, preparations and every modifier
+	Dish(ctx context.Context, id int) (DishItem, error)
+	// SaveDish creates the dish when its ID is zero and replaces it, recipe
+	// and preparations included, otherwise
+	SaveDish(ctx context.Context, dish *DishItem) error
+	// SetDishActive puts the dish on or takes it off sale; dishes are never
+	// deleted as past orders refer to them
+	SetDishActive(ctx context.Context, id int, active bool) error
+	// DishesByID returns the dishes, active or not, with every modifier;
+	// unknown ids are left out
+	DishesByID(ctx context.Context, ids []int) (map[int]DishItem, error)
+	ModifierGroups(ctx context.Context) ([]ModifierGroup, error)
+	// SaveModifierGroup creates the group when its ID is zero and replaces
+	// it otherwise. Modifiers without an ID are created; modifiers left out
+	// are deactivated, as past orders refer to them.
+	SaveModifierGroup(ctx context.Context, group *ModifierGroup) error
+	// SetDishModifierGroups offers the groups with the dish, in order
+	SetDishModifierGroups(ctx context.Context, dishID int, groupIDs []int) error
+
+	Categories(ctx context.Context) ([]Category, error)
+	// SaveCategory creates the category when its ID is zero and renames or
+	// reorders it otherwise
+	SaveCategory(ctx context.Context, category *Category) error
+	// DeleteCategory removes the category; its dishes become uncategorised
+	DeleteCategory(ctx context.Context, id int) error
+
+	// CostInputs loads what costing the whole menu needs
+	CostInputs(ctx context.Context) (CostInputs, error)
+}
+
+var (
+	_ Store = (*PostgresStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
@@ -0,0 +1,35 @@
+package dishes
+
+import (
+	"context"
+	"fmt"
+)
+
+// Units converts quantities of a product into the unit its stock is kept
+// in; the warehouse store is one
+type Units interface {
+	// Factor returns how many of the product's own unit one unit is worth
+	// and false if the product has no such unit
+	Factor(ctx context.Context, productID int, unit string) (float64, bool, error)
+}
+
+// ConvertRecipe rewrites the recipe lines given in a unit of their own
+// into the unit their product is kept in, which is how recipes are stored
+func ConvertRecipe(ctx context.Context, units Units, recipe []RecipeLine) error {
+	for i := range recipe {
+		line := &recipe[i]
+		if line.Unit == "" {
+			continue
+		}
+		factor, ok, err := units.Factor(ctx, line.ProductID, line.Unit)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w %q for product %d", ErrUnknownUnit, line.Unit, line.ProductID)
+		}
+		line.Quantity *= factor
+		line.Unit = ""
+	}
+	return nil
+}
@@ -7,4 +7,5 @@ require (
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/lib/pq v1.10.9
 	golang.org/x/crypto v0.25.0
+	gopkg.in/yaml.v3 v3.0.1
 )
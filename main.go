@@ -1,58 +1,39 @@
 package main
 
 import (
-	"database/sql"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/julienschmidt/httprouter"
-	_ "github.com/lib/pq"
 
+	"randevu-shawarma-server/config"
+	"randevu-shawarma-server/connection"
 	"randevu-shawarma-server/dishes"
+	"randevu-shawarma-server/money"
 	"randevu-shawarma-server/orders"
+	"randevu-shawarma-server/preparations"
+	"randevu-shawarma-server/shifts"
+	"randevu-shawarma-server/stocktake"
 	"randevu-shawarma-server/supply"
 	"randevu-shawarma-server/users"
 	"randevu-shawarma-server/warehouse"
 	"randevu-shawarma-server/writeoff"
 )
 
-func initDB() *sql.DB {
-
-	// Get environment variables
-	// dbHost := os.Getenv("DB_HOST")
-	// dbPort := os.Getenv("DB_PORT")
-	// dbUser := os.Getenv("DB_USER")
-	// dbPassword := os.Getenv("DB_PASSWORD")
-	// dbName := os.Getenv("DB_NAME")
-
-	// println(dbHost)
-	// println(dbPort)
-	// println(dbUser)
-	// println(dbPassword)
-	// println(dbName)
-
-	// Connect to the database
-	// connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-	// 	dbHost, dbPort, dbUser, dbPassword, dbName)
-
-	connStr := "user=kostia password=foDfyf-vufvim-muvwy9 dbname=randevu_database host=localhost port=5432 sslmode=disable"
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = db.Ping()
-	if err != nil {
-		log.Fatal(err)
-	}
-	return db
-}
-
-func setupCORS(next http.Handler) http.Handler {
+func setupCORS(next http.Handler, allowedOrigins []string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Set the headers for CORS
-		w.Header().Set("Access-Control-Allow-Origin", "https://localhost:5173")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		// Set the headers for CORS only for known origins
+		origin := r.Header.Get("Origin")
+		for _, allowed := range allowedOrigins {
+			if allowed == origin {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Vary", "Origin")
+				break
+			}
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Terminal-Token, Last-Event-ID")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		// Handle preflight requests
@@ -67,25 +48,54 @@ func setupCORS(next http.Handler) http.Handler {
 }
 
 func main() {
-	db := initDB()
-	defer db.Close()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		dbConfig, err := config.LoadDatabase()
+		if err != nil {
+			log.Fatal(err)
+		}
+		db := connection.OpenDatabase(dbConfig)
+		defer db.Close()
 
-	users.SetDatabase(db)
-	supply.SetDatabase(db)
-	writeoff.SetDatabase(db)
-	orders.SetDatabase(db)
-	warehouse.SetDatabase(db)
-	dishes.SetDatabase(db)
+		runMigrate(db, os.Args[2:])
+		return
+	}
 
-	router := httprouter.New()
-	users.RegisterRoutes(router)
-	supply.RegisterRoutes(router)
-	writeoff.RegisterRoutes(router)
-	orders.RegisterRoutes(router)
-	warehouse.RegisterRoutes(router)
-	dishes.RegisterRoutes(router)
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	money.SetDefaultCurrency(cfg.Money.Currency)
+
+	db := connection.OpenDatabase(cfg.Database)
+	defer db.Close()
+
+	checkSchema(db)
 
-	corsRouter := setupCORS(router)
+	userService := users.NewService(users.NewPostgresStore(db), cfg.Auth)
+	warehouseStore := warehouse.NewPostgresStore(db, warehouse.Costing(cfg.Inventory.Costing))
+	warehouseService := warehouse.NewService(warehouseStore, userService)
+	supplyService := supply.NewService(supply.NewPostgresStore(db, warehouseStore), userService, warehouseService)
+	writeOffService := writeoff.NewService(writeoff.NewPostgresStore(db, warehouseStore), userService, warehouseService)
+	dishStore := dishes.NewPostgresStore(db)
+	orderService := orders.NewService(orders.NewPostgresStore(db, warehouseStore), userService, dishStore)
+	dishService := dishes.NewService(dishStore, userService, warehouseStore)
+	shiftService := shifts.NewService(shifts.NewPostgresStore(db), userService)
+	preparationService := preparations.NewService(preparations.NewPostgresStore(db, warehouseStore), userService, warehouseStore)
+	stocktakeService := stocktake.NewService(stocktake.NewPostgresStore(db, warehouseStore), userService)
 
-	log.Fatal(http.ListenAndServe(":8090", corsRouter))
+	router := httprouter.New()
+	userService.RegisterRoutes(router)
+	supplyService.RegisterRoutes(router)
+	writeOffService.RegisterRoutes(router)
+	orderService.RegisterRoutes(router)
+	warehouseService.RegisterRoutes(router)
+	dishService.RegisterRoutes(router)
+	shiftService.RegisterRoutes(router)
+	preparationService.RegisterRoutes(router)
+	stocktakeService.RegisterRoutes(router)
+
+	corsRouter := setupCORS(router, cfg.Server.AllowedOrigins)
+
+	log.Fatal(http.ListenAndServe(cfg.Server.Addr, corsRouter))
 }
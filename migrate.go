@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+
+	"randevu-shawarma-server/migrations"
+)
+
+const migrateUsage = "usage: main migrate up|down|status"
+
+// runMigrate handles the "migrate" subcommand
+func runMigrate(db *sql.DB, args []string) {
+	if len(args) != 1 {
+		log.Fatal(migrateUsage)
+	}
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		m, err := migrator.Down(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, state)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+}
+
+// checkSchema refuses to serve against a database at a different schema version
+func checkSchema(db *sql.DB) {
+	migrator, err := migrations.New(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := migrator.Check(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,209 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// advisoryLockID serialises migration runs started from several processes
+const advisoryLockID = 7350319
+
+var ErrNothingToRevert = errors.New("migrations: no applied migrations to revert")
+
+// Status describes one known migration and whether it has been applied
+type Status struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt"`
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New returns a migrator over the embedded migrations
+func New(db *sql.DB) (*Migrator, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS public.schema_migrations (
+			version    integer PRIMARY KEY,
+			name       text NOT NULL,
+			applied_at timestamp with time zone NOT NULL DEFAULT now()
+		)`)
+	return err
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT version, applied_at FROM public.schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// Up applies every pending migration in order, each in its own transaction
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range m.migrations {
+		err := m.inLockedTx(ctx, func(tx *sql.Tx) error {
+			var exists bool
+			err := tx.QueryRowContext(ctx,
+				"SELECT EXISTS (SELECT 1 FROM public.schema_migrations WHERE version = $1)",
+				migration.Version,
+			).Scan(&exists)
+			if err != nil || exists {
+				return err
+			}
+
+			if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+				return fmt.Errorf("migrations: apply %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+			_, err = tx.ExecContext(ctx,
+				"INSERT INTO public.schema_migrations (version, name) VALUES ($1, $2)",
+				migration.Version, migration.Name,
+			)
+			if err == nil {
+				done = append(done, migration)
+			}
+			return err
+		})
+		if err != nil {
+			return done, err
+		}
+	}
+	return done, nil
+}
+
+// Down reverts the most recently applied migration
+func (m *Migrator) Down(ctx context.Context) (Migration, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return Migration{}, err
+	}
+
+	var reverted Migration
+	err := m.inLockedTx(ctx, func(tx *sql.Tx) error {
+		var version int
+		err := tx.QueryRowContext(ctx, "SELECT version FROM public.schema_migrations ORDER BY version DESC LIMIT 1").Scan(&version)
+		if err == sql.ErrNoRows {
+			return ErrNothingToRevert
+		} else if err != nil {
+			return err
+		}
+
+		migration, ok := m.find(version)
+		if !ok {
+			return fmt.Errorf("migrations: applied version %d is unknown to this binary", version)
+		}
+
+		if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+			return fmt.Errorf("migrations: revert %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM public.schema_migrations WHERE version = $1", version); err != nil {
+			return err
+		}
+		reverted = migration
+		return nil
+	})
+	return reverted, err
+}
+
+// Status lists embedded migrations with their applied time, followed by
+// any applied versions this binary does not know about
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &appliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for version, appliedAt := range applied {
+		appliedAt := appliedAt
+		statuses = append(statuses, Status{Version: version, Name: "(unknown)", AppliedAt: &appliedAt})
+	}
+	return statuses, nil
+}
+
+// Check returns an error unless the database is at exactly the schema
+// version embedded in this binary
+func (m *Migrator) Check(ctx context.Context) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	var pending, unknown []int
+	for _, status := range statuses {
+		switch {
+		case status.AppliedAt == nil:
+			pending = append(pending, status.Version)
+		case status.Name == "(unknown)":
+			unknown = append(unknown, status.Version)
+		}
+	}
+	if len(pending) > 0 || len(unknown) > 0 {
+		return fmt.Errorf("migrations: schema mismatch (pending %v, unknown %v); run \"migrate up\" or deploy a matching binary", pending, unknown)
+	}
+	return nil
+}
+
+func (m *Migrator) find(version int) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+func (m *Migrator) inLockedTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", advisoryLockID); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
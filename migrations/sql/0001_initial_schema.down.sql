@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS public."Order_dish_relations";
+DROP TABLE IF EXISTS public."Orders";
+DROP TABLE IF EXISTS public."Dishes_Preparations";
+DROP TABLE IF EXISTS public."Preparation_recipe";
+DROP TABLE IF EXISTS public."Preparations";
+DROP TABLE IF EXISTS public."Dish_recipe";
+DROP TABLE IF EXISTS public."Dishes";
+DROP TABLE IF EXISTS public."Write_off_product_relations";
+DROP TABLE IF EXISTS public."Write_off";
+DROP TABLE IF EXISTS public."Supply_product_relations";
+DROP TABLE IF EXISTS public."Supply";
+DROP TABLE IF EXISTS public."Warehouse";
+DROP TABLE IF EXISTS public."Products";
+DROP TABLE IF EXISTS public."Users";
@@ -0,0 +1,98 @@
+-- Baseline schema. IF NOT EXISTS lets databases created by hand before
+-- migrations existed adopt this version without changes.
+
+CREATE TABLE IF NOT EXISTS public."Users" (
+    id         serial PRIMARY KEY,
+    name       text NOT NULL,
+    email      text NOT NULL UNIQUE,
+    password   text NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS public."Products" (
+    id   serial PRIMARY KEY,
+    name text NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public."Warehouse" (
+    id            serial PRIMARY KEY,
+    product_id    integer NOT NULL UNIQUE REFERENCES public."Products" (id),
+    current_stock double precision NOT NULL DEFAULT 0,
+    average_cost  money NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS public."Supply" (
+    id         serial PRIMARY KEY,
+    user_id    integer NOT NULL REFERENCES public."Users" (id),
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS public."Supply_product_relations" (
+    id         serial PRIMARY KEY,
+    supply_id  integer NOT NULL REFERENCES public."Supply" (id) ON DELETE CASCADE,
+    product_id integer NOT NULL REFERENCES public."Products" (id),
+    quantity   double precision NOT NULL,
+    price      money NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public."Write_off" (
+    id         serial PRIMARY KEY,
+    user_id    integer NOT NULL REFERENCES public."Users" (id),
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    notes      text NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS public."Write_off_product_relations" (
+    id           serial PRIMARY KEY,
+    write_off_id integer NOT NULL REFERENCES public."Write_off" (id) ON DELETE CASCADE,
+    product_id   integer NOT NULL REFERENCES public."Products" (id),
+    quantity     double precision NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public."Dishes" (
+    id        serial PRIMARY KEY,
+    name      text NOT NULL,
+    price     money NOT NULL,
+    is_active boolean NOT NULL DEFAULT true
+);
+
+CREATE TABLE IF NOT EXISTS public."Dish_recipe" (
+    id         serial PRIMARY KEY,
+    dish_id    integer NOT NULL REFERENCES public."Dishes" (id) ON DELETE CASCADE,
+    product_id integer NOT NULL REFERENCES public."Products" (id),
+    quantity   double precision NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public."Preparations" (
+    id   serial PRIMARY KEY,
+    name text NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public."Preparation_recipe" (
+    id             serial PRIMARY KEY,
+    preparation_id integer NOT NULL REFERENCES public."Preparations" (id) ON DELETE CASCADE,
+    product_id     integer NOT NULL REFERENCES public."Products" (id),
+    quantity       double precision NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public."Dishes_Preparations" (
+    id              serial PRIMARY KEY,
+    dishes_id       integer NOT NULL REFERENCES public."Dishes" (id) ON DELETE CASCADE,
+    preparations_id integer NOT NULL REFERENCES public."Preparations" (id)
+);
+
+CREATE TABLE IF NOT EXISTS public."Orders" (
+    id         serial PRIMARY KEY,
+    user_id    integer NOT NULL REFERENCES public."Users" (id),
+    name       text NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    processing boolean NOT NULL DEFAULT true,
+    sold       boolean NOT NULL DEFAULT false
+);
+
+CREATE TABLE IF NOT EXISTS public."Order_dish_relations" (
+    id       serial PRIMARY KEY,
+    order_id integer NOT NULL REFERENCES public."Orders" (id) ON DELETE CASCADE,
+    dish_id  integer NOT NULL REFERENCES public."Dishes" (id),
+    quantity integer NOT NULL
+);
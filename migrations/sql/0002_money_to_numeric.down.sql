@@ -0,0 +1,7 @@
+ALTER TABLE public."Dishes" ALTER COLUMN price TYPE money USING price::money;
+
+ALTER TABLE public."Supply_product_relations" ALTER COLUMN price TYPE money USING price::money;
+
+ALTER TABLE public."Warehouse" ALTER COLUMN average_cost DROP DEFAULT;
+ALTER TABLE public."Warehouse" ALTER COLUMN average_cost TYPE money USING average_cost::money;
+ALTER TABLE public."Warehouse" ALTER COLUMN average_cost SET DEFAULT 0;
@@ -0,0 +1,11 @@
+-- money depends on lc_monetary and loses precision when averaged;
+-- store exact decimals instead. Unit costs of stock kept in small units
+-- (grams, millilitres) are fractions of a kopeck, so they keep six
+-- decimals and only totals are rounded.
+ALTER TABLE public."Warehouse" ALTER COLUMN average_cost DROP DEFAULT;
+ALTER TABLE public."Warehouse" ALTER COLUMN average_cost TYPE numeric(18, 6) USING average_cost::numeric;
+ALTER TABLE public."Warehouse" ALTER COLUMN average_cost SET DEFAULT 0;
+
+ALTER TABLE public."Supply_product_relations" ALTER COLUMN price TYPE numeric(18, 6) USING price::numeric;
+
+ALTER TABLE public."Dishes" ALTER COLUMN price TYPE numeric(14, 2) USING price::numeric;
@@ -0,0 +1 @@
+ALTER TABLE public."Users" DROP COLUMN role;
@@ -0,0 +1,5 @@
+-- Accounts that exist before roles keep full access; new accounts start
+-- as cashiers until an owner or manager assigns a role
+ALTER TABLE public."Users" ADD COLUMN role text NOT NULL DEFAULT 'owner'
+    CHECK (role IN ('owner', 'manager', 'cashier', 'cook', 'storekeeper'));
+ALTER TABLE public."Users" ALTER COLUMN role SET DEFAULT 'cashier';
@@ -0,0 +1,13 @@
+CREATE TABLE public."Sessions" (
+    id                  bigserial PRIMARY KEY,
+    user_id             integer NOT NULL REFERENCES public."Users" (id) ON DELETE CASCADE,
+    refresh_token_hash  text NOT NULL UNIQUE,
+    previous_token_hash text UNIQUE,
+    user_agent          text NOT NULL DEFAULT '',
+    created_at          timestamp with time zone NOT NULL DEFAULT now(),
+    last_used_at        timestamp with time zone NOT NULL DEFAULT now(),
+    expires_at          timestamp with time zone NOT NULL,
+    revoked_at          timestamp with time zone
+);
+
+CREATE INDEX sessions_user_id_idx ON public."Sessions" (user_id) WHERE revoked_at IS NULL;
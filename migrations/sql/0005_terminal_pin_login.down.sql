@@ -0,0 +1,8 @@
+ALTER TABLE public."Sessions" DROP COLUMN terminal_id;
+
+ALTER TABLE public."Users"
+    DROP COLUMN pin_hash,
+    DROP COLUMN pin_failed_attempts,
+    DROP COLUMN pin_locked_until;
+
+DROP TABLE public."Terminals";
@@ -0,0 +1,16 @@
+CREATE TABLE public."Terminals" (
+    id         serial PRIMARY KEY,
+    name       text NOT NULL,
+    token_hash text NOT NULL UNIQUE,
+    created_by integer NOT NULL REFERENCES public."Users" (id),
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    revoked_at timestamp with time zone
+);
+
+ALTER TABLE public."Users"
+    ADD COLUMN pin_hash            text,
+    ADD COLUMN pin_failed_attempts integer NOT NULL DEFAULT 0,
+    ADD COLUMN pin_locked_until    timestamp with time zone;
+
+ALTER TABLE public."Sessions"
+    ADD COLUMN terminal_id integer REFERENCES public."Terminals" (id);
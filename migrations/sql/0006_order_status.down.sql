@@ -0,0 +1,13 @@
+DROP TABLE public."Order_status_history";
+
+ALTER TABLE public."Orders"
+    ADD COLUMN processing boolean NOT NULL DEFAULT true,
+    ADD COLUMN sold boolean NOT NULL DEFAULT false;
+
+UPDATE public."Orders"
+SET processing = status IN ('new', 'accepted', 'cooking', 'ready'),
+    sold = status = 'picked_up';
+
+ALTER TABLE public."Orders"
+    DROP COLUMN status,
+    DROP COLUMN stock_deducted_at;
@@ -0,0 +1,31 @@
+ALTER TABLE public."Orders"
+    ADD COLUMN status text NOT NULL DEFAULT 'new'
+        CHECK (status IN ('new', 'accepted', 'cooking', 'ready', 'picked_up', 'cancelled')),
+    ADD COLUMN stock_deducted_at timestamp with time zone;
+
+-- processing=false without sold meant the order was dropped from the kitchen
+UPDATE public."Orders"
+SET status = CASE
+        WHEN sold THEN 'picked_up'
+        WHEN processing THEN 'new'
+        ELSE 'cancelled'
+    END,
+    stock_deducted_at = CASE WHEN sold THEN created_at END;
+
+ALTER TABLE public."Orders"
+    DROP COLUMN processing,
+    DROP COLUMN sold;
+
+CREATE TABLE public."Order_status_history" (
+    id          bigserial PRIMARY KEY,
+    order_id    integer NOT NULL REFERENCES public."Orders" (id) ON DELETE CASCADE,
+    from_status text,
+    to_status   text NOT NULL,
+    user_id     integer REFERENCES public."Users" (id),
+    changed_at  timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX order_status_history_order_id_idx ON public."Order_status_history" (order_id);
+
+INSERT INTO public."Order_status_history" (order_id, from_status, to_status, user_id, changed_at)
+SELECT id, NULL, status, user_id, created_at FROM public."Orders";
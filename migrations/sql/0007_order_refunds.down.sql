@@ -0,0 +1,13 @@
+DROP TABLE public."Order_refund_lines";
+DROP TABLE public."Order_refunds";
+
+UPDATE public."Orders" SET status = 'picked_up' WHERE status = 'refunded';
+ALTER TABLE public."Orders" DROP CONSTRAINT "Orders_status_check";
+ALTER TABLE public."Orders" ADD CONSTRAINT "Orders_status_check"
+    CHECK (status IN ('new', 'accepted', 'cooking', 'ready', 'picked_up', 'cancelled'));
+
+DROP TABLE public."Order_line_ingredients";
+
+ALTER TABLE public."Order_dish_relations"
+    DROP CONSTRAINT order_dish_relations_refunded_quantity_check,
+    DROP COLUMN refunded_quantity;
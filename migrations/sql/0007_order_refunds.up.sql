@@ -0,0 +1,52 @@
+ALTER TABLE public."Order_dish_relations"
+    ADD COLUMN refunded_quantity integer NOT NULL DEFAULT 0,
+    ADD CONSTRAINT order_dish_relations_refunded_quantity_check
+        CHECK (refunded_quantity >= 0 AND refunded_quantity <= quantity);
+
+-- What one unit of an order line took from the warehouse, recorded when
+-- the stock was deducted so refunds return exactly that
+CREATE TABLE public."Order_line_ingredients" (
+    id                serial PRIMARY KEY,
+    order_line_id     integer NOT NULL REFERENCES public."Order_dish_relations" (id) ON DELETE CASCADE,
+    product_id        integer NOT NULL REFERENCES public."Products" (id),
+    quantity_per_unit double precision NOT NULL,
+    UNIQUE (order_line_id, product_id)
+);
+
+-- Orders deducted before this migration: best estimate from current recipes
+INSERT INTO public."Order_line_ingredients" (order_line_id, product_id, quantity_per_unit)
+SELECT odr.id, x.product_id, SUM(x.quantity)
+FROM public."Order_dish_relations" odr
+JOIN public."Orders" o ON o.id = odr.order_id
+JOIN (
+    SELECT dr.dish_id, dr.product_id, dr.quantity
+    FROM public."Dish_recipe" dr
+    UNION ALL
+    SELECT dp.dishes_id, pr.product_id, pr.quantity
+    FROM public."Dishes_Preparations" dp
+    JOIN public."Preparation_recipe" pr ON pr.preparation_id = dp.preparations_id
+) x ON x.dish_id = odr.dish_id
+WHERE o.stock_deducted_at IS NOT NULL
+GROUP BY odr.id, x.product_id;
+
+ALTER TABLE public."Orders" DROP CONSTRAINT "Orders_status_check";
+ALTER TABLE public."Orders" ADD CONSTRAINT "Orders_status_check"
+    CHECK (status IN ('new', 'accepted', 'cooking', 'ready', 'picked_up', 'cancelled', 'refunded'));
+
+CREATE TABLE public."Order_refunds" (
+    id           serial PRIMARY KEY,
+    order_id     integer NOT NULL REFERENCES public."Orders" (id) ON DELETE CASCADE,
+    user_id      integer NOT NULL REFERENCES public."Users" (id),
+    reason       text NOT NULL DEFAULT '',
+    stock_action text NOT NULL CHECK (stock_action IN ('none', 'return', 'write_off')),
+    write_off_id integer REFERENCES public."Write_off" (id),
+    amount       numeric(14, 2) NOT NULL,
+    created_at   timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE TABLE public."Order_refund_lines" (
+    id            serial PRIMARY KEY,
+    refund_id     integer NOT NULL REFERENCES public."Order_refunds" (id) ON DELETE CASCADE,
+    order_line_id integer NOT NULL REFERENCES public."Order_dish_relations" (id),
+    quantity      integer NOT NULL CHECK (quantity > 0)
+);
@@ -0,0 +1 @@
+DROP TABLE public."Order_payments";
@@ -0,0 +1,17 @@
+-- Money taken for an order. amount is what counts towards the order; for
+-- cash, tendered is what the customer handed over and change what went back.
+CREATE TABLE public."Order_payments" (
+    id         serial PRIMARY KEY,
+    order_id   integer NOT NULL REFERENCES public."Orders" (id) ON DELETE CASCADE,
+    user_id    integer NOT NULL REFERENCES public."Users" (id),
+    method     text NOT NULL CHECK (method IN ('cash', 'card')),
+    amount     numeric(14, 2) NOT NULL CHECK (amount > 0),
+    tendered   numeric(14, 2),
+    change     numeric(14, 2) NOT NULL DEFAULT 0 CHECK (change >= 0),
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CHECK (method = 'cash' OR (tendered IS NULL AND change = 0)),
+    CHECK (tendered IS NULL OR tendered = amount + change)
+);
+
+CREATE INDEX order_payments_order_id_idx ON public."Order_payments" (order_id);
+CREATE INDEX order_payments_created_at_idx ON public."Order_payments" (created_at);
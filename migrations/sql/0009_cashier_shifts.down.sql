@@ -0,0 +1,10 @@
+ALTER TABLE public."Order_refunds"
+    DROP COLUMN payout_method,
+    DROP COLUMN paid_out,
+    DROP COLUMN shift_id;
+
+ALTER TABLE public."Order_payments"
+    DROP COLUMN shift_id;
+
+DROP TABLE public."Shift_cash_movements";
+DROP TABLE public."Shifts";
@@ -0,0 +1,39 @@
+CREATE TABLE public."Shifts" (
+    id            serial PRIMARY KEY,
+    user_id       integer NOT NULL REFERENCES public."Users" (id),
+    opened_at     timestamp with time zone NOT NULL DEFAULT now(),
+    opening_float numeric(14, 2) NOT NULL CHECK (opening_float >= 0),
+    closed_at     timestamp with time zone,
+    closed_by     integer REFERENCES public."Users" (id),
+    expected_cash numeric(14, 2),
+    counted_cash  numeric(14, 2),
+    notes         text NOT NULL DEFAULT '',
+    CHECK ((closed_at IS NULL) = (counted_cash IS NULL))
+);
+
+-- A cashier works one drawer at a time
+CREATE UNIQUE INDEX shifts_one_open_per_user ON public."Shifts" (user_id) WHERE closed_at IS NULL;
+
+-- Cash put into or taken out of the drawer other than through orders
+CREATE TABLE public."Shift_cash_movements" (
+    id         serial PRIMARY KEY,
+    shift_id   integer NOT NULL REFERENCES public."Shifts" (id) ON DELETE CASCADE,
+    user_id    integer NOT NULL REFERENCES public."Users" (id),
+    kind       text NOT NULL CHECK (kind IN ('cash_in', 'drop', 'payout')),
+    amount     numeric(14, 2) NOT NULL CHECK (amount > 0),
+    reason     text NOT NULL DEFAULT '',
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+ALTER TABLE public."Order_payments"
+    ADD COLUMN shift_id integer REFERENCES public."Shifts" (id);
+
+-- paid_out is the money handed back to the customer, in payout_method
+ALTER TABLE public."Order_refunds"
+    ADD COLUMN shift_id      integer REFERENCES public."Shifts" (id),
+    ADD COLUMN paid_out      numeric(14, 2) NOT NULL DEFAULT 0 CHECK (paid_out >= 0),
+    ADD COLUMN payout_method text CHECK (payout_method IN ('cash', 'card')),
+    ADD CHECK (paid_out = 0 OR payout_method IS NOT NULL);
+
+CREATE INDEX order_payments_shift_id_idx ON public."Order_payments" (shift_id);
+CREATE INDEX order_refunds_shift_id_idx ON public."Order_refunds" (shift_id);
@@ -0,0 +1,9 @@
+DROP TABLE public."Order_line_modifiers";
+
+ALTER TABLE public."Order_dish_relations"
+    DROP COLUMN unit_price;
+
+DROP TABLE public."Dish_modifier_groups";
+DROP TABLE public."Modifier_recipe";
+DROP TABLE public."Modifiers";
+DROP TABLE public."Modifier_groups";
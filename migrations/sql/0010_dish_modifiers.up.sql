@@ -0,0 +1,55 @@
+-- A choice offered with dishes, e.g. "Sauce" or "Extras". min_select of
+-- one or more makes the group required; a NULL max_select is unlimited.
+CREATE TABLE public."Modifier_groups" (
+    id         serial PRIMARY KEY,
+    name       text NOT NULL,
+    min_select integer NOT NULL DEFAULT 0 CHECK (min_select >= 0),
+    max_select integer CHECK (max_select >= 1 AND max_select >= min_select)
+);
+
+CREATE TABLE public."Modifiers" (
+    id          serial PRIMARY KEY,
+    group_id    integer NOT NULL REFERENCES public."Modifier_groups" (id) ON DELETE CASCADE,
+    name        text NOT NULL,
+    price_delta numeric(14, 2) NOT NULL DEFAULT 0,
+    sort_order  integer NOT NULL DEFAULT 0,
+    is_active   boolean NOT NULL DEFAULT true
+);
+
+-- What choosing a modifier adds to (positive) or removes from (negative)
+-- one unit of the dish
+CREATE TABLE public."Modifier_recipe" (
+    id          serial PRIMARY KEY,
+    modifier_id integer NOT NULL REFERENCES public."Modifiers" (id) ON DELETE CASCADE,
+    product_id  integer NOT NULL REFERENCES public."Products" (id),
+    quantity    double precision NOT NULL CHECK (quantity <> 0),
+    UNIQUE (modifier_id, product_id)
+);
+
+CREATE TABLE public."Dish_modifier_groups" (
+    id         serial PRIMARY KEY,
+    dish_id    integer NOT NULL REFERENCES public."Dishes" (id) ON DELETE CASCADE,
+    group_id   integer NOT NULL REFERENCES public."Modifier_groups" (id) ON DELETE CASCADE,
+    sort_order integer NOT NULL DEFAULT 0,
+    UNIQUE (dish_id, group_id)
+);
+
+-- Lines keep the price they were sold at, modifiers included
+ALTER TABLE public."Order_dish_relations"
+    ADD COLUMN unit_price numeric(14, 2);
+UPDATE public."Order_dish_relations" odr
+SET unit_price = d.price
+FROM public."Dishes" d
+WHERE d.id = odr.dish_id;
+ALTER TABLE public."Order_dish_relations"
+    ALTER COLUMN unit_price SET NOT NULL;
+
+-- The modifiers chosen on a line, with name and price as sold
+CREATE TABLE public."Order_line_modifiers" (
+    id            serial PRIMARY KEY,
+    order_line_id integer NOT NULL REFERENCES public."Order_dish_relations" (id) ON DELETE CASCADE,
+    modifier_id   integer NOT NULL REFERENCES public."Modifiers" (id),
+    name          text NOT NULL,
+    price_delta   numeric(14, 2) NOT NULL,
+    UNIQUE (order_line_id, modifier_id)
+);
@@ -0,0 +1,8 @@
+ALTER TABLE public."Dishes_Preparations"
+    DROP COLUMN quantity;
+
+ALTER TABLE public."Dishes"
+    DROP COLUMN sort_order,
+    DROP COLUMN category_id;
+
+DROP TABLE public."Menu_categories";
@@ -0,0 +1,15 @@
+CREATE TABLE public."Menu_categories" (
+    id         serial PRIMARY KEY,
+    name       text NOT NULL,
+    sort_order integer NOT NULL DEFAULT 0
+);
+
+-- Dishes without a category are listed after the categorised ones
+ALTER TABLE public."Dishes"
+    ADD COLUMN category_id integer REFERENCES public."Menu_categories" (id) ON DELETE SET NULL,
+    ADD COLUMN sort_order  integer NOT NULL DEFAULT 0;
+
+-- How many portions of the preparation's recipe one unit of the dish
+-- uses; existing links used exactly one
+ALTER TABLE public."Dishes_Preparations"
+    ADD COLUMN quantity double precision NOT NULL DEFAULT 1 CHECK (quantity > 0);
@@ -0,0 +1,9 @@
+DROP TABLE public."Production_ingredients";
+DROP TABLE public."Productions";
+
+-- The products made for preparations stay, as stock and documents may
+-- refer to them
+ALTER TABLE public."Preparations"
+    DROP COLUMN deduct_from,
+    DROP COLUMN yield_percent,
+    DROP COLUMN product_id;
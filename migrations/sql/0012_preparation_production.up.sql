@@ -0,0 +1,44 @@
+-- Every preparation gets a product of its own so its stock lives in the
+-- warehouse like any other. Recipes are what one unit of the preparation
+-- takes at full yield; yield_percent is how much of that usually comes out.
+ALTER TABLE public."Preparations"
+    ADD COLUMN product_id    integer UNIQUE REFERENCES public."Products" (id),
+    ADD COLUMN yield_percent double precision NOT NULL DEFAULT 100 CHECK (yield_percent > 0),
+    -- ingredients: sales explode the recipe into raw products as before;
+    -- stock: sales take the preparation from the warehouse
+    ADD COLUMN deduct_from   text NOT NULL DEFAULT 'ingredients' CHECK (deduct_from IN ('ingredients', 'stock'));
+
+DO $$
+DECLARE
+    prep record;
+    new_id integer;
+BEGIN
+    FOR prep IN SELECT id, name FROM public."Preparations" ORDER BY id LOOP
+        INSERT INTO public."Products" (name) VALUES (prep.name) RETURNING id INTO new_id;
+        UPDATE public."Preparations" SET product_id = new_id WHERE id = prep.id;
+    END LOOP;
+END $$;
+
+ALTER TABLE public."Preparations"
+    ALTER COLUMN product_id SET NOT NULL;
+
+-- A batch of a preparation made from raw products
+CREATE TABLE public."Productions" (
+    id             serial PRIMARY KEY,
+    preparation_id integer NOT NULL REFERENCES public."Preparations" (id),
+    user_id        integer NOT NULL REFERENCES public."Users" (id),
+    -- planned is what the recipe was made for, quantity what came out
+    planned        double precision NOT NULL CHECK (planned > 0),
+    quantity       double precision NOT NULL CHECK (quantity > 0),
+    cost           numeric(14, 2) NOT NULL,
+    notes          text NOT NULL DEFAULT '',
+    created_at     timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE TABLE public."Production_ingredients" (
+    id            serial PRIMARY KEY,
+    production_id integer NOT NULL REFERENCES public."Productions" (id) ON DELETE CASCADE,
+    product_id    integer NOT NULL REFERENCES public."Products" (id),
+    quantity      double precision NOT NULL,
+    cost          numeric(14, 2) NOT NULL
+);
@@ -0,0 +1,3 @@
+DROP TRIGGER stock_movements_append_only ON public."Stock_movements";
+DROP FUNCTION public.stock_movements_append_only();
+DROP TABLE public."Stock_movements";
@@ -0,0 +1,32 @@
+-- Every change to a stock level, kept for good: balance is the level
+-- after the movement and unit_cost what one unit was valued at.
+CREATE TABLE public."Stock_movements" (
+    id          bigserial PRIMARY KEY,
+    product_id  integer NOT NULL REFERENCES public."Products" (id),
+    delta       double precision NOT NULL,
+    balance     double precision NOT NULL,
+    unit_cost   numeric(18, 6) NOT NULL,
+    source_type text NOT NULL CHECK (source_type IN ('supply', 'write_off', 'order', 'refund', 'production', 'adjustment')),
+    source_id   integer,
+    user_id     integer REFERENCES public."Users" (id),
+    created_at  timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX "Stock_movements_product_created_idx" ON public."Stock_movements" (product_id, created_at);
+
+-- Mistakes are corrected with another movement, never by editing history
+CREATE FUNCTION public.stock_movements_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'stock movements are append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER stock_movements_append_only
+    BEFORE UPDATE OR DELETE ON public."Stock_movements"
+    FOR EACH ROW EXECUTE FUNCTION public.stock_movements_append_only();
+
+-- Stock on hand before the ledger existed opens it
+INSERT INTO public."Stock_movements" (product_id, delta, balance, unit_cost, source_type)
+SELECT product_id, current_stock, current_stock, average_cost, 'adjustment'
+FROM public."Warehouse"
+WHERE current_stock <> 0;
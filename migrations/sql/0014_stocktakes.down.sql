@@ -0,0 +1,7 @@
+DROP TABLE public."Stocktake_lines";
+DROP TABLE public."Stocktakes";
+
+ALTER TABLE public."Products"
+    DROP COLUMN category_id;
+
+DROP TABLE public."Product_categories";
@@ -0,0 +1,34 @@
+-- Categories products are counted by, e.g. "Fridge" or "Dry store"
+CREATE TABLE public."Product_categories" (
+    id   serial PRIMARY KEY,
+    name text NOT NULL
+);
+
+ALTER TABLE public."Products"
+    ADD COLUMN category_id integer REFERENCES public."Product_categories" (id) ON DELETE SET NULL;
+
+-- A count of the warehouse, or of one category when category_id is set
+CREATE TABLE public."Stocktakes" (
+    id          serial PRIMARY KEY,
+    user_id     integer NOT NULL REFERENCES public."Users" (id),
+    category_id integer REFERENCES public."Product_categories" (id),
+    status      text NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'posted', 'cancelled')),
+    notes       text NOT NULL DEFAULT '',
+    created_at  timestamp with time zone NOT NULL DEFAULT now(),
+    closed_at   timestamp with time zone,
+    closed_by   integer REFERENCES public."Users" (id)
+);
+
+-- expected and average_cost are the warehouse as it was when the count
+-- started; counted stays null until someone counts the product
+CREATE TABLE public."Stocktake_lines" (
+    id           serial PRIMARY KEY,
+    stocktake_id integer NOT NULL REFERENCES public."Stocktakes" (id) ON DELETE CASCADE,
+    product_id   integer NOT NULL REFERENCES public."Products" (id),
+    expected     double precision NOT NULL,
+    average_cost numeric(18, 6) NOT NULL,
+    counted      double precision CHECK (counted >= 0),
+    counted_by   integer REFERENCES public."Users" (id),
+    counted_at   timestamp with time zone,
+    UNIQUE (stocktake_id, product_id)
+);
@@ -0,0 +1,5 @@
+ALTER TABLE public."Stock_movements"
+    DROP COLUMN shortage;
+
+ALTER TABLE public."Products"
+    DROP COLUMN stock_policy;
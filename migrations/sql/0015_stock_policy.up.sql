@@ -0,0 +1,10 @@
+-- What happens when more of a product is taken than the warehouse holds:
+-- block refuses, flag lets stock go negative and marks the movement,
+-- allow lets it go negative. Sales went through regardless of stock
+-- before, so products start on flag and owners opt into block.
+ALTER TABLE public."Products"
+    ADD COLUMN stock_policy text NOT NULL DEFAULT 'flag' CHECK (stock_policy IN ('block', 'flag', 'allow'));
+
+-- How much of a take the stock did not cover, for flagged products
+ALTER TABLE public."Stock_movements"
+    ADD COLUMN shortage double precision NOT NULL DEFAULT 0;
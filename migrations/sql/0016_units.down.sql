@@ -0,0 +1,13 @@
+ALTER TABLE public."Write_off_product_relations"
+    DROP COLUMN unit_quantity,
+    DROP COLUMN unit;
+
+ALTER TABLE public."Supply_product_relations"
+    DROP COLUMN unit_price,
+    DROP COLUMN unit_quantity,
+    DROP COLUMN unit;
+
+DROP TABLE public."Product_units";
+
+ALTER TABLE public."Products"
+    DROP COLUMN unit;
@@ -0,0 +1,25 @@
+-- The unit a product is kept in; stock, recipes and average cost are all
+-- in this unit
+ALTER TABLE public."Products"
+    ADD COLUMN unit text NOT NULL DEFAULT 'pcs';
+
+-- Other units a product is bought or used in, each worth factor of its own
+-- unit, e.g. a box of 5000 g
+CREATE TABLE public."Product_units" (
+    id serial PRIMARY KEY,
+    product_id integer NOT NULL REFERENCES public."Products" (id) ON DELETE CASCADE,
+    name text NOT NULL,
+    factor double precision NOT NULL CHECK (factor > 0),
+    UNIQUE (product_id, name)
+);
+
+-- Lines keep quantity and price in the product's unit and, when entered in
+-- another unit, what was entered
+ALTER TABLE public."Supply_product_relations"
+    ADD COLUMN unit text NOT NULL DEFAULT '',
+    ADD COLUMN unit_quantity double precision,
+    ADD COLUMN unit_price numeric(14, 2);
+
+ALTER TABLE public."Write_off_product_relations"
+    ADD COLUMN unit text NOT NULL DEFAULT '',
+    ADD COLUMN unit_quantity double precision;
@@ -0,0 +1,9 @@
+ALTER TABLE public."Supply"
+    DROP COLUMN invoice_number,
+    DROP COLUMN purchase_order_id,
+    DROP COLUMN supplier_id;
+
+DROP TABLE public."Purchase_order_lines";
+DROP TABLE public."Purchase_orders";
+DROP TABLE public."Supplier_products";
+DROP TABLE public."Suppliers";
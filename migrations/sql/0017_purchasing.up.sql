@@ -0,0 +1,51 @@
+-- Companies products are bought from
+CREATE TABLE public."Suppliers" (
+    id            serial PRIMARY KEY,
+    name          text NOT NULL,
+    contact_name  text NOT NULL DEFAULT '',
+    phone         text NOT NULL DEFAULT '',
+    email         text NOT NULL DEFAULT '',
+    payment_terms text NOT NULL DEFAULT '',
+    notes         text NOT NULL DEFAULT ''
+);
+
+-- Products a supplier carries, the unit they are bought in ('' for the
+-- product's own) and the price per unit they were last received at
+CREATE TABLE public."Supplier_products" (
+    supplier_id      integer NOT NULL REFERENCES public."Suppliers" (id) ON DELETE CASCADE,
+    product_id       integer NOT NULL REFERENCES public."Products" (id) ON DELETE CASCADE,
+    unit             text NOT NULL DEFAULT '',
+    last_price       numeric(14, 2),
+    last_received_at timestamp with time zone,
+    PRIMARY KEY (supplier_id, product_id)
+);
+
+CREATE TABLE public."Purchase_orders" (
+    id          serial PRIMARY KEY,
+    supplier_id integer NOT NULL REFERENCES public."Suppliers" (id),
+    user_id     integer NOT NULL REFERENCES public."Users" (id),
+    status      text NOT NULL DEFAULT 'draft'
+                CHECK (status IN ('draft', 'sent', 'partially_received', 'received', 'closed', 'cancelled')),
+    notes       text NOT NULL DEFAULT '',
+    expected_at timestamp with time zone,
+    created_at  timestamp with time zone NOT NULL DEFAULT now(),
+    sent_at     timestamp with time zone,
+    closed_at   timestamp with time zone
+);
+
+-- quantity, price and received are all per unit
+CREATE TABLE public."Purchase_order_lines" (
+    id                serial PRIMARY KEY,
+    purchase_order_id integer NOT NULL REFERENCES public."Purchase_orders" (id) ON DELETE CASCADE,
+    product_id        integer NOT NULL REFERENCES public."Products" (id),
+    unit              text NOT NULL DEFAULT '',
+    quantity          double precision NOT NULL CHECK (quantity > 0),
+    price             numeric(14, 2) NOT NULL,
+    received          double precision NOT NULL DEFAULT 0,
+    UNIQUE (purchase_order_id, product_id)
+);
+
+ALTER TABLE public."Supply"
+    ADD COLUMN supplier_id integer REFERENCES public."Suppliers" (id),
+    ADD COLUMN purchase_order_id integer REFERENCES public."Purchase_orders" (id),
+    ADD COLUMN invoice_number text NOT NULL DEFAULT '';
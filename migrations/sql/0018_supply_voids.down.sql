@@ -0,0 +1,7 @@
+DROP INDEX public."Supply_created_idx";
+
+ALTER TABLE public."Supply"
+    DROP COLUMN corrects,
+    DROP COLUMN void_reason,
+    DROP COLUMN voided_by,
+    DROP COLUMN voided_at;
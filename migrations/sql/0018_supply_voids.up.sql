@@ -0,0 +1,9 @@
+-- A supply booked in error is voided rather than deleted; a correction
+-- is a new supply that corrects the voided one
+ALTER TABLE public."Supply"
+    ADD COLUMN voided_at timestamp with time zone,
+    ADD COLUMN voided_by integer REFERENCES public."Users" (id),
+    ADD COLUMN void_reason text NOT NULL DEFAULT '',
+    ADD COLUMN corrects integer UNIQUE REFERENCES public."Supply" (id);
+
+CREATE INDEX "Supply_created_idx" ON public."Supply" (created_at);
@@ -0,0 +1 @@
+DROP TABLE public."Stock_lots";
@@ -0,0 +1,20 @@
+-- Each receipt of stock is a lot that is used up oldest first; under FIFO
+-- costing stock leaves at the cost of the lots it is taken from.
+CREATE TABLE public."Stock_lots" (
+    id          serial PRIMARY KEY,
+    product_id  integer NOT NULL REFERENCES public."Products" (id),
+    source_type text NOT NULL CHECK (source_type IN ('supply', 'write_off', 'order', 'refund', 'production', 'adjustment')),
+    source_id   integer,
+    quantity    double precision NOT NULL,
+    remaining   double precision NOT NULL CHECK (remaining >= 0),
+    unit_cost   numeric(18, 6) NOT NULL,
+    received_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX "Stock_lots_open_idx" ON public."Stock_lots" (product_id, received_at) WHERE remaining > 0;
+
+-- Stock on hand before lots existed opens them at its average cost
+INSERT INTO public."Stock_lots" (product_id, source_type, quantity, remaining, unit_cost)
+SELECT product_id, 'adjustment', current_stock, current_stock, average_cost
+FROM public."Warehouse"
+WHERE current_stock > 0;
@@ -0,0 +1,5 @@
+DROP INDEX public."Stock_lots_expiring_idx";
+
+ALTER TABLE public."Stock_lots" DROP COLUMN expires_at;
+ALTER TABLE public."Productions" DROP COLUMN expires_at;
+ALTER TABLE public."Supply_product_relations" DROP COLUMN expires_at;
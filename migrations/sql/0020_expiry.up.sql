@@ -0,0 +1,7 @@
+-- Perishable stock carries the date it must be used by, from the supply
+-- or production that brought it in to the lot it is kept in
+ALTER TABLE public."Supply_product_relations" ADD COLUMN expires_at timestamp with time zone;
+ALTER TABLE public."Productions" ADD COLUMN expires_at timestamp with time zone;
+ALTER TABLE public."Stock_lots" ADD COLUMN expires_at timestamp with time zone;
+
+CREATE INDEX "Stock_lots_expiring_idx" ON public."Stock_lots" (expires_at) WHERE remaining > 0 AND expires_at IS NOT NULL;
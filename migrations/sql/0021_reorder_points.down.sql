@@ -0,0 +1,3 @@
+ALTER TABLE public."Products"
+    DROP COLUMN max_stock,
+    DROP COLUMN reorder_point;
@@ -0,0 +1,5 @@
+-- When a product should be bought again: at reorder_point, up to
+-- max_stock. Unset, both are worked out from recent usage.
+ALTER TABLE public."Products"
+    ADD COLUMN reorder_point double precision CHECK (reorder_point >= 0),
+    ADD COLUMN max_stock double precision CHECK (max_stock > 0);
@@ -0,0 +1,416 @@
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Amount is an exact sum of money held as an integer number of minor
+// units (kopecks, cents) of its currency
+type Amount struct {
+	minor    int64
+	currency string
+}
+
+// Rounding decides what happens to a fraction of a minor unit
+type Rounding int
+
+const (
+	// HalfUp rounds halves away from zero, as cash registers and invoices do
+	HalfUp Rounding = iota
+	// HalfEven rounds halves to the even neighbour, avoiding bias in sums
+	HalfEven
+	// Down truncates towards zero
+	Down
+)
+
+var defaultCurrency = "RUB"
+
+// SetDefaultCurrency sets the currency of amounts parsed from JSON and the database
+func SetDefaultCurrency(code string) {
+	defaultCurrency = code
+}
+
+// DefaultCurrency returns the deployment currency
+func DefaultCurrency() string {
+	return defaultCurrency
+}
+
+// zeroDecimalCurrencies have no minor unit
+var zeroDecimalCurrencies = map[string]bool{"JPY": true, "KRW": true, "VND": true}
+
+func exponent(currency string) int {
+	if zeroDecimalCurrencies[currency] {
+		return 0
+	}
+	return 2
+}
+
+func scale(currency string) int64 {
+	s := int64(1)
+	for i := 0; i < exponent(currency); i++ {
+		s *= 10
+	}
+	return s
+}
+
+// New returns an amount of minor units in the given currency
+func New(minor int64, currency string) Amount {
+	return Amount{minor: minor, currency: currency}
+}
+
+// Zero returns nothing in the default currency
+func Zero() Amount {
+	return Amount{currency: defaultCurrency}
+}
+
+// FromMinor returns minor units of the default currency
+func FromMinor(minor int64) Amount {
+	return Amount{minor: minor, currency: defaultCurrency}
+}
+
+// Parse reads a decimal amount in the default currency. It accepts plain
+// numbers ("12.5") as well as the text of a Postgres money column
+// ("$1,234.50", "-$3.00"). Extra decimals are rounded half up; anything
+// that is not a number, a sign or a currency symbol is rejected.
+func Parse(s string) (Amount, error) {
+	return ParseIn(s, defaultCurrency)
+}
+
+// ParseIn is Parse for an explicit currency
+func ParseIn(s string, currency string) (Amount, error) {
+	r, ok := parseDecimal(s, currency)
+	if !ok {
+		return Amount{}, fmt.Errorf("money: invalid amount %q", s)
+	}
+	return fromRat(r, currency, HalfUp), nil
+}
+
+// currencySymbols may lead or trail an amount, next to its ISO code
+var currencySymbols = []string{"$", "€", "£", "¥", "₽", "руб."}
+
+func parseDecimal(s string, currency string) (*big.Rat, bool) {
+	s = strings.TrimSpace(s)
+	negative := false
+
+	// accounting notation for negatives
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		negative = true
+		s = strings.TrimSpace(s[1 : len(s)-1])
+	}
+	// the sign may come before or after a leading symbol: "-$3.00", "$-3.00"
+	for i := 0; i < 2; i++ {
+		if strings.HasPrefix(s, "-") && !negative {
+			negative = true
+			s = strings.TrimSpace(s[1:])
+		} else if strings.HasPrefix(s, "+") {
+			s = strings.TrimSpace(s[1:])
+		}
+		if i == 0 {
+			s = trimSymbol(s, currency)
+		}
+	}
+
+	// A comma is a decimal separator only when there is no dot and it is
+	// followed by one or two digits; otherwise it groups thousands, as
+	// spaces do in "1 234,50"
+	number := s
+	if i := strings.LastIndex(number, ","); i >= 0 && !strings.Contains(number, ".") && len(number)-i-1 <= 2 && strings.Count(number, ",") == 1 {
+		number = number[:i] + "." + number[i+1:]
+	}
+	number = strings.NewReplacer(" ", ",", "\u00a0", ",").Replace(number)
+
+	whole, fraction, hasPoint := strings.Cut(number, ".")
+	if !groupedDigits(whole) || (hasPoint && !digits(fraction)) || (whole == "" && fraction == "") {
+		return nil, false
+	}
+	whole = strings.ReplaceAll(whole, ",", "")
+	if whole == "" {
+		whole = "0"
+	}
+
+	r, ok := new(big.Rat).SetString(whole + "." + fraction + "0")
+	if !ok {
+		return nil, false
+	}
+	if negative {
+		r.Neg(r)
+	}
+	return r, true
+}
+
+// trimSymbol removes one currency symbol or the ISO code from either end
+func trimSymbol(s string, currency string) string {
+	for _, symbol := range append([]string{currency}, currencySymbols...) {
+		if strings.HasPrefix(s, symbol) {
+			return strings.TrimSpace(strings.TrimPrefix(s, symbol))
+		}
+		if strings.HasSuffix(s, symbol) {
+			return strings.TrimSpace(strings.TrimSuffix(s, symbol))
+		}
+	}
+	return s
+}
+
+// groupedDigits accepts "", "1234" or thousands groups such as "1,234,567"
+func groupedDigits(s string) bool {
+	if !strings.Contains(s, ",") {
+		return s == "" || digits(s)
+	}
+	groups := strings.Split(s, ",")
+	if len(groups[0]) == 0 || len(groups[0]) > 3 || !digits(groups[0]) {
+		return false
+	}
+	for _, g := range groups[1:] {
+		if len(g) != 3 || !digits(g) {
+			return false
+		}
+	}
+	return true
+}
+
+func digits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// MustParse is Parse for constants; it panics on invalid input
+func MustParse(s string) Amount {
+	a, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// FromFloat converts a float, rounding half up to the minor unit
+func FromFloat(f float64) Amount {
+	return fromRat(new(big.Rat).SetFloat64(f), defaultCurrency, HalfUp)
+}
+
+func fromRat(r *big.Rat, currency string, mode Rounding) Amount {
+	minor := new(big.Rat).Mul(r, new(big.Rat).SetInt64(scale(currency)))
+	return Amount{minor: roundRat(minor, mode), currency: currency}
+}
+
+func roundRat(r *big.Rat, mode Rounding) int64 {
+	num := new(big.Int).Set(r.Num())
+	den := r.Denom()
+
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Sign() == 0 || mode == Down {
+		return quo.Int64()
+	}
+
+	// compare 2*|rem| with den to find out which side of the half we are on
+	twice := new(big.Int).Abs(rem)
+	twice.Lsh(twice, 1)
+	cmp := twice.Cmp(den)
+
+	awayFromZero := cmp > 0
+	if cmp == 0 {
+		switch mode {
+		case HalfUp:
+			awayFromZero = true
+		case HalfEven:
+			awayFromZero = quo.Bit(0) == 1
+		}
+	}
+	if awayFromZero {
+		if num.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	return quo.Int64()
+}
+
+func (a Amount) rat() *big.Rat {
+	return new(big.Rat).SetFrac(big.NewInt(a.minor), big.NewInt(scale(a.currencyOrDefault())))
+}
+
+func (a Amount) currencyOrDefault() string {
+	if a.currency == "" {
+		return defaultCurrency
+	}
+	return a.currency
+}
+
+// Minor returns the amount in minor units
+func (a Amount) Minor() int64 {
+	return a.minor
+}
+
+// Currency returns the ISO 4217 code of the amount
+func (a Amount) Currency() string {
+	return a.currencyOrDefault()
+}
+
+func (a Amount) IsZero() bool {
+	return a.minor == 0
+}
+
+func (a Amount) IsNegative() bool {
+	return a.minor < 0
+}
+
+// sameCurrency panics when amounts in different currencies are combined;
+// the zero Amount adopts the currency of the other operand
+func sameCurrency(a, b Amount) string {
+	ac, bc := a.currency, b.currency
+	switch {
+	case ac == "":
+		return b.currencyOrDefault()
+	case bc == "" || ac == bc:
+		return ac
+	}
+	panic(fmt.Sprintf("money: mixing %s and %s", ac, bc))
+}
+
+func (a Amount) Add(b Amount) Amount {
+	return Amount{minor: a.minor + b.minor, currency: sameCurrency(a, b)}
+}
+
+func (a Amount) Sub(b Amount) Amount {
+	return Amount{minor: a.minor - b.minor, currency: sameCurrency(a, b)}
+}
+
+func (a Amount) Neg() Amount {
+	return Amount{minor: -a.minor, currency: a.currency}
+}
+
+// Cmp returns -1, 0 or 1 as a is less than, equal to or greater than b
+func (a Amount) Cmp(b Amount) int {
+	sameCurrency(a, b)
+	switch {
+	case a.minor < b.minor:
+		return -1
+	case a.minor > b.minor:
+		return 1
+	}
+	return 0
+}
+
+// MulInt multiplies by a whole number, e.g. a dish price by its quantity
+func (a Amount) MulInt(n int64) Amount {
+	return Amount{minor: a.minor * n, currency: a.currency}
+}
+
+// Mul multiplies by a fractional quantity, e.g. a unit cost by 0.12 kg,
+// rounding the result to the minor unit
+func (a Amount) Mul(quantity float64, mode Rounding) Amount {
+	r := new(big.Rat).Mul(a.rat(), new(big.Rat).SetFloat64(quantity))
+	return fromRat(r, a.currencyOrDefault(), mode)
+}
+
+// Div divides by a quantity, e.g. a line total by the number of units
+func (a Amount) Div(quantity float64, mode Rounding) (Amount, error) {
+	if quantity == 0 {
+		return Amount{}, errors.New("money: division by zero")
+	}
+	r := new(big.Rat).Quo(a.rat(), new(big.Rat).SetFloat64(quantity))
+	return fromRat(r, a.currencyOrDefault(), mode), nil
+}
+
+// String formats the amount as a plain decimal, e.g. "-12.50"
+func (a Amount) String() string {
+	exp := exponent(a.currencyOrDefault())
+	minor := a.minor
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	if exp == 0 {
+		return sign + strconv.FormatInt(minor, 10)
+	}
+	s := scale(a.currencyOrDefault())
+	return fmt.Sprintf("%s%d.%0*d", sign, minor/s, exp, minor%s)
+}
+
+// Float64 is for ratios and display only, never for further arithmetic
+func (a Amount) Float64() float64 {
+	f, _ := a.rat().Float64()
+	return f
+}
+
+// MarshalJSON writes the amount as a decimal string, e.g. "12.50"
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON accepts a JSON number or a string in any form Parse accepts
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	switch value := v.(type) {
+	case nil:
+		*a = Zero()
+		return nil
+	case string:
+		parsed, err := Parse(value)
+		if err != nil {
+			return err
+		}
+		*a = parsed
+	case float64:
+		// use the literal rather than the float to stay exact; SetString
+		// understands exponents such as 1e2
+		r, ok := new(big.Rat).SetString(strings.TrimSpace(string(data)))
+		if !ok {
+			return fmt.Errorf("money: invalid amount %s", data)
+		}
+		*a = fromRat(r, defaultCurrency, HalfUp)
+	default:
+		return fmt.Errorf("money: unexpected JSON type %T", v)
+	}
+	return nil
+}
+
+// Value stores the amount as a decimal string, valid for numeric and money columns
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// Scan reads numeric, money, integer and float columns
+func (a *Amount) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*a = Zero()
+	case []byte:
+		parsed, err := Parse(string(v))
+		if err != nil {
+			return err
+		}
+		*a = parsed
+	case string:
+		parsed, err := Parse(v)
+		if err != nil {
+			return err
+		}
+		*a = parsed
+	case int64:
+		*a = Amount{minor: v * scale(defaultCurrency), currency: defaultCurrency}
+	case float64:
+		*a = FromFloat(v)
+	default:
+		return fmt.Errorf("money: cannot scan %T", src)
+	}
+	return nil
+}
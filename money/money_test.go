@@ -0,0 +1,202 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"12.5", "12.50"},
+		{"12", "12.00"},
+		{".5", "0.50"},
+		{"0.005", "0.01"},
+		{"-0.005", "-0.01"},
+		{"$1,234.50", "1234.50"},
+		{"-$3.00", "-3.00"},
+		{"$-3.00", "-3.00"},
+		{"(5.00)", "-5.00"},
+		{"+7", "7.00"},
+		{"1 234,50", "1234.50"},
+		{"12,5", "12.50"},
+		{"1,234", "1234.00"},
+		{"₽100", "100.00"},
+		{"100 RUB", "100.00"},
+		{"  42.10  ", "42.10"},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.in, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseRejectsStrayCharacters(t *testing.T) {
+	for _, in := range []string{
+		"", "abc", "$", "12abc", "12.5x", "1.2.3", "12-", "--5", "1,23,4", "12,", "1e5", "12.50 USD", "5 $ 5",
+	} {
+		if got, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) = %s, want an error", in, got)
+		}
+	}
+}
+
+func TestRounding(t *testing.T) {
+	tests := []struct {
+		amount   string
+		quantity float64
+		mode     Rounding
+		want     string
+	}{
+		{"0.05", 0.5, HalfUp, "0.03"},
+		{"0.05", 0.5, HalfEven, "0.02"},
+		{"0.05", 0.5, Down, "0.02"},
+		{"0.15", 0.5, HalfUp, "0.08"},
+		{"0.15", 0.5, HalfEven, "0.08"},
+		{"-0.05", 0.5, HalfUp, "-0.03"},
+		{"-0.05", 0.5, HalfEven, "-0.02"},
+		{"-0.05", 0.5, Down, "-0.02"},
+		{"0.07", 0.5, HalfEven, "0.04"},
+		{"10.00", 0.333, HalfUp, "3.33"},
+	}
+	for _, tt := range tests {
+		got := MustParse(tt.amount).Mul(tt.quantity, tt.mode)
+		if got.String() != tt.want {
+			t.Errorf("%s × %v (mode %d) = %s, want %s", tt.amount, tt.quantity, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestDiv(t *testing.T) {
+	got, err := MustParse("10.00").Div(3, HalfEven)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "3.33" {
+		t.Errorf("10.00 / 3 = %s, want 3.33", got)
+	}
+	if _, err := MustParse("10.00").Div(0, HalfUp); err == nil {
+		t.Error("dividing by zero did not fail")
+	}
+}
+
+func TestPerUnit(t *testing.T) {
+	tests := []struct {
+		amount   string
+		quantity float64
+		want     string
+	}{
+		{"450.00", 5000, "0.09"},
+		{"1.00", 3, "0.333333"},
+		{"2.00", 3, "0.666667"},
+		{"0.01", 1000, "0.00001"},
+		{"-4.50", 2, "-2.25"},
+	}
+	for _, tt := range tests {
+		got, err := MustParse(tt.amount).PerUnit(tt.quantity)
+		if err != nil {
+			t.Errorf("%s per %v: %v", tt.amount, tt.quantity, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("%s per %v = %s, want %s", tt.amount, tt.quantity, got, tt.want)
+		}
+	}
+
+	if _, err := MustParse("450.00").PerUnit(0); err == nil {
+		t.Error("a cost per zero units did not fail")
+	}
+}
+
+func TestUnitCostScale(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"4", "4.00"},
+		{"0.004", "0.004"},
+		{"0.123456", "0.123456"},
+		{"0.1234564", "0.123456"},
+		{"0.1234565", "0.123457"},
+		{"0.0000005", "0.000001"},
+		{"0.0000004", "0.00"},
+		{"-0.0000015", "-0.000002"},
+		{"$1,000.5", "1000.50"},
+	}
+	for _, tt := range tests {
+		got, err := ParseUnitCost(tt.in)
+		if err != nil {
+			t.Errorf("ParseUnitCost(%q): %v", tt.in, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("ParseUnitCost(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+
+	// Totals are rounded to the minor unit, never the unit cost itself
+	cost := MustParseUnitCost("0.004")
+	if got := cost.Mul(120, HalfUp).String(); got != "0.48" {
+		t.Errorf("120 × 0.004 = %s, want 0.48", got)
+	}
+	if got := cost.Scale(0.5).String(); got != "0.002" {
+		t.Errorf("0.004 scaled by 0.5 = %s, want 0.002", got)
+	}
+}
+
+func TestWeightedAverage(t *testing.T) {
+	tests := []struct {
+		name               string
+		stockCost, addCost string
+		stockQty, addQty   float64
+		want               string
+	}{
+		{"empty stock", "0", "12.50", 0, 10, "12.50"},
+		{"merge", "100", "110", 10, 20, "106.666667"},
+		{"merge again", "106.666667", "90", 30, 40, "97.142857"},
+		{"oversold stock", "10", "12", -5, 20, "12.00"},
+		{"still oversold", "10", "12", -5, 3, "12.00"},
+		{"fractions of a kopeck", "0.09", "0.1", 5000, 5000, "0.095"},
+	}
+	for _, tt := range tests {
+		got := WeightedAverage(MustParseUnitCost(tt.stockCost), tt.stockQty, MustParseUnitCost(tt.addCost), tt.addQty)
+		if got.String() != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`0.1`, "0.10"},
+		{`1e2`, "100.00"},
+		{`"$3.50"`, "3.50"},
+		{`null`, "0.00"},
+	}
+	for _, tt := range tests {
+		var a Amount
+		if err := json.Unmarshal([]byte(tt.in), &a); err != nil {
+			t.Errorf("unmarshal %s: %v", tt.in, err)
+			continue
+		}
+		if a.String() != tt.want {
+			t.Errorf("unmarshal %s = %s, want %s", tt.in, a, tt.want)
+		}
+	}
+
+	var a Amount
+	if err := json.Unmarshal([]byte(`"12abc"`), &a); err == nil {
+		t.Error("unmarshalling a stray character did not fail")
+	}
+}
@@ -0,0 +1,230 @@
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// unitCostScale is how many parts of a major unit a UnitCost counts in
+const unitCostScale = 1000000
+
+// UnitCost is the exact cost of one unit of stock, held to six decimal
+// places of its currency. Stock kept in grams but bought by the kilogram
+// costs fractions of a kopeck a unit, which an Amount would round away;
+// only totals worked out from a UnitCost are rounded to the minor unit.
+type UnitCost struct {
+	micros   int64
+	currency string
+}
+
+// ZeroCost returns a unit cost of nothing in the default currency
+func ZeroCost() UnitCost {
+	return UnitCost{currency: defaultCurrency}
+}
+
+// ParseUnitCost reads a decimal unit cost in the default currency in any
+// form Parse accepts. Decimals past the sixth are rounded half up.
+func ParseUnitCost(s string) (UnitCost, error) {
+	r, ok := parseDecimal(s, defaultCurrency)
+	if !ok {
+		return UnitCost{}, fmt.Errorf("money: invalid unit cost %q", s)
+	}
+	return unitCostFromRat(r, defaultCurrency, HalfUp), nil
+}
+
+// MustParseUnitCost is ParseUnitCost for constants; it panics on invalid input
+func MustParseUnitCost(s string) UnitCost {
+	c, err := ParseUnitCost(s)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func unitCostFromRat(r *big.Rat, currency string, mode Rounding) UnitCost {
+	micros := new(big.Rat).Mul(r, new(big.Rat).SetInt64(unitCostScale))
+	return UnitCost{micros: roundRat(micros, mode), currency: currency}
+}
+
+func (c UnitCost) rat() *big.Rat {
+	return new(big.Rat).SetFrac(big.NewInt(c.micros), big.NewInt(unitCostScale))
+}
+
+func (c UnitCost) currencyOrDefault() string {
+	if c.currency == "" {
+		return defaultCurrency
+	}
+	return c.currency
+}
+
+// PerUnit is the unit cost of quantity units that together cost a, e.g.
+// a 450.00 box of 5000 g at 0.09 a gram
+func (a Amount) PerUnit(quantity float64) (UnitCost, error) {
+	if quantity == 0 {
+		return UnitCost{}, errors.New("money: division by zero")
+	}
+	r := new(big.Rat).Quo(a.rat(), new(big.Rat).SetFloat64(quantity))
+	return unitCostFromRat(r, a.currencyOrDefault(), HalfEven), nil
+}
+
+// UnitCost is a as the cost of one unit
+func (a Amount) UnitCost() UnitCost {
+	return unitCostFromRat(a.rat(), a.currencyOrDefault(), HalfEven)
+}
+
+// Currency returns the ISO 4217 code of the unit cost
+func (c UnitCost) Currency() string {
+	return c.currencyOrDefault()
+}
+
+func (c UnitCost) IsZero() bool {
+	return c.micros == 0
+}
+
+// Cmp returns -1, 0 or 1 as c is less than, equal to or greater than d
+func (c UnitCost) Cmp(d UnitCost) int {
+	unitCurrency(c, d)
+	switch {
+	case c.micros < d.micros:
+		return -1
+	case c.micros > d.micros:
+		return 1
+	}
+	return 0
+}
+
+// Add sums unit costs, e.g. the ingredients of one unit of a preparation
+func (c UnitCost) Add(d UnitCost) UnitCost {
+	return UnitCost{micros: c.micros + d.micros, currency: unitCurrency(c, d)}
+}
+
+// Scale multiplies by a quantity and stays a unit cost, e.g. what the
+// 0.12 kg of chicken in one unit of a preparation adds to its cost
+func (c UnitCost) Scale(quantity float64) UnitCost {
+	r := new(big.Rat).Mul(c.rat(), new(big.Rat).SetFloat64(quantity))
+	return unitCostFromRat(r, c.currencyOrDefault(), HalfEven)
+}
+
+// Mul is what quantity units come to, rounded to the minor unit
+func (c UnitCost) Mul(quantity float64, mode Rounding) Amount {
+	r := new(big.Rat).Mul(c.rat(), new(big.Rat).SetFloat64(quantity))
+	return fromRat(r, c.currencyOrDefault(), mode)
+}
+
+// unitCurrency is sameCurrency for unit costs
+func unitCurrency(c, d UnitCost) string {
+	return sameCurrency(Amount{currency: c.currency}, Amount{currency: d.currency})
+}
+
+// WeightedAverage returns the unit cost of stockQty units at stockCost
+// merged with addQty units at addCost. Intermediate values are exact; only
+// the result is rounded, half even, so repeated receipts do not drift.
+// Stock at or below zero has nothing to merge: the units coming in cover
+// what was oversold first, and the rest is worth addCost.
+func WeightedAverage(stockCost UnitCost, stockQty float64, addCost UnitCost, addQty float64) UnitCost {
+	currency := unitCurrency(stockCost, addCost)
+	if stockQty <= 0 || stockQty+addQty <= 0 {
+		return UnitCost{micros: addCost.micros, currency: currency}
+	}
+	total := new(big.Rat).Mul(stockCost.rat(), new(big.Rat).SetFloat64(stockQty))
+	total.Add(total, new(big.Rat).Mul(addCost.rat(), new(big.Rat).SetFloat64(addQty)))
+	qty := new(big.Rat).SetFloat64(stockQty + addQty)
+	return unitCostFromRat(total.Quo(total, qty), currency, HalfEven)
+}
+
+// String formats the unit cost as a plain decimal with at least the
+// decimals of its currency and no trailing zeros past them, e.g. "4.00"
+// or "0.004"
+func (c UnitCost) String() string {
+	micros := c.micros
+	sign := ""
+	if micros < 0 {
+		sign = "-"
+		micros = -micros
+	}
+	fraction := strings.TrimRight(fmt.Sprintf("%06d", micros%unitCostScale), "0")
+	if exp := exponent(c.currencyOrDefault()); len(fraction) < exp {
+		fraction += strings.Repeat("0", exp-len(fraction))
+	}
+	whole := strconv.FormatInt(micros/unitCostScale, 10)
+	if fraction == "" {
+		return sign + whole
+	}
+	return sign + whole + "." + fraction
+}
+
+// Float64 is for ratios and display only, never for further arithmetic
+func (c UnitCost) Float64() float64 {
+	f, _ := c.rat().Float64()
+	return f
+}
+
+// MarshalJSON writes the unit cost as a decimal string, e.g. "0.004"
+func (c UnitCost) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+// UnmarshalJSON accepts a JSON number or a string in any form Parse accepts
+func (c *UnitCost) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	switch value := v.(type) {
+	case nil:
+		*c = ZeroCost()
+	case string:
+		parsed, err := ParseUnitCost(value)
+		if err != nil {
+			return err
+		}
+		*c = parsed
+	case float64:
+		r, ok := new(big.Rat).SetString(strings.TrimSpace(string(data)))
+		if !ok {
+			return fmt.Errorf("money: invalid unit cost %s", data)
+		}
+		*c = unitCostFromRat(r, defaultCurrency, HalfUp)
+	default:
+		return fmt.Errorf("money: unexpected JSON type %T", v)
+	}
+	return nil
+}
+
+// Value stores the unit cost as a decimal string for numeric columns
+func (c UnitCost) Value() (driver.Value, error) {
+	return c.String(), nil
+}
+
+// Scan reads numeric, integer and float columns
+func (c *UnitCost) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*c = ZeroCost()
+	case []byte:
+		parsed, err := ParseUnitCost(string(v))
+		if err != nil {
+			return err
+		}
+		*c = parsed
+	case string:
+		parsed, err := ParseUnitCost(v)
+		if err != nil {
+			return err
+		}
+		*c = parsed
+	case int64:
+		*c = UnitCost{micros: v * unitCostScale, currency: defaultCurrency}
+	case float64:
+		*c = unitCostFromRat(new(big.Rat).SetFloat64(v), defaultCurrency, HalfUp)
+	default:
+		return fmt.Errorf("money: cannot scan %T", src)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package orders
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"randevu-shawarma-server/users"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// EventType names an order event on the stream
+type EventType string
+
+const (
+	EventCreated         EventType = "order-created"
+	EventStatusChanged   EventType = "status-changed"
+	EventCancelled       EventType = "cancelled"
+	EventRefunded        EventType = "refunded"
+	EventPaymentReceived EventType = "payment-received"
+	// eventReset tells a client its Last-Event-ID can no longer be replayed
+	// and it should reload GET /orders
+	eventReset EventType = "reset"
+)
+
+const (
+	eventHistorySize = 1024
+	subscriberBuffer = 64
+	heartbeatEvery   = 15 * time.Second
+)
+
+// Event is one change pushed to kitchen and counter screens
+type Event struct {
+	ID    int64     `json:"id"`
+	Type  EventType `json:"type"`
+	Order OrderView `json:"order"`
+}
+
+// broker fans order events out to stream subscribers and keeps the most
+// recent ones so a reconnecting client can catch up via Last-Event-ID
+type broker struct {
+	mu          sync.Mutex
+	lastID      int64
+	history     []Event
+	subscribers map[chan Event]struct{}
+}
+
+func newBroker() *broker {
+	// Ids continue from the boot time so ids from before a restart are
+	// never mistaken for new ones
+	return &broker{
+		lastID:      time.Now().UnixNano(),
+		subscribers: map[chan Event]struct{}{},
+	}
+}
+
+func (b *broker) publish(eventType EventType, order OrderView) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event := Event{ID: b.lastID, Type: eventType, Order: order}
+	b.history = append(b.history, event)
+	if len(b.history) > eventHistorySize {
+		b.history = b.history[len(b.history)-eventHistorySize:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// Too slow; the client reconnects and replays what it missed
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe registers a subscriber and returns the events after lastID.
+// ok is false when those events are no longer kept.
+func (b *broker) subscribe(lastID int64, resume bool) (ch chan Event, missed []Event, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch = make(chan Event, subscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+	if !resume || lastID == b.lastID {
+		return ch, nil, true
+	}
+
+	if lastID > b.lastID || len(b.history) == 0 || lastID < b.history[0].ID-1 {
+		return ch, nil, false
+	}
+	for _, event := range b.history {
+		if event.ID > lastID {
+			missed = append(missed, event)
+		}
+	}
+	return ch, missed, true
+}
+
+func (b *broker) unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publish sends the current state of the order to the stream. It runs
+// after the change is committed, so a failure to load the order only
+// costs the event.
+func (s *Service) publish(r *http.Request, eventType EventType, orderID int) {
+	order, err := s.store.ByID(r.Context(), orderID)
+	if err != nil {
+		return
+	}
+	// Refunding the last line of an open order cancels it
+	if eventType == EventRefunded && order.Status == StatusCancelled {
+		eventType = EventCancelled
+	}
+	s.events.publish(eventType, order)
+}
+
+// StreamOrders pushes order events as Server-Sent Events. A client that
+// reconnects with Last-Event-ID gets the events it missed, or a reset
+// event when they are too old. The stream ends when the caller's token
+// expires or their session is revoked.
+func (s *Service) StreamOrders(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	var lastID int64
+	resume := lastEventID != ""
+	if resume {
+		var err error
+		lastID, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ch, missed, ok := s.events.subscribe(lastID, resume)
+	defer s.events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if !ok {
+		fmt.Fprintf(w, "event: %s\ndata: {}\n\n", eventReset)
+	}
+	for _, event := range missed {
+		writeEvent(w, event)
+	}
+	flusher.Flush()
+
+	// The stream outlives the check Authorize made when it opened
+	claims, _ := users.ClaimsFromContext(r.Context())
+	signedOut := s.auth.WatchSession(r.Context(), claims)
+
+	heartbeat := time.NewTicker(heartbeatEvery)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-signedOut:
+			return
+		case event, open := <-ch:
+			if !open {
+				return
+			}
+			writeEvent(w, event)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event Event) {
+	data, err := json.Marshal(event.Order)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
@@ -1,92 +1,57 @@
 package orders
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"randevu-shawarma-server/money"
 	"randevu-shawarma-server/users"
+	"randevu-shawarma-server/warehouse"
 
 	"github.com/julienschmidt/httprouter"
 )
 
-var db *sql.DB
+type Service struct {
+	store  Store
+	auth   *users.Service
+	menu   Menu
+	events *broker
+}
 
-// SetDatabase sets the database connection
-func SetDatabase(database *sql.DB) {
-	db = database
+func NewService(store Store, auth *users.Service, menu Menu) *Service {
+	return &Service{store: store, auth: auth, menu: menu, events: newBroker()}
 }
 
 // RegisterRoutes registers all orders routes
-func RegisterRoutes(router *httprouter.Router) {
-	router.GET("/orders", users.Authenticate(GetOrders))
-	router.POST("/orders", users.Authenticate(CreateOrder))
-	router.PUT("/orders", users.Authenticate(UpdateOrder))
+func (s *Service) RegisterRoutes(router *httprouter.Router) {
+	router.GET("/orders", s.auth.Authorize(users.PermOrdersRead)(s.GetOrders))
+	router.GET("/orders/stream", s.auth.Authorize(users.PermOrdersRead)(s.StreamOrders))
+	router.POST("/orders", s.auth.Authorize(users.PermOrdersCreate)(s.CreateOrder))
+	router.PUT("/orders", s.auth.Authorize(users.PermOrdersUpdate)(s.UpdateOrder))
+	router.PATCH("/orders/:id/status", s.auth.Authorize(users.PermOrdersUpdate)(s.UpdateOrderStatus))
+	router.POST("/orders/:id/cancel", s.auth.Authorize(users.PermOrdersCancel)(s.CancelOrder))
+	router.POST("/orders/:id/refunds", s.auth.Authorize(users.PermOrdersRefund)(s.CreateRefund))
+	router.POST("/orders/:id/payments", s.auth.Authorize(users.PermOrdersPay)(s.PayOrder))
 }
 
-func GetOrders(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	query := `
-		SELECT o.id, o.user_id, o.name, SUM(d.price * odr.quantity) AS total_price
-		FROM public."Orders" o
-		JOIN public."Order_dish_relations" odr ON o.id = odr.order_id
-		JOIN public."Dishes" d ON odr.dish_id = d.id
-		WHERE o.processing = true
-		GROUP BY o.id, o.user_id, o.name
-	`
-	rows, err := db.Query(query)
+func (s *Service) GetOrders(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	orders, err := s.store.ListActive(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var orders []OrderView
-	for rows.Next() {
-		var order OrderView
-		err := rows.Scan(&order.ID, &order.UserID, &order.Name, &order.TotalPrice)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		dishQuery := `
-			SELECT d.id, d.name, odr.quantity, d.price
-			FROM public."Order_dish_relations" odr
-			JOIN public."Dishes" d ON odr.dish_id = d.id
-			WHERE odr.order_id = $1
-		`
-		dishRows, err := db.Query(dishQuery, order.ID)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		defer dishRows.Close()
-
-		var dishes []OrderDishRelationView
-		for dishRows.Next() {
-			var dish OrderDishRelationView
-			err := dishRows.Scan(&dish.DishID, &dish.DishName, &dish.Quantity, &dish.Price)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			dishes = append(dishes, dish)
-		}
-		order.Dishes = dishes
-		orders = append(orders, order)
-	}
-
-	if err := rows.Err(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(orders)
 }
 
-func CreateOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (s *Service) CreateOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	var newOrder Order
 	err := json.NewDecoder(r.Body).Decode(&newOrder)
 	if err != nil {
@@ -94,44 +59,37 @@ func CreateOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		return
 	}
 
-	tx, err := db.Begin()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	// Orders are booked to whoever is signed in; only managers may book
+	// one for someone else
+	claims, _ := users.ClaimsFromContext(r.Context())
+	if newOrder.UserID == 0 || !claims.Can(users.PermShiftsManage) {
+		newOrder.UserID = claims.UserID
 	}
-	defer tx.Rollback()
+	newOrder.CreatedAt = time.Now()
+	newOrder.Status = StatusNew
 
-	// Insert new order
-	err = tx.QueryRow(
-		"INSERT INTO public.\"Orders\" (user_id, name, created_at, processing, sold) VALUES ($1, $2, $3, $4, $5) RETURNING id",
-		newOrder.UserID, newOrder.Name, time.Now(), true, false,
-	).Scan(&newOrder.ID)
-	if err != nil {
+	err = s.priceLines(r.Context(), newOrder.Dishes)
+	if errors.Is(err, ErrInvalidOrder) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Insert order dishes
-	for _, dish := range newOrder.Dishes {
-		_, err = tx.Exec(
-			"INSERT INTO public.\"Order_dish_relations\" (order_id, dish_id, quantity) VALUES ($1, $2, $3)",
-			newOrder.ID, dish.DishID, dish.Quantity,
-		)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	}
-
-	err = tx.Commit()
+	err = s.store.Create(r.Context(), &newOrder)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	GetOrders(w, r, ps)
+	s.publish(r, EventCreated, newOrder.ID)
+	s.GetOrders(w, r, ps)
 }
 
-func UpdateOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+// UpdateOrder is the original counter flow kept for existing clients:
+// sold=true walks a fully paid order through to picked_up, sold=false cancels it
+// and returns its stock
+func (s *Service) UpdateOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	var updateData struct {
 		OrderID int  `json:"orderId"`
 		Sold    bool `json:"sold"`
@@ -142,89 +100,201 @@ func UpdateOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		return
 	}
 
-	tx, err := db.Begin()
+	claims, _ := users.ClaimsFromContext(r.Context())
+	eventType := EventStatusChanged
+	if updateData.Sold {
+		err = s.advance(r.Context(), updateData.OrderID, StatusPickedUp, claims.UserID)
+	} else {
+		eventType = EventCancelled
+		if !claims.Can(users.PermOrdersCancel) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		err = s.cancel(r.Context(), updateData.OrderID, RefundRequest{}, claims.UserID)
+	}
+	if !writeStatusError(w, r, err) {
+		return
+	}
+	s.publish(r, eventType, updateData.OrderID)
+
+	s.GetOrders(w, r, ps)
+}
+
+func (s *Service) UpdateOrderStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	// Reason and StockAction apply when the order is cancelled or refunded
+	var body struct {
+		Status      Status      `json:"status"`
+		Reason      string      `json:"reason"`
+		StockAction StockAction `json:"stockAction"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !body.Status.Valid() {
+		http.Error(w, "Invalid status", http.StatusBadRequest)
 		return
 	}
-	defer tx.Rollback()
 
-	if updateData.Sold {
-		// Fetch products
-		query := `
-		WITH product_quantities AS (
-			SELECT dr.product_id, SUM(dr.quantity * odr.quantity) AS total_quantity
-			FROM public."Order_dish_relations" odr
-			INNER JOIN public."Dish_recipe" dr ON odr.dish_id = dr.dish_id
-			WHERE odr.order_id = $1
-			GROUP BY dr.product_id
-			UNION ALL
-			SELECT pr.product_id, SUM(pr.quantity * odr.quantity) AS total_quantity
-			FROM public."Order_dish_relations" odr
-			INNER JOIN public."Dishes_Preparations" dp ON odr.dish_id = dp.dishes_id
-			INNER JOIN public."Preparation_recipe" pr ON dp.preparations_id = pr.preparation_id
-			WHERE odr.order_id = $1
-			GROUP BY pr.product_id
-		)
-		SELECT product_id, SUM(total_quantity) AS total_quantity
-		FROM product_quantities
-		GROUP BY product_id
-		`
-
-		rows, err := db.Query(query, updateData.OrderID)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	claims, _ := users.ClaimsFromContext(r.Context())
+	req := RefundRequest{Reason: body.Reason, StockAction: body.StockAction}
+	eventType := EventStatusChanged
+	switch body.Status {
+	case StatusCancelled:
+		eventType = EventCancelled
+		if !claims.Can(users.PermOrdersCancel) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
-		defer rows.Close()
+		err = s.cancel(r.Context(), id, req, claims.UserID)
+	case StatusRefunded:
+		eventType = EventRefunded
+		if !claims.Can(users.PermOrdersRefund) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		// a refund needs a reason whichever endpoint it comes through
+		if strings.TrimSpace(req.Reason) == "" {
+			http.Error(w, "Reason is required", http.StatusBadRequest)
+			return
+		}
+		err = s.store.InTx(r.Context(), func(tx Tx) error {
+			_, err := s.refund(r.Context(), tx, id, req, claims.UserID)
+			return err
+		})
+	default:
+		err = s.changeStatus(r.Context(), id, body.Status, claims.UserID)
+	}
+	if !writeStatusError(w, r, err) {
+		return
+	}
+	s.publish(r, eventType, id)
 
-		var productID int
-		var totalQuantity float64
+	s.writeOrder(w, r, id)
+}
 
-		// Update warehouse inventory
-		for rows.Next() {
-			err := rows.Scan(&productID, &totalQuantity)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+func (s *Service) writeOrder(w http.ResponseWriter, r *http.Request, id int) {
+	order, err := s.store.ByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+}
+
+// writeStatusError answers for a failed status change and reports whether err was nil
+func writeStatusError(w http.ResponseWriter, r *http.Request, err error) bool {
+	var transitionErr *TransitionError
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, warehouse.ErrInsufficientStock):
+		warehouse.WriteShortageError(w, err)
+	case errors.As(err, &transitionErr), errors.Is(err, ErrNothingToRefund),
+		errors.Is(err, ErrNotPaid), errors.Is(err, ErrAlreadyPaid), errors.Is(err, ErrNoOpenShift):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrInvalidRefund), errors.Is(err, ErrInvalidPayment):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
+
+// changeStatus moves the order to next if the state machine allows it
+func (s *Service) changeStatus(ctx context.Context, orderID int, next Status, userID int) error {
+	return s.store.InTx(ctx, func(tx Tx) error {
+		return s.transition(ctx, tx, orderID, next, userID)
+	})
+}
 
-			_, err = tx.Exec(
-				"UPDATE public.\"Warehouse\" SET current_stock = current_stock - $1 WHERE product_id = $2",
-				totalQuantity, productID,
-			)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+// advance moves the order forward through every intermediate status up
+// to target, in one transaction
+func (s *Service) advance(ctx context.Context, orderID int, target Status, userID int) error {
+	return s.store.InTx(ctx, func(tx Tx) error {
+		state, err := tx.LockOrder(ctx, orderID)
+		if err != nil {
+			return err
+		}
+		path := state.Status.pathTo(target)
+		if path == nil {
+			return &TransitionError{From: state.Status, To: target}
+		}
+		for _, next := range path {
+			if err := s.transition(ctx, tx, orderID, next, userID); err != nil {
+				return err
 			}
 		}
+		return nil
+	})
+}
 
-		// Update order status
-		_, err = tx.Exec(
-			"UPDATE public.\"Orders\" SET processing = $1, sold = $2 WHERE id = $3",
-			false, true, updateData.OrderID,
-		)
+// transition performs one status change inside tx. Ingredients are taken
+// from the warehouse when the order enters a status that consumes them,
+// and never twice for the same order.
+func (s *Service) transition(ctx context.Context, tx Tx, orderID int, next Status, userID int) error {
+	state, err := tx.LockOrder(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if !state.Status.CanTransitionTo(next) {
+		return &TransitionError{From: state.Status, To: next}
+	}
+	if next.RequiresPayment() {
+		due, err := amountDue(ctx, tx, orderID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return err
 		}
-	} else {
-		// Update order status to processing false
-		_, err = tx.Exec(
-			"UPDATE public.\"Orders\" SET processing = $1 WHERE id = $2",
-			false, updateData.OrderID,
-		)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		if due.Cmp(money.Zero()) > 0 {
+			return fmt.Errorf("%w: %s due", ErrNotPaid, due)
 		}
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	now := time.Now()
+	if next.ConsumesStock() && !state.StockDeducted {
+		usage, err := tx.RecipeUsage(ctx, orderID)
+		if err != nil {
+			return err
+		}
+
+		// Update warehouse inventory as each product's stock policy allows
+		quantities := map[int]float64{}
+		for _, u := range usage {
+			quantities[u.ProductID] += u.QuantityPerUnit * float64(u.Units)
+		}
+		err = tx.Take(ctx, quantities, warehouse.Source{
+			Type:   warehouse.SourceOrder,
+			ID:     orderID,
+			UserID: userID,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.RecordUsage(ctx, usage)
+		if err != nil {
+			return err
+		}
+		err = tx.MarkStockDeducted(ctx, orderID, now)
+		if err != nil {
+			return err
+		}
 	}
 
-	GetOrders(w, r, ps)
+	return tx.SetStatus(ctx, orderID, StatusChange{
+		From:      state.Status,
+		To:        next,
+		UserID:    userID,
+		ChangedAt: now,
+	})
 }
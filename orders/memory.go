@@ -0,0 +1,395 @@
+package orders
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/warehouse"
+)
+
+// MemoryDish is the menu data the in-memory store needs to show orders
+// and compute their ingredient usage; prices come with the order lines
+type MemoryDish struct {
+	ID     int
+	Name   string
+	Recipe map[int]float64
+}
+
+// MemoryStore keeps orders in memory and moves stock in the shared
+// warehouse.MemoryStore
+type MemoryStore struct {
+	mu        sync.Mutex
+	stock     *warehouse.MemoryStore
+	dishes    map[int]MemoryDish
+	orders    map[int]memoryOrder
+	writeOffs map[int]map[int]float64
+	// openShift stands in for the shifts table; see SetShiftLookup
+	openShift func(userID int) (int, bool)
+	// ids are handed out like database sequences, one per table, and are
+	// not reused when a transaction rolls back
+	nextID         int
+	nextLineID     int
+	nextRefundID   int
+	nextWriteOffID int
+	nextPaymentID  int
+}
+
+type memoryOrder struct {
+	Order
+	lines         []memoryLine
+	stockDeducted bool
+	history       []StatusChange
+	refunds       []Refund
+	payments      []Payment
+}
+
+type memoryLine struct {
+	ID       int
+	DishID   int
+	Quantity int
+	Refunded int
+	// unitPrice and modifiers are what the line was sold with
+	unitPrice money.Amount
+	modifiers []LineModifier
+	// usage is what one unit took from the warehouse
+	usage map[int]float64
+}
+
+func NewMemoryStore(stock *warehouse.MemoryStore) *MemoryStore {
+	return &MemoryStore{
+		stock:          stock,
+		dishes:         map[int]MemoryDish{},
+		orders:         map[int]memoryOrder{},
+		writeOffs:      map[int]map[int]float64{},
+		nextID:         1,
+		nextLineID:     1,
+		nextRefundID:   1,
+		nextWriteOffID: 1,
+		nextPaymentID:  1,
+	}
+}
+
+// WriteOffs returns the products booked as written off by refunds, by write-off id
+func (m *MemoryStore) WriteOffs() map[int]map[int]float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := map[int]map[int]float64{}
+	for id, products := range m.writeOffs {
+		out[id] = products
+	}
+	return out
+}
+
+// SetShiftLookup tells the store how to find the open shift of a user,
+// which lives in another package's store
+func (m *MemoryStore) SetShiftLookup(lookup func(userID int) (int, bool)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.openShift = lookup
+}
+
+// Payments returns a copy of every committed payment
+func (m *MemoryStore) Payments() []Payment {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var payments []Payment
+	for _, o := range m.orders {
+		payments = append(payments, o.payments...)
+	}
+	sort.Slice(payments, func(i, j int) bool { return payments[i].ID < payments[j].ID })
+	return payments
+}
+
+// Refunds returns a copy of every committed refund
+func (m *MemoryStore) Refunds() []Refund {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var refunds []Refund
+	for _, o := range m.orders {
+		refunds = append(refunds, o.refunds...)
+	}
+	sort.Slice(refunds, func(i, j int) bool { return refunds[i].ID < refunds[j].ID })
+	return refunds
+}
+
+func nextSeq(seq *int) int {
+	id := *seq
+	*seq++
+	return id
+}
+
+// PutDish adds or replaces a dish; Recipe maps product id to quantity
+// with preparations already exploded
+func (m *MemoryStore) PutDish(d MemoryDish) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dishes[d.ID] = d
+}
+
+func (m *MemoryStore) view(o memoryOrder) OrderView {
+	view := OrderView{ID: o.ID, UserID: o.UserID, Name: o.Name, Status: o.Status, TotalPrice: money.Zero(), Paid: paidAmount(o)}
+	for _, line := range o.lines {
+		dish := m.dishes[line.DishID]
+		view.TotalPrice = view.TotalPrice.Add(line.unitPrice.MulInt(int64(line.Quantity - line.Refunded)))
+		view.Dishes = append(view.Dishes, OrderDishRelationView{
+			LineID:           line.ID,
+			DishID:           dish.ID,
+			DishName:         dish.Name,
+			Quantity:         line.Quantity,
+			RefundedQuantity: line.Refunded,
+			Price:            line.unitPrice,
+			Modifiers:        line.modifiers,
+		})
+	}
+	return view
+}
+
+func (m *MemoryStore) ListActive(ctx context.Context) ([]OrderView, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var views []OrderView
+	for _, o := range m.orders {
+		if !o.Status.Active() || len(o.lines) == 0 {
+			continue
+		}
+		views = append(views, m.view(o))
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].ID < views[j].ID })
+	return views, nil
+}
+
+func (m *MemoryStore) ByID(ctx context.Context, id int) (OrderView, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.orders[id]
+	if !ok {
+		return OrderView{}, ErrNotFound
+	}
+	view := m.view(o)
+	view.History = append([]StatusChange(nil), o.history...)
+	view.Refunds = append([]Refund(nil), o.refunds...)
+	view.Payments = append([]Payment(nil), o.payments...)
+	return view, nil
+}
+
+func (m *MemoryStore) Create(ctx context.Context, o *Order) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o.ID = nextSeq(&m.nextID)
+	stored := memoryOrder{Order: *o}
+	stored.Dishes = nil
+	for _, d := range o.Dishes {
+		stored.lines = append(stored.lines, memoryLine{
+			ID:        nextSeq(&m.nextLineID),
+			DishID:    d.DishID,
+			Quantity:  d.Quantity,
+			unitPrice: d.UnitPrice,
+			modifiers: d.Selected,
+		})
+	}
+	stored.history = []StatusChange{{To: o.Status, UserID: o.UserID, ChangedAt: o.CreatedAt}}
+	m.orders[o.ID] = stored
+	return nil
+}
+
+func (m *MemoryStore) InTx(ctx context.Context, fn func(Tx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.stock.Tx(func(stock warehouse.StockTx) error {
+		tx := &memoryTx{StockTx: stock, store: m, orders: map[int]memoryOrder{}, writeOffs: map[int]map[int]float64{}}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		for id, o := range tx.orders {
+			m.orders[id] = o
+		}
+		for id, products := range tx.writeOffs {
+			m.writeOffs[id] = products
+		}
+		return nil
+	})
+}
+
+// memoryTx buffers order changes until the transaction commits
+type memoryTx struct {
+	warehouse.StockTx
+	store     *MemoryStore
+	orders    map[int]memoryOrder
+	writeOffs map[int]map[int]float64
+}
+
+func (t *memoryTx) order(id int) (memoryOrder, bool) {
+	if o, ok := t.orders[id]; ok {
+		return o, true
+	}
+	o, ok := t.store.orders[id]
+	if ok {
+		o.lines = append([]memoryLine(nil), o.lines...)
+		o.history = append([]StatusChange(nil), o.history...)
+		o.refunds = append([]Refund(nil), o.refunds...)
+		o.payments = append([]Payment(nil), o.payments...)
+	}
+	return o, ok
+}
+
+func (t *memoryTx) LockOrder(ctx context.Context, orderID int) (OrderState, error) {
+	o, ok := t.order(orderID)
+	if !ok {
+		return OrderState{}, ErrNotFound
+	}
+	return OrderState{Status: o.Status, StockDeducted: o.stockDeducted}, nil
+}
+
+func (t *memoryTx) Lines(ctx context.Context, orderID int) ([]OrderLine, error) {
+	o, _ := t.order(orderID)
+	var lines []OrderLine
+	for _, line := range o.lines {
+		lines = append(lines, OrderLine{
+			ID:               line.ID,
+			DishID:           line.DishID,
+			Quantity:         line.Quantity,
+			RefundedQuantity: line.Refunded,
+			Price:            line.unitPrice,
+		})
+	}
+	return lines, nil
+}
+
+func (t *memoryTx) RecipeUsage(ctx context.Context, orderID int) ([]LineUsage, error) {
+	o, _ := t.order(orderID)
+	var usage []LineUsage
+	for _, line := range o.lines {
+		perUnit := map[int]float64{}
+		for productID, quantity := range t.store.dishes[line.DishID].Recipe {
+			perUnit[productID] += quantity
+		}
+		for _, modifier := range line.modifiers {
+			for _, r := range modifier.recipe {
+				perUnit[r.ProductID] += r.Quantity
+			}
+		}
+		for productID, quantity := range perUnit {
+			if quantity > 0 {
+				usage = append(usage, LineUsage{LineID: line.ID, ProductID: productID, QuantityPerUnit: quantity, Units: line.Quantity - line.Refunded})
+			}
+		}
+	}
+	return usage, nil
+}
+
+func (t *memoryTx) RecordUsage(ctx context.Context, usage []LineUsage) error {
+	for _, u := range usage {
+		for id := range t.store.orders {
+			o, _ := t.order(id)
+			for i, line := range o.lines {
+				if line.ID != u.LineID {
+					continue
+				}
+				// copy, the committed line shares its map
+				recorded := map[int]float64{u.ProductID: u.QuantityPerUnit}
+				for productID, quantity := range line.usage {
+					if productID != u.ProductID {
+						recorded[productID] = quantity
+					}
+				}
+				o.lines[i].usage = recorded
+				t.orders[id] = o
+			}
+		}
+	}
+	return nil
+}
+
+func (t *memoryTx) DeductedUsage(ctx context.Context, orderID int) ([]LineUsage, error) {
+	o, _ := t.order(orderID)
+	var usage []LineUsage
+	for _, line := range o.lines {
+		for productID, quantity := range line.usage {
+			usage = append(usage, LineUsage{LineID: line.ID, ProductID: productID, QuantityPerUnit: quantity, Units: line.Quantity - line.Refunded})
+		}
+	}
+	return usage, nil
+}
+
+func (t *memoryTx) InsertRefund(ctx context.Context, refund *Refund) error {
+	o, ok := t.order(refund.OrderID)
+	if !ok {
+		return ErrNotFound
+	}
+	refund.ID = nextSeq(&t.store.nextRefundID)
+	for _, refunded := range refund.Lines {
+		for i := range o.lines {
+			if o.lines[i].ID == refunded.LineID {
+				o.lines[i].Refunded += refunded.Quantity
+			}
+		}
+	}
+	o.refunds = append(o.refunds, *refund)
+	t.orders[refund.OrderID] = o
+	return nil
+}
+
+func paidAmount(o memoryOrder) money.Amount {
+	paid := money.Zero()
+	for _, payment := range o.payments {
+		paid = paid.Add(payment.Amount)
+	}
+	return paid
+}
+
+func (t *memoryTx) OpenShift(ctx context.Context, userID int) (int, bool, error) {
+	if t.store.openShift == nil {
+		return 0, false, nil
+	}
+	id, ok := t.store.openShift(userID)
+	return id, ok, nil
+}
+
+func (t *memoryTx) PaidAmount(ctx context.Context, orderID int) (money.Amount, error) {
+	o, _ := t.order(orderID)
+	return paidAmount(o), nil
+}
+
+func (t *memoryTx) InsertPayment(ctx context.Context, payment *Payment) error {
+	o, ok := t.order(payment.OrderID)
+	if !ok {
+		return ErrNotFound
+	}
+	payment.ID = nextSeq(&t.store.nextPaymentID)
+	o.payments = append(o.payments, *payment)
+	t.orders[payment.OrderID] = o
+	return nil
+}
+
+func (t *memoryTx) InsertWriteOff(ctx context.Context, userID int, notes string, at time.Time, products map[int]float64) (int, error) {
+	id := nextSeq(&t.store.nextWriteOffID)
+	t.writeOffs[id] = products
+	return id, nil
+}
+
+func (t *memoryTx) MarkStockDeducted(ctx context.Context, orderID int, at time.Time) error {
+	if o, ok := t.order(orderID); ok {
+		o.stockDeducted = true
+		t.orders[orderID] = o
+	}
+	return nil
+}
+
+func (t *memoryTx) SetStatus(ctx context.Context, orderID int, change StatusChange) error {
+	if o, ok := t.order(orderID); ok {
+		o.Status = change.To
+		o.history = append(o.history, change)
+		t.orders[orderID] = o
+	}
+	return nil
+}
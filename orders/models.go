@@ -2,35 +2,167 @@ package orders
 
 import (
 	"time"
+
+	"randevu-shawarma-server/dishes"
+	"randevu-shawarma-server/money"
 )
 
 type Order struct {
-	ID         int                 `json:"id"`
-	UserID     int                 `json:"userId"`
-	Name       string              `json:"name"`
-	CreatedAt  time.Time           `json:"createdAt"`
-	Processing bool                `json:"processing"`
-	Sold       bool                `json:"sold"`
-	Dishes     []OrderDishRelation `json:"dishes"`
+	ID        int                 `json:"id"`
+	UserID    int                 `json:"userId"`
+	Name      string              `json:"name"`
+	CreatedAt time.Time           `json:"createdAt"`
+	Status    Status              `json:"status"`
+	Dishes    []OrderDishRelation `json:"dishes"`
 }
 
 type OrderDishRelation struct {
 	OrderID  int `json:"orderId"`
 	DishID   int `json:"dishId"`
 	Quantity int `json:"quantity"`
+	// Modifiers are the ids of the modifiers chosen for every unit
+	Modifiers []int `json:"modifiers,omitempty"`
+
+	// UnitPrice and Selected are filled in from the menu when the order is taken
+	UnitPrice money.Amount   `json:"-"`
+	Selected  []LineModifier `json:"-"`
+}
+
+// LineModifier is a modifier as it was sold on an order line
+type LineModifier struct {
+	ModifierID int          `json:"modifierId"`
+	Name       string       `json:"name"`
+	PriceDelta money.Amount `json:"priceDelta"`
+
+	// recipe is kept for the in-memory store, which has no menu to look in
+	recipe []dishes.RecipeLine
 }
 
+// OrderDishRelationView is an order line; Price is the unit price it was
+// sold at, modifiers included
 type OrderDishRelationView struct {
-	DishID   int    `json:"dishId"`
-	DishName string `json:"name"`
-	Quantity int    `json:"quantity"`
-	Price    string `json:"price"`
+	LineID           int            `json:"lineId"`
+	DishID           int            `json:"dishId"`
+	DishName         string         `json:"name"`
+	Quantity         int            `json:"quantity"`
+	RefundedQuantity int            `json:"refundedQuantity"`
+	Price            money.Amount   `json:"price"`
+	Modifiers        []LineModifier `json:"modifiers,omitempty"`
 }
 
 type OrderView struct {
 	ID         int                     `json:"id"`
 	UserID     int                     `json:"userId"`
 	Name       string                  `json:"name"`
-	TotalPrice string                  `json:"TotalPrice"`
+	Status     Status                  `json:"status"`
+	TotalPrice money.Amount            `json:"TotalPrice"`
+	Paid       money.Amount            `json:"paid"`
 	Dishes     []OrderDishRelationView `json:"dishes"`
+	History    []StatusChange          `json:"history,omitempty"`
+	Refunds    []Refund                `json:"refunds,omitempty"`
+	Payments   []Payment               `json:"payments,omitempty"`
+}
+
+// StatusChange records one transition; From is empty for the creation
+type StatusChange struct {
+	From      Status    `json:"from,omitempty"`
+	To        Status    `json:"to"`
+	UserID    int       `json:"userId"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+// OrderState is what a transition needs to know about the locked order
+type OrderState struct {
+	Status        Status
+	StockDeducted bool
+}
+
+// StockAction says what happens to the ingredients of refunded food
+type StockAction string
+
+const (
+	// StockNone is used when nothing had been taken from the warehouse yet
+	StockNone StockAction = "none"
+	// StockReturn puts the ingredients back into the warehouse
+	StockReturn StockAction = "return"
+	// StockWriteOff books the ingredients as a write-off, for food already cooked
+	StockWriteOff StockAction = "write_off"
+)
+
+type Refund struct {
+	ID          int          `json:"id"`
+	OrderID     int          `json:"orderId"`
+	UserID      int          `json:"userId"`
+	Reason      string       `json:"reason"`
+	StockAction StockAction  `json:"stockAction"`
+	WriteOffID  *int         `json:"writeOffId"`
+	Amount      money.Amount `json:"amount"`
+	// PaidOut is the money handed back, the part of Amount the customer had paid
+	PaidOut      money.Amount  `json:"paidOut"`
+	PayoutMethod PaymentMethod `json:"payoutMethod,omitempty"`
+	ShiftID      *int          `json:"shiftId"`
+	CreatedAt    time.Time     `json:"createdAt"`
+	Lines        []RefundLine  `json:"lines"`
+}
+
+type RefundLine struct {
+	LineID   int `json:"lineId"`
+	Quantity int `json:"quantity"`
+}
+
+// OrderLine is an order line as refunds see it
+type OrderLine struct {
+	ID               int
+	DishID           int
+	Quantity         int
+	RefundedQuantity int
+	Price            money.Amount
+}
+
+// LineUsage is how much of a product one unit of an order line needs
+type LineUsage struct {
+	LineID          int
+	ProductID       int
+	QuantityPerUnit float64
+	// Units is the part of the line that has not been refunded
+	Units int
+}
+
+// RefundRequest selects what to refund; no lines means everything left.
+// PayoutMethod says how paid money goes back and defaults to cash.
+type RefundRequest struct {
+	Reason       string        `json:"reason"`
+	StockAction  StockAction   `json:"stockAction"`
+	PayoutMethod PaymentMethod `json:"payoutMethod"`
+	Lines        []RefundLine  `json:"lines"`
+}
+
+type PaymentMethod string
+
+const (
+	PaymentCash PaymentMethod = "cash"
+	PaymentCard PaymentMethod = "card"
+)
+
+// Payment is money taken for an order. Amount counts towards the order;
+// for cash Tendered is what the customer handed over and Change what they
+// got back.
+type Payment struct {
+	ID        int           `json:"id"`
+	OrderID   int           `json:"orderId"`
+	UserID    int           `json:"userId"`
+	Method    PaymentMethod `json:"method"`
+	Amount    money.Amount  `json:"amount"`
+	Tendered  *money.Amount `json:"tendered,omitempty"`
+	Change    money.Amount  `json:"change"`
+	ShiftID   *int          `json:"shiftId"`
+	CreatedAt time.Time     `json:"createdAt"`
+}
+
+// PaymentRequest takes one payment. Amount defaults to what is still due;
+// cash may instead give only Tendered.
+type PaymentRequest struct {
+	Method   PaymentMethod `json:"method"`
+	Amount   *money.Amount `json:"amount"`
+	Tendered *money.Amount `json:"tendered"`
 }
@@ -0,0 +1,93 @@
+package orders
+
+import (
+	"context"
+	"fmt"
+
+	"randevu-shawarma-server/dishes"
+)
+
+// priceLines checks every line against the menu and fills in its unit
+// price and chosen modifiers. Each modifier group of the dish must get
+// between its minimum and maximum number of choices.
+func (s *Service) priceLines(ctx context.Context, lines []OrderDishRelation) error {
+	if len(lines) == 0 {
+		return fmt.Errorf("%w: no dishes", ErrInvalidOrder)
+	}
+
+	ids := make([]int, 0, len(lines))
+	for _, line := range lines {
+		ids = append(ids, line.DishID)
+	}
+	menu, err := s.menu.DishesByID(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range lines {
+		line := &lines[i]
+		dish, ok := menu[line.DishID]
+		if !ok || !dish.Active {
+			return fmt.Errorf("%w: dish %d is not on the menu", ErrInvalidOrder, line.DishID)
+		}
+		if line.Quantity <= 0 {
+			return fmt.Errorf("%w: quantity of dish %d must be positive", ErrInvalidOrder, line.DishID)
+		}
+
+		selected, err := chooseModifiers(dish, line.Modifiers)
+		if err != nil {
+			return err
+		}
+		line.Selected = selected
+		line.UnitPrice = dish.Price
+		for _, m := range selected {
+			line.UnitPrice = line.UnitPrice.Add(m.PriceDelta)
+		}
+		if line.UnitPrice.IsNegative() {
+			return fmt.Errorf("%w: modifiers make %s cost less than nothing", ErrInvalidOrder, dish.Name)
+		}
+	}
+	return nil
+}
+
+func chooseModifiers(dish dishes.DishItem, chosen []int) ([]LineModifier, error) {
+	counts := map[int]int{}
+	seen := map[int]bool{}
+	var selected []LineModifier
+
+	for _, id := range chosen {
+		if seen[id] {
+			return nil, fmt.Errorf("%w: modifier %d chosen twice for %s", ErrInvalidOrder, id, dish.Name)
+		}
+		seen[id] = true
+
+		found := false
+		for _, group := range dish.ModifierGroups {
+			m, ok := group.Modifier(id)
+			if !ok {
+				continue
+			}
+			if !m.Active {
+				return nil, fmt.Errorf("%w: %s is no longer offered", ErrInvalidOrder, m.Name)
+			}
+			counts[group.ID]++
+			selected = append(selected, LineModifier{ModifierID: m.ID, Name: m.Name, PriceDelta: m.PriceDelta, recipe: m.Recipe})
+			found = true
+			break
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: modifier %d is not offered with %s", ErrInvalidOrder, id, dish.Name)
+		}
+	}
+
+	for _, group := range dish.ModifierGroups {
+		n := counts[group.ID]
+		if n < group.MinSelect {
+			return nil, fmt.Errorf("%w: choose at least %d from %s for %s", ErrInvalidOrder, group.MinSelect, group.Name, dish.Name)
+		}
+		if group.MaxSelect != nil && n > *group.MaxSelect {
+			return nil, fmt.Errorf("%w: choose at most %d from %s for %s", ErrInvalidOrder, *group.MaxSelect, group.Name, dish.Name)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,113 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/warehouse"
+)
+
+// kitchen returns an order store selling shawarma (0.12 chicken and one
+// lavash) over a warehouse with 1 chicken and 10 lavash
+func kitchen(t *testing.T) (*MemoryStore, *warehouse.MemoryStore) {
+	t.Helper()
+	stock := warehouse.NewMemoryStore(warehouse.CostingAverage)
+	stock.AddProduct(1, "chicken")
+	stock.AddProduct(2, "lavash")
+	err := stock.Tx(func(tx warehouse.StockTx) error {
+		src := warehouse.Source{Type: warehouse.SourceSupply, ID: 1, UserID: 1}
+		if _, err := tx.Receive(context.Background(), 1, 1, money.MustParseUnitCost("400"), src, nil); err != nil {
+			return err
+		}
+		_, err := tx.Receive(context.Background(), 2, 10, money.MustParseUnitCost("0.40"), src, nil)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewMemoryStore(stock)
+	store.PutDish(MemoryDish{ID: 1, Name: "shawarma", Recipe: map[int]float64{1: 0.12, 2: 1}})
+	return store, stock
+}
+
+func levels(t *testing.T, stock *warehouse.MemoryStore) map[int]float64 {
+	t.Helper()
+	items, err := stock.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels := map[int]float64{}
+	for _, item := range items {
+		levels[item.ProductID] = item.CurrentStock
+	}
+	return levels
+}
+
+func newOrder(t *testing.T, store *MemoryStore, quantity int) int {
+	t.Helper()
+	order := Order{
+		UserID:    1,
+		Name:      "counter",
+		Status:    StatusNew,
+		CreatedAt: time.Now(),
+		Dishes:    []OrderDishRelation{{DishID: 1, Quantity: quantity, UnitPrice: money.MustParse("3.50")}},
+	}
+	if err := store.Create(context.Background(), &order); err != nil {
+		t.Fatal(err)
+	}
+	return order.ID
+}
+
+func TestCookingTakesStockOnce(t *testing.T) {
+	ctx := context.Background()
+	store, stock := kitchen(t)
+	s := NewService(store, nil, nil)
+	id := newOrder(t, store, 2)
+
+	if got := levels(t, stock); got[1] != 1 || got[2] != 10 {
+		t.Fatalf("creating the order moved stock: %v", got)
+	}
+
+	if err := s.advance(ctx, id, StatusCooking, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := levels(t, stock); got[1] != 0.76 || got[2] != 8 {
+		t.Errorf("stock after cooking %v, want 0.76 chicken and 8 lavash", got)
+	}
+
+	if err := s.changeStatus(ctx, id, StatusReady, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := levels(t, stock); got[1] != 0.76 || got[2] != 8 {
+		t.Errorf("stock taken twice: %v", got)
+	}
+}
+
+func TestCookingBlockedRollsBack(t *testing.T) {
+	ctx := context.Background()
+	store, stock := kitchen(t)
+	if err := stock.SetPolicy(ctx, 2, warehouse.PolicyBlock); err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(store, nil, nil)
+	id := newOrder(t, store, 11)
+
+	err := s.advance(ctx, id, StatusCooking, 1)
+	if !errors.Is(err, warehouse.ErrInsufficientStock) {
+		t.Fatalf("got %v, want %v", err, warehouse.ErrInsufficientStock)
+	}
+	if got := levels(t, stock); got[1] != 1 || got[2] != 10 {
+		t.Errorf("a blocked order moved stock: %v", got)
+	}
+	order, err := store.ByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != StatusNew {
+		t.Errorf("status %s, want %s", order.Status, StatusNew)
+	}
+}
@@ -0,0 +1,152 @@
+package orders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/users"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// PayOrder takes one payment towards an order. Several payments, in any
+// mix of methods, may settle one order.
+func (s *Service) PayOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	var req PaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := users.ClaimsFromContext(r.Context())
+	var payment Payment
+	err = s.store.InTx(r.Context(), func(tx Tx) error {
+		payment, err = s.pay(r.Context(), tx, id, req, claims.UserID)
+		return err
+	})
+	if !writeStatusError(w, r, err) {
+		return
+	}
+	s.publish(r, EventPaymentReceived, id)
+
+	order, err := s.store.ByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Payment Payment   `json:"payment"`
+		Order   OrderView `json:"order"`
+	}{payment, order})
+}
+
+// pay records a payment inside tx against the cashier's open shift. Cash
+// needs one, for the drawer. Nothing may be paid beyond what is due,
+// except that cash tendered above the amount is given back as change.
+func (s *Service) pay(ctx context.Context, tx Tx, orderID int, req PaymentRequest, userID int) (Payment, error) {
+	state, err := tx.LockOrder(ctx, orderID)
+	if err != nil {
+		return Payment{}, err
+	}
+	if state.Status == StatusCancelled || state.Status == StatusRefunded {
+		return Payment{}, fmt.Errorf("%w: order is %s", ErrInvalidPayment, state.Status)
+	}
+
+	due, err := amountDue(ctx, tx, orderID)
+	if err != nil {
+		return Payment{}, err
+	}
+	if due.Cmp(money.Zero()) <= 0 {
+		return Payment{}, ErrAlreadyPaid
+	}
+
+	payment := Payment{
+		OrderID:   orderID,
+		UserID:    userID,
+		Method:    req.Method,
+		Change:    money.Zero(),
+		CreatedAt: time.Now(),
+	}
+	amount := due
+	switch req.Method {
+	case PaymentCard:
+		if req.Tendered != nil {
+			return Payment{}, fmt.Errorf("%w: tendered is only for cash", ErrInvalidPayment)
+		}
+		if req.Amount != nil {
+			amount = *req.Amount
+		}
+	case PaymentCash:
+		if req.Amount != nil {
+			amount = *req.Amount
+		} else if req.Tendered != nil && req.Tendered.Cmp(due) < 0 {
+			amount = *req.Tendered
+		}
+		tendered := amount
+		if req.Tendered != nil {
+			tendered = *req.Tendered
+		}
+		if tendered.Cmp(amount) < 0 {
+			return Payment{}, fmt.Errorf("%w: tendered %s is less than the amount %s", ErrInvalidPayment, tendered, amount)
+		}
+		payment.Tendered = &tendered
+		payment.Change = tendered.Sub(amount)
+	default:
+		return Payment{}, fmt.Errorf("%w: unknown method %q", ErrInvalidPayment, req.Method)
+	}
+
+	if amount.Cmp(money.Zero()) <= 0 {
+		return Payment{}, fmt.Errorf("%w: amount must be positive", ErrInvalidPayment)
+	}
+	if amount.Cmp(due) > 0 {
+		return Payment{}, fmt.Errorf("%w: amount %s exceeds the %s due", ErrInvalidPayment, amount, due)
+	}
+	payment.Amount = amount
+
+	shiftID, open, err := tx.OpenShift(ctx, userID)
+	if err != nil {
+		return Payment{}, err
+	}
+	if open {
+		payment.ShiftID = &shiftID
+	} else if payment.Method == PaymentCash {
+		return Payment{}, ErrNoOpenShift
+	}
+
+	if err := tx.InsertPayment(ctx, &payment); err != nil {
+		return Payment{}, err
+	}
+	return payment, nil
+}
+
+// amountDue is the order total, net of refunds, less what has been paid.
+// It is negative when refunds left the customer overpaid.
+func amountDue(ctx context.Context, tx Tx, orderID int) (money.Amount, error) {
+	lines, err := tx.Lines(ctx, orderID)
+	if err != nil {
+		return money.Amount{}, err
+	}
+	total := money.Zero()
+	for _, line := range lines {
+		total = total.Add(line.Price.MulInt(int64(line.Quantity - line.RefundedQuantity)))
+	}
+
+	paid, err := tx.PaidAmount(ctx, orderID)
+	if err != nil {
+		return money.Amount{}, err
+	}
+	return total.Sub(paid), nil
+}
@@ -0,0 +1,72 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"randevu-shawarma-server/money"
+)
+
+func TestPaymentsSplitAndGiveChange(t *testing.T) {
+	ctx := context.Background()
+	store, _ := kitchen(t)
+	s := NewService(store, nil, nil)
+	id := newOrder(t, store, 2)
+
+	pay := func(req PaymentRequest) (Payment, error) {
+		var payment Payment
+		err := store.InTx(ctx, func(tx Tx) error {
+			var err error
+			payment, err = s.pay(ctx, tx, id, req, 1)
+			return err
+		})
+		return payment, err
+	}
+	amount := func(v string) *money.Amount {
+		a := money.MustParse(v)
+		return &a
+	}
+
+	// Cash goes into a drawer, so it needs an open shift; cards do not
+	if _, err := pay(PaymentRequest{Method: PaymentCash}); !errors.Is(err, ErrNoOpenShift) {
+		t.Fatalf("cash without a shift: got %v, want %v", err, ErrNoOpenShift)
+	}
+	card, err := pay(PaymentRequest{Method: PaymentCard, Amount: amount("3.00")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.ShiftID != nil || !card.Change.IsZero() {
+		t.Errorf("card payment on shift %v with %s change, want no shift and no change", card.ShiftID, card.Change)
+	}
+
+	store.SetShiftLookup(func(userID int) (int, bool) { return 7, true })
+	if _, err := pay(PaymentRequest{Method: PaymentCard, Amount: amount("5.00")}); !errors.Is(err, ErrInvalidPayment) {
+		t.Errorf("paying 5.00 of 4.00 due: got %v, want %v", err, ErrInvalidPayment)
+	}
+	if _, err := pay(PaymentRequest{Method: PaymentCash, Amount: amount("4.00"), Tendered: amount("3.00")}); !errors.Is(err, ErrInvalidPayment) {
+		t.Errorf("tendering less than the amount: got %v, want %v", err, ErrInvalidPayment)
+	}
+
+	cash, err := pay(PaymentRequest{Method: PaymentCash, Tendered: amount("10.00")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cash.Amount.String() != "4.00" || cash.Change.String() != "6.00" {
+		t.Errorf("got %s with %s change, want 4.00 with 6.00", cash.Amount, cash.Change)
+	}
+	if cash.ShiftID == nil || *cash.ShiftID != 7 {
+		t.Errorf("cash booked on shift %v, want 7", cash.ShiftID)
+	}
+
+	if _, err := pay(PaymentRequest{Method: PaymentCard}); !errors.Is(err, ErrAlreadyPaid) {
+		t.Errorf("paying a settled order: got %v, want %v", err, ErrAlreadyPaid)
+	}
+	order, err := store.ByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Paid.String() != "7.00" || len(order.Payments) != 2 {
+		t.Errorf("order paid %s in %d payments, want 7.00 in 2", order.Paid, len(order.Payments))
+	}
+}
@@ -0,0 +1,551 @@
+package orders
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/warehouse"
+)
+
+type PostgresStore struct {
+	db    *sql.DB
+	stock *warehouse.PostgresStore
+}
+
+func NewPostgresStore(db *sql.DB, stock *warehouse.PostgresStore) *PostgresStore {
+	return &PostgresStore{db: db, stock: stock}
+}
+
+// ListActive loads the orders and their lines in one query
+func (s *PostgresStore) ListActive(ctx context.Context) ([]OrderView, error) {
+	query := `
+		SELECT o.id, o.user_id, o.name, o.status, COALESCE(p.paid, 0),
+			odr.id, d.id, d.name, odr.quantity, odr.refunded_quantity, odr.unit_price
+		FROM public."Orders" o
+		JOIN public."Order_dish_relations" odr ON o.id = odr.order_id
+		JOIN public."Dishes" d ON odr.dish_id = d.id
+		LEFT JOIN (
+			SELECT order_id, SUM(amount) AS paid
+			FROM public."Order_payments"
+			GROUP BY order_id
+		) p ON p.order_id = o.id
+		WHERE o.status IN ('new', 'accepted', 'cooking', 'ready')
+		ORDER BY o.id, odr.id
+	`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []OrderView
+	for rows.Next() {
+		var order OrderView
+		var dish OrderDishRelationView
+		err := rows.Scan(&order.ID, &order.UserID, &order.Name, &order.Status, &order.Paid,
+			&dish.LineID, &dish.DishID, &dish.DishName, &dish.Quantity, &dish.RefundedQuantity, &dish.Price)
+		if err != nil {
+			return nil, err
+		}
+
+		if n := len(orders); n == 0 || orders[n-1].ID != order.ID {
+			order.TotalPrice = money.Zero()
+			orders = append(orders, order)
+		}
+		current := &orders[len(orders)-1]
+		current.TotalPrice = current.TotalPrice.Add(dish.Price.MulInt(int64(dish.Quantity - dish.RefundedQuantity)))
+		current.Dishes = append(current.Dishes, dish)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, len(orders))
+	for i, order := range orders {
+		ids[i] = order.ID
+	}
+	modifiers, err := s.lineModifiers(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range orders {
+		for j := range orders[i].Dishes {
+			orders[i].Dishes[j].Modifiers = modifiers[orders[i].Dishes[j].LineID]
+		}
+	}
+	return orders, nil
+}
+
+// lineModifiers returns the modifiers sold on the lines of the orders,
+// keyed by line id
+func (s *PostgresStore) lineModifiers(ctx context.Context, orderIDs []int) (map[int][]LineModifier, error) {
+	byLine := map[int][]LineModifier{}
+	if len(orderIDs) == 0 {
+		return byLine, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT olm.order_line_id, olm.modifier_id, olm.name, olm.price_delta
+		FROM public."Order_line_modifiers" olm
+		JOIN public."Order_dish_relations" odr ON odr.id = olm.order_line_id
+		WHERE odr.order_id = ANY($1)
+		ORDER BY olm.order_line_id, olm.id`, pq.Array(orderIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var lineID int
+		var m LineModifier
+		if err := rows.Scan(&lineID, &m.ModifierID, &m.Name, &m.PriceDelta); err != nil {
+			return nil, err
+		}
+		byLine[lineID] = append(byLine[lineID], m)
+	}
+	return byLine, rows.Err()
+}
+
+func (s *PostgresStore) ByID(ctx context.Context, id int) (OrderView, error) {
+	query := `
+		SELECT o.id, o.user_id, o.name, o.status, COALESCE(SUM(odr.unit_price * (odr.quantity - odr.refunded_quantity)), 0) AS total_price,
+			(SELECT COALESCE(SUM(amount), 0) FROM public."Order_payments" WHERE order_id = o.id) AS paid
+		FROM public."Orders" o
+		LEFT JOIN public."Order_dish_relations" odr ON o.id = odr.order_id
+		WHERE o.id = $1
+		GROUP BY o.id, o.user_id, o.name, o.status
+	`
+	var order OrderView
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&order.ID, &order.UserID, &order.Name, &order.Status, &order.TotalPrice, &order.Paid)
+	if err == sql.ErrNoRows {
+		return order, ErrNotFound
+	} else if err != nil {
+		return order, err
+	}
+
+	order.Dishes, err = s.orderDishes(ctx, id)
+	if err != nil {
+		return order, err
+	}
+	order.History, err = s.statusHistory(ctx, id)
+	if err != nil {
+		return order, err
+	}
+	order.Refunds, err = s.refunds(ctx, id)
+	if err != nil {
+		return order, err
+	}
+	order.Payments, err = s.payments(ctx, id)
+	return order, err
+}
+
+func (s *PostgresStore) payments(ctx context.Context, orderID int) ([]Payment, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, order_id, user_id, method, amount, tendered, change, shift_id, created_at
+		FROM public."Order_payments"
+		WHERE order_id = $1
+		ORDER BY created_at, id`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []Payment
+	for rows.Next() {
+		var payment Payment
+		var tendered sql.NullString
+		err := rows.Scan(&payment.ID, &payment.OrderID, &payment.UserID, &payment.Method,
+			&payment.Amount, &tendered, &payment.Change, &payment.ShiftID, &payment.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if tendered.Valid {
+			amount, err := money.Parse(tendered.String)
+			if err != nil {
+				return nil, err
+			}
+			payment.Tendered = &amount
+		}
+		payments = append(payments, payment)
+	}
+	return payments, rows.Err()
+}
+
+func (s *PostgresStore) refunds(ctx context.Context, orderID int) ([]Refund, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT r.id, r.order_id, r.user_id, r.reason, r.stock_action, r.write_off_id, r.amount,
+			r.paid_out, COALESCE(r.payout_method, ''), r.shift_id, r.created_at,
+			l.order_line_id, l.quantity
+		FROM public."Order_refunds" r
+		JOIN public."Order_refund_lines" l ON l.refund_id = r.id
+		WHERE r.order_id = $1
+		ORDER BY r.created_at, r.id, l.id`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refunds []Refund
+	for rows.Next() {
+		var refund Refund
+		var line RefundLine
+		err := rows.Scan(&refund.ID, &refund.OrderID, &refund.UserID, &refund.Reason, &refund.StockAction,
+			&refund.WriteOffID, &refund.Amount, &refund.PaidOut, &refund.PayoutMethod, &refund.ShiftID,
+			&refund.CreatedAt, &line.LineID, &line.Quantity)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(refunds); n > 0 && refunds[n-1].ID == refund.ID {
+			refunds[n-1].Lines = append(refunds[n-1].Lines, line)
+			continue
+		}
+		refund.Lines = []RefundLine{line}
+		refunds = append(refunds, refund)
+	}
+	return refunds, rows.Err()
+}
+
+func (s *PostgresStore) statusHistory(ctx context.Context, orderID int) ([]StatusChange, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT COALESCE(from_status, ''), to_status, COALESCE(user_id, 0), changed_at
+		FROM public."Order_status_history"
+		WHERE order_id = $1
+		ORDER BY changed_at, id`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []StatusChange
+	for rows.Next() {
+		var change StatusChange
+		if err := rows.Scan(&change.From, &change.To, &change.UserID, &change.ChangedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
+
+func (s *PostgresStore) orderDishes(ctx context.Context, orderID int) ([]OrderDishRelationView, error) {
+	dishQuery := `
+		SELECT odr.id, d.id, d.name, odr.quantity, odr.refunded_quantity, odr.unit_price
+		FROM public."Order_dish_relations" odr
+		JOIN public."Dishes" d ON odr.dish_id = d.id
+		WHERE odr.order_id = $1
+		ORDER BY odr.id
+	`
+	dishRows, err := s.db.QueryContext(ctx, dishQuery, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer dishRows.Close()
+
+	var dishes []OrderDishRelationView
+	for dishRows.Next() {
+		var dish OrderDishRelationView
+		err := dishRows.Scan(&dish.LineID, &dish.DishID, &dish.DishName, &dish.Quantity, &dish.RefundedQuantity, &dish.Price)
+		if err != nil {
+			return nil, err
+		}
+		dishes = append(dishes, dish)
+	}
+	if err := dishRows.Err(); err != nil {
+		return nil, err
+	}
+
+	modifiers, err := s.lineModifiers(ctx, []int{orderID})
+	if err != nil {
+		return nil, err
+	}
+	for i := range dishes {
+		dishes[i].Modifiers = modifiers[dishes[i].LineID]
+	}
+	return dishes, nil
+}
+
+func (s *PostgresStore) Create(ctx context.Context, o *Order) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Insert new order
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO public.\"Orders\" (user_id, name, created_at, status) VALUES ($1, $2, $3, $4) RETURNING id",
+		o.UserID, o.Name, o.CreatedAt, o.Status,
+	).Scan(&o.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO public.\"Order_status_history\" (order_id, to_status, user_id, changed_at) VALUES ($1, $2, $3, $4)",
+		o.ID, o.Status, o.UserID, o.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Insert order dishes
+	for _, dish := range o.Dishes {
+		var lineID int
+		err = tx.QueryRowContext(ctx,
+			"INSERT INTO public.\"Order_dish_relations\" (order_id, dish_id, quantity, unit_price) VALUES ($1, $2, $3, $4) RETURNING id",
+			o.ID, dish.DishID, dish.Quantity, dish.UnitPrice,
+		).Scan(&lineID)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range dish.Selected {
+			_, err = tx.ExecContext(ctx,
+				"INSERT INTO public.\"Order_line_modifiers\" (order_line_id, modifier_id, name, price_delta) VALUES ($1, $2, $3, $4)",
+				lineID, m.ModifierID, m.Name, m.PriceDelta,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) InTx(ctx context.Context, fn func(Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&postgresTx{StockTx: s.stock.Stock(tx), tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type postgresTx struct {
+	warehouse.StockTx
+	tx *sql.Tx
+}
+
+func (t *postgresTx) Lines(ctx context.Context, orderID int) ([]OrderLine, error) {
+	rows, err := t.tx.QueryContext(ctx, `
+		SELECT odr.id, odr.dish_id, odr.quantity, odr.refunded_quantity, odr.unit_price
+		FROM public."Order_dish_relations" odr
+		WHERE odr.order_id = $1
+		ORDER BY odr.id`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []OrderLine
+	for rows.Next() {
+		var line OrderLine
+		if err := rows.Scan(&line.ID, &line.DishID, &line.Quantity, &line.RefundedQuantity, &line.Price); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+func (t *postgresTx) RecipeUsage(ctx context.Context, orderID int) ([]LineUsage, error) {
+	query := `
+	WITH recipe AS (
+		SELECT dr.dish_id, dr.product_id, dr.quantity
+		FROM public."Dish_recipe" dr
+		UNION ALL
+		-- Preparations sold from their ingredients need more of them when
+		-- the preparation loses weight in the making
+		SELECT dp.dishes_id, pr.product_id, pr.quantity * dp.quantity * 100 / p.yield_percent
+		FROM public."Dishes_Preparations" dp
+		INNER JOIN public."Preparations" p ON p.id = dp.preparations_id AND p.deduct_from = 'ingredients'
+		INNER JOIN public."Preparation_recipe" pr ON dp.preparations_id = pr.preparation_id
+		UNION ALL
+		SELECT dp.dishes_id, p.product_id, dp.quantity
+		FROM public."Dishes_Preparations" dp
+		INNER JOIN public."Preparations" p ON p.id = dp.preparations_id AND p.deduct_from = 'stock'
+	)
+	SELECT u.line_id, u.product_id, SUM(u.quantity), u.units
+	FROM (
+		SELECT odr.id AS line_id, r.product_id, r.quantity, odr.quantity - odr.refunded_quantity AS units
+		FROM public."Order_dish_relations" odr
+		INNER JOIN recipe r ON odr.dish_id = r.dish_id
+		WHERE odr.order_id = $1
+		UNION ALL
+		SELECT odr.id, mr.product_id, mr.quantity, odr.quantity - odr.refunded_quantity
+		FROM public."Order_dish_relations" odr
+		INNER JOIN public."Order_line_modifiers" olm ON olm.order_line_id = odr.id
+		INNER JOIN public."Modifier_recipe" mr ON mr.modifier_id = olm.modifier_id
+		WHERE odr.order_id = $1
+	) u
+	GROUP BY u.line_id, u.product_id, u.units
+	-- A modifier such as "no onion" can take a product out entirely
+	HAVING SUM(u.quantity) > 0
+	`
+	return t.scanUsage(ctx, query, orderID)
+}
+
+func (t *postgresTx) DeductedUsage(ctx context.Context, orderID int) ([]LineUsage, error) {
+	query := `
+	SELECT odr.id, oli.product_id, oli.quantity_per_unit, odr.quantity - odr.refunded_quantity
+	FROM public."Order_dish_relations" odr
+	INNER JOIN public."Order_line_ingredients" oli ON oli.order_line_id = odr.id
+	WHERE odr.order_id = $1
+	`
+	return t.scanUsage(ctx, query, orderID)
+}
+
+func (t *postgresTx) scanUsage(ctx context.Context, query string, orderID int) ([]LineUsage, error) {
+	rows, err := t.tx.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []LineUsage
+	for rows.Next() {
+		var u LineUsage
+		if err := rows.Scan(&u.LineID, &u.ProductID, &u.QuantityPerUnit, &u.Units); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+func (t *postgresTx) RecordUsage(ctx context.Context, usage []LineUsage) error {
+	for _, u := range usage {
+		_, err := t.tx.ExecContext(ctx,
+			"INSERT INTO public.\"Order_line_ingredients\" (order_line_id, product_id, quantity_per_unit) VALUES ($1, $2, $3)",
+			u.LineID, u.ProductID, u.QuantityPerUnit,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *postgresTx) InsertRefund(ctx context.Context, refund *Refund) error {
+	err := t.tx.QueryRowContext(ctx,
+		"INSERT INTO public.\"Order_refunds\" (order_id, user_id, reason, stock_action, write_off_id, amount, paid_out, payout_method, shift_id, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10) RETURNING id",
+		refund.OrderID, refund.UserID, refund.Reason, refund.StockAction, refund.WriteOffID, refund.Amount,
+		refund.PaidOut, refund.PayoutMethod, refund.ShiftID, refund.CreatedAt,
+	).Scan(&refund.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range refund.Lines {
+		_, err = t.tx.ExecContext(ctx,
+			"INSERT INTO public.\"Order_refund_lines\" (refund_id, order_line_id, quantity) VALUES ($1, $2, $3)",
+			refund.ID, line.LineID, line.Quantity,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = t.tx.ExecContext(ctx,
+			"UPDATE public.\"Order_dish_relations\" SET refunded_quantity = refunded_quantity + $1 WHERE id = $2",
+			line.Quantity, line.LineID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *postgresTx) OpenShift(ctx context.Context, userID int) (int, bool, error) {
+	var id int
+	err := t.tx.QueryRowContext(ctx,
+		"SELECT id FROM public.\"Shifts\" WHERE user_id = $1 AND closed_at IS NULL FOR SHARE",
+		userID,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return id, err == nil, err
+}
+
+func (t *postgresTx) PaidAmount(ctx context.Context, orderID int) (money.Amount, error) {
+	var paid money.Amount
+	err := t.tx.QueryRowContext(ctx,
+		"SELECT COALESCE(SUM(amount), 0) FROM public.\"Order_payments\" WHERE order_id = $1",
+		orderID,
+	).Scan(&paid)
+	return paid, err
+}
+
+func (t *postgresTx) InsertPayment(ctx context.Context, payment *Payment) error {
+	return t.tx.QueryRowContext(ctx,
+		"INSERT INTO public.\"Order_payments\" (order_id, user_id, method, amount, tendered, change, shift_id, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+		payment.OrderID, payment.UserID, payment.Method, payment.Amount, payment.Tendered, payment.Change, payment.ShiftID, payment.CreatedAt,
+	).Scan(&payment.ID)
+}
+
+func (t *postgresTx) InsertWriteOff(ctx context.Context, userID int, notes string, at time.Time, products map[int]float64) (int, error) {
+	var id int
+	err := t.tx.QueryRowContext(ctx,
+		"INSERT INTO public.\"Write_off\" (user_id, created_at, notes) VALUES ($1, $2, $3) RETURNING id",
+		userID, at, notes,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	for productID, quantity := range products {
+		_, err = t.tx.ExecContext(ctx,
+			"INSERT INTO public.\"Write_off_product_relations\" (write_off_id, product_id, quantity) VALUES ($1, $2, $3)",
+			id, productID, quantity,
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+func (t *postgresTx) LockOrder(ctx context.Context, orderID int) (OrderState, error) {
+	var state OrderState
+	err := t.tx.QueryRowContext(ctx,
+		"SELECT status, stock_deducted_at IS NOT NULL FROM public.\"Orders\" WHERE id = $1 FOR UPDATE",
+		orderID,
+	).Scan(&state.Status, &state.StockDeducted)
+	if err == sql.ErrNoRows {
+		return state, ErrNotFound
+	}
+	return state, err
+}
+
+func (t *postgresTx) MarkStockDeducted(ctx context.Context, orderID int, at time.Time) error {
+	_, err := t.tx.ExecContext(ctx,
+		"UPDATE public.\"Orders\" SET stock_deducted_at = $1 WHERE id = $2",
+		at, orderID,
+	)
+	return err
+}
+
+func (t *postgresTx) SetStatus(ctx context.Context, orderID int, change StatusChange) error {
+	_, err := t.tx.ExecContext(ctx,
+		"UPDATE public.\"Orders\" SET status = $1 WHERE id = $2",
+		change.To, orderID,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.tx.ExecContext(ctx,
+		"INSERT INTO public.\"Order_status_history\" (order_id, from_status, to_status, user_id, changed_at) VALUES ($1, $2, $3, $4, $5)",
+		orderID, change.From, change.To, change.UserID, change.ChangedAt,
+	)
+	return err
+}
@@ -0,0 +1,308 @@
+package orders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/users"
+	"randevu-shawarma-server/warehouse"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// CancelOrder cancels an order that has not been picked up yet. The body
+// is optional and may carry a reason and what to do with cooked food.
+func (s *Service) CancelOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	var req RefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Lines = nil
+
+	claims, _ := users.ClaimsFromContext(r.Context())
+	err = s.cancel(r.Context(), id, req, claims.UserID)
+	if !writeStatusError(w, r, err) {
+		return
+	}
+	s.publish(r, EventCancelled, id)
+	s.writeOrder(w, r, id)
+}
+
+// CreateRefund refunds some or all of the remaining lines of an order
+func (s *Service) CreateRefund(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid order id", http.StatusBadRequest)
+		return
+	}
+
+	var req RefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		http.Error(w, "Reason is required", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := users.ClaimsFromContext(r.Context())
+	var refund Refund
+	err = s.store.InTx(r.Context(), func(tx Tx) error {
+		refund, err = s.refund(r.Context(), tx, id, req, claims.UserID)
+		return err
+	})
+	if !writeStatusError(w, r, err) {
+		return
+	}
+	s.publish(r, EventRefunded, id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(refund)
+}
+
+// cancel refunds whatever is left of an order that has not been picked
+// up, which moves it to cancelled
+func (s *Service) cancel(ctx context.Context, orderID int, req RefundRequest, userID int) error {
+	return s.store.InTx(ctx, func(tx Tx) error {
+		state, err := tx.LockOrder(ctx, orderID)
+		if err != nil {
+			return err
+		}
+		if !state.Status.CanTransitionTo(StatusCancelled) {
+			return &TransitionError{From: state.Status, To: StatusCancelled}
+		}
+
+		lines, err := tx.Lines(ctx, orderID)
+		if err != nil {
+			return err
+		}
+		if remainingUnits(lines) == 0 {
+			return s.transition(ctx, tx, orderID, StatusCancelled, userID)
+		}
+		_, err = s.refund(ctx, tx, orderID, req, userID)
+		return err
+	})
+}
+
+// refund records a refund inside tx. Stock that was deducted for the
+// refunded units is returned to the warehouse or booked as a write-off,
+// using the quantities recorded at deduction time. Once nothing is left
+// the order becomes cancelled, or refunded if it had been picked up.
+func (s *Service) refund(ctx context.Context, tx Tx, orderID int, req RefundRequest, userID int) (Refund, error) {
+	state, err := tx.LockOrder(ctx, orderID)
+	if err != nil {
+		return Refund{}, err
+	}
+	if !state.Status.Active() && state.Status != StatusPickedUp {
+		return Refund{}, ErrNothingToRefund
+	}
+
+	lines, err := tx.Lines(ctx, orderID)
+	if err != nil {
+		return Refund{}, err
+	}
+	quantities, err := refundQuantities(lines, req.Lines)
+	if err != nil {
+		return Refund{}, err
+	}
+
+	action, err := refundStockAction(state, req.StockAction)
+	if err != nil {
+		return Refund{}, err
+	}
+
+	dueBefore, err := amountDue(ctx, tx, orderID)
+	if err != nil {
+		return Refund{}, err
+	}
+
+	now := time.Now()
+	refund := Refund{
+		OrderID:     orderID,
+		UserID:      userID,
+		Reason:      strings.TrimSpace(req.Reason),
+		StockAction: action,
+		Amount:      money.Zero(),
+		CreatedAt:   now,
+	}
+	for _, line := range lines {
+		if quantity := quantities[line.ID]; quantity > 0 {
+			refund.Amount = refund.Amount.Add(line.Price.MulInt(int64(quantity)))
+			refund.Lines = append(refund.Lines, RefundLine{LineID: line.ID, Quantity: quantity})
+		}
+	}
+	if err := settlePayout(ctx, tx, &refund, dueBefore, req.PayoutMethod); err != nil {
+		return Refund{}, err
+	}
+
+	// Returned stock is booked against the refund, so it goes back once
+	// the refund has an id
+	var returned map[int]float64
+	if action != StockNone {
+		usage, err := tx.DeductedUsage(ctx, orderID)
+		if err != nil {
+			return Refund{}, err
+		}
+		products := map[int]float64{}
+		for _, u := range usage {
+			if quantity := quantities[u.LineID]; quantity > 0 {
+				products[u.ProductID] += u.QuantityPerUnit * float64(quantity)
+			}
+		}
+
+		switch action {
+		case StockReturn:
+			returned = products
+		case StockWriteOff:
+			notes := fmt.Sprintf("Refund of order %d", orderID)
+			if refund.Reason != "" {
+				notes += ": " + refund.Reason
+			}
+			writeOffID, err := tx.InsertWriteOff(ctx, userID, notes, now, products)
+			if err != nil {
+				return Refund{}, err
+			}
+			refund.WriteOffID = &writeOffID
+		}
+	}
+
+	if err := tx.InsertRefund(ctx, &refund); err != nil {
+		return Refund{}, err
+	}
+	// Returned stock goes back at the cost the order took it out at
+	sold := warehouse.Source{Type: warehouse.SourceOrder, ID: orderID}
+	for productID, quantity := range returned {
+		src := warehouse.Source{Type: warehouse.SourceRefund, ID: refund.ID, UserID: userID}
+		if err := tx.Return(ctx, productID, quantity, src, sold); err != nil {
+			return Refund{}, err
+		}
+	}
+
+	for i := range lines {
+		lines[i].RefundedQuantity += quantities[lines[i].ID]
+	}
+	if remainingUnits(lines) == 0 {
+		final := StatusCancelled
+		if state.Status == StatusPickedUp {
+			final = StatusRefunded
+		}
+		if err := s.transition(ctx, tx, orderID, final, userID); err != nil {
+			return Refund{}, err
+		}
+	}
+	return refund, nil
+}
+
+// settlePayout works out how much of the refund goes back to the customer:
+// only what the refund leaves them overpaid, beyond what they already were.
+// Refunds are booked on the user's open shift, which cash payouts require.
+func settlePayout(ctx context.Context, tx Tx, refund *Refund, dueBefore money.Amount, method PaymentMethod) error {
+	overpaid := func(due money.Amount) money.Amount {
+		if due.IsNegative() {
+			return due.Neg()
+		}
+		return money.Zero()
+	}
+	refund.PaidOut = overpaid(dueBefore.Sub(refund.Amount)).Sub(overpaid(dueBefore))
+
+	shiftID, open, err := tx.OpenShift(ctx, refund.UserID)
+	if err != nil {
+		return err
+	}
+	if open {
+		refund.ShiftID = &shiftID
+	}
+	if refund.PaidOut.IsZero() {
+		return nil
+	}
+
+	switch method {
+	case "":
+		method = PaymentCash
+	case PaymentCash, PaymentCard:
+	default:
+		return fmt.Errorf("%w: unknown payout method %q", ErrInvalidRefund, method)
+	}
+	if method == PaymentCash && !open {
+		return ErrNoOpenShift
+	}
+	refund.PayoutMethod = method
+	return nil
+}
+
+// refundQuantities validates the requested lines against the order and
+// returns the units to refund per line id; no lines means all that is left
+func refundQuantities(lines []OrderLine, requested []RefundLine) (map[int]int, error) {
+	remaining := map[int]int{}
+	for _, line := range lines {
+		remaining[line.ID] = line.Quantity - line.RefundedQuantity
+	}
+
+	quantities := map[int]int{}
+	if len(requested) == 0 {
+		for id, left := range remaining {
+			if left > 0 {
+				quantities[id] = left
+			}
+		}
+	}
+	for _, line := range requested {
+		left, ok := remaining[line.LineID]
+		if !ok {
+			return nil, fmt.Errorf("%w: line %d is not on this order", ErrInvalidRefund, line.LineID)
+		}
+		if line.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: quantity for line %d must be positive", ErrInvalidRefund, line.LineID)
+		}
+		quantities[line.LineID] += line.Quantity
+		if quantities[line.LineID] > left {
+			return nil, fmt.Errorf("%w: only %d left to refund on line %d", ErrInvalidRefund, left, line.LineID)
+		}
+	}
+
+	if len(quantities) == 0 {
+		return nil, ErrNothingToRefund
+	}
+	return quantities, nil
+}
+
+// refundStockAction settles the requested stock action against whether
+// the order's ingredients were taken from the warehouse
+func refundStockAction(state OrderState, requested StockAction) (StockAction, error) {
+	if !state.StockDeducted {
+		return StockNone, nil
+	}
+	switch requested {
+	case "":
+		return StockReturn, nil
+	case StockReturn, StockWriteOff:
+		return requested, nil
+	case StockNone:
+		return "", fmt.Errorf("%w: stock was already deducted, choose %q or %q", ErrInvalidRefund, StockReturn, StockWriteOff)
+	}
+	return "", fmt.Errorf("%w: unknown stock action %q", ErrInvalidRefund, requested)
+}
+
+func remainingUnits(lines []OrderLine) int {
+	total := 0
+	for _, line := range lines {
+		total += line.Quantity - line.RefundedQuantity
+	}
+	return total
+}
@@ -0,0 +1,77 @@
+package orders
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRefundReturnsOrWritesOffStock(t *testing.T) {
+	ctx := context.Background()
+	store, stock := kitchen(t)
+	store.SetShiftLookup(func(userID int) (int, bool) { return 1, true })
+	s := NewService(store, nil, nil)
+	id := newOrder(t, store, 2)
+	if err := s.advance(ctx, id, StatusCooking, 1); err != nil {
+		t.Fatal(err)
+	}
+	err := store.InTx(ctx, func(tx Tx) error {
+		_, err := s.pay(ctx, tx, id, PaymentRequest{Method: PaymentCash}, 1)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := store.ByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lineID := order.Dishes[0].LineID
+
+	refund := func(req RefundRequest) Refund {
+		t.Helper()
+		var refund Refund
+		err := store.InTx(ctx, func(tx Tx) error {
+			var err error
+			refund, err = s.refund(ctx, tx, id, req, 1)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return refund
+	}
+
+	// One of the two goes back to the warehouse and its price to the customer
+	returned := refund(RefundRequest{Reason: "wrong dish", Lines: []RefundLine{{LineID: lineID, Quantity: 1}}})
+	if returned.StockAction != StockReturn || returned.Amount.String() != "3.50" || returned.PaidOut.String() != "3.50" {
+		t.Errorf("got %s refunded, %s paid out with stock %s, want 3.50, 3.50 and %s",
+			returned.Amount, returned.PaidOut, returned.StockAction, StockReturn)
+	}
+	if got := levels(t, stock); got[1] != 0.88 || got[2] != 9 {
+		t.Errorf("stock after returning one %v, want 0.88 chicken and 9 lavash", got)
+	}
+
+	// The cooked one is booked as a write-off and stays out
+	writtenOff := refund(RefundRequest{Reason: "dropped", StockAction: StockWriteOff})
+	if writtenOff.WriteOffID == nil {
+		t.Fatal("the write-off was not booked")
+	}
+	products := store.WriteOffs()[*writtenOff.WriteOffID]
+	if products[1] != 0.12 || products[2] != 1 {
+		t.Errorf("wrote off %v, want 0.12 chicken and 1 lavash", products)
+	}
+	if got := levels(t, stock); got[1] != 0.88 || got[2] != 9 {
+		t.Errorf("stock after writing one off %v, want 0.88 chicken and 9 lavash", got)
+	}
+
+	order, err = store.ByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != StatusCancelled || !order.TotalPrice.IsZero() {
+		t.Errorf("order is %s at %s, want %s at 0.00", order.Status, order.TotalPrice, StatusCancelled)
+	}
+	if len(order.Refunds) != 2 || order.Paid.String() != "7.00" {
+		t.Errorf("got %d refunds against %s paid, want 2 against 7.00", len(order.Refunds), order.Paid)
+	}
+}
@@ -0,0 +1,90 @@
+package orders
+
+import (
+	"fmt"
+)
+
+type Status string
+
+const (
+	StatusNew       Status = "new"
+	StatusAccepted  Status = "accepted"
+	StatusCooking   Status = "cooking"
+	StatusReady     Status = "ready"
+	StatusPickedUp  Status = "picked_up"
+	StatusCancelled Status = "cancelled"
+	// StatusRefunded is reached once every line of a picked up order is refunded
+	StatusRefunded Status = "refunded"
+)
+
+// transitions lists the statuses each status may move to
+var transitions = map[Status][]Status{
+	StatusNew:       {StatusAccepted, StatusCancelled},
+	StatusAccepted:  {StatusCooking, StatusCancelled},
+	StatusCooking:   {StatusReady, StatusCancelled},
+	StatusReady:     {StatusPickedUp, StatusCancelled},
+	StatusPickedUp:  {StatusRefunded},
+	StatusCancelled: {},
+	StatusRefunded:  {},
+}
+
+// Valid reports whether s is a known status
+func (s Status) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// Active reports whether the order is still on its way to the customer
+func (s Status) Active() bool {
+	return s == StatusNew || s == StatusAccepted || s == StatusCooking || s == StatusReady
+}
+
+// CanTransitionTo reports whether the order may move from s to next
+func (s Status) CanTransitionTo(next Status) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiresPayment reports whether the order must be paid in full to enter s
+func (s Status) RequiresPayment() bool {
+	return s == StatusPickedUp
+}
+
+// ConsumesStock reports whether entering s uses up the ingredients
+func (s Status) ConsumesStock() bool {
+	return s == StatusCooking
+}
+
+// pathTo returns the statuses to pass through to get from s to target
+// along the normal flow, or nil if target is not ahead of s
+func (s Status) pathTo(target Status) []Status {
+	flow := []Status{StatusNew, StatusAccepted, StatusCooking, StatusReady, StatusPickedUp}
+	var path []Status
+	found := false
+	for _, status := range flow {
+		if found {
+			path = append(path, status)
+			if status == target {
+				return path
+			}
+		}
+		if status == s {
+			found = true
+		}
+	}
+	return nil
+}
+
+// TransitionError is returned for a status change the state machine forbids
+type TransitionError struct {
+	From Status
+	To   Status
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("order cannot go from %s to %s", e.From, e.To)
+}
@@ -0,0 +1,87 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"randevu-shawarma-server/dishes"
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/warehouse"
+)
+
+var (
+	ErrNotFound = errors.New("order not found")
+	// ErrInvalidRefund is returned for refund lines that are not on the
+	// order or exceed what is left to refund
+	ErrInvalidRefund = errors.New("invalid refund")
+	// ErrNothingToRefund is returned once every line has been refunded
+	ErrNothingToRefund = errors.New("nothing left to refund")
+	// ErrInvalidPayment is returned for a payment that does not fit the order
+	ErrInvalidPayment = errors.New("invalid payment")
+	// ErrNotPaid is returned when an order that is not paid in full is handed out
+	ErrNotPaid = errors.New("order is not paid in full")
+	// ErrAlreadyPaid is returned for a payment on an order with nothing due
+	ErrAlreadyPaid = errors.New("order is already paid in full")
+	// ErrInvalidOrder is returned for dishes or modifiers that cannot be ordered
+	ErrInvalidOrder = errors.New("invalid order")
+	// ErrNoOpenShift is returned when cash would move without an open shift
+	ErrNoOpenShift = errors.New("open a shift before handling cash")
+)
+
+// Menu looks up the dishes orders are taken for
+type Menu interface {
+	// DishesByID returns the dishes with all their modifiers; unknown ids are left out
+	DishesByID(ctx context.Context, ids []int) (map[int]dishes.DishItem, error)
+}
+
+// Store persists orders
+type Store interface {
+	// ListActive returns the orders not yet picked up or cancelled with their dishes
+	ListActive(ctx context.Context) ([]OrderView, error)
+	// ByID returns one order with its dishes, status history, refunds and payments
+	ByID(ctx context.Context, id int) (OrderView, error)
+	// Create stores the order, its lines with their unit prices and
+	// modifiers, and its first status
+	Create(ctx context.Context, o *Order) error
+	// InTx runs fn in one transaction, rolled back if fn returns an error
+	InTx(ctx context.Context, fn func(Tx) error) error
+}
+
+var (
+	_ Store = (*PostgresStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// Tx is the part of a transaction status changes and refunds need
+type Tx interface {
+	warehouse.StockTx
+	// LockOrder locks the order against concurrent status changes
+	LockOrder(ctx context.Context, orderID int) (OrderState, error)
+	// Lines returns the order lines with the unit prices they were sold at
+	Lines(ctx context.Context, orderID int) ([]OrderLine, error)
+	// RecipeUsage returns what one unit of every line consumes according
+	// to the current recipes and its modifiers. Preparations are exploded
+	// into products or taken from their own stock, as each one is set up.
+	RecipeUsage(ctx context.Context, orderID int) ([]LineUsage, error)
+	// RecordUsage stores what was deducted per line so a refund can give
+	// back exactly that even after the recipe changes
+	RecordUsage(ctx context.Context, usage []LineUsage) error
+	// DeductedUsage returns the usage recorded by RecordUsage
+	DeductedUsage(ctx context.Context, orderID int) ([]LineUsage, error)
+	MarkStockDeducted(ctx context.Context, orderID int, at time.Time) error
+	// InsertRefund stores the refund with its lines and counts the lines
+	// as refunded
+	InsertRefund(ctx context.Context, refund *Refund) error
+	// OpenShift returns the open shift of the user, locked against closing
+	// until the transaction ends
+	OpenShift(ctx context.Context, userID int) (int, bool, error)
+	// PaidAmount returns the sum of the order's payments
+	PaidAmount(ctx context.Context, orderID int) (money.Amount, error)
+	InsertPayment(ctx context.Context, payment *Payment) error
+	// InsertWriteOff books products as written off without touching stock,
+	// which the caller has already settled
+	InsertWriteOff(ctx context.Context, userID int, notes string, at time.Time, products map[int]float64) (int, error)
+	// SetStatus moves the order to change.To and records the change
+	SetStatus(ctx context.Context, orderID int, change StatusChange) error
+}
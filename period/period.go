@@ -0,0 +1,45 @@
+// Package period reads the from and to query parameters of list and
+// report endpoints
+package period
+
+import (
+	"errors"
+	"net/url"
+	"time"
+)
+
+var (
+	ErrInvalidFrom = errors.New("Invalid from")
+	ErrInvalidTo   = errors.New("Invalid to")
+)
+
+// FromQuery returns the period given by ?from= and ?to=, each a date or an
+// RFC 3339 time, a date to including that whole day. Either left out
+// defaults to the last days days up to now.
+func FromQuery(query url.Values, days int) (from, to time.Time, err error) {
+	to = time.Now()
+	from = to.AddDate(0, 0, -days)
+	if v := query.Get("from"); v != "" {
+		if from, err = ParseTime(v, false); err != nil {
+			return from, to, ErrInvalidFrom
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if to, err = ParseTime(v, true); err != nil {
+			return from, to, ErrInvalidTo
+		}
+	}
+	return from, to, nil
+}
+
+// ParseTime reads a date or an RFC 3339 time. A date read as the end of a
+// range means the start of the next day.
+func ParseTime(v string, end bool) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,269 @@
+package preparations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"randevu-shawarma-server/dishes"
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/users"
+	"randevu-shawarma-server/warehouse"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type Service struct {
+	store Store
+	auth  *users.Service
+	units dishes.Units
+}
+
+func NewService(store Store, auth *users.Service, units dishes.Units) *Service {
+	return &Service{store: store, auth: auth, units: units}
+}
+
+// RegisterRoutes registers all preparation routes
+func (s *Service) RegisterRoutes(router *httprouter.Router) {
+	router.GET("/preparations", s.auth.Authorize(users.PermDishesRead)(s.GetPreparations))
+	router.POST("/preparations", s.auth.Authorize(users.PermMenuManage)(s.SavePreparation))
+	router.PUT("/preparations/:id", s.auth.Authorize(users.PermMenuManage)(s.SavePreparation))
+	router.GET("/preparations/:id/productions", s.auth.Authorize(users.PermWarehouseRead)(s.GetProductions))
+	router.POST("/preparations/:id/productions", s.auth.Authorize(users.PermProductionRun)(s.Produce))
+}
+
+func (s *Service) GetPreparations(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	list, err := s.store.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// SavePreparation creates a preparation (POST) or replaces one (PUT)
+// together with its recipe
+func (s *Service) SavePreparation(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var prep Preparation
+	err := json.NewDecoder(r.Body).Decode(&prep)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	prep.ID = 0
+	if idParam := ps.ByName("id"); idParam != "" {
+		prep.ID, err = strconv.Atoi(idParam)
+		if err != nil {
+			http.Error(w, "Invalid preparation id", http.StatusBadRequest)
+			return
+		}
+	}
+	if msg := normalizePreparation(&prep); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	created := prep.ID == 0
+	err = dishes.ConvertRecipe(r.Context(), s.units, prep.Recipe)
+	if err == nil {
+		err = s.store.Save(r.Context(), &prep)
+	}
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, ErrUnknownProduct):
+		http.Error(w, "Recipe refers to an unknown product", http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrMadeFromItself):
+		http.Error(w, "A preparation cannot be made from itself", http.StatusBadRequest)
+		return
+	case errors.Is(err, dishes.ErrUnknownUnit):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(prep)
+}
+
+// normalizePreparation checks a preparation from a request and returns
+// what is wrong with it, if anything. Defaults are full yield and
+// exploding into ingredients, which is how sales worked before.
+func normalizePreparation(prep *Preparation) string {
+	prep.Name = strings.TrimSpace(prep.Name)
+	if prep.Name == "" {
+		return "Name is required"
+	}
+	if prep.YieldPercent == 0 {
+		prep.YieldPercent = 100
+	}
+	if prep.YieldPercent < 0 {
+		return "yieldPercent must be positive"
+	}
+	if prep.DeductFrom == "" {
+		prep.DeductFrom = DeductIngredients
+	}
+	if !prep.DeductFrom.Valid() {
+		return "deductFrom must be ingredients or stock"
+	}
+	if len(prep.Recipe) == 0 {
+		return "Recipe is required"
+	}
+
+	products := map[int]bool{}
+	for _, line := range prep.Recipe {
+		if line.Quantity <= 0 {
+			return "Recipe quantities must be positive"
+		}
+		if products[line.ProductID] {
+			return "Recipe lists a product twice"
+		}
+		products[line.ProductID] = true
+	}
+	return ""
+}
+
+func (s *Service) GetProductions(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid preparation id", http.StatusBadRequest)
+		return
+	}
+
+	list, err := s.store.Productions(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// Produce records a batch: the raw products of the recipe leave the
+// warehouse and what came out is added at their cost
+func (s *Service) Produce(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid preparation id", http.StatusBadRequest)
+		return
+	}
+
+	var req ProductionRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Planned <= 0 {
+		http.Error(w, "planned must be positive", http.StatusBadRequest)
+		return
+	}
+	if req.Quantity != nil && *req.Quantity <= 0 {
+		http.Error(w, "quantity must be positive", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := users.ClaimsFromContext(r.Context())
+	production, err := s.produce(r.Context(), id, claims.UserID, req)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case warehouse.WriteShortageError(w, err):
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(production)
+}
+
+// produce consumes recipe × planned of every raw product, as far as their
+// stock policies allow, and adds the output to the preparation's stock,
+// merging its unit cost into the weighted average
+func (s *Service) produce(ctx context.Context, preparationID, userID int, req ProductionRequest) (Production, error) {
+	production := Production{
+		PreparationID: preparationID,
+		UserID:        userID,
+		Planned:       req.Planned,
+		Notes:         strings.TrimSpace(req.Notes),
+		ExpiresAt:     req.ExpiresAt,
+		CreatedAt:     time.Now(),
+		Cost:          money.Zero(),
+	}
+
+	err := s.store.InTx(ctx, func(tx Tx) error {
+		prep, err := tx.Preparation(ctx, preparationID)
+		if err != nil {
+			return err
+		}
+
+		production.Quantity = req.Planned * prep.YieldPercent / 100
+		if req.Quantity != nil {
+			production.Quantity = *req.Quantity
+		}
+		production.LossPercent = production.lossPercent()
+
+		quantities := map[int]float64{}
+		for _, line := range prep.Recipe {
+			quantities[line.ProductID] += line.Quantity * req.Planned
+		}
+		// Hold the ingredients so their costs cannot change under us
+		if _, err := tx.Shortages(ctx, quantities); err != nil {
+			return err
+		}
+		for _, line := range prep.Recipe {
+			used, err := consume(ctx, tx, line, req.Planned)
+			if err != nil {
+				return err
+			}
+			production.Ingredients = append(production.Ingredients, used)
+			production.Cost = production.Cost.Add(used.Cost)
+		}
+
+		production.UnitCost, err = production.Cost.PerUnit(production.Quantity)
+		if err != nil {
+			return err
+		}
+
+		// Stock moves once the production has an id to book it against
+		if err := tx.InsertProduction(ctx, &production); err != nil {
+			return err
+		}
+		src := warehouse.Source{Type: warehouse.SourceProduction, ID: production.ID, UserID: userID}
+		if err := tx.Take(ctx, quantities, src); err != nil {
+			return err
+		}
+		_, err = tx.Receive(ctx, prep.ProductID, production.Quantity, production.UnitCost, src, production.ExpiresAt)
+		return err
+	})
+	return production, err
+}
+
+// consume values one recipe line for the planned quantity as taking it
+// from stock would, under the deployment's costing method
+func consume(ctx context.Context, tx Tx, line dishes.RecipeLine, planned float64) (ProductionIngredient, error) {
+	used := ProductionIngredient{ProductID: line.ProductID, Quantity: line.Quantity * planned}
+
+	cost, err := tx.Cost(ctx, line.ProductID, used.Quantity)
+	used.Cost = cost
+	return used, err
+}
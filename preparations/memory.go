@@ -0,0 +1,134 @@
+package preparations
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"randevu-shawarma-server/dishes"
+	"randevu-shawarma-server/warehouse"
+)
+
+// MemoryStore keeps preparations in memory and moves stock in the shared
+// warehouse.MemoryStore, where their products are registered too
+type MemoryStore struct {
+	mu               sync.Mutex
+	stock            *warehouse.MemoryStore
+	preparations     map[int]Preparation
+	productions      []Production
+	nextID           int
+	nextProductionID int
+}
+
+func NewMemoryStore(stock *warehouse.MemoryStore) *MemoryStore {
+	return &MemoryStore{
+		stock:            stock,
+		preparations:     map[int]Preparation{},
+		nextID:           1,
+		nextProductionID: 1,
+	}
+}
+
+func copyPreparation(prep Preparation) Preparation {
+	prep.Recipe = append([]dishes.RecipeLine{}, prep.Recipe...)
+	return prep
+}
+
+func (m *MemoryStore) List(ctx context.Context) ([]Preparation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := []Preparation{}
+	for _, prep := range m.preparations {
+		list = append(list, copyPreparation(prep))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (m *MemoryStore) Save(ctx context.Context, prep *Preparation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, line := range prep.Recipe {
+		if !m.stock.HasProduct(line.ProductID) {
+			return ErrUnknownProduct
+		}
+	}
+
+	if prep.ID == 0 {
+		prep.ID = m.nextID
+		m.nextID++
+		prep.ProductID = m.stock.NewProduct(prep.Name)
+	} else {
+		existing, ok := m.preparations[prep.ID]
+		if !ok {
+			return ErrNotFound
+		}
+		for _, line := range prep.Recipe {
+			if line.ProductID == existing.ProductID {
+				return ErrMadeFromItself
+			}
+		}
+		prep.ProductID = existing.ProductID
+		m.stock.AddProduct(prep.ProductID, prep.Name)
+	}
+	m.preparations[prep.ID] = copyPreparation(*prep)
+	return nil
+}
+
+func (m *MemoryStore) Productions(ctx context.Context, preparationID int) ([]Production, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := []Production{}
+	for i := len(m.productions) - 1; i >= 0; i-- {
+		if m.productions[i].PreparationID == preparationID {
+			list = append(list, m.productions[i])
+		}
+	}
+	return list, nil
+}
+
+func (m *MemoryStore) InTx(ctx context.Context, fn func(Tx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.stock.Tx(func(stock warehouse.StockTx) error {
+		tx := &memoryTx{StockTx: stock, store: m}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		m.productions = append(m.productions, tx.productions...)
+		return nil
+	})
+}
+
+type memoryTx struct {
+	warehouse.StockTx
+	store       *MemoryStore
+	productions []Production
+}
+
+func (t *memoryTx) Preparation(ctx context.Context, id int) (Preparation, error) {
+	prep, ok := t.store.preparations[id]
+	if !ok {
+		return Preparation{}, ErrNotFound
+	}
+	return copyPreparation(prep), nil
+}
+
+func (t *memoryTx) InsertProduction(ctx context.Context, p *Production) error {
+	p.ID = t.store.nextProductionID
+	t.store.nextProductionID++
+
+	stored := *p
+	stored.Ingredients = append([]ProductionIngredient(nil), p.Ingredients...)
+	t.productions = append(t.productions, stored)
+	return nil
+}
@@ -0,0 +1,76 @@
+package preparations
+
+import (
+	"math"
+	"time"
+
+	"randevu-shawarma-server/dishes"
+	"randevu-shawarma-server/money"
+)
+
+// DeductFrom decides what selling a dish made with a preparation takes
+// from the warehouse
+type DeductFrom string
+
+const (
+	// DeductIngredients explodes the preparation into its raw products
+	DeductIngredients DeductFrom = "ingredients"
+	// DeductStock takes the preparation itself, made beforehand by production
+	DeductStock DeductFrom = "stock"
+)
+
+func (d DeductFrom) Valid() bool {
+	return d == DeductIngredients || d == DeductStock
+}
+
+// Preparation is a semi-finished good such as a sauce or marinated
+// chicken. Its stock is kept under ProductID. Recipe is what one unit
+// takes at full yield; YieldPercent is how much of that usually comes out.
+type Preparation struct {
+	ID           int                 `json:"id"`
+	Name         string              `json:"name"`
+	ProductID    int                 `json:"productId"`
+	YieldPercent float64             `json:"yieldPercent"`
+	DeductFrom   DeductFrom          `json:"deductFrom"`
+	Recipe       []dishes.RecipeLine `json:"recipe"`
+}
+
+// Production is a batch of a preparation made from raw products. Planned
+// is what the recipe was made for and Quantity what actually came out.
+type Production struct {
+	ID            int                    `json:"id"`
+	PreparationID int                    `json:"preparationId"`
+	UserID        int                    `json:"userId"`
+	Planned       float64                `json:"planned"`
+	Quantity      float64                `json:"quantity"`
+	LossPercent   float64                `json:"lossPercent"`
+	Cost          money.Amount           `json:"cost"`
+	UnitCost      money.UnitCost         `json:"unitCost"`
+	Notes         string                 `json:"notes"`
+	ExpiresAt     *time.Time             `json:"expiresAt"`
+	CreatedAt     time.Time              `json:"createdAt"`
+	Ingredients   []ProductionIngredient `json:"ingredients"`
+}
+
+// ProductionIngredient is a raw product a production consumed, valued as
+// it left the warehouse
+type ProductionIngredient struct {
+	ProductID int          `json:"productId"`
+	Quantity  float64      `json:"quantity"`
+	Cost      money.Amount `json:"cost"`
+}
+
+// ProductionRequest is the body of POST /preparations/:id/productions.
+// Without a quantity the preparation's usual yield is assumed. ExpiresAt
+// is when the batch must be used by.
+type ProductionRequest struct {
+	Planned   float64    `json:"planned"`
+	Quantity  *float64   `json:"quantity"`
+	Notes     string     `json:"notes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// lossPercent is the share of the planned quantity that did not come out
+func (p Production) lossPercent() float64 {
+	return math.Round((1-p.Quantity/p.Planned)*10000) / 100
+}
@@ -0,0 +1,237 @@
+package preparations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+
+	"randevu-shawarma-server/dishes"
+	"randevu-shawarma-server/warehouse"
+)
+
+type PostgresStore struct {
+	db    *sql.DB
+	stock *warehouse.PostgresStore
+}
+
+func NewPostgresStore(db *sql.DB, stock *warehouse.PostgresStore) *PostgresStore {
+	return &PostgresStore{db: db, stock: stock}
+}
+
+// queryer is what *sql.DB and *sql.Tx have in common
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (s *PostgresStore) List(ctx context.Context) ([]Preparation, error) {
+	return preparationsWhere(ctx, s.db, "true")
+}
+
+// preparationsWhere loads the preparations and their recipes in two queries
+func preparationsWhere(ctx context.Context, q queryer, where string, args ...interface{}) ([]Preparation, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, name, product_id, yield_percent, deduct_from
+		FROM public."Preparations"
+		WHERE `+where+`
+		ORDER BY name, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Preparation{}
+	index := map[int]int{}
+	var ids []int
+	for rows.Next() {
+		var prep Preparation
+		if err := rows.Scan(&prep.ID, &prep.Name, &prep.ProductID, &prep.YieldPercent, &prep.DeductFrom); err != nil {
+			return nil, err
+		}
+		prep.Recipe = []dishes.RecipeLine{}
+		index[prep.ID] = len(list)
+		ids = append(ids, prep.ID)
+		list = append(list, prep)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return list, nil
+	}
+
+	recipeRows, err := q.QueryContext(ctx, `
+		SELECT preparation_id, product_id, quantity
+		FROM public."Preparation_recipe"
+		WHERE preparation_id = ANY($1)
+		ORDER BY preparation_id, id`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer recipeRows.Close()
+
+	for recipeRows.Next() {
+		var prepID int
+		var line dishes.RecipeLine
+		if err := recipeRows.Scan(&prepID, &line.ProductID, &line.Quantity); err != nil {
+			return nil, err
+		}
+		prep := &list[index[prepID]]
+		prep.Recipe = append(prep.Recipe, line)
+	}
+	return list, recipeRows.Err()
+}
+
+func (s *PostgresStore) Save(ctx context.Context, prep *Preparation) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids := make([]int, 0, len(prep.Recipe))
+	for _, line := range prep.Recipe {
+		ids = append(ids, line.ProductID)
+	}
+	var found int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM public.\"Products\" WHERE id = ANY($1)", pq.Array(ids)).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if found != len(ids) {
+		return ErrUnknownProduct
+	}
+
+	if prep.ID == 0 {
+		err = tx.QueryRowContext(ctx,
+			"INSERT INTO public.\"Products\" (name) VALUES ($1) RETURNING id",
+			prep.Name,
+		).Scan(&prep.ProductID)
+		if err != nil {
+			return err
+		}
+		err = tx.QueryRowContext(ctx,
+			"INSERT INTO public.\"Preparations\" (name, product_id, yield_percent, deduct_from) VALUES ($1, $2, $3, $4) RETURNING id",
+			prep.Name, prep.ProductID, prep.YieldPercent, prep.DeductFrom,
+		).Scan(&prep.ID)
+	} else {
+		err = tx.QueryRowContext(ctx,
+			"UPDATE public.\"Preparations\" SET name = $1, yield_percent = $2, deduct_from = $3 WHERE id = $4 RETURNING product_id",
+			prep.Name, prep.YieldPercent, prep.DeductFrom, prep.ID,
+		).Scan(&prep.ProductID)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "UPDATE public.\"Products\" SET name = $1 WHERE id = $2", prep.Name, prep.ProductID)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM public.\"Preparation_recipe\" WHERE preparation_id = $1", prep.ID); err != nil {
+		return err
+	}
+	for _, line := range prep.Recipe {
+		if line.ProductID == prep.ProductID {
+			return ErrMadeFromItself
+		}
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO public.\"Preparation_recipe\" (preparation_id, product_id, quantity) VALUES ($1, $2, $3)",
+			prep.ID, line.ProductID, line.Quantity,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) Productions(ctx context.Context, preparationID int) ([]Production, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.id, p.preparation_id, p.user_id, p.planned, p.quantity, p.cost, p.notes, p.expires_at, p.created_at,
+			i.product_id, i.quantity, i.cost
+		FROM public."Productions" p
+		JOIN public."Production_ingredients" i ON i.production_id = p.id
+		WHERE p.preparation_id = $1
+		ORDER BY p.created_at DESC, p.id DESC, i.id`, preparationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Production{}
+	for rows.Next() {
+		var p Production
+		var ingredient ProductionIngredient
+		err := rows.Scan(&p.ID, &p.PreparationID, &p.UserID, &p.Planned, &p.Quantity, &p.Cost, &p.Notes, &p.ExpiresAt, &p.CreatedAt,
+			&ingredient.ProductID, &ingredient.Quantity, &ingredient.Cost)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(list); n > 0 && list[n-1].ID == p.ID {
+			list[n-1].Ingredients = append(list[n-1].Ingredients, ingredient)
+			continue
+		}
+		p.LossPercent = p.lossPercent()
+		if p.UnitCost, err = p.Cost.PerUnit(p.Quantity); err != nil {
+			return nil, err
+		}
+		p.Ingredients = []ProductionIngredient{ingredient}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+func (s *PostgresStore) InTx(ctx context.Context, fn func(Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&postgresTx{StockTx: s.stock.Stock(tx), tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type postgresTx struct {
+	warehouse.StockTx
+	tx *sql.Tx
+}
+
+func (t *postgresTx) Preparation(ctx context.Context, id int) (Preparation, error) {
+	list, err := preparationsWhere(ctx, t.tx, "id = $1", id)
+	if err != nil {
+		return Preparation{}, err
+	}
+	if len(list) == 0 {
+		return Preparation{}, ErrNotFound
+	}
+	return list[0], nil
+}
+
+func (t *postgresTx) InsertProduction(ctx context.Context, p *Production) error {
+	err := t.tx.QueryRowContext(ctx, `
+		INSERT INTO public."Productions" (preparation_id, user_id, planned, quantity, cost, notes, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		p.PreparationID, p.UserID, p.Planned, p.Quantity, p.Cost, p.Notes, p.ExpiresAt, p.CreatedAt,
+	).Scan(&p.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, ingredient := range p.Ingredients {
+		_, err := t.tx.ExecContext(ctx,
+			"INSERT INTO public.\"Production_ingredients\" (production_id, product_id, quantity, cost) VALUES ($1, $2, $3, $4)",
+			p.ID, ingredient.ProductID, ingredient.Quantity, ingredient.Cost,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
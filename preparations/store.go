@@ -0,0 +1,38 @@
+package preparations
+
+import (
+	"context"
+	"errors"
+
+	"randevu-shawarma-server/warehouse"
+)
+
+var (
+	ErrNotFound       = errors.New("not found")
+	ErrUnknownProduct = errors.New("unknown product")
+	ErrMadeFromItself = errors.New("preparation made from itself")
+)
+
+// Store persists preparations and their production
+type Store interface {
+	List(ctx context.Context) ([]Preparation, error)
+	// Save creates the preparation, and the product its stock is kept
+	// under, when its ID is zero and replaces it otherwise
+	Save(ctx context.Context, prep *Preparation) error
+	// Productions returns the batches made of a preparation, newest first
+	Productions(ctx context.Context, preparationID int) ([]Production, error)
+	// InTx runs fn in one transaction, rolled back if fn returns an error
+	InTx(ctx context.Context, fn func(Tx) error) error
+}
+
+var (
+	_ Store = (*PostgresStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// Tx is the part of a transaction a production needs
+type Tx interface {
+	warehouse.StockTx
+	Preparation(ctx context.Context, id int) (Preparation, error)
+	InsertProduction(ctx context.Context, p *Production) error
+}
@@ -0,0 +1,302 @@
+package shifts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/period"
+	"randevu-shawarma-server/users"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type Service struct {
+	store Store
+	auth  *users.Service
+}
+
+func NewService(store Store, auth *users.Service) *Service {
+	return &Service{store: store, auth: auth}
+}
+
+// RegisterRoutes registers all shift routes
+func (s *Service) RegisterRoutes(router *httprouter.Router) {
+	router.GET("/shifts", s.auth.Authorize(users.PermShiftsManage)(s.ListShifts))
+	router.POST("/shifts", s.auth.Authorize(users.PermShiftsOperate)(s.OpenShift))
+	router.GET("/shifts/:id", s.auth.Authorize(users.PermShiftsOperate)(s.GetShift))
+	router.GET("/shifts/:id/report", s.auth.Authorize(users.PermShiftsOperate)(s.GetReport))
+	router.POST("/shifts/:id/movements", s.auth.Authorize(users.PermShiftsOperate)(s.AddMovement))
+	router.POST("/shifts/:id/close", s.auth.Authorize(users.PermShiftsOperate)(s.CloseShift))
+}
+
+func (s *Service) OpenShift(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var body struct {
+		OpeningFloat money.Amount `json:"openingFloat"`
+		Notes        string       `json:"notes"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.OpeningFloat.IsNegative() {
+		http.Error(w, "Opening float must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := users.ClaimsFromContext(r.Context())
+	shift := Shift{
+		UserID:       claims.UserID,
+		OpenedAt:     time.Now(),
+		OpeningFloat: body.OpeningFloat,
+		Notes:        body.Notes,
+	}
+	err = s.store.Open(r.Context(), &shift)
+	if errors.Is(err, ErrAlreadyOpen) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(shift)
+}
+
+// ListShifts lists shifts opened between from and to, which take a date
+// or an RFC 3339 time, a date to including that whole day, and default to
+// the last 30 days
+func (s *Service) ListShifts(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	from, to, err := period.FromQuery(r.URL.Query(), 30)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	shifts, err := s.store.List(r.Context(), from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shifts)
+}
+
+// GetShift returns a shift; the id "current" means the caller's open shift
+func (s *Service) GetShift(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	shift, ok := s.loadShift(w, r, ps)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shift)
+}
+
+// GetReport returns the Z-report of a closed shift or the running
+// X-report of an open one, as JSON or, with format=text, ready to print
+func (s *Service) GetReport(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	shift, ok := s.loadShift(w, r, ps)
+	if !ok {
+		return
+	}
+
+	until := time.Now()
+	if shift.ClosedAt != nil {
+		until = *shift.ClosedAt
+	}
+	activity, err := s.store.Activity(r.Context(), shift, until)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeReport(w, r, buildReport(shift, activity))
+}
+
+func (s *Service) AddMovement(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid shift id", http.StatusBadRequest)
+		return
+	}
+
+	var movement CashMovement
+	err = json.NewDecoder(r.Body).Decode(&movement)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch movement.Kind {
+	case MovementCashIn, MovementDrop, MovementPayout:
+	default:
+		http.Error(w, "Kind must be cash_in, drop or payout", http.StatusBadRequest)
+		return
+	}
+	if movement.Amount.Cmp(money.Zero()) <= 0 {
+		http.Error(w, "Amount must be positive", http.StatusBadRequest)
+		return
+	}
+	if movement.Kind == MovementPayout && strings.TrimSpace(movement.Reason) == "" {
+		http.Error(w, "Reason is required for a payout", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := users.ClaimsFromContext(r.Context())
+	movement.ID = 0
+	movement.ShiftID = id
+	movement.UserID = claims.UserID
+	movement.CreatedAt = time.Now()
+	err = s.store.InTx(r.Context(), func(tx Tx) error {
+		shift, err := s.lockOwnShift(r.Context(), tx, id, claims)
+		if err != nil {
+			return err
+		}
+
+		// The drawer cannot hand out more than it holds
+		if movement.Kind != MovementCashIn {
+			activity, err := tx.Activity(r.Context(), shift, movement.CreatedAt)
+			if err != nil {
+				return err
+			}
+			if buildReport(shift, activity).ExpectedCash.Cmp(movement.Amount) < 0 {
+				return errInsufficientCash
+			}
+		}
+		return tx.InsertMovement(r.Context(), &movement)
+	})
+	if !writeShiftError(w, r, err) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(movement)
+}
+
+// CloseShift records the counted cash and answers with the Z-report
+func (s *Service) CloseShift(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid shift id", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		CountedCash *money.Amount `json:"countedCash"`
+		Notes       string        `json:"notes"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.CountedCash == nil || body.CountedCash.IsNegative() {
+		http.Error(w, "Counted cash is required", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := users.ClaimsFromContext(r.Context())
+	var report Report
+	err = s.store.InTx(r.Context(), func(tx Tx) error {
+		shift, err := s.lockOwnShift(r.Context(), tx, id, claims)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		activity, err := tx.Activity(r.Context(), shift, now)
+		if err != nil {
+			return err
+		}
+		expected := buildReport(shift, activity).ExpectedCash
+
+		shift.ClosedAt = &now
+		shift.ClosedBy = &claims.UserID
+		shift.ExpectedCash = &expected
+		shift.CountedCash = body.CountedCash
+		if body.Notes != "" {
+			shift.Notes = strings.TrimSpace(shift.Notes + "\n" + body.Notes)
+		}
+		if err := tx.Close(r.Context(), shift); err != nil {
+			return err
+		}
+		report = buildReport(shift, activity)
+		return nil
+	})
+	if !writeShiftError(w, r, err) {
+		return
+	}
+	writeReport(w, r, report)
+}
+
+var (
+	errForbidden        = errors.New("forbidden")
+	errInsufficientCash = errors.New("not enough cash in the drawer")
+)
+
+// loadShift loads the shift named in the path and answers for the
+// request if it cannot be shown to the caller
+func (s *Service) loadShift(w http.ResponseWriter, r *http.Request, ps httprouter.Params) (Shift, bool) {
+	claims, _ := users.ClaimsFromContext(r.Context())
+
+	var shift Shift
+	var err error
+	if ps.ByName("id") == "current" {
+		shift, err = s.store.OpenFor(r.Context(), claims.UserID)
+	} else {
+		id, convErr := strconv.Atoi(ps.ByName("id"))
+		if convErr != nil {
+			http.Error(w, "Invalid shift id", http.StatusBadRequest)
+			return Shift{}, false
+		}
+		shift, err = s.store.ByID(r.Context(), id)
+	}
+	if err == nil && !canAccess(claims, shift) {
+		err = errForbidden
+	}
+	return shift, writeShiftError(w, r, err)
+}
+
+// lockOwnShift locks an open shift the caller may work on
+func (s *Service) lockOwnShift(ctx context.Context, tx Tx, id int, claims *users.Claims) (Shift, error) {
+	shift, err := tx.LockShift(ctx, id)
+	if err != nil {
+		return Shift{}, err
+	}
+	if !canAccess(claims, shift) {
+		return Shift{}, errForbidden
+	}
+	if !shift.Open() {
+		return Shift{}, ErrClosed
+	}
+	return shift, nil
+}
+
+// canAccess lets cashiers work on their own shifts and managers on any
+func canAccess(claims *users.Claims, shift Shift) bool {
+	return claims.UserID == shift.UserID || claims.Can(users.PermShiftsManage)
+}
+
+// writeShiftError answers for a failed shift operation and reports whether err was nil
+func writeShiftError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, errForbidden):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	case errors.Is(err, ErrClosed), errors.Is(err, errInsufficientCash):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+package shifts
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/orders"
+	"randevu-shawarma-server/warehouse"
+	"randevu-shawarma-server/writeoff"
+)
+
+// MemoryStore keeps shifts in memory and reads activity from the other
+// in-memory stores. Refund write-offs kept by orders.MemoryStore carry no
+// time and are left out of reports.
+type MemoryStore struct {
+	mu             sync.Mutex
+	shifts         map[int]Shift
+	nextID         int
+	nextMovementID int
+	orders         *orders.MemoryStore
+	writeOffs      *writeoff.MemoryStore
+	stock          *warehouse.MemoryStore
+
+	// openMu guards open on its own: orders.MemoryStore looks shifts up
+	// while holding its lock, and this store reads orders while holding mu
+	openMu sync.Mutex
+	open   map[int]int
+}
+
+// NewMemoryStore returns a store that also answers the open-shift lookups
+// of ord
+func NewMemoryStore(ord *orders.MemoryStore, writeOffs *writeoff.MemoryStore, stock *warehouse.MemoryStore) *MemoryStore {
+	m := &MemoryStore{
+		shifts:         map[int]Shift{},
+		nextID:         1,
+		nextMovementID: 1,
+		orders:         ord,
+		writeOffs:      writeOffs,
+		stock:          stock,
+		open:           map[int]int{},
+	}
+	ord.SetShiftLookup(m.openShift)
+	return m
+}
+
+func (m *MemoryStore) openShift(userID int) (int, bool) {
+	m.openMu.Lock()
+	defer m.openMu.Unlock()
+	id, ok := m.open[userID]
+	return id, ok
+}
+
+func (m *MemoryStore) Open(ctx context.Context, shift *Shift) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.openShift(shift.UserID); ok {
+		return ErrAlreadyOpen
+	}
+	shift.ID = m.nextID
+	m.nextID++
+	m.shifts[shift.ID] = *shift
+
+	m.openMu.Lock()
+	m.open[shift.UserID] = shift.ID
+	m.openMu.Unlock()
+	return nil
+}
+
+func (m *MemoryStore) ByID(ctx context.Context, id int) (Shift, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	shift, ok := m.shifts[id]
+	if !ok {
+		return Shift{}, ErrNotFound
+	}
+	return shift, nil
+}
+
+func (m *MemoryStore) OpenFor(ctx context.Context, userID int) (Shift, error) {
+	id, ok := m.openShift(userID)
+	if !ok {
+		return Shift{}, ErrNotFound
+	}
+	return m.ByID(ctx, id)
+}
+
+func (m *MemoryStore) List(ctx context.Context, from, to time.Time) ([]Shift, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var list []Shift
+	for _, shift := range m.shifts {
+		if !shift.OpenedAt.Before(from) && shift.OpenedAt.Before(to) {
+			shift.Movements = nil
+			list = append(list, shift)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	return list, nil
+}
+
+func (m *MemoryStore) Activity(ctx context.Context, shift Shift, until time.Time) (Activity, error) {
+	var a Activity
+
+	payments := map[orders.PaymentMethod]*PaymentTotal{}
+	for _, p := range m.orders.Payments() {
+		if p.ShiftID == nil || *p.ShiftID != shift.ID {
+			continue
+		}
+		t, ok := payments[p.Method]
+		if !ok {
+			t = &PaymentTotal{Method: p.Method, Amount: money.Zero()}
+			payments[p.Method] = t
+		}
+		t.Count++
+		t.Amount = t.Amount.Add(p.Amount)
+	}
+	for _, t := range payments {
+		a.Payments = append(a.Payments, *t)
+	}
+	sort.Slice(a.Payments, func(i, j int) bool { return a.Payments[i].Method < a.Payments[j].Method })
+
+	refunds := map[orders.PaymentMethod]*RefundTotal{}
+	for _, r := range m.orders.Refunds() {
+		if r.ShiftID == nil || *r.ShiftID != shift.ID {
+			continue
+		}
+		t, ok := refunds[r.PayoutMethod]
+		if !ok {
+			t = &RefundTotal{Method: r.PayoutMethod, Amount: money.Zero(), PaidOut: money.Zero()}
+			refunds[r.PayoutMethod] = t
+		}
+		t.Count++
+		t.Amount = t.Amount.Add(r.Amount)
+		t.PaidOut = t.PaidOut.Add(r.PaidOut)
+	}
+	for _, t := range refunds {
+		a.Refunds = append(a.Refunds, *t)
+	}
+	sort.Slice(a.Refunds, func(i, j int) bool { return a.Refunds[i].Method < a.Refunds[j].Method })
+
+	for _, wo := range m.writeOffs.WriteOffs() {
+		if wo.UserID != shift.UserID || wo.CreatedAt.Before(shift.OpenedAt) || !wo.CreatedAt.Before(until) {
+			continue
+		}
+		value, err := m.writeOffValue(ctx, wo, until)
+		if err != nil {
+			return a, err
+		}
+		a.WriteOffs = append(a.WriteOffs, WriteOffTotal{ID: wo.ID, UserID: wo.UserID, CreatedAt: wo.CreatedAt, Notes: wo.Notes, Value: value})
+	}
+	return a, nil
+}
+
+// writeOffValue is what the stock ledger took the write-off's products
+// out at
+func (m *MemoryStore) writeOffValue(ctx context.Context, wo writeoff.WriteOff, until time.Time) (money.Amount, error) {
+	value := money.Zero()
+	seen := map[int]bool{}
+	for _, line := range wo.Products {
+		if seen[line.ProductID] {
+			continue
+		}
+		seen[line.ProductID] = true
+
+		movements, err := m.stock.Movements(ctx, line.ProductID, wo.CreatedAt, until)
+		if err != nil {
+			return value, err
+		}
+		for _, mv := range movements {
+			if mv.SourceType == warehouse.SourceWriteOff && mv.SourceID != nil && *mv.SourceID == wo.ID {
+				value = value.Add(mv.UnitCost.Mul(-mv.Delta, money.HalfUp))
+			}
+		}
+	}
+	return value, nil
+}
+
+func (m *MemoryStore) InTx(ctx context.Context, fn func(Tx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := &memoryTx{store: m, shifts: map[int]Shift{}}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for id, shift := range tx.shifts {
+		m.shifts[id] = shift
+		if !shift.Open() {
+			m.openMu.Lock()
+			delete(m.open, shift.UserID)
+			m.openMu.Unlock()
+		}
+	}
+	return nil
+}
+
+// memoryTx buffers shift changes until the transaction commits
+type memoryTx struct {
+	store  *MemoryStore
+	shifts map[int]Shift
+}
+
+func (t *memoryTx) LockShift(ctx context.Context, id int) (Shift, error) {
+	if shift, ok := t.shifts[id]; ok {
+		return shift, nil
+	}
+	shift, ok := t.store.shifts[id]
+	if !ok {
+		return Shift{}, ErrNotFound
+	}
+	shift.Movements = append([]CashMovement(nil), shift.Movements...)
+	return shift, nil
+}
+
+func (t *memoryTx) InsertMovement(ctx context.Context, movement *CashMovement) error {
+	shift, err := t.LockShift(ctx, movement.ShiftID)
+	if err != nil {
+		return err
+	}
+	movement.ID = t.store.nextMovementID
+	t.store.nextMovementID++
+	shift.Movements = append(shift.Movements, *movement)
+	t.shifts[shift.ID] = shift
+	return nil
+}
+
+func (t *memoryTx) Activity(ctx context.Context, shift Shift, until time.Time) (Activity, error) {
+	return t.store.Activity(ctx, shift, until)
+}
+
+func (t *memoryTx) Close(ctx context.Context, shift Shift) error {
+	current, err := t.LockShift(ctx, shift.ID)
+	if err != nil {
+		return err
+	}
+	shift.Movements = current.Movements
+	t.shifts[shift.ID] = shift
+	return nil
+}
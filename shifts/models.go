@@ -0,0 +1,102 @@
+package shifts
+
+import (
+	"time"
+
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/orders"
+)
+
+// Shift is one cashier's turn at a drawer, from opening float to count
+type Shift struct {
+	ID           int            `json:"id"`
+	UserID       int            `json:"userId"`
+	OpenedAt     time.Time      `json:"openedAt"`
+	OpeningFloat money.Amount   `json:"openingFloat"`
+	ClosedAt     *time.Time     `json:"closedAt"`
+	ClosedBy     *int           `json:"closedBy"`
+	ExpectedCash *money.Amount  `json:"expectedCash"`
+	CountedCash  *money.Amount  `json:"countedCash"`
+	Notes        string         `json:"notes"`
+	Movements    []CashMovement `json:"movements,omitempty"`
+}
+
+// Open reports whether the shift has not been closed
+func (s Shift) Open() bool {
+	return s.ClosedAt == nil
+}
+
+type MovementKind string
+
+const (
+	// MovementCashIn adds cash to the drawer, e.g. more change
+	MovementCashIn MovementKind = "cash_in"
+	// MovementDrop takes cash out of the drawer into the safe
+	MovementDrop MovementKind = "drop"
+	// MovementPayout pays an expense out of the drawer
+	MovementPayout MovementKind = "payout"
+)
+
+// CashMovement is cash put into or taken out of the drawer other than
+// through orders
+type CashMovement struct {
+	ID        int          `json:"id"`
+	ShiftID   int          `json:"shiftId"`
+	UserID    int          `json:"userId"`
+	Kind      MovementKind `json:"kind"`
+	Amount    money.Amount `json:"amount"`
+	Reason    string       `json:"reason"`
+	CreatedAt time.Time    `json:"createdAt"`
+}
+
+// Activity is what happened during a shift, as the Z-report needs it
+type Activity struct {
+	Payments  []PaymentTotal
+	Refunds   []RefundTotal
+	WriteOffs []WriteOffTotal
+}
+
+// PaymentTotal sums the shift's payments of one method
+type PaymentTotal struct {
+	Method orders.PaymentMethod `json:"method"`
+	Count  int                  `json:"count"`
+	Amount money.Amount         `json:"amount"`
+}
+
+// RefundTotal sums the shift's refunds paid out by one method; Method is
+// empty for refunds of unpaid food
+type RefundTotal struct {
+	Method  orders.PaymentMethod `json:"method"`
+	Count   int                  `json:"count"`
+	Amount  money.Amount         `json:"amount"`
+	PaidOut money.Amount         `json:"paidOut"`
+}
+
+// WriteOffTotal is one write-off the cashier booked during the shift,
+// valued at the cost the stock ledger took its products out at
+type WriteOffTotal struct {
+	ID        int          `json:"id"`
+	UserID    int          `json:"userId"`
+	CreatedAt time.Time    `json:"createdAt"`
+	Notes     string       `json:"notes"`
+	Value     money.Amount `json:"value"`
+}
+
+// Report is the Z-report of a shift, or the X-report of an open one
+type Report struct {
+	Shift          Shift           `json:"shift"`
+	Sales          []PaymentTotal  `json:"sales"`
+	SalesTotal     money.Amount    `json:"salesTotal"`
+	Refunds        []RefundTotal   `json:"refunds"`
+	RefundsTotal   money.Amount    `json:"refundsTotal"`
+	CashIn         money.Amount    `json:"cashIn"`
+	Drops          money.Amount    `json:"drops"`
+	Payouts        money.Amount    `json:"payouts"`
+	WriteOffs      []WriteOffTotal `json:"writeOffs"`
+	WriteOffsTotal money.Amount    `json:"writeOffsTotal"`
+	ExpectedCash   money.Amount    `json:"expectedCash"`
+	CountedCash    *money.Amount   `json:"countedCash"`
+	// Difference is counted minus expected cash; negative means a shortage
+	Difference  *money.Amount `json:"difference"`
+	GeneratedAt time.Time     `json:"generatedAt"`
+}
@@ -0,0 +1,260 @@
+package shifts
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"randevu-shawarma-server/money"
+
+	"github.com/lib/pq"
+)
+
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// queryer is what *sql.DB and *sql.Tx have in common
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+const shiftColumns = `id, user_id, opened_at, opening_float, closed_at, closed_by, expected_cash, counted_cash, notes`
+
+func scanShift(row interface{ Scan(...interface{}) error }) (Shift, error) {
+	var shift Shift
+	var expected, counted sql.NullString
+	err := row.Scan(&shift.ID, &shift.UserID, &shift.OpenedAt, &shift.OpeningFloat,
+		&shift.ClosedAt, &shift.ClosedBy, &expected, &counted, &shift.Notes)
+	if err != nil {
+		return shift, err
+	}
+	if shift.ExpectedCash, err = nullAmount(expected); err != nil {
+		return shift, err
+	}
+	shift.CountedCash, err = nullAmount(counted)
+	return shift, err
+}
+
+func nullAmount(v sql.NullString) (*money.Amount, error) {
+	if !v.Valid {
+		return nil, nil
+	}
+	amount, err := money.Parse(v.String)
+	if err != nil {
+		return nil, err
+	}
+	return &amount, nil
+}
+
+func (s *PostgresStore) Open(ctx context.Context, shift *Shift) error {
+	err := s.db.QueryRowContext(ctx,
+		"INSERT INTO public.\"Shifts\" (user_id, opened_at, opening_float, notes) VALUES ($1, $2, $3, $4) RETURNING id",
+		shift.UserID, shift.OpenedAt, shift.OpeningFloat, shift.Notes,
+	).Scan(&shift.ID)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrAlreadyOpen
+	}
+	return err
+}
+
+func (s *PostgresStore) ByID(ctx context.Context, id int) (Shift, error) {
+	return shiftWhere(ctx, s.db, "id = $1", id)
+}
+
+func (s *PostgresStore) OpenFor(ctx context.Context, userID int) (Shift, error) {
+	return shiftWhere(ctx, s.db, "user_id = $1 AND closed_at IS NULL", userID)
+}
+
+func shiftWhere(ctx context.Context, q queryer, where string, arg interface{}) (Shift, error) {
+	query := "SELECT " + shiftColumns + " FROM public.\"Shifts\" WHERE " + where
+	shift, err := scanShift(q.QueryRowContext(ctx, query, arg))
+	if err == sql.ErrNoRows {
+		return shift, ErrNotFound
+	} else if err != nil {
+		return shift, err
+	}
+
+	shift.Movements, err = movements(ctx, q, shift.ID)
+	return shift, err
+}
+
+func movements(ctx context.Context, q queryer, shiftID int) ([]CashMovement, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, shift_id, user_id, kind, amount, reason, created_at
+		FROM public."Shift_cash_movements"
+		WHERE shift_id = $1
+		ORDER BY created_at, id`, shiftID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []CashMovement
+	for rows.Next() {
+		var m CashMovement
+		if err := rows.Scan(&m.ID, &m.ShiftID, &m.UserID, &m.Kind, &m.Amount, &m.Reason, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
+
+func (s *PostgresStore) List(ctx context.Context, from, to time.Time) ([]Shift, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+shiftColumns+" FROM public.\"Shifts\" WHERE opened_at >= $1 AND opened_at < $2 ORDER BY opened_at DESC, id DESC",
+		from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shifts []Shift
+	for rows.Next() {
+		shift, err := scanShift(rows)
+		if err != nil {
+			return nil, err
+		}
+		shifts = append(shifts, shift)
+	}
+	return shifts, rows.Err()
+}
+
+func (s *PostgresStore) Activity(ctx context.Context, shift Shift, until time.Time) (Activity, error) {
+	return activity(ctx, s.db, shift, until)
+}
+
+func activity(ctx context.Context, q queryer, shift Shift, until time.Time) (Activity, error) {
+	var a Activity
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT method, COUNT(*), SUM(amount)
+		FROM public."Order_payments"
+		WHERE shift_id = $1
+		GROUP BY method
+		ORDER BY method`, shift.ID)
+	if err != nil {
+		return a, err
+	}
+	for rows.Next() {
+		var t PaymentTotal
+		if err := rows.Scan(&t.Method, &t.Count, &t.Amount); err != nil {
+			rows.Close()
+			return a, err
+		}
+		a.Payments = append(a.Payments, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return a, err
+	}
+
+	rows, err = q.QueryContext(ctx, `
+		SELECT COALESCE(payout_method, ''), COUNT(*), SUM(amount), SUM(paid_out)
+		FROM public."Order_refunds"
+		WHERE shift_id = $1
+		GROUP BY payout_method
+		ORDER BY payout_method NULLS FIRST`, shift.ID)
+	if err != nil {
+		return a, err
+	}
+	for rows.Next() {
+		var t RefundTotal
+		if err := rows.Scan(&t.Method, &t.Count, &t.Amount, &t.PaidOut); err != nil {
+			rows.Close()
+			return a, err
+		}
+		a.Refunds = append(a.Refunds, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return a, err
+	}
+
+	// A write-off is worth what the ledger took its stock out at. Those
+	// booked by refunds of cooked food move no stock of their own; their
+	// products left with the order, at the cost the order took them at.
+	rows, err = q.QueryContext(ctx, `
+		SELECT wo.id, wo.user_id, wo.created_at, wo.notes,
+			COALESCE((
+				SELECT SUM(ROUND((-m.delta * m.unit_cost)::numeric, 2))
+				FROM public."Stock_movements" m
+				WHERE m.source_type = 'write_off' AND m.source_id = wo.id
+			), (
+				SELECT SUM(ROUND((wopr.quantity * sold.unit_cost)::numeric, 2))
+				FROM public."Order_refunds" r
+				JOIN public."Write_off_product_relations" wopr ON wopr.write_off_id = wo.id
+				CROSS JOIN LATERAL (
+					SELECT SUM(m.delta * m.unit_cost) / NULLIF(SUM(m.delta), 0) AS unit_cost
+					FROM public."Stock_movements" m
+					WHERE m.source_type = 'order' AND m.source_id = r.order_id
+						AND m.product_id = wopr.product_id AND m.delta < 0
+				) sold
+				WHERE r.write_off_id = wo.id
+			), 0)
+		FROM public."Write_off" wo
+		WHERE wo.user_id = $3 AND wo.created_at >= $1 AND wo.created_at < $2
+		ORDER BY wo.created_at, wo.id`, shift.OpenedAt, until, shift.UserID)
+	if err != nil {
+		return a, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t WriteOffTotal
+		if err := rows.Scan(&t.ID, &t.UserID, &t.CreatedAt, &t.Notes, &t.Value); err != nil {
+			return a, err
+		}
+		a.WriteOffs = append(a.WriteOffs, t)
+	}
+	return a, rows.Err()
+}
+
+func (s *PostgresStore) InTx(ctx context.Context, fn func(Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&postgresTx{tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type postgresTx struct {
+	tx *sql.Tx
+}
+
+func (t *postgresTx) LockShift(ctx context.Context, id int) (Shift, error) {
+	return shiftWhere(ctx, t.tx, "id = $1 FOR UPDATE", id)
+}
+
+func (t *postgresTx) InsertMovement(ctx context.Context, m *CashMovement) error {
+	return t.tx.QueryRowContext(ctx,
+		"INSERT INTO public.\"Shift_cash_movements\" (shift_id, user_id, kind, amount, reason, created_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		m.ShiftID, m.UserID, m.Kind, m.Amount, m.Reason, m.CreatedAt,
+	).Scan(&m.ID)
+}
+
+func (t *postgresTx) Activity(ctx context.Context, shift Shift, until time.Time) (Activity, error) {
+	return activity(ctx, t.tx, shift, until)
+}
+
+func (t *postgresTx) Close(ctx context.Context, shift Shift) error {
+	_, err := t.tx.ExecContext(ctx,
+		"UPDATE public.\"Shifts\" SET closed_at = $1, closed_by = $2, expected_cash = $3, counted_cash = $4, notes = $5 WHERE id = $6",
+		shift.ClosedAt, shift.ClosedBy, shift.ExpectedCash, shift.CountedCash, shift.Notes, shift.ID,
+	)
+	return err
+}
@@ -0,0 +1,147 @@
+package shifts
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/orders"
+)
+
+// buildReport totals the shift. Expected cash is the opening float plus
+// cash sales and cash put in, less drops, payouts and cash refunded; a
+// closed shift keeps the figure it was closed with.
+func buildReport(shift Shift, activity Activity) Report {
+	report := Report{
+		Shift:          shift,
+		Sales:          activity.Payments,
+		SalesTotal:     money.Zero(),
+		Refunds:        activity.Refunds,
+		RefundsTotal:   money.Zero(),
+		CashIn:         money.Zero(),
+		Drops:          money.Zero(),
+		Payouts:        money.Zero(),
+		WriteOffs:      activity.WriteOffs,
+		WriteOffsTotal: money.Zero(),
+		CountedCash:    shift.CountedCash,
+		GeneratedAt:    time.Now(),
+	}
+
+	expected := shift.OpeningFloat
+	for _, sale := range activity.Payments {
+		report.SalesTotal = report.SalesTotal.Add(sale.Amount)
+		if sale.Method == orders.PaymentCash {
+			expected = expected.Add(sale.Amount)
+		}
+	}
+	for _, refund := range activity.Refunds {
+		report.RefundsTotal = report.RefundsTotal.Add(refund.Amount)
+		if refund.Method == orders.PaymentCash {
+			expected = expected.Sub(refund.PaidOut)
+		}
+	}
+	for _, m := range shift.Movements {
+		switch m.Kind {
+		case MovementCashIn:
+			report.CashIn = report.CashIn.Add(m.Amount)
+			expected = expected.Add(m.Amount)
+		case MovementDrop:
+			report.Drops = report.Drops.Add(m.Amount)
+			expected = expected.Sub(m.Amount)
+		case MovementPayout:
+			report.Payouts = report.Payouts.Add(m.Amount)
+			expected = expected.Sub(m.Amount)
+		}
+	}
+	for _, wo := range activity.WriteOffs {
+		report.WriteOffsTotal = report.WriteOffsTotal.Add(wo.Value)
+	}
+
+	report.ExpectedCash = expected
+	if shift.ExpectedCash != nil {
+		report.ExpectedCash = *shift.ExpectedCash
+	}
+	if shift.CountedCash != nil {
+		difference := shift.CountedCash.Sub(report.ExpectedCash)
+		report.Difference = &difference
+	}
+	return report
+}
+
+func writeReport(w http.ResponseWriter, r *http.Request, report Report) {
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, report.Text())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// Text lays the report out for a 40 column receipt printer
+func (r Report) Text() string {
+	var b strings.Builder
+	line := func(label string, value interface{}) {
+		fmt.Fprintf(&b, "%-24s%16v\n", label, value)
+	}
+	rule := strings.Repeat("-", 40) + "\n"
+
+	title := "X-REPORT"
+	if !r.Shift.Open() {
+		title = "Z-REPORT"
+	}
+	fmt.Fprintf(&b, "%s  shift #%d\n", title, r.Shift.ID)
+	line("Cashier", r.Shift.UserID)
+	line("Opened", r.Shift.OpenedAt.Format("2006-01-02 15:04"))
+	if r.Shift.ClosedAt != nil {
+		line("Closed", r.Shift.ClosedAt.Format("2006-01-02 15:04"))
+	}
+	b.WriteString(rule)
+
+	b.WriteString("SALES\n")
+	for _, sale := range r.Sales {
+		line(fmt.Sprintf("  %s (%d)", sale.Method, sale.Count), sale.Amount)
+	}
+	line("Total", r.SalesTotal)
+	b.WriteString(rule)
+
+	b.WriteString("REFUNDS\n")
+	for _, refund := range r.Refunds {
+		method := string(refund.Method)
+		if method == "" {
+			method = "unpaid"
+		}
+		line(fmt.Sprintf("  %s (%d)", method, refund.Count), refund.Amount)
+		if !refund.PaidOut.IsZero() {
+			line("    paid out", refund.PaidOut)
+		}
+	}
+	line("Total", r.RefundsTotal)
+	b.WriteString(rule)
+
+	b.WriteString("WRITE-OFFS\n")
+	for _, wo := range r.WriteOffs {
+		line(fmt.Sprintf("  #%d %s", wo.ID, wo.CreatedAt.Format("15:04")), wo.Value)
+	}
+	line("Total at cost", r.WriteOffsTotal)
+	b.WriteString(rule)
+
+	b.WriteString("CASH DRAWER\n")
+	line("  Opening float", r.Shift.OpeningFloat)
+	line("  Cash in", r.CashIn)
+	line("  Drops", r.Drops.Neg())
+	line("  Payouts", r.Payouts.Neg())
+	line("Expected", r.ExpectedCash)
+	if r.CountedCash != nil {
+		line("Counted", *r.CountedCash)
+	}
+	if r.Difference != nil {
+		line("Difference", *r.Difference)
+	}
+	b.WriteString(rule)
+	line("Printed", r.GeneratedAt.Format("2006-01-02 15:04"))
+	return b.String()
+}
@@ -0,0 +1,134 @@
+package shifts
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/orders"
+	"randevu-shawarma-server/warehouse"
+	"randevu-shawarma-server/writeoff"
+)
+
+func TestReportTotalsShift(t *testing.T) {
+	ctx := context.Background()
+	stock := warehouse.NewMemoryStore(warehouse.CostingAverage)
+	stock.AddProduct(1, "chicken")
+	err := stock.Tx(func(tx warehouse.StockTx) error {
+		_, err := tx.Receive(ctx, 1, 10, money.MustParseUnitCost("2.50"), warehouse.Source{Type: warehouse.SourceSupply, ID: 1, UserID: 1}, nil)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ord := orders.NewMemoryStore(stock)
+	writeOffs := writeoff.NewMemoryStore(stock)
+	store := NewMemoryStore(ord, writeOffs, stock)
+
+	shift := Shift{UserID: 1, OpenedAt: time.Now().Add(-time.Hour), OpeningFloat: money.MustParse("100.00")}
+	if err := store.Open(ctx, &shift); err != nil {
+		t.Fatal(err)
+	}
+	other := 99
+
+	// Sales and refunds count on the shift they were booked on
+	order := orders.Order{UserID: 1, Status: orders.StatusNew, CreatedAt: time.Now()}
+	if err := ord.Create(ctx, &order); err != nil {
+		t.Fatal(err)
+	}
+	err = ord.InTx(ctx, func(tx orders.Tx) error {
+		for _, p := range []orders.Payment{
+			{Method: orders.PaymentCash, Amount: money.MustParse("7.00"), ShiftID: &shift.ID},
+			{Method: orders.PaymentCard, Amount: money.MustParse("5.00"), ShiftID: &shift.ID},
+			{Method: orders.PaymentCash, Amount: money.MustParse("40.00"), ShiftID: &other},
+		} {
+			p.OrderID = order.ID
+			if err := tx.InsertPayment(ctx, &p); err != nil {
+				return err
+			}
+		}
+		return tx.InsertRefund(ctx, &orders.Refund{
+			OrderID:      order.ID,
+			Amount:       money.MustParse("3.50"),
+			PaidOut:      money.MustParse("3.50"),
+			PayoutMethod: orders.PaymentCash,
+			ShiftID:      &shift.ID,
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the cashier's own write-offs are listed, at ledger cost
+	for _, userID := range []int{1, 2} {
+		err = writeOffs.InTx(ctx, func(tx writeoff.Tx) error {
+			wo := writeoff.WriteOff{UserID: userID, CreatedAt: time.Now()}
+			if err := tx.InsertWriteOff(ctx, &wo); err != nil {
+				return err
+			}
+			if err := tx.InsertLine(ctx, wo.ID, writeoff.WriteOffProductRelation{ProductID: 1, Quantity: 2, Factor: 1}); err != nil {
+				return err
+			}
+			return tx.Take(ctx, map[int]float64{1: 2}, warehouse.Source{Type: warehouse.SourceWriteOff, ID: wo.ID, UserID: userID})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = store.InTx(ctx, func(tx Tx) error {
+		for _, m := range []CashMovement{
+			{Kind: MovementCashIn, Amount: money.MustParse("20.00")},
+			{Kind: MovementDrop, Amount: money.MustParse("50.00")},
+			{Kind: MovementPayout, Amount: money.MustParse("10.00")},
+		} {
+			m.ShiftID, m.UserID = shift.ID, 1
+			if err := tx.InsertMovement(ctx, &m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shift, err = store.ByID(ctx, shift.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counted := money.MustParse("60.00")
+	shift.CountedCash = &counted
+	activity, err := store.Activity(ctx, shift, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := buildReport(shift, activity)
+
+	totals := []struct {
+		name string
+		got  money.Amount
+		want string
+	}{
+		{"sales", report.SalesTotal, "12.00"},
+		{"refunds", report.RefundsTotal, "3.50"},
+		{"cash in", report.CashIn, "20.00"},
+		{"drops", report.Drops, "50.00"},
+		{"payouts", report.Payouts, "10.00"},
+		{"write-offs", report.WriteOffsTotal, "5.00"},
+		// 100 float + 7 cash sales - 3.50 cash refunded + 20 - 50 - 10
+		{"expected cash", report.ExpectedCash, "63.50"},
+	}
+	for _, tt := range totals {
+		if tt.got.String() != tt.want {
+			t.Errorf("%s %s, want %s", tt.name, tt.got, tt.want)
+		}
+	}
+	if len(report.Sales) != 2 || len(report.WriteOffs) != 1 {
+		t.Errorf("got %d sales lines and %d write-offs, want 2 and 1", len(report.Sales), len(report.WriteOffs))
+	}
+	if report.Difference == nil || report.Difference.String() != "-3.50" {
+		t.Errorf("difference %v, want a 3.50 shortage", report.Difference)
+	}
+}
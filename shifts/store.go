@@ -0,0 +1,46 @@
+package shifts
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotFound    = errors.New("shift not found")
+	ErrAlreadyOpen = errors.New("a shift is already open for this user")
+	ErrClosed      = errors.New("shift is closed")
+)
+
+// Store persists shifts and reads what happened during them
+type Store interface {
+	// Open starts the shift; ErrAlreadyOpen if the user has one open
+	Open(ctx context.Context, shift *Shift) error
+	// ByID returns the shift with its cash movements
+	ByID(ctx context.Context, id int) (Shift, error)
+	// OpenFor returns the open shift of the user with its cash movements
+	OpenFor(ctx context.Context, userID int) (Shift, error)
+	// List returns the shifts opened in [from, to), newest first
+	List(ctx context.Context, from, to time.Time) ([]Shift, error)
+	// Activity returns the payments and refunds booked on the shift and
+	// the write-offs its cashier made between its opening and until
+	Activity(ctx context.Context, shift Shift, until time.Time) (Activity, error)
+	// InTx runs fn in one transaction, rolled back if fn returns an error
+	InTx(ctx context.Context, fn func(Tx) error) error
+}
+
+var (
+	_ Store = (*PostgresStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// Tx is the part of a transaction cash movements and closing need
+type Tx interface {
+	// LockShift locks the shift against payments, movements and a
+	// concurrent close, and returns it with its cash movements
+	LockShift(ctx context.Context, id int) (Shift, error)
+	InsertMovement(ctx context.Context, movement *CashMovement) error
+	Activity(ctx context.Context, shift Shift, until time.Time) (Activity, error)
+	// Close stores ClosedAt, ClosedBy, ExpectedCash, CountedCash and Notes
+	Close(ctx context.Context, shift Shift) error
+}
@@ -0,0 +1,215 @@
+package stocktake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"randevu-shawarma-server/users"
+	"randevu-shawarma-server/warehouse"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type Service struct {
+	store Store
+	auth  *users.Service
+}
+
+func NewService(store Store, auth *users.Service) *Service {
+	return &Service{store: store, auth: auth}
+}
+
+// RegisterRoutes registers all stocktake routes
+func (s *Service) RegisterRoutes(router *httprouter.Router) {
+	router.GET("/stocktakes", s.auth.Authorize(users.PermStocktakeCount)(s.ListStocktakes))
+	router.POST("/stocktakes", s.auth.Authorize(users.PermStocktakeCount)(s.StartStocktake))
+	router.GET("/stocktakes/:id", s.auth.Authorize(users.PermStocktakeCount)(s.GetStocktake))
+	router.PUT("/stocktakes/:id/counts", s.auth.Authorize(users.PermStocktakeCount)(s.CountStocktake))
+	router.POST("/stocktakes/:id/post", s.auth.Authorize(users.PermStocktakePost)(s.PostStocktake))
+	router.POST("/stocktakes/:id/cancel", s.auth.Authorize(users.PermStocktakePost)(s.CancelStocktake))
+}
+
+func (s *Service) ListStocktakes(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	list, err := s.store.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// StartStocktake snapshots the expected stock of the whole warehouse, or
+// of one category when the body names it
+func (s *Service) StartStocktake(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var body struct {
+		CategoryID *int   `json:"categoryId"`
+		Notes      string `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := users.ClaimsFromContext(r.Context())
+	st := Stocktake{
+		UserID:     claims.UserID,
+		CategoryID: body.CategoryID,
+		Status:     StatusOpen,
+		Notes:      strings.TrimSpace(body.Notes),
+		CreatedAt:  time.Now(),
+	}
+	err := s.store.Start(r.Context(), &st)
+	if !writeStocktakeError(w, r, err) {
+		return
+	}
+	st.review()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(st)
+}
+
+// GetStocktake returns a stocktake with the variance of every line
+// counted so far, valued at the average cost snapshotted with it
+func (s *Service) GetStocktake(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid stocktake id", http.StatusBadRequest)
+		return
+	}
+	s.writeStocktake(w, r, id)
+}
+
+// CountStocktake takes the counts of one device. Several devices may
+// count the same stocktake; a product counted twice keeps the last
+// quantity unless the count is added.
+func (s *Service) CountStocktake(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid stocktake id", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Counts []Count `json:"counts"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body.Counts) == 0 {
+		http.Error(w, "Counts are required", http.StatusBadRequest)
+		return
+	}
+	for _, count := range body.Counts {
+		if count.Quantity < 0 {
+			http.Error(w, "Counted quantity must not be negative", http.StatusBadRequest)
+			return
+		}
+	}
+
+	claims, _ := users.ClaimsFromContext(r.Context())
+	err = s.store.Count(r.Context(), id, body.Counts, claims.UserID, time.Now())
+	if !writeStocktakeError(w, r, err) {
+		return
+	}
+	s.writeStocktake(w, r, id)
+}
+
+// PostStocktake books the variance of every counted line as an
+// adjustment. Lines nobody counted leave their stock as it is.
+func (s *Service) PostStocktake(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid stocktake id", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := users.ClaimsFromContext(r.Context())
+	err = s.post(r.Context(), id, claims.UserID)
+	if !writeStocktakeError(w, r, err) {
+		return
+	}
+	s.writeStocktake(w, r, id)
+}
+
+// post applies the variance to the stock as it is now, so whatever moved
+// while the count was going on is kept
+func (s *Service) post(ctx context.Context, id, userID int) error {
+	return s.store.InTx(ctx, func(tx Tx) error {
+		st, err := tx.Lock(ctx, id)
+		if err != nil {
+			return err
+		}
+		if st.Status != StatusOpen {
+			return ErrNotOpen
+		}
+		st.review()
+		if st.Variance.Counted == 0 {
+			return ErrNothingCounted
+		}
+
+		src := warehouse.Source{Type: warehouse.SourceAdjustment, ID: st.ID, UserID: userID}
+		for _, line := range st.Lines {
+			if line.Variance == nil || *line.Variance == 0 {
+				continue
+			}
+			if err := tx.AddStock(ctx, line.ProductID, *line.Variance, src); err != nil {
+				return err
+			}
+		}
+		return tx.MarkPosted(ctx, id, userID, time.Now())
+	})
+}
+
+func (s *Service) CancelStocktake(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid stocktake id", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := users.ClaimsFromContext(r.Context())
+	err = s.store.Cancel(r.Context(), id, claims.UserID, time.Now())
+	if !writeStocktakeError(w, r, err) {
+		return
+	}
+	s.writeStocktake(w, r, id)
+}
+
+func (s *Service) writeStocktake(w http.ResponseWriter, r *http.Request, id int) {
+	st, err := s.store.ByID(r.Context(), id)
+	if !writeStocktakeError(w, r, err) {
+		return
+	}
+	st.review()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// writeStocktakeError answers for a failed stocktake operation and reports whether err was nil
+func writeStocktakeError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, ErrUnknownCategory), errors.Is(err, ErrNotInStocktake):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrAlreadyOpen), errors.Is(err, ErrNotOpen), errors.Is(err, ErrNothingCounted):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
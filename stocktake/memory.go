@@ -0,0 +1,199 @@
+package stocktake
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"randevu-shawarma-server/warehouse"
+)
+
+// MemoryStore keeps stocktakes in memory and snapshots and moves stock in
+// the shared warehouse.MemoryStore
+type MemoryStore struct {
+	mu         sync.Mutex
+	stock      *warehouse.MemoryStore
+	stocktakes map[int]Stocktake
+	nextID     int
+}
+
+func NewMemoryStore(stock *warehouse.MemoryStore) *MemoryStore {
+	return &MemoryStore{
+		stock:      stock,
+		stocktakes: map[int]Stocktake{},
+		nextID:     1,
+	}
+}
+
+func copyStocktake(st Stocktake) Stocktake {
+	st.Lines = append([]Line{}, st.Lines...)
+	return st
+}
+
+func (m *MemoryStore) List(ctx context.Context) ([]Stocktake, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := []Stocktake{}
+	for _, st := range m.stocktakes {
+		st.Lines = nil
+		list = append(list, st)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	return list, nil
+}
+
+func (m *MemoryStore) ByID(ctx context.Context, id int) (Stocktake, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st, ok := m.stocktakes[id]
+	if !ok {
+		return Stocktake{}, ErrNotFound
+	}
+	return copyStocktake(st), nil
+}
+
+func (m *MemoryStore) Start(ctx context.Context, st *Stocktake) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if st.CategoryID != nil {
+		categories, err := m.stock.Categories(ctx)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, c := range categories {
+			found = found || c.ID == *st.CategoryID
+		}
+		if !found {
+			return ErrUnknownCategory
+		}
+	}
+	for _, open := range m.stocktakes {
+		if open.Status == StatusOpen && (st.CategoryID == nil || open.CategoryID == nil || *open.CategoryID == *st.CategoryID) {
+			return ErrAlreadyOpen
+		}
+	}
+
+	items, err := m.stock.List(ctx)
+	if err != nil {
+		return err
+	}
+	st.Lines = []Line{}
+	for _, item := range items {
+		if st.CategoryID != nil && (item.CategoryID == nil || *item.CategoryID != *st.CategoryID) {
+			continue
+		}
+		st.Lines = append(st.Lines, Line{
+			ProductID:   item.ProductID,
+			ProductName: item.ProductName,
+			Expected:    item.CurrentStock,
+			AverageCost: item.AverageCost,
+		})
+	}
+	sort.Slice(st.Lines, func(i, j int) bool {
+		if st.Lines[i].ProductName != st.Lines[j].ProductName {
+			return st.Lines[i].ProductName < st.Lines[j].ProductName
+		}
+		return st.Lines[i].ProductID < st.Lines[j].ProductID
+	})
+
+	st.ID = m.nextID
+	m.nextID++
+	m.stocktakes[st.ID] = copyStocktake(*st)
+	return nil
+}
+
+// open returns an open stocktake; m.mu must be held
+func (m *MemoryStore) open(id int) (Stocktake, error) {
+	st, ok := m.stocktakes[id]
+	if !ok {
+		return Stocktake{}, ErrNotFound
+	}
+	if st.Status != StatusOpen {
+		return Stocktake{}, ErrNotOpen
+	}
+	return copyStocktake(st), nil
+}
+
+func (m *MemoryStore) Count(ctx context.Context, id int, counts []Count, userID int, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st, err := m.open(id)
+	if err != nil {
+		return err
+	}
+	for _, count := range counts {
+		i := 0
+		for i < len(st.Lines) && st.Lines[i].ProductID != count.ProductID {
+			i++
+		}
+		if i == len(st.Lines) {
+			return ErrNotInStocktake
+		}
+
+		line := &st.Lines[i]
+		quantity := count.Quantity
+		if count.Add && line.Counted != nil {
+			quantity += *line.Counted
+		}
+		countedBy, countedAt := userID, at
+		line.Counted, line.CountedBy, line.CountedAt = &quantity, &countedBy, &countedAt
+	}
+	m.stocktakes[id] = st
+	return nil
+}
+
+func (m *MemoryStore) Cancel(ctx context.Context, id int, userID int, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st, err := m.open(id)
+	if err != nil {
+		return err
+	}
+	st.Status, st.ClosedAt, st.ClosedBy = StatusCancelled, &at, &userID
+	m.stocktakes[id] = st
+	return nil
+}
+
+func (m *MemoryStore) InTx(ctx context.Context, fn func(Tx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.stock.Tx(func(stock warehouse.StockTx) error {
+		tx := &memoryTx{StockTx: stock, store: m, posted: map[int]Stocktake{}}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		for id, st := range tx.posted {
+			m.stocktakes[id] = st
+		}
+		return nil
+	})
+}
+
+type memoryTx struct {
+	warehouse.StockTx
+	store  *MemoryStore
+	posted map[int]Stocktake
+}
+
+func (t *memoryTx) Lock(ctx context.Context, id int) (Stocktake, error) {
+	st, ok := t.store.stocktakes[id]
+	if !ok {
+		return Stocktake{}, ErrNotFound
+	}
+	return copyStocktake(st), nil
+}
+
+func (t *memoryTx) MarkPosted(ctx context.Context, id int, userID int, at time.Time) error {
+	st := copyStocktake(t.store.stocktakes[id])
+	st.Status, st.ClosedAt, st.ClosedBy = StatusPosted, &at, &userID
+	t.posted[id] = st
+	return nil
+}
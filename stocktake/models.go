@@ -0,0 +1,95 @@
+package stocktake
+
+import (
+	"time"
+
+	"randevu-shawarma-server/money"
+)
+
+type Status string
+
+const (
+	StatusOpen      Status = "open"
+	StatusPosted    Status = "posted"
+	StatusCancelled Status = "cancelled"
+)
+
+// Stocktake is a count of the warehouse, or of one product category when
+// CategoryID is set. Each line keeps the stock expected when the count
+// started and the average cost it is valued at.
+type Stocktake struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"userId"`
+	CategoryID *int       `json:"categoryId"`
+	Status     Status     `json:"status"`
+	Notes      string     `json:"notes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ClosedAt   *time.Time `json:"closedAt"`
+	ClosedBy   *int       `json:"closedBy"`
+	Lines      []Line     `json:"lines,omitempty"`
+	Variance   *Variance  `json:"variance,omitempty"`
+}
+
+// Line is one product of a stocktake. Counted stays nil until someone
+// counts the product; Variance is counted minus expected.
+type Line struct {
+	ProductID     int            `json:"productId"`
+	ProductName   string         `json:"productName"`
+	Expected      float64        `json:"expected"`
+	AverageCost   money.UnitCost `json:"averageCost"`
+	Counted       *float64       `json:"counted"`
+	CountedBy     *int           `json:"countedBy"`
+	CountedAt     *time.Time     `json:"countedAt"`
+	Variance      *float64       `json:"variance"`
+	VarianceValue *money.Amount  `json:"varianceValue"`
+}
+
+// Variance sums up the lines counted so far. Shortage and Surplus are
+// the value of the negative and positive variances; Net is their sum.
+type Variance struct {
+	Products  int          `json:"products"`
+	Counted   int          `json:"counted"`
+	Shortage  money.Amount `json:"shortage"`
+	Surplus   money.Amount `json:"surplus"`
+	Net       money.Amount `json:"net"`
+	Uncounted []int        `json:"uncounted"`
+}
+
+// Count is a quantity entered for a product. With Add set it is added to
+// what was counted before, for stock kept in more than one place.
+type Count struct {
+	ProductID int     `json:"productId"`
+	Quantity  float64 `json:"quantity"`
+	Add       bool    `json:"add"`
+}
+
+// review fills in the variance of every counted line and the totals
+func (st *Stocktake) review() {
+	v := Variance{
+		Products:  len(st.Lines),
+		Shortage:  money.Zero(),
+		Surplus:   money.Zero(),
+		Net:       money.Zero(),
+		Uncounted: []int{},
+	}
+	for i := range st.Lines {
+		line := &st.Lines[i]
+		if line.Counted == nil {
+			line.Variance, line.VarianceValue = nil, nil
+			v.Uncounted = append(v.Uncounted, line.ProductID)
+			continue
+		}
+		v.Counted++
+
+		variance := *line.Counted - line.Expected
+		value := line.AverageCost.Mul(variance, money.HalfEven)
+		line.Variance, line.VarianceValue = &variance, &value
+		if value.IsNegative() {
+			v.Shortage = v.Shortage.Add(value)
+		} else {
+			v.Surplus = v.Surplus.Add(value)
+		}
+		v.Net = v.Net.Add(value)
+	}
+	st.Variance = &v
+}
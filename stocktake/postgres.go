@@ -0,0 +1,253 @@
+package stocktake
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"randevu-shawarma-server/warehouse"
+)
+
+type PostgresStore struct {
+	db    *sql.DB
+	stock *warehouse.PostgresStore
+}
+
+func NewPostgresStore(db *sql.DB, stock *warehouse.PostgresStore) *PostgresStore {
+	return &PostgresStore{db: db, stock: stock}
+}
+
+// queryer is what *sql.DB and *sql.Tx have in common
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+const stocktakeColumns = "id, user_id, category_id, status, notes, created_at, closed_at, closed_by"
+
+func scanStocktake(row interface{ Scan(...interface{}) error }) (Stocktake, error) {
+	var st Stocktake
+	err := row.Scan(&st.ID, &st.UserID, &st.CategoryID, &st.Status, &st.Notes, &st.CreatedAt, &st.ClosedAt, &st.ClosedBy)
+	return st, err
+}
+
+func (s *PostgresStore) List(ctx context.Context) ([]Stocktake, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+stocktakeColumns+" FROM public.\"Stocktakes\" ORDER BY created_at DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Stocktake{}
+	for rows.Next() {
+		st, err := scanStocktake(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, st)
+	}
+	return list, rows.Err()
+}
+
+func (s *PostgresStore) ByID(ctx context.Context, id int) (Stocktake, error) {
+	return load(ctx, s.db, id, "")
+}
+
+// load reads a stocktake and its lines; lock is appended to the first
+// query, e.g. FOR UPDATE
+func load(ctx context.Context, q queryer, id int, lock string) (Stocktake, error) {
+	st, err := scanStocktake(q.QueryRowContext(ctx,
+		"SELECT "+stocktakeColumns+" FROM public.\"Stocktakes\" WHERE id = $1 "+lock, id))
+	if err == sql.ErrNoRows {
+		return st, ErrNotFound
+	} else if err != nil {
+		return st, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT l.product_id, p.name, l.expected, l.average_cost, l.counted, l.counted_by, l.counted_at
+		FROM public."Stocktake_lines" l
+		JOIN public."Products" p ON p.id = l.product_id
+		WHERE l.stocktake_id = $1
+		ORDER BY p.name, l.product_id`, id)
+	if err != nil {
+		return st, err
+	}
+	defer rows.Close()
+
+	st.Lines = []Line{}
+	for rows.Next() {
+		var line Line
+		err := rows.Scan(&line.ProductID, &line.ProductName, &line.Expected, &line.AverageCost,
+			&line.Counted, &line.CountedBy, &line.CountedAt)
+		if err != nil {
+			return st, err
+		}
+		st.Lines = append(st.Lines, line)
+	}
+	return st, rows.Err()
+}
+
+func (s *PostgresStore) Start(ctx context.Context, st *Stocktake) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Two stocktakes starting at once must see each other
+	if _, err := tx.ExecContext(ctx, "LOCK TABLE public.\"Stocktakes\" IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return err
+	}
+
+	if st.CategoryID != nil {
+		var exists bool
+		err := tx.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM public.\"Product_categories\" WHERE id = $1)", *st.CategoryID,
+		).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrUnknownCategory
+		}
+	}
+
+	// A full count overlaps every other count, a partial one only a full
+	// count or one of the same category
+	var overlaps bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM public."Stocktakes"
+			WHERE status = 'open' AND ($1::integer IS NULL OR category_id IS NULL OR category_id = $1)
+		)`, st.CategoryID,
+	).Scan(&overlaps)
+	if err != nil {
+		return err
+	}
+	if overlaps {
+		return ErrAlreadyOpen
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO public."Stocktakes" (user_id, category_id, status, notes, created_at)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		st.UserID, st.CategoryID, st.Status, st.Notes, st.CreatedAt,
+	).Scan(&st.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO public."Stocktake_lines" (stocktake_id, product_id, expected, average_cost)
+		SELECT $1, w.product_id, w.current_stock, w.average_cost
+		FROM public."Warehouse" w
+		JOIN public."Products" p ON p.id = w.product_id
+		WHERE $2::integer IS NULL OR p.category_id = $2`,
+		st.ID, st.CategoryID,
+	)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := load(ctx, tx, st.ID, "")
+	if err != nil {
+		return err
+	}
+	st.Lines = snapshot.Lines
+	return tx.Commit()
+}
+
+// lockOpen locks the stocktake row and checks it is still open
+func lockOpen(ctx context.Context, tx *sql.Tx, id int) error {
+	var status Status
+	err := tx.QueryRowContext(ctx, "SELECT status FROM public.\"Stocktakes\" WHERE id = $1 FOR UPDATE", id).Scan(&status)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	if status != StatusOpen {
+		return ErrNotOpen
+	}
+	return nil
+}
+
+func (s *PostgresStore) Count(ctx context.Context, id int, counts []Count, userID int, at time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockOpen(ctx, tx, id); err != nil {
+		return err
+	}
+	for _, count := range counts {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE public."Stocktake_lines"
+			SET counted = CASE WHEN $3 THEN COALESCE(counted, 0) + $4 ELSE $4 END,
+				counted_by = $5, counted_at = $6
+			WHERE stocktake_id = $1 AND product_id = $2`,
+			id, count.ProductID, count.Add, count.Quantity, userID, at,
+		)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotInStocktake
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) Cancel(ctx context.Context, id int, userID int, at time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockOpen(ctx, tx, id); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE public.\"Stocktakes\" SET status = $1, closed_at = $2, closed_by = $3 WHERE id = $4",
+		StatusCancelled, at, userID, id,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) InTx(ctx context.Context, fn func(Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&postgresTx{StockTx: s.stock.Stock(tx), tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type postgresTx struct {
+	warehouse.StockTx
+	tx *sql.Tx
+}
+
+func (t *postgresTx) Lock(ctx context.Context, id int) (Stocktake, error) {
+	return load(ctx, t.tx, id, "FOR UPDATE")
+}
+
+func (t *postgresTx) MarkPosted(ctx context.Context, id int, userID int, at time.Time) error {
+	_, err := t.tx.ExecContext(ctx,
+		"UPDATE public.\"Stocktakes\" SET status = $1, closed_at = $2, closed_by = $3 WHERE id = $4",
+		StatusPosted, at, userID, id,
+	)
+	return err
+}
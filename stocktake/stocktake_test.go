@@ -0,0 +1,91 @@
+package stocktake
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/warehouse"
+)
+
+func currentStock(t *testing.T, stock *warehouse.MemoryStore) map[int]float64 {
+	t.Helper()
+	items, err := stock.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels := map[int]float64{}
+	for _, item := range items {
+		levels[item.ProductID] = item.CurrentStock
+	}
+	return levels
+}
+
+func TestPostAppliesVariance(t *testing.T) {
+	ctx := context.Background()
+	stock := warehouse.NewMemoryStore(warehouse.CostingAverage)
+	stock.AddProduct(1, "chicken")
+	stock.AddProduct(2, "lavash")
+	stock.AddProduct(3, "sauce")
+	err := stock.Tx(func(tx warehouse.StockTx) error {
+		src := warehouse.Source{Type: warehouse.SourceSupply, ID: 1, UserID: 1}
+		for _, p := range []struct {
+			id       int
+			quantity float64
+			cost     string
+		}{{1, 10, "4.00"}, {2, 100, "0.40"}, {3, 5, "1.00"}} {
+			if _, err := tx.Receive(ctx, p.id, p.quantity, money.MustParseUnitCost(p.cost), src, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStore(stock)
+	s := NewService(store, nil)
+
+	st := Stocktake{UserID: 1, Status: StatusOpen, CreatedAt: time.Now()}
+	if err := store.Start(ctx, &st); err != nil {
+		t.Fatal(err)
+	}
+	// Chicken is kept in two places and counted in both
+	counts := []Count{{ProductID: 1, Quantity: 5}, {ProductID: 1, Quantity: 3, Add: true}, {ProductID: 2, Quantity: 103}}
+	if err := store.Count(ctx, st.ID, counts, 1, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// A sale during the count is kept when the variance is posted
+	err = stock.Tx(func(tx warehouse.StockTx) error {
+		return tx.Take(ctx, map[int]float64{2: 1}, warehouse.Source{Type: warehouse.SourceOrder, ID: 1, UserID: 1})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err = store.ByID(ctx, st.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.review()
+	v := st.Variance
+	if v.Counted != 2 || len(v.Uncounted) != 1 || v.Uncounted[0] != 3 {
+		t.Errorf("counted %d with %v uncounted, want 2 with the sauce uncounted", v.Counted, v.Uncounted)
+	}
+	// Two chicken short at 4.00 and three lavash over at 0.40
+	if v.Shortage.String() != "-8.00" || v.Surplus.String() != "1.20" || v.Net.String() != "-6.80" {
+		t.Errorf("shortage %s, surplus %s, net %s, want -8.00, 1.20 and -6.80", v.Shortage, v.Surplus, v.Net)
+	}
+
+	if err := s.post(ctx, st.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := currentStock(t, stock); got[1] != 8 || got[2] != 102 || got[3] != 5 {
+		t.Errorf("stock after posting %v, want 8 chicken, 102 lavash and 5 sauce", got)
+	}
+	if err := s.post(ctx, st.ID, 1); !errors.Is(err, ErrNotOpen) {
+		t.Errorf("posting twice: got %v, want %v", err, ErrNotOpen)
+	}
+}
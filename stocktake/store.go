@@ -0,0 +1,49 @@
+package stocktake
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"randevu-shawarma-server/warehouse"
+)
+
+var (
+	ErrNotFound        = errors.New("not found")
+	ErrUnknownCategory = errors.New("unknown category")
+	ErrAlreadyOpen     = errors.New("another open stocktake covers these products")
+	ErrNotOpen         = errors.New("stocktake is not open")
+	ErrNotInStocktake  = errors.New("product is not part of this stocktake")
+	ErrNothingCounted  = errors.New("nothing has been counted")
+)
+
+// Store persists stocktakes
+type Store interface {
+	// List returns the stocktakes without their lines, newest first
+	List(ctx context.Context) ([]Stocktake, error)
+	ByID(ctx context.Context, id int) (Stocktake, error)
+	// Start creates the stocktake with a line for every product in the
+	// warehouse, or in its category, expecting the current stock. Products
+	// can be in only one open stocktake.
+	Start(ctx context.Context, st *Stocktake) error
+	// Count records counts against an open stocktake
+	Count(ctx context.Context, id int, counts []Count, userID int, at time.Time) error
+	// Cancel closes an open stocktake without touching stock
+	Cancel(ctx context.Context, id int, userID int, at time.Time) error
+	// InTx runs fn in one transaction, rolled back if fn returns an error
+	InTx(ctx context.Context, fn func(Tx) error) error
+}
+
+var (
+	_ Store = (*PostgresStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// Tx is the part of a transaction posting a stocktake needs
+type Tx interface {
+	warehouse.StockTx
+	// Lock returns the stocktake with its lines and holds it until the
+	// transaction ends
+	Lock(ctx context.Context, id int) (Stocktake, error)
+	MarkPosted(ctx context.Context, id int, userID int, at time.Time) error
+}
@@ -0,0 +1,202 @@
+package supply
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"randevu-shawarma-server/users"
+	"randevu-shawarma-server/warehouse"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// VoidSupply takes a supply booked in error back out of the warehouse and
+// recomputes the average cost of its products as if it had never come in
+func (s *Service) VoidSupply(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid supply id", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	if body.Reason == "" {
+		http.Error(w, "Reason is required", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := users.ClaimsFromContext(r.Context())
+	err = s.void(r.Context(), id, claims.UserID, body.Reason, nil)
+	if !writeVoidError(w, r, err) {
+		return
+	}
+	s.writeSupply(w, r, id, http.StatusOK)
+}
+
+// CorrectSupply voids a supply and books the products as they should have
+// been in its place, for the same supplier and purchase order
+func (s *Service) CorrectSupply(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid supply id", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Reason        string                  `json:"reason"`
+		InvoiceNumber string                  `json:"invoiceNumber"`
+		Products      []SupplyProductRelation `json:"products"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	if body.Reason == "" {
+		http.Error(w, "Reason is required", http.StatusBadRequest)
+		return
+	}
+	if len(body.Products) == 0 {
+		http.Error(w, "Products are required; void the supply to take it back entirely", http.StatusBadRequest)
+		return
+	}
+	for i := range body.Products {
+		line := &body.Products[i]
+		if msg := checkLine(line.Quantity, line.Price); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		line.Unit, line.ProductName, line.Factor = strings.TrimSpace(line.Unit), "", 0
+	}
+
+	claims, _ := users.ClaimsFromContext(r.Context())
+	replacement := Supply{
+		UserID:        claims.UserID,
+		InvoiceNumber: strings.TrimSpace(body.InvoiceNumber),
+		Products:      body.Products,
+	}
+	err = s.void(r.Context(), id, claims.UserID, body.Reason, &replacement)
+	if !writeVoidError(w, r, err) {
+		return
+	}
+	s.writeSupply(w, r, replacement.ID, http.StatusCreated)
+}
+
+// void books the replacement, if any, then reverses the stock the supply
+// brought in and revalues its products as if it had never come. A
+// supply received against a purchase order is taken off what the order
+// has received, and the replacement counted in its place.
+func (s *Service) void(ctx context.Context, id, userID int, reason string, replacement *Supply) error {
+	return s.store.InTx(ctx, func(tx Tx) error {
+		original, err := tx.LockSupply(ctx, id)
+		if err != nil {
+			return err
+		}
+		if original.VoidedAt != nil {
+			return ErrVoided
+		}
+		now := time.Now()
+
+		var po PurchaseOrder
+		if original.PurchaseOrderID != nil {
+			po, err = tx.LockPurchaseOrder(ctx, *original.PurchaseOrderID)
+			if err != nil {
+				return err
+			}
+			if err := countReceived(ctx, tx, &po, original.Products, -1); err != nil {
+				return err
+			}
+		}
+
+		// The replacement comes in first so that taking the original out
+		// only has to find what the two differ by
+		if replacement != nil {
+			replacement.SupplierID = original.SupplierID
+			replacement.PurchaseOrderID = original.PurchaseOrderID
+			replacement.Corrects = &original.ID
+			replacement.CreatedAt = now
+			if replacement.InvoiceNumber == "" {
+				replacement.InvoiceNumber = original.InvoiceNumber
+			}
+			if original.PurchaseOrderID != nil {
+				if err := countReceived(ctx, tx, &po, replacement.Products, 1); err != nil {
+					return err
+				}
+			}
+			if err := receive(ctx, tx, replacement); err != nil {
+				return err
+			}
+		}
+
+		quantities := map[int]float64{}
+		for _, line := range original.Products {
+			quantities[line.ProductID] += line.baseQuantity()
+		}
+		src := warehouse.Source{Type: warehouse.SourceSupply, ID: original.ID, UserID: userID}
+		if err := tx.Take(ctx, quantities, src); err != nil {
+			return err
+		}
+		if err := tx.MarkVoided(ctx, original.ID, userID, now, reason); err != nil {
+			return err
+		}
+		// The ledger is replayed in full, so the replacement is averaged in
+		// and every supply voided before stays left out
+		for _, productID := range productIDs(quantities) {
+			if err := revalue(ctx, tx, productID); err != nil {
+				return err
+			}
+		}
+
+		if original.PurchaseOrderID == nil {
+			return nil
+		}
+		po.settle(false, now)
+		return tx.SaveReceived(ctx, po)
+	})
+}
+
+// revalue replays the product's average cost without its voided supplies
+func revalue(ctx context.Context, tx Tx, productID int) error {
+	voided, err := tx.VoidedSupplies(ctx, productID)
+	if err != nil {
+		return err
+	}
+	skip := make([]warehouse.Source, len(voided))
+	for i, id := range voided {
+		skip[i] = warehouse.Source{Type: warehouse.SourceSupply, ID: id}
+	}
+	return tx.Revalue(ctx, productID, skip...)
+}
+
+// productIDs returns the products of quantities in id order
+func productIDs(quantities map[int]float64) []int {
+	ids := make([]int, 0, len(quantities))
+	for id := range quantities {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// writeVoidError answers for a failed void or correction, which may find
+// too little stock left to take back, and reports whether err was nil
+func writeVoidError(w http.ResponseWriter, r *http.Request, err error) bool {
+	if warehouse.WriteShortageError(w, err) {
+		return false
+	}
+	return writePurchasingError(w, r, err)
+}
@@ -1,129 +1,221 @@
 package supply
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/period"
 	"randevu-shawarma-server/users"
 	"randevu-shawarma-server/warehouse"
 
 	"github.com/julienschmidt/httprouter"
 )
 
-var db *sql.DB
+type Service struct {
+	store     Store
+	auth      *users.Service
+	warehouse *warehouse.Service
+}
 
-// SetDatabase sets the database connection
-func SetDatabase(database *sql.DB) {
-	db = database
+func NewService(store Store, auth *users.Service, warehouse *warehouse.Service) *Service {
+	return &Service{store: store, auth: auth, warehouse: warehouse}
 }
 
 // RegisterRoutes registers all supply routes
-func RegisterRoutes(router *httprouter.Router) {
-	router.POST("/supply", users.Authenticate(CreateSupply))
+func (s *Service) RegisterRoutes(router *httprouter.Router) {
+	router.GET("/supply", s.auth.Authorize(users.PermSupplyCreate)(s.GetSupplies))
+	router.POST("/supply", s.auth.Authorize(users.PermSupplyCreate)(s.CreateSupply))
+	router.GET("/supply/:id", s.auth.Authorize(users.PermSupplyCreate)(s.GetSupply))
+	router.POST("/supply/:id/void", s.auth.Authorize(users.PermSupplyCreate)(s.VoidSupply))
+	router.POST("/supply/:id/correct", s.auth.Authorize(users.PermSupplyCreate)(s.CorrectSupply))
+
+	router.GET("/suppliers", s.auth.Authorize(users.PermPurchasing)(s.GetSuppliers))
+	router.POST("/suppliers", s.auth.Authorize(users.PermPurchasing)(s.SaveSupplier))
+	router.GET("/suppliers/:id", s.auth.Authorize(users.PermPurchasing)(s.GetSupplier))
+	router.PUT("/suppliers/:id", s.auth.Authorize(users.PermPurchasing)(s.SaveSupplier))
+	router.PUT("/suppliers/:id/products", s.auth.Authorize(users.PermPurchasing)(s.SetSupplierProducts))
+
+	router.GET("/purchase-orders", s.auth.Authorize(users.PermPurchasing)(s.GetPurchaseOrders))
+	router.POST("/purchase-orders", s.auth.Authorize(users.PermPurchasing)(s.SavePurchaseOrder))
+	router.GET("/purchase-orders/:id", s.auth.Authorize(users.PermPurchasing)(s.GetPurchaseOrder))
+	router.PUT("/purchase-orders/:id", s.auth.Authorize(users.PermPurchasing)(s.SavePurchaseOrder))
+	router.POST("/purchase-orders/:id/send", s.auth.Authorize(users.PermPurchasing)(s.SendPurchaseOrder))
+	router.POST("/purchase-orders/:id/cancel", s.auth.Authorize(users.PermPurchasing)(s.CancelPurchaseOrder))
+	router.POST("/purchase-orders/:id/close", s.auth.Authorize(users.PermPurchasing)(s.ClosePurchaseOrder))
+	router.POST("/purchase-orders/:id/receipts", s.auth.Authorize(users.PermSupplyCreate)(s.ReceivePurchaseOrder))
+
+	router.GET("/warehouse/reorder-suggestions", s.auth.Authorize(users.PermPurchasing)(s.GetReorderSuggestions))
+	router.POST("/warehouse/reorder-suggestions", s.auth.Authorize(users.PermPurchasing)(s.CreateSuggestedOrder))
 }
 
-func CreateSupply(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	var newSupply Supply
-	err := json.NewDecoder(r.Body).Decode(&newSupply)
-	if err != nil {
+// GetSupplies lists the supplies booked in a period, newest first. from
+// and to take a date or an RFC 3339 time, a date to including that whole
+// day, and default to the last 30 days; supplierId and productId narrow
+// the list down.
+func (s *Service) GetSupplies(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+	var filter Filter
+	var err error
+	if filter.From, filter.To, err = period.FromQuery(query, 30); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if v := query.Get("supplierId"); v != "" {
+		if filter.SupplierID, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Invalid supplierId", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("productId"); v != "" {
+		if filter.ProductID, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Invalid productId", http.StatusBadRequest)
+			return
+		}
+	}
 
-	tx, err := db.Begin()
+	list, err := s.store.List(r.Context(), filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer tx.Rollback()
 
-	// Insert new supply
-	err = tx.QueryRow(
-		"INSERT INTO public.\"Supply\" (user_id, created_at) VALUES ($1, $2) RETURNING id",
-		newSupply.UserID, time.Now(),
-	).Scan(&newSupply.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetSupply returns a supply with its lines as they were entered
+func (s *Service) GetSupply(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
+		http.Error(w, "Invalid supply id", http.StatusBadRequest)
+		return
+	}
+	s.writeSupply(w, r, id, http.StatusOK)
+}
+
+func (s *Service) writeSupply(w http.ResponseWriter, r *http.Request, id int, status int) {
+	supply, err := s.store.ByID(r.Context(), id)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Insert supply products and update warehouse
-	for _, product := range newSupply.Products {
-		// Insert into supply_product_relations
-		_, err = tx.Exec(
-			"INSERT INTO public.\"Supply_product_relations\" (supply_id, product_id, quantity, price) VALUES ($1, $2, $3, $4)",
-			newSupply.ID, product.ProductID, product.Quantity, product.Price,
-		)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(supply)
+}
 
-		// Update warehouse
-		var currentStock sql.NullFloat64
-		var averageCost sql.NullString
+func (s *Service) CreateSupply(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var newSupply Supply
+	err := json.NewDecoder(r.Body).Decode(&newSupply)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		err = tx.QueryRow(
-			"SELECT current_stock, average_cost FROM public.\"Warehouse\" WHERE product_id = $1",
-			product.ProductID,
-		).Scan(&currentStock, &averageCost)
-		if err != nil && err != sql.ErrNoRows {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Only receiving a purchase order books a supply against it, and only
+	// a correction replaces one
+	newSupply.PurchaseOrderID, newSupply.Corrects = nil, nil
+	newSupply.VoidedAt, newSupply.VoidedBy, newSupply.VoidReason = nil, nil, ""
+	newSupply.InvoiceNumber = strings.TrimSpace(newSupply.InvoiceNumber)
+	for _, line := range newSupply.Products {
+		if msg := checkLine(line.Quantity, line.Price); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
 			return
 		}
+	}
 
-		// Convert averageCost to float64 if it exists
-		var avgCostFloat64 float64
-		if averageCost.Valid {
-			avgCostFloat64, err = parseMoneyToFloat(averageCost.String)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-		}
+	err = s.create(r.Context(), &newSupply)
+	if errors.Is(err, ErrUnknownUnit) || errors.Is(err, ErrUnknownSupplier) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-		productPriceFloat64, err := parseMoneyToFloat(product.Price)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	s.warehouse.GetWarehouse(w, r, ps)
+}
 
-		newStock := product.Quantity
-		newCost := productPriceFloat64
+// checkLine returns what is wrong with the quantity and price of a line
+// coming into the warehouse, if anything. Stock only leaves through the
+// flows that check it against the stock policy.
+func checkLine(quantity float64, price money.Amount) string {
+	if quantity <= 0 {
+		return "Quantity must be positive"
+	}
+	if price.IsNegative() {
+		return "Price must not be negative"
+	}
+	return ""
+}
+
+// create stores the supply and adds its products to the warehouse
+func (s *Service) create(ctx context.Context, newSupply *Supply) error {
+	newSupply.CreatedAt = time.Now()
+
+	return s.store.InTx(ctx, func(tx Tx) error {
+		return receive(ctx, tx, newSupply)
+	})
+}
 
-		if currentStock.Valid && averageCost.Valid {
-			totalCost := (currentStock.Float64 * avgCostFloat64) + (product.Quantity * productPriceFloat64)
-			newStock += currentStock.Float64
-			newCost = totalCost / newStock
+// receive stores the supply in tx and adds its products to the warehouse,
+// updating the weighted average cost of each product and the prices of
+// its supplier
+func receive(ctx context.Context, tx Tx, newSupply *Supply) error {
+	// Insert new supply
+	err := tx.InsertSupply(ctx, newSupply)
+	if err != nil {
+		return err
+	}
+
+	// Insert supply products and update warehouse in the unit each
+	// product is kept in
+	for _, product := range newSupply.Products {
+		factor, ok, err := tx.Factor(ctx, product.ProductID, product.Unit)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w %q for product %d", ErrUnknownUnit, product.Unit, product.ProductID)
+		}
+		product.Factor = factor
+		quantity := product.baseQuantity()
+		price, err := product.basePrice()
+		if err != nil {
+			return err
 		}
 
-		newCostStr := formatFloatToMoney(newCost)
-
-		if currentStock.Valid {
-			_, err = tx.Exec(
-				"UPDATE public.\"Warehouse\" SET current_stock = $1, average_cost = $2 WHERE product_id = $3",
-				newStock, newCostStr, product.ProductID,
-			)
-		} else {
-			_, err = tx.Exec(
-				"INSERT INTO public.\"Warehouse\" (product_id, current_stock, average_cost) VALUES ($1, $2, $3)",
-				product.ProductID, newStock, newCostStr,
-			)
+		err = tx.InsertLine(ctx, newSupply.ID, product)
+		if err != nil {
+			return err
 		}
 
+		_, err = tx.Receive(ctx, product.ProductID, quantity, price, warehouse.Source{
+			Type:   warehouse.SourceSupply,
+			ID:     newSupply.ID,
+			UserID: newSupply.UserID,
+		}, product.ExpiresAt)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return err
 		}
-	}
 
-	err = tx.Commit()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		if newSupply.SupplierID != nil {
+			err = tx.RecordPrice(ctx, *newSupply.SupplierID, product, newSupply.CreatedAt)
+			if err != nil {
+				return err
+			}
+		}
 	}
-
-	warehouse.GetWarehouse(w, r, ps)
+	return nil
 }
@@ -0,0 +1,439 @@
+package supply
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/warehouse"
+)
+
+// MemoryStore keeps supplies, suppliers and purchase orders in memory and
+// moves stock in the shared warehouse.MemoryStore
+type MemoryStore struct {
+	mu        sync.Mutex
+	stock     *warehouse.MemoryStore
+	supplies  []Supply
+	suppliers map[int]Supplier
+	orders    map[int]PurchaseOrder
+}
+
+func NewMemoryStore(stock *warehouse.MemoryStore) *MemoryStore {
+	return &MemoryStore{
+		stock:     stock,
+		suppliers: map[int]Supplier{},
+		orders:    map[int]PurchaseOrder{},
+	}
+}
+
+// Supplies returns a copy of every committed supply
+func (m *MemoryStore) Supplies() []Supply {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Supply(nil), m.supplies...)
+}
+
+func (m *MemoryStore) List(ctx context.Context, filter Filter) ([]Supply, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := []Supply{}
+	for _, supply := range m.supplies {
+		if supply.CreatedAt.Before(filter.From) || !supply.CreatedAt.Before(filter.To) {
+			continue
+		}
+		if filter.SupplierID != 0 && (supply.SupplierID == nil || *supply.SupplierID != filter.SupplierID) {
+			continue
+		}
+		found := filter.ProductID == 0
+		for _, line := range supply.Products {
+			found = found || line.ProductID == filter.ProductID
+		}
+		if !found {
+			continue
+		}
+		supply = m.describe(supply, nil)
+		supply.Products = nil
+		list = append(list, supply)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	return list, nil
+}
+
+func (m *MemoryStore) ByID(ctx context.Context, id int) (Supply, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > len(m.supplies) {
+		return Supply{}, ErrNotFound
+	}
+	return m.describe(m.supplies[id-1], m.stock.ProductNames()), nil
+}
+
+// describe fills in what the Postgres store reads alongside a supply:
+// its supplier's name, its total, the supply correcting it and, given
+// names, the names of its products. m.mu must be held.
+func (m *MemoryStore) describe(supply Supply, names map[int]string) Supply {
+	if supply.SupplierID != nil {
+		supply.SupplierName = m.suppliers[*supply.SupplierID].Name
+	}
+	for _, other := range m.supplies {
+		if other.Corrects != nil && *other.Corrects == supply.ID {
+			id := other.ID
+			supply.CorrectedBy = &id
+		}
+	}
+	supply.Total = money.Zero()
+	lines := make([]SupplyProductRelation, len(supply.Products))
+	for i, line := range supply.Products {
+		supply.Total = supply.Total.Add(line.total())
+		line.ProductName = names[line.ProductID]
+		lines[i] = line
+	}
+	supply.Products = lines
+	return supply
+}
+
+func (m *MemoryStore) Suppliers(ctx context.Context) ([]Supplier, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := []Supplier{}
+	for _, supplier := range m.suppliers {
+		supplier.Products = nil
+		list = append(list, supplier)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (m *MemoryStore) SupplierByID(ctx context.Context, id int) (Supplier, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	supplier, ok := m.suppliers[id]
+	if !ok {
+		return Supplier{}, ErrNotFound
+	}
+	names := m.stock.ProductNames()
+	products := []SupplierProduct{}
+	for _, p := range supplier.Products {
+		p.ProductName = names[p.ProductID]
+		products = append(products, p)
+	}
+	sort.Slice(products, func(i, j int) bool {
+		if products[i].ProductName != products[j].ProductName {
+			return products[i].ProductName < products[j].ProductName
+		}
+		return products[i].ProductID < products[j].ProductID
+	})
+	supplier.Products = products
+	return supplier, nil
+}
+
+func (m *MemoryStore) Carriers(ctx context.Context) (map[int]Carrier, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := m.stock.ProductNames()
+	carriers := map[int]Carrier{}
+	for _, supplier := range m.suppliers {
+		for _, p := range supplier.Products {
+			current, ok := carriers[p.ProductID]
+			if ok && !laterCarrier(supplier.ID, p, current) {
+				continue
+			}
+			p.ProductName = names[p.ProductID]
+			carriers[p.ProductID] = Carrier{SupplierID: supplier.ID, SupplierName: supplier.Name, Product: p}
+		}
+	}
+	return carriers, nil
+}
+
+// laterCarrier reports whether the supplier's product was received more
+// recently than the current carrier's, or as recently by a lower id
+func laterCarrier(supplierID int, p SupplierProduct, current Carrier) bool {
+	a, b := p.LastReceivedAt, current.Product.LastReceivedAt
+	switch {
+	case a != nil && b == nil:
+		return true
+	case a == nil && b != nil:
+		return false
+	case a != nil && !a.Equal(*b):
+		return a.After(*b)
+	}
+	return supplierID < current.SupplierID
+}
+
+func (m *MemoryStore) SaveSupplier(ctx context.Context, supplier *Supplier) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if supplier.ID == 0 {
+		supplier.ID = len(m.suppliers) + 1
+	} else if existing, ok := m.suppliers[supplier.ID]; !ok {
+		return ErrNotFound
+	} else {
+		supplier.Products = existing.Products
+	}
+	m.suppliers[supplier.ID] = *supplier
+	supplier.Products = nil
+	return nil
+}
+
+func (m *MemoryStore) PurchaseOrders(ctx context.Context, status OrderStatus, supplierID int) ([]PurchaseOrder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := []PurchaseOrder{}
+	for _, po := range m.orders {
+		if (status != "" && po.Status != status) || (supplierID != 0 && po.SupplierID != supplierID) {
+			continue
+		}
+		po.SupplierName = m.suppliers[po.SupplierID].Name
+		po.Lines, po.SupplyIDs = nil, nil
+		list = append(list, po)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	return list, nil
+}
+
+func (m *MemoryStore) PurchaseOrderByID(ctx context.Context, id int) (PurchaseOrder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	po, ok := m.orders[id]
+	if !ok {
+		return PurchaseOrder{}, ErrNotFound
+	}
+	names := m.stock.ProductNames()
+	po = copyOrder(po)
+	po.SupplierName = m.suppliers[po.SupplierID].Name
+	for i := range po.Lines {
+		po.Lines[i].ProductName = names[po.Lines[i].ProductID]
+	}
+	for _, s := range m.supplies {
+		if s.PurchaseOrderID != nil && *s.PurchaseOrderID == id {
+			po.SupplyIDs = append(po.SupplyIDs, s.ID)
+		}
+	}
+	return po, nil
+}
+
+func (m *MemoryStore) SetOrderStatus(ctx context.Context, id int, status OrderStatus, from []OrderStatus, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	po, ok := m.orders[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if !statusIn(po.Status, from) {
+		return fmt.Errorf("%w: it is %s", ErrOrderStatus, po.Status)
+	}
+	po.Status = status
+	switch status {
+	case OrderSent:
+		po.SentAt = &at
+	case OrderClosed, OrderCancelled:
+		po.ClosedAt = &at
+	}
+	m.orders[id] = po
+	return nil
+}
+
+func copyOrder(po PurchaseOrder) PurchaseOrder {
+	po.Lines = append([]PurchaseOrderLine(nil), po.Lines...)
+	po.SupplyIDs = nil
+	return po
+}
+
+func (m *MemoryStore) InTx(ctx context.Context, fn func(Tx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Product names are read before the stock is locked, which Tx holds
+	names := m.stock.ProductNames()
+	return m.stock.Tx(func(stock warehouse.StockTx) error {
+		tx := &memoryTx{
+			StockTx:   stock,
+			names:     names,
+			supplies:  append([]Supply(nil), m.supplies...),
+			suppliers: map[int]Supplier{},
+			orders:    map[int]PurchaseOrder{},
+		}
+		for id, supplier := range m.suppliers {
+			tx.suppliers[id] = supplier
+		}
+		for id, po := range m.orders {
+			tx.orders[id] = po
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		m.supplies, m.suppliers, m.orders = tx.supplies, tx.suppliers, tx.orders
+		return nil
+	})
+}
+
+// memoryTx works on copies of the store's maps, which InTx keeps only if
+// the transaction succeeds. Slices inside them are replaced, never
+// changed in place.
+type memoryTx struct {
+	warehouse.StockTx
+	names     map[int]string
+	supplies  []Supply
+	suppliers map[int]Supplier
+	orders    map[int]PurchaseOrder
+}
+
+func (t *memoryTx) InsertSupply(ctx context.Context, s *Supply) error {
+	if s.SupplierID != nil {
+		if _, ok := t.suppliers[*s.SupplierID]; !ok {
+			return ErrUnknownSupplier
+		}
+	}
+	s.ID = len(t.supplies) + 1
+	t.supplies = append(t.supplies, Supply{
+		ID:              s.ID,
+		UserID:          s.UserID,
+		SupplierID:      s.SupplierID,
+		PurchaseOrderID: s.PurchaseOrderID,
+		InvoiceNumber:   s.InvoiceNumber,
+		Corrects:        s.Corrects,
+		CreatedAt:       s.CreatedAt,
+	})
+	return nil
+}
+
+func (t *memoryTx) LockSupply(ctx context.Context, id int) (Supply, error) {
+	if id < 1 || id > len(t.supplies) {
+		return Supply{}, ErrNotFound
+	}
+	supply := t.supplies[id-1]
+	supply.Products = append([]SupplyProductRelation(nil), supply.Products...)
+	return supply, nil
+}
+
+func (t *memoryTx) MarkVoided(ctx context.Context, id int, userID int, at time.Time, reason string) error {
+	supply := &t.supplies[id-1]
+	supply.VoidedAt, supply.VoidedBy, supply.VoidReason = &at, &userID, reason
+	return nil
+}
+
+func (t *memoryTx) VoidedSupplies(ctx context.Context, productID int) ([]int, error) {
+	var ids []int
+	for _, supply := range t.supplies {
+		if supply.VoidedAt == nil {
+			continue
+		}
+		for _, line := range supply.Products {
+			if line.ProductID == productID {
+				ids = append(ids, supply.ID)
+				break
+			}
+		}
+	}
+	return ids, nil
+}
+
+func (t *memoryTx) InsertLine(ctx context.Context, supplyID int, line SupplyProductRelation) error {
+	line.SupplyID = supplyID
+	s := &t.supplies[supplyID-1]
+	s.Products = append(s.Products, line)
+	return nil
+}
+
+func (t *memoryTx) RecordPrice(ctx context.Context, supplierID int, line SupplyProductRelation, at time.Time) error {
+	supplier := t.suppliers[supplierID]
+	price := line.Price
+	recorded := SupplierProduct{ProductID: line.ProductID, Unit: line.Unit, LastPrice: &price, LastReceivedAt: &at}
+
+	products := []SupplierProduct{}
+	for _, p := range supplier.Products {
+		if p.ProductID != line.ProductID {
+			products = append(products, p)
+		}
+	}
+	supplier.Products = append(products, recorded)
+	t.suppliers[supplierID] = supplier
+	return nil
+}
+
+func (t *memoryTx) SetSupplierProducts(ctx context.Context, supplierID int, products []SupplierProduct) error {
+	supplier, ok := t.suppliers[supplierID]
+	if !ok {
+		return ErrNotFound
+	}
+	previous := map[int]SupplierProduct{}
+	for _, p := range supplier.Products {
+		previous[p.ProductID] = p
+	}
+
+	carried := []SupplierProduct{}
+	for _, p := range products {
+		if _, ok := t.names[p.ProductID]; !ok {
+			return fmt.Errorf("%w: %d", ErrUnknownProduct, p.ProductID)
+		}
+		p.ProductName, p.LastPrice, p.LastReceivedAt = "", nil, nil
+		if old, ok := previous[p.ProductID]; ok && old.Unit == p.Unit {
+			p.LastPrice, p.LastReceivedAt = old.LastPrice, old.LastReceivedAt
+		}
+		carried = append(carried, p)
+	}
+	supplier.Products = carried
+	t.suppliers[supplierID] = supplier
+	return nil
+}
+
+func (t *memoryTx) SavePurchaseOrder(ctx context.Context, po *PurchaseOrder) error {
+	if _, ok := t.suppliers[po.SupplierID]; !ok {
+		return ErrUnknownSupplier
+	}
+	for _, line := range po.Lines {
+		if _, ok := t.names[line.ProductID]; !ok {
+			return fmt.Errorf("%w: %d", ErrUnknownProduct, line.ProductID)
+		}
+	}
+
+	saved := copyOrder(*po)
+	if po.ID == 0 {
+		po.ID = len(t.orders) + 1
+	} else {
+		existing, ok := t.orders[po.ID]
+		if !ok {
+			return ErrNotFound
+		}
+		if existing.Status != OrderDraft {
+			return fmt.Errorf("%w: it is %s", ErrOrderStatus, existing.Status)
+		}
+		saved.UserID, saved.Status, saved.CreatedAt = existing.UserID, existing.Status, existing.CreatedAt
+	}
+	saved.ID, saved.SupplierName = po.ID, ""
+	t.orders[po.ID] = saved
+	return nil
+}
+
+func (t *memoryTx) LockPurchaseOrder(ctx context.Context, id int) (PurchaseOrder, error) {
+	po, ok := t.orders[id]
+	if !ok {
+		return PurchaseOrder{}, ErrNotFound
+	}
+	return copyOrder(po), nil
+}
+
+func (t *memoryTx) SaveReceived(ctx context.Context, po PurchaseOrder) error {
+	saved := t.orders[po.ID]
+	saved.Lines = append([]PurchaseOrderLine(nil), po.Lines...)
+	saved.Status, saved.ClosedAt = po.Status, po.ClosedAt
+	t.orders[po.ID] = saved
+	return nil
+}
@@ -2,18 +2,263 @@ package supply
 
 import (
 	"time"
+
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/warehouse"
 )
 
+// Supply is a delivery booked into the warehouse. PurchaseOrderID is set
+// when it was received against a purchase order. A voided supply no
+// longer counts; CorrectedBy is the supply that replaced it, if any, and
+// Corrects the one a replacement stands in for.
 type Supply struct {
-	ID        int                     `json:"id"`
-	UserID    int                     `json:"userId"`
-	CreatedAt time.Time               `json:"createdAt"`
-	Products  []SupplyProductRelation `json:"products"`
+	ID              int                     `json:"id"`
+	UserID          int                     `json:"userId"`
+	SupplierID      *int                    `json:"supplierId"`
+	SupplierName    string                  `json:"supplierName,omitempty"`
+	PurchaseOrderID *int                    `json:"purchaseOrderId"`
+	InvoiceNumber   string                  `json:"invoiceNumber"`
+	CreatedAt       time.Time               `json:"createdAt"`
+	Total           money.Amount            `json:"total"`
+	VoidedAt        *time.Time              `json:"voidedAt"`
+	VoidedBy        *int                    `json:"voidedBy"`
+	VoidReason      string                  `json:"voidReason,omitempty"`
+	Corrects        *int                    `json:"corrects"`
+	CorrectedBy     *int                    `json:"correctedBy"`
+	Products        []SupplyProductRelation `json:"products,omitempty"`
+}
+
+// Filter narrows a list of supplies to those booked from From (inclusive)
+// to To (exclusive), and to a supplier and a product when those are set
+type Filter struct {
+	From       time.Time
+	To         time.Time
+	SupplierID int
+	ProductID  int
 }
 
+// SupplyProductRelation is a line of a supply. Quantity and Price are per
+// Unit when it is set, e.g. 2 boxes at 450.00 a box, and per the unit the
+// product is kept in otherwise.
 type SupplyProductRelation struct {
-	SupplyID  int     `json:"supplyId"`
-	ProductID int     `json:"productId"`
-	Quantity  float64 `json:"quantity"`
-	Price     string  `json:"price"`
+	SupplyID    int          `json:"supplyId"`
+	ProductID   int          `json:"productId"`
+	ProductName string       `json:"productName,omitempty"`
+	Quantity    float64      `json:"quantity"`
+	Price       money.Amount `json:"price"`
+	Unit        string       `json:"unit,omitempty"`
+	// ExpiresAt is the use-by or best-before date of the delivered stock
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Factor converts Unit into the unit the product is kept in
+	Factor float64 `json:"-"`
+}
+
+// baseQuantity is the quantity in the unit the product is kept in
+func (l SupplyProductRelation) baseQuantity() float64 {
+	if l.Factor == 0 {
+		return l.Quantity
+	}
+	return l.Quantity * l.Factor
+}
+
+// total is what the line was invoiced at
+func (l SupplyProductRelation) total() money.Amount {
+	return l.Price.Mul(l.Quantity, money.HalfEven)
+}
+
+// basePrice is the price of one unit the product is kept in
+func (l SupplyProductRelation) basePrice() (money.UnitCost, error) {
+	if l.Factor == 0 || l.Factor == 1 {
+		return l.Price.UnitCost(), nil
+	}
+	return l.Price.PerUnit(l.Factor)
+}
+
+// Supplier is a company products are bought from
+type Supplier struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	ContactName  string `json:"contactName"`
+	Phone        string `json:"phone"`
+	Email        string `json:"email"`
+	PaymentTerms string `json:"paymentTerms"`
+	Notes        string `json:"notes"`
+	// Products is only filled in for a single supplier
+	Products []SupplierProduct `json:"products,omitempty"`
+}
+
+// SupplierProduct is a product a supplier carries, bought in Unit (empty
+// for the unit the product is kept in). LastPrice is per Unit and stays
+// nil until the product is first received from the supplier.
+type SupplierProduct struct {
+	ProductID      int           `json:"productId"`
+	ProductName    string        `json:"productName"`
+	Unit           string        `json:"unit"`
+	LastPrice      *money.Amount `json:"lastPrice"`
+	LastReceivedAt *time.Time    `json:"lastReceivedAt"`
+}
+
+type OrderStatus string
+
+const (
+	OrderDraft     OrderStatus = "draft"
+	OrderSent      OrderStatus = "sent"
+	OrderPartial   OrderStatus = "partially_received"
+	OrderReceived  OrderStatus = "received"
+	OrderClosed    OrderStatus = "closed"
+	OrderCancelled OrderStatus = "cancelled"
+)
+
+// open reports whether deliveries can still be received against the order
+func (st OrderStatus) open() bool {
+	return st == OrderSent || st == OrderPartial
+}
+
+// statusIn reports whether status is one of statuses
+func statusIn(status OrderStatus, statuses []OrderStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// PurchaseOrder is what was ordered from a supplier. It is edited as a
+// draft, sent, and then received in one or more deliveries until all of
+// it has come or what is missing is given up on (closed).
+type PurchaseOrder struct {
+	ID           int                 `json:"id"`
+	SupplierID   int                 `json:"supplierId"`
+	SupplierName string              `json:"supplierName"`
+	UserID       int                 `json:"userId"`
+	Status       OrderStatus         `json:"status"`
+	Notes        string              `json:"notes"`
+	ExpectedAt   *time.Time          `json:"expectedAt"`
+	CreatedAt    time.Time           `json:"createdAt"`
+	SentAt       *time.Time          `json:"sentAt"`
+	ClosedAt     *time.Time          `json:"closedAt"`
+	Lines        []PurchaseOrderLine `json:"lines,omitempty"`
+	// SupplyIDs are the supplies received against the order
+	SupplyIDs []int `json:"supplyIds,omitempty"`
+}
+
+// PurchaseOrderLine is a product ordered, with Quantity and Price per Unit
+// as for a supply line. Received counts every delivery in the same unit.
+type PurchaseOrderLine struct {
+	ProductID   int          `json:"productId"`
+	ProductName string       `json:"productName"`
+	Unit        string       `json:"unit"`
+	Quantity    float64      `json:"quantity"`
+	Price       money.Amount `json:"price"`
+	Received    float64      `json:"received"`
+	// Outstanding is what is still expected (the backorder) and Over
+	// what came beyond the quantity ordered
+	Outstanding float64 `json:"outstanding"`
+	Over        float64 `json:"over"`
+}
+
+// tally fills in what is outstanding and over on every line
+func (po *PurchaseOrder) tally() {
+	for i := range po.Lines {
+		line := &po.Lines[i]
+		line.Outstanding, line.Over = 0, 0
+		if diff := line.Quantity - line.Received; diff > tolerance && po.Status != OrderClosed && po.Status != OrderCancelled {
+			line.Outstanding = diff
+		} else if diff < -tolerance {
+			line.Over = -diff
+		}
+	}
+}
+
+// line returns the index of the product's line, or -1
+func (po *PurchaseOrder) line(productID int) int {
+	for i, line := range po.Lines {
+		if line.ProductID == productID {
+			return i
+		}
+	}
+	return -1
+}
+
+// settle sets the status of an order after deliveries were counted
+// against it or taken off again: received once complete, closed when
+// closeShort gives up on the rest, and sent or partially received
+// otherwise. Drafts and closed or cancelled orders keep their status.
+func (po *PurchaseOrder) settle(closeShort bool, at time.Time) {
+	if po.Status != OrderSent && po.Status != OrderPartial && po.Status != OrderReceived {
+		return
+	}
+	received := false
+	for _, line := range po.Lines {
+		received = received || line.Received > tolerance
+	}
+	switch {
+	case po.complete():
+		if po.Status != OrderReceived {
+			po.ClosedAt = &at
+		}
+		po.Status = OrderReceived
+	case closeShort:
+		po.Status, po.ClosedAt = OrderClosed, &at
+	case received:
+		po.Status, po.ClosedAt = OrderPartial, nil
+	default:
+		po.Status, po.ClosedAt = OrderSent, nil
+	}
+}
+
+// complete reports whether every line has been received in full
+func (po *PurchaseOrder) complete() bool {
+	for _, line := range po.Lines {
+		if line.Quantity-line.Received > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// Receipt is a delivery against a purchase order. Lines leave out the
+// products that did not come.
+type Receipt struct {
+	InvoiceNumber string        `json:"invoiceNumber"`
+	Lines         []ReceiptLine `json:"lines"`
+	// CloseShort gives up on whatever the delivery leaves outstanding
+	// instead of waiting for it as a backorder
+	CloseShort bool `json:"closeShort"`
+}
+
+// ReceiptLine is a product delivered. Unit and Price default to those it
+// was ordered at; a different unit is converted for the order.
+type ReceiptLine struct {
+	ProductID int           `json:"productId"`
+	Quantity  float64       `json:"quantity"`
+	Unit      *string       `json:"unit"`
+	Price     *money.Amount `json:"price"`
+	ExpiresAt *time.Time    `json:"expiresAt"`
+}
+
+// Carrier is the supplier a product is bought from, with the unit and
+// price it was last bought in
+type Carrier struct {
+	SupplierID   int
+	SupplierName string
+	Product      SupplierProduct
+}
+
+// SupplierSuggestion is what to reorder from one supplier. Products no
+// supplier carries come last, without a supplier.
+type SupplierSuggestion struct {
+	SupplierID   *int             `json:"supplierId"`
+	SupplierName string           `json:"supplierName"`
+	Lines        []SuggestionLine `json:"lines"`
+}
+
+// SuggestionLine is a reorder suggestion in the unit the supplier sells
+// the product in, rounded up to whole units of it
+type SuggestionLine struct {
+	warehouse.ReorderSuggestion
+	OrderUnit     string        `json:"orderUnit"`
+	OrderQuantity float64       `json:"orderQuantity"`
+	Price         *money.Amount `json:"price"`
 }
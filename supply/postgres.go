@@ -0,0 +1,598 @@
+package supply
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/warehouse"
+)
+
+type PostgresStore struct {
+	db    *sql.DB
+	stock *warehouse.PostgresStore
+}
+
+func NewPostgresStore(db *sql.DB, stock *warehouse.PostgresStore) *PostgresStore {
+	return &PostgresStore{db: db, stock: stock}
+}
+
+// queryer is what *sql.DB and *sql.Tx have in common
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+const supplyColumns = `s.id, s.user_id, s.supplier_id, COALESCE(sup.name, ''), s.purchase_order_id,
+	s.invoice_number, s.created_at, s.voided_at, s.voided_by, s.void_reason, s.corrects,
+	(SELECT c.id FROM public."Supply" c WHERE c.corrects = s.id),
+	COALESCE((
+		SELECT SUM(ROUND(COALESCE(l.unit_price * l.unit_quantity::numeric, l.price * l.quantity::numeric), 2))
+		FROM public."Supply_product_relations" l WHERE l.supply_id = s.id
+	), 0)`
+
+const supplyFrom = `FROM public."Supply" s LEFT JOIN public."Suppliers" sup ON sup.id = s.supplier_id`
+
+func scanSupply(row interface{ Scan(...interface{}) error }) (Supply, error) {
+	var s Supply
+	err := row.Scan(&s.ID, &s.UserID, &s.SupplierID, &s.SupplierName, &s.PurchaseOrderID,
+		&s.InvoiceNumber, &s.CreatedAt, &s.VoidedAt, &s.VoidedBy, &s.VoidReason, &s.Corrects,
+		&s.CorrectedBy, &s.Total)
+	return s, err
+}
+
+func (s *PostgresStore) List(ctx context.Context, filter Filter) ([]Supply, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+supplyColumns+` `+supplyFrom+`
+		WHERE s.created_at >= $1 AND s.created_at < $2
+			AND ($3 = 0 OR s.supplier_id = $3)
+			AND ($4 = 0 OR EXISTS (
+				SELECT 1 FROM public."Supply_product_relations" l WHERE l.supply_id = s.id AND l.product_id = $4
+			))
+		ORDER BY s.created_at DESC, s.id DESC`,
+		filter.From, filter.To, filter.SupplierID, filter.ProductID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Supply{}
+	for rows.Next() {
+		supply, err := scanSupply(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, supply)
+	}
+	return list, rows.Err()
+}
+
+func (s *PostgresStore) ByID(ctx context.Context, id int) (Supply, error) {
+	return loadSupply(ctx, s.db, id, "")
+}
+
+// loadSupply reads a supply and its lines as they were entered; lock is
+// appended to the first query, e.g. FOR UPDATE OF s
+func loadSupply(ctx context.Context, q queryer, id int, lock string) (Supply, error) {
+	supply, err := scanSupply(q.QueryRowContext(ctx,
+		"SELECT "+supplyColumns+" "+supplyFrom+" WHERE s.id = $1 "+lock, id))
+	if err == sql.ErrNoRows {
+		return supply, ErrNotFound
+	} else if err != nil {
+		return supply, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT l.product_id, p.name, l.quantity, l.price, l.unit, l.unit_quantity, l.unit_price, l.expires_at
+		FROM public."Supply_product_relations" l
+		JOIN public."Products" p ON p.id = l.product_id
+		WHERE l.supply_id = $1
+		ORDER BY l.id`, id)
+	if err != nil {
+		return supply, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		line := SupplyProductRelation{SupplyID: id}
+		var unitQuantity *float64
+		var unitPrice *money.Amount
+		err := rows.Scan(&line.ProductID, &line.ProductName, &line.Quantity, &line.Price,
+			&line.Unit, &unitQuantity, &unitPrice, &line.ExpiresAt)
+		if err != nil {
+			return supply, err
+		}
+		// Lines entered in another unit read back as they were entered
+		if line.Unit != "" && unitQuantity != nil && *unitQuantity != 0 && unitPrice != nil {
+			line.Factor = line.Quantity / *unitQuantity
+			line.Quantity, line.Price = *unitQuantity, *unitPrice
+		}
+		supply.Products = append(supply.Products, line)
+	}
+	return supply, rows.Err()
+}
+
+func (s *PostgresStore) Carriers(ctx context.Context) (map[int]Carrier, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT ON (sp.product_id) sp.product_id, p.name, s.id, s.name, sp.unit, sp.last_price, sp.last_received_at
+		FROM public."Supplier_products" sp
+		JOIN public."Suppliers" s ON s.id = sp.supplier_id
+		JOIN public."Products" p ON p.id = sp.product_id
+		ORDER BY sp.product_id, sp.last_received_at DESC NULLS LAST, s.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	carriers := map[int]Carrier{}
+	for rows.Next() {
+		var c Carrier
+		err := rows.Scan(&c.Product.ProductID, &c.Product.ProductName, &c.SupplierID, &c.SupplierName,
+			&c.Product.Unit, &c.Product.LastPrice, &c.Product.LastReceivedAt)
+		if err != nil {
+			return nil, err
+		}
+		carriers[c.Product.ProductID] = c
+	}
+	return carriers, rows.Err()
+}
+
+const supplierColumns = "id, name, contact_name, phone, email, payment_terms, notes"
+
+func scanSupplier(row interface{ Scan(...interface{}) error }) (Supplier, error) {
+	var s Supplier
+	err := row.Scan(&s.ID, &s.Name, &s.ContactName, &s.Phone, &s.Email, &s.PaymentTerms, &s.Notes)
+	return s, err
+}
+
+func (s *PostgresStore) Suppliers(ctx context.Context) ([]Supplier, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+supplierColumns+" FROM public.\"Suppliers\" ORDER BY name, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Supplier{}
+	for rows.Next() {
+		supplier, err := scanSupplier(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, supplier)
+	}
+	return list, rows.Err()
+}
+
+func (s *PostgresStore) SupplierByID(ctx context.Context, id int) (Supplier, error) {
+	supplier, err := scanSupplier(s.db.QueryRowContext(ctx,
+		"SELECT "+supplierColumns+" FROM public.\"Suppliers\" WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return supplier, ErrNotFound
+	} else if err != nil {
+		return supplier, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT sp.product_id, p.name, sp.unit, sp.last_price, sp.last_received_at
+		FROM public."Supplier_products" sp
+		JOIN public."Products" p ON p.id = sp.product_id
+		WHERE sp.supplier_id = $1
+		ORDER BY p.name, sp.product_id`, id)
+	if err != nil {
+		return supplier, err
+	}
+	defer rows.Close()
+
+	supplier.Products = []SupplierProduct{}
+	for rows.Next() {
+		var p SupplierProduct
+		if err := rows.Scan(&p.ProductID, &p.ProductName, &p.Unit, &p.LastPrice, &p.LastReceivedAt); err != nil {
+			return supplier, err
+		}
+		supplier.Products = append(supplier.Products, p)
+	}
+	return supplier, rows.Err()
+}
+
+func (s *PostgresStore) SaveSupplier(ctx context.Context, supplier *Supplier) error {
+	if supplier.ID == 0 {
+		return s.db.QueryRowContext(ctx, `
+			INSERT INTO public."Suppliers" (name, contact_name, phone, email, payment_terms, notes)
+			VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+			supplier.Name, supplier.ContactName, supplier.Phone, supplier.Email, supplier.PaymentTerms, supplier.Notes,
+		).Scan(&supplier.ID)
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE public."Suppliers"
+		SET name = $1, contact_name = $2, phone = $3, email = $4, payment_terms = $5, notes = $6
+		WHERE id = $7`,
+		supplier.Name, supplier.ContactName, supplier.Phone, supplier.Email, supplier.PaymentTerms, supplier.Notes, supplier.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const orderColumns = `po.id, po.supplier_id, s.name, po.user_id, po.status, po.notes,
+	po.expected_at, po.created_at, po.sent_at, po.closed_at`
+
+func scanOrder(row interface{ Scan(...interface{}) error }) (PurchaseOrder, error) {
+	var po PurchaseOrder
+	err := row.Scan(&po.ID, &po.SupplierID, &po.SupplierName, &po.UserID, &po.Status, &po.Notes,
+		&po.ExpectedAt, &po.CreatedAt, &po.SentAt, &po.ClosedAt)
+	return po, err
+}
+
+func (s *PostgresStore) PurchaseOrders(ctx context.Context, status OrderStatus, supplierID int) ([]PurchaseOrder, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+orderColumns+`
+		FROM public."Purchase_orders" po
+		JOIN public."Suppliers" s ON s.id = po.supplier_id
+		WHERE ($1 = '' OR po.status = $1) AND ($2 = 0 OR po.supplier_id = $2)
+		ORDER BY po.created_at DESC, po.id DESC`, status, supplierID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []PurchaseOrder{}
+	for rows.Next() {
+		po, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, po)
+	}
+	return list, rows.Err()
+}
+
+func (s *PostgresStore) PurchaseOrderByID(ctx context.Context, id int) (PurchaseOrder, error) {
+	return loadOrder(ctx, s.db, id, "")
+}
+
+// loadOrder reads a purchase order, its lines and the supplies received
+// against it; lock is appended to the first query, e.g. FOR UPDATE OF po
+func loadOrder(ctx context.Context, q queryer, id int, lock string) (PurchaseOrder, error) {
+	po, err := scanOrder(q.QueryRowContext(ctx, `
+		SELECT `+orderColumns+`
+		FROM public."Purchase_orders" po
+		JOIN public."Suppliers" s ON s.id = po.supplier_id
+		WHERE po.id = $1 `+lock, id))
+	if err == sql.ErrNoRows {
+		return po, ErrNotFound
+	} else if err != nil {
+		return po, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT l.product_id, p.name, l.unit, l.quantity, l.price, l.received
+		FROM public."Purchase_order_lines" l
+		JOIN public."Products" p ON p.id = l.product_id
+		WHERE l.purchase_order_id = $1
+		ORDER BY l.id`, id)
+	if err != nil {
+		return po, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line PurchaseOrderLine
+		err := rows.Scan(&line.ProductID, &line.ProductName, &line.Unit, &line.Quantity, &line.Price, &line.Received)
+		if err != nil {
+			return po, err
+		}
+		po.Lines = append(po.Lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return po, err
+	}
+
+	supplies, err := q.QueryContext(ctx,
+		"SELECT id FROM public.\"Supply\" WHERE purchase_order_id = $1 ORDER BY id", id)
+	if err != nil {
+		return po, err
+	}
+	defer supplies.Close()
+
+	for supplies.Next() {
+		var supplyID int
+		if err := supplies.Scan(&supplyID); err != nil {
+			return po, err
+		}
+		po.SupplyIDs = append(po.SupplyIDs, supplyID)
+	}
+	return po, supplies.Err()
+}
+
+func (s *PostgresStore) SetOrderStatus(ctx context.Context, id int, status OrderStatus, from []OrderStatus, at time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current OrderStatus
+	err = tx.QueryRowContext(ctx,
+		"SELECT status FROM public.\"Purchase_orders\" WHERE id = $1 FOR UPDATE", id).Scan(&current)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	if !statusIn(current, from) {
+		return fmt.Errorf("%w: it is %s", ErrOrderStatus, current)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE public."Purchase_orders"
+		SET status = $1,
+			sent_at = CASE WHEN $1 = 'sent' THEN $2 ELSE sent_at END,
+			closed_at = CASE WHEN $1 IN ('closed', 'cancelled') THEN $2 ELSE closed_at END
+		WHERE id = $3`,
+		status, at, id,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) InTx(ctx context.Context, fn func(Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&postgresTx{StockTx: s.stock.Stock(tx), tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type postgresTx struct {
+	warehouse.StockTx
+	tx *sql.Tx
+}
+
+// exists reports whether the table has a row with the id
+func (t *postgresTx) exists(ctx context.Context, table string, id int) (bool, error) {
+	var found bool
+	err := t.tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM public.\""+table+"\" WHERE id = $1)", id,
+	).Scan(&found)
+	return found, err
+}
+
+func (t *postgresTx) InsertSupply(ctx context.Context, s *Supply) error {
+	if s.SupplierID != nil {
+		found, err := t.exists(ctx, "Suppliers", *s.SupplierID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrUnknownSupplier
+		}
+	}
+	return t.tx.QueryRowContext(ctx, `
+		INSERT INTO public."Supply" (user_id, supplier_id, purchase_order_id, invoice_number, corrects, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		s.UserID, s.SupplierID, s.PurchaseOrderID, s.InvoiceNumber, s.Corrects, s.CreatedAt,
+	).Scan(&s.ID)
+}
+
+func (t *postgresTx) LockSupply(ctx context.Context, id int) (Supply, error) {
+	return loadSupply(ctx, t.tx, id, "FOR UPDATE OF s")
+}
+
+func (t *postgresTx) MarkVoided(ctx context.Context, id int, userID int, at time.Time, reason string) error {
+	_, err := t.tx.ExecContext(ctx,
+		"UPDATE public.\"Supply\" SET voided_at = $1, voided_by = $2, void_reason = $3 WHERE id = $4",
+		at, userID, reason, id,
+	)
+	return err
+}
+
+func (t *postgresTx) VoidedSupplies(ctx context.Context, productID int) ([]int, error) {
+	rows, err := t.tx.QueryContext(ctx, `
+		SELECT DISTINCT s.id
+		FROM public."Supply" s
+		JOIN public."Supply_product_relations" l ON l.supply_id = s.id
+		WHERE s.voided_at IS NOT NULL AND l.product_id = $1
+		ORDER BY s.id`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// InsertLine stores the line in the unit the product is kept in, and as
+// entered when it was given in another unit
+func (t *postgresTx) InsertLine(ctx context.Context, supplyID int, line SupplyProductRelation) error {
+	price, err := line.basePrice()
+	if err != nil {
+		return err
+	}
+	var unitQuantity, unitPrice interface{}
+	if line.Unit != "" {
+		unitQuantity, unitPrice = line.Quantity, line.Price
+	}
+	_, err = t.tx.ExecContext(ctx, `
+		INSERT INTO public."Supply_product_relations" (supply_id, product_id, quantity, price, unit, unit_quantity, unit_price, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		supplyID, line.ProductID, line.baseQuantity(), price, line.Unit, unitQuantity, unitPrice, line.ExpiresAt,
+	)
+	return err
+}
+
+func (t *postgresTx) RecordPrice(ctx context.Context, supplierID int, line SupplyProductRelation, at time.Time) error {
+	_, err := t.tx.ExecContext(ctx, `
+		INSERT INTO public."Supplier_products" (supplier_id, product_id, unit, last_price, last_received_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (supplier_id, product_id)
+		DO UPDATE SET unit = EXCLUDED.unit, last_price = EXCLUDED.last_price, last_received_at = EXCLUDED.last_received_at`,
+		supplierID, line.ProductID, line.Unit, line.Price, at,
+	)
+	return err
+}
+
+func (t *postgresTx) SetSupplierProducts(ctx context.Context, supplierID int, products []SupplierProduct) error {
+	var found bool
+	err := t.tx.QueryRowContext(ctx,
+		"SELECT true FROM public.\"Suppliers\" WHERE id = $1 FOR UPDATE", supplierID).Scan(&found)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	rows, err := t.tx.QueryContext(ctx, `
+		DELETE FROM public."Supplier_products" WHERE supplier_id = $1
+		RETURNING product_id, unit, last_price, last_received_at`, supplierID)
+	if err != nil {
+		return err
+	}
+	previous := map[int]SupplierProduct{}
+	for rows.Next() {
+		var p SupplierProduct
+		if err := rows.Scan(&p.ProductID, &p.Unit, &p.LastPrice, &p.LastReceivedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		previous[p.ProductID] = p
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range products {
+		found, err := t.exists(ctx, "Products", p.ProductID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%w: %d", ErrUnknownProduct, p.ProductID)
+		}
+		if old, ok := previous[p.ProductID]; ok && old.Unit == p.Unit {
+			p.LastPrice, p.LastReceivedAt = old.LastPrice, old.LastReceivedAt
+		} else {
+			p.LastPrice, p.LastReceivedAt = nil, nil
+		}
+		_, err = t.tx.ExecContext(ctx, `
+			INSERT INTO public."Supplier_products" (supplier_id, product_id, unit, last_price, last_received_at)
+			VALUES ($1, $2, $3, $4, $5)`,
+			supplierID, p.ProductID, p.Unit, p.LastPrice, p.LastReceivedAt,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *postgresTx) SavePurchaseOrder(ctx context.Context, po *PurchaseOrder) error {
+	found, err := t.exists(ctx, "Suppliers", po.SupplierID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrUnknownSupplier
+	}
+	for _, line := range po.Lines {
+		found, err := t.exists(ctx, "Products", line.ProductID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%w: %d", ErrUnknownProduct, line.ProductID)
+		}
+	}
+
+	if po.ID == 0 {
+		err = t.tx.QueryRowContext(ctx, `
+			INSERT INTO public."Purchase_orders" (supplier_id, user_id, status, notes, expected_at, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+			po.SupplierID, po.UserID, po.Status, po.Notes, po.ExpectedAt, po.CreatedAt,
+		).Scan(&po.ID)
+		if err != nil {
+			return err
+		}
+	} else {
+		var status OrderStatus
+		err = t.tx.QueryRowContext(ctx,
+			"SELECT status FROM public.\"Purchase_orders\" WHERE id = $1 FOR UPDATE", po.ID).Scan(&status)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		if status != OrderDraft {
+			return fmt.Errorf("%w: it is %s", ErrOrderStatus, status)
+		}
+
+		_, err = t.tx.ExecContext(ctx,
+			"UPDATE public.\"Purchase_orders\" SET supplier_id = $1, notes = $2, expected_at = $3 WHERE id = $4",
+			po.SupplierID, po.Notes, po.ExpectedAt, po.ID,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = t.tx.ExecContext(ctx, "DELETE FROM public.\"Purchase_order_lines\" WHERE purchase_order_id = $1", po.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, line := range po.Lines {
+		_, err := t.tx.ExecContext(ctx, `
+			INSERT INTO public."Purchase_order_lines" (purchase_order_id, product_id, unit, quantity, price)
+			VALUES ($1, $2, $3, $4, $5)`,
+			po.ID, line.ProductID, line.Unit, line.Quantity, line.Price,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *postgresTx) LockPurchaseOrder(ctx context.Context, id int) (PurchaseOrder, error) {
+	return loadOrder(ctx, t.tx, id, "FOR UPDATE OF po")
+}
+
+func (t *postgresTx) SaveReceived(ctx context.Context, po PurchaseOrder) error {
+	for _, line := range po.Lines {
+		_, err := t.tx.ExecContext(ctx,
+			"UPDATE public.\"Purchase_order_lines\" SET received = $1 WHERE purchase_order_id = $2 AND product_id = $3",
+			line.Received, po.ID, line.ProductID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := t.tx.ExecContext(ctx,
+		"UPDATE public.\"Purchase_orders\" SET status = $1, closed_at = $2 WHERE id = $3",
+		po.Status, po.ClosedAt, po.ID,
+	)
+	return err
+}
@@ -0,0 +1,331 @@
+package supply
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/users"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// GetPurchaseOrders lists the purchase orders, newest first, optionally
+// only those with ?status= or for ?supplierId=
+func (s *Service) GetPurchaseOrders(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	status := OrderStatus(r.URL.Query().Get("status"))
+	supplierID := 0
+	if v := r.URL.Query().Get("supplierId"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid supplierId", http.StatusBadRequest)
+			return
+		}
+		supplierID = id
+	}
+
+	list, err := s.store.PurchaseOrders(r.Context(), status, supplierID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetPurchaseOrder returns an order with what has been received of each
+// line and what is still outstanding
+func (s *Service) GetPurchaseOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid purchase order id", http.StatusBadRequest)
+		return
+	}
+	s.writePurchaseOrder(w, r, id, http.StatusOK)
+}
+
+// SavePurchaseOrder creates a draft order (POST) or replaces a draft
+// (PUT). Lines without a unit or price take the unit the supplier sells
+// the product in and the price it was last received at.
+func (s *Service) SavePurchaseOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var po PurchaseOrder
+	err := json.NewDecoder(r.Body).Decode(&po)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	po.ID = 0
+	if idParam := ps.ByName("id"); idParam != "" {
+		po.ID, err = strconv.Atoi(idParam)
+		if err != nil {
+			http.Error(w, "Invalid purchase order id", http.StatusBadRequest)
+			return
+		}
+	}
+	if msg := normalizePurchaseOrder(&po); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := users.ClaimsFromContext(r.Context())
+	po.UserID = claims.UserID
+	po.Status = OrderDraft
+	po.CreatedAt = time.Now()
+
+	created := po.ID == 0
+	err = s.savePurchaseOrder(r.Context(), &po)
+	if !writePurchasingError(w, r, err) {
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	s.writePurchaseOrder(w, r, po.ID, status)
+}
+
+func (s *Service) savePurchaseOrder(ctx context.Context, po *PurchaseOrder) error {
+	supplier, err := s.store.SupplierByID(ctx, po.SupplierID)
+	if errors.Is(err, ErrNotFound) {
+		return ErrUnknownSupplier
+	} else if err != nil {
+		return err
+	}
+	carried := map[int]SupplierProduct{}
+	for _, p := range supplier.Products {
+		carried[p.ProductID] = p
+	}
+	for i := range po.Lines {
+		line := &po.Lines[i]
+		p, ok := carried[line.ProductID]
+		if !ok {
+			continue
+		}
+		if line.Unit == "" {
+			line.Unit = p.Unit
+		}
+		if line.Price.IsZero() && p.LastPrice != nil && line.Unit == p.Unit {
+			line.Price = *p.LastPrice
+		}
+	}
+
+	return s.store.InTx(ctx, func(tx Tx) error {
+		for _, line := range po.Lines {
+			if err := checkUnit(ctx, tx, line.ProductID, line.Unit); err != nil {
+				return err
+			}
+		}
+		return tx.SavePurchaseOrder(ctx, po)
+	})
+}
+
+// SendPurchaseOrder marks a draft as sent to the supplier; from then on
+// deliveries can be received against it
+func (s *Service) SendPurchaseOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s.moveOrder(w, r, ps, OrderSent, OrderDraft)
+}
+
+// CancelPurchaseOrder calls off an order nothing has been received against
+func (s *Service) CancelPurchaseOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s.moveOrder(w, r, ps, OrderCancelled, OrderDraft, OrderSent)
+}
+
+// ClosePurchaseOrder gives up on the backorder of a partly received order
+func (s *Service) ClosePurchaseOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s.moveOrder(w, r, ps, OrderClosed, OrderPartial)
+}
+
+func (s *Service) moveOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params, status OrderStatus, from ...OrderStatus) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid purchase order id", http.StatusBadRequest)
+		return
+	}
+
+	err = s.store.SetOrderStatus(r.Context(), id, status, from, time.Now())
+	if !writePurchasingError(w, r, err) {
+		return
+	}
+	s.writePurchaseOrder(w, r, id, http.StatusOK)
+}
+
+// ReceivePurchaseOrder books a delivery against a sent order as a supply
+// of the order's supplier. Short lines stay outstanding as a backorder
+// unless the receipt closes them; more than ordered is taken in and shown
+// as over.
+func (s *Service) ReceivePurchaseOrder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid purchase order id", http.StatusBadRequest)
+		return
+	}
+
+	var receipt Receipt
+	err = json.NewDecoder(r.Body).Decode(&receipt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	receipt.InvoiceNumber = strings.TrimSpace(receipt.InvoiceNumber)
+	if len(receipt.Lines) == 0 {
+		http.Error(w, "Lines are required", http.StatusBadRequest)
+		return
+	}
+	for _, line := range receipt.Lines {
+		price := money.Zero()
+		if line.Price != nil {
+			price = *line.Price
+		}
+		if msg := checkLine(line.Quantity, price); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+	}
+
+	claims, _ := users.ClaimsFromContext(r.Context())
+	err = s.receiveOrder(r.Context(), id, receipt, claims.UserID)
+	if !writePurchasingError(w, r, err) {
+		return
+	}
+	s.writePurchaseOrder(w, r, id, http.StatusCreated)
+}
+
+// receiveOrder counts the receipt against the order's lines and books it
+// into the warehouse like any other supply
+func (s *Service) receiveOrder(ctx context.Context, id int, receipt Receipt, userID int) error {
+	return s.store.InTx(ctx, func(tx Tx) error {
+		po, err := tx.LockPurchaseOrder(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !po.Status.open() {
+			return fmt.Errorf("%w: it is %s", ErrOrderStatus, po.Status)
+		}
+
+		supply := Supply{
+			UserID:          userID,
+			SupplierID:      &po.SupplierID,
+			PurchaseOrderID: &po.ID,
+			InvoiceNumber:   receipt.InvoiceNumber,
+			CreatedAt:       time.Now(),
+		}
+		for _, line := range receipt.Lines {
+			i := po.line(line.ProductID)
+			if i < 0 {
+				return fmt.Errorf("%w: %d", ErrNotOnOrder, line.ProductID)
+			}
+			unit, price := po.Lines[i].Unit, po.Lines[i].Price
+			if line.Unit != nil {
+				unit = strings.TrimSpace(*line.Unit)
+			}
+			if line.Price != nil {
+				price = *line.Price
+			}
+			supply.Products = append(supply.Products, SupplyProductRelation{
+				ProductID: line.ProductID,
+				Quantity:  line.Quantity,
+				Price:     price,
+				Unit:      unit,
+				ExpiresAt: line.ExpiresAt,
+			})
+		}
+		if err := countReceived(ctx, tx, &po, supply.Products, 1); err != nil {
+			return err
+		}
+		po.settle(receipt.CloseShort, supply.CreatedAt)
+
+		if err := receive(ctx, tx, &supply); err != nil {
+			return err
+		}
+		return tx.SaveReceived(ctx, po)
+	})
+}
+
+// countReceived adds the supply lines to what has been received of the
+// order, or takes them off again with sign -1, in the units ordered
+func countReceived(ctx context.Context, tx Tx, po *PurchaseOrder, lines []SupplyProductRelation, sign float64) error {
+	for _, line := range lines {
+		i := po.line(line.ProductID)
+		if i < 0 {
+			return fmt.Errorf("%w: %d", ErrNotOnOrder, line.ProductID)
+		}
+		quantity, err := convert(ctx, tx, line.ProductID, line.Quantity, line.Unit, po.Lines[i].Unit)
+		if err != nil {
+			return err
+		}
+		po.Lines[i].Received += sign * quantity
+	}
+	return nil
+}
+
+// convert turns a quantity of a product in one of its units into another
+func convert(ctx context.Context, tx Tx, productID int, quantity float64, from, to string) (float64, error) {
+	if from == to {
+		return quantity, nil
+	}
+	fromFactor, ok, err := tx.Factor(ctx, productID, from)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("%w %q for product %d", ErrUnknownUnit, from, productID)
+	}
+	toFactor, ok, err := tx.Factor(ctx, productID, to)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("%w %q for product %d", ErrUnknownUnit, to, productID)
+	}
+	return quantity * fromFactor / toFactor, nil
+}
+
+func (s *Service) writePurchaseOrder(w http.ResponseWriter, r *http.Request, id int, status int) {
+	po, err := s.store.PurchaseOrderByID(r.Context(), id)
+	if !writePurchasingError(w, r, err) {
+		return
+	}
+	po.tally()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(po)
+}
+
+// normalizePurchaseOrder trims the order and returns a message for the
+// first thing that is invalid
+func normalizePurchaseOrder(po *PurchaseOrder) string {
+	po.Notes = strings.TrimSpace(po.Notes)
+	if po.SupplierID <= 0 {
+		return "Supplier id is required"
+	}
+	if len(po.Lines) == 0 {
+		return "Lines are required"
+	}
+
+	seen := map[int]bool{}
+	for i := range po.Lines {
+		line := &po.Lines[i]
+		if line.ProductID <= 0 {
+			return "Product id is required"
+		}
+		if seen[line.ProductID] {
+			return "Each product can be ordered once"
+		}
+		seen[line.ProductID] = true
+		if msg := checkLine(line.Quantity, line.Price); msg != "" {
+			return msg
+		}
+		line.Unit = strings.TrimSpace(line.Unit)
+		line.ProductName, line.Received = "", 0
+	}
+	return ""
+}
@@ -0,0 +1,212 @@
+package supply
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"randevu-shawarma-server/users"
+	"randevu-shawarma-server/warehouse"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// GetReorderSuggestions lists the products that have fallen to their
+// reorder point grouped by the supplier each is bought from. ?days=,
+// ?leadDays= and ?coverDays= tune how much is suggested.
+func (s *Service) GetReorderSuggestions(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	opts, msg := warehouse.ParseReorderOptions(r.URL.Query())
+	if msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	groups, err := s.suggest(r.Context(), opts)
+	if !writePurchasingError(w, r, err) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// CreateSuggestedOrder turns the suggestions for one supplier into a draft
+// purchase order, to be checked and sent like any other. A supplier that
+// already has a draft gets no second one.
+func (s *Service) CreateSuggestedOrder(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	opts, msg := warehouse.ParseReorderOptions(r.URL.Query())
+	if msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		SupplierID int `json:"supplierId"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.SupplierID <= 0 {
+		http.Error(w, "Supplier id is required", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := users.ClaimsFromContext(r.Context())
+	po, err := s.draftSuggestions(r.Context(), body.SupplierID, claims.UserID, opts)
+	if !writePurchasingError(w, r, err) {
+		return
+	}
+	s.writePurchaseOrder(w, r, po.ID, http.StatusCreated)
+}
+
+func (s *Service) draftSuggestions(ctx context.Context, supplierID, userID int, opts warehouse.ReorderOptions) (PurchaseOrder, error) {
+	drafts, err := s.store.PurchaseOrders(ctx, OrderDraft, supplierID)
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+	if len(drafts) > 0 {
+		return PurchaseOrder{}, fmt.Errorf("%w: %d", ErrDraftExists, drafts[0].ID)
+	}
+
+	groups, err := s.suggest(ctx, opts)
+	if err != nil {
+		return PurchaseOrder{}, err
+	}
+
+	po := PurchaseOrder{
+		SupplierID: supplierID,
+		UserID:     userID,
+		Status:     OrderDraft,
+		Notes:      "Reorder suggestion",
+		CreatedAt:  time.Now(),
+	}
+	for _, group := range groups {
+		if group.SupplierID == nil || *group.SupplierID != supplierID {
+			continue
+		}
+		for _, line := range group.Lines {
+			po.Lines = append(po.Lines, PurchaseOrderLine{
+				ProductID: line.ProductID,
+				Unit:      line.OrderUnit,
+				Quantity:  line.OrderQuantity,
+			})
+		}
+	}
+	if len(po.Lines) == 0 {
+		return po, ErrNothingToOrder
+	}
+	return po, s.savePurchaseOrder(ctx, &po)
+}
+
+// suggest groups the warehouse's reorder suggestions by the supplier each
+// product is bought from, in the unit it is bought in, suppliers by name
+func (s *Service) suggest(ctx context.Context, opts warehouse.ReorderOptions) ([]SupplierSuggestion, error) {
+	onOrder, err := s.onOrder(ctx)
+	if err != nil {
+		return nil, err
+	}
+	suggestions, err := s.warehouse.Suggest(ctx, opts, onOrder)
+	if err != nil {
+		return nil, err
+	}
+	carriers, err := s.store.Carriers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bySupplier := map[int]*SupplierSuggestion{}
+	unsupplied := SupplierSuggestion{}
+	err = s.store.InTx(ctx, func(tx Tx) error {
+		for _, suggestion := range suggestions {
+			line := SuggestionLine{ReorderSuggestion: suggestion, OrderQuantity: suggestion.Quantity}
+			carrier, ok := carriers[suggestion.ProductID]
+			if !ok {
+				unsupplied.Lines = append(unsupplied.Lines, line)
+				continue
+			}
+
+			if carrier.Product.Unit != "" {
+				quantity, err := convert(ctx, tx, suggestion.ProductID, suggestion.Quantity, "", carrier.Product.Unit)
+				if err != nil {
+					return err
+				}
+				line.OrderUnit, line.OrderQuantity = carrier.Product.Unit, math.Ceil(quantity-tolerance)
+			}
+			if carrier.Product.LastPrice != nil {
+				price := *carrier.Product.LastPrice
+				line.Price = &price
+			}
+
+			group, ok := bySupplier[carrier.SupplierID]
+			if !ok {
+				id := carrier.SupplierID
+				group = &SupplierSuggestion{SupplierID: &id, SupplierName: carrier.SupplierName}
+				bySupplier[id] = group
+			}
+			group.Lines = append(group.Lines, line)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]SupplierSuggestion, 0, len(bySupplier)+1)
+	for _, group := range bySupplier {
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].SupplierName != groups[j].SupplierName {
+			return groups[i].SupplierName < groups[j].SupplierName
+		}
+		return *groups[i].SupplierID < *groups[j].SupplierID
+	})
+	if len(unsupplied.Lines) > 0 {
+		groups = append(groups, unsupplied)
+	}
+	return groups, nil
+}
+
+// onOrder returns what sent and partially received purchase orders are
+// still to bring in of each product, in the unit it is kept in
+func (s *Service) onOrder(ctx context.Context) (map[int]float64, error) {
+	var open []PurchaseOrder
+	for _, status := range []OrderStatus{OrderSent, OrderPartial} {
+		list, err := s.store.PurchaseOrders(ctx, status, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, summary := range list {
+			po, err := s.store.PurchaseOrderByID(ctx, summary.ID)
+			if err != nil {
+				return nil, err
+			}
+			po.tally()
+			open = append(open, po)
+		}
+	}
+
+	onOrder := map[int]float64{}
+	err := s.store.InTx(ctx, func(tx Tx) error {
+		for _, po := range open {
+			for _, line := range po.Lines {
+				if line.Outstanding <= tolerance {
+					continue
+				}
+				quantity, err := convert(ctx, tx, line.ProductID, line.Outstanding, line.Unit, "")
+				if err != nil {
+					return err
+				}
+				onOrder[line.ProductID] += quantity
+			}
+		}
+		return nil
+	})
+	return onOrder, err
+}
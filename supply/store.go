@@ -0,0 +1,96 @@
+package supply
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"randevu-shawarma-server/warehouse"
+)
+
+var (
+	ErrNotFound        = errors.New("not found")
+	ErrUnknownUnit     = errors.New("unknown unit")
+	ErrUnknownProduct  = errors.New("unknown product")
+	ErrUnknownSupplier = errors.New("unknown supplier")
+	// ErrOrderStatus is returned for what the purchase order's status
+	// does not allow, e.g. editing a sent order
+	ErrOrderStatus = errors.New("not allowed in the purchase order's status")
+	ErrNotOnOrder  = errors.New("product is not on the purchase order")
+	ErrVoided      = errors.New("supply has been voided")
+	// ErrNothingToOrder is returned for a draft of suggestions when none
+	// of them are bought from the supplier
+	ErrNothingToOrder = errors.New("nothing to reorder from the supplier")
+	// ErrDraftExists is returned for a draft of suggestions when the
+	// supplier already has a draft to check and send first
+	ErrDraftExists = errors.New("the supplier already has a draft purchase order")
+)
+
+// tolerance keeps float noise from leaving a line outstanding
+const tolerance = 1e-9
+
+// Store persists supplies, suppliers and purchase orders
+type Store interface {
+	// List returns the supplies the filter lets through without their
+	// lines, newest first
+	List(ctx context.Context, filter Filter) ([]Supply, error)
+	// ByID returns a supply with its lines as they were entered
+	ByID(ctx context.Context, id int) (Supply, error)
+	// Suppliers returns every supplier without its products, by name
+	Suppliers(ctx context.Context) ([]Supplier, error)
+	// SupplierByID returns a supplier with the products it carries
+	SupplierByID(ctx context.Context, id int) (Supplier, error)
+	// Carriers returns, by product, the supplier each product is bought
+	// from: of those that carry it, the one it was last received from
+	Carriers(ctx context.Context) (map[int]Carrier, error)
+	// SaveSupplier creates the supplier when its ID is zero and updates its
+	// details otherwise; its products are left alone
+	SaveSupplier(ctx context.Context, supplier *Supplier) error
+	// PurchaseOrders returns the orders without their lines, newest first,
+	// narrowed to a status and a supplier when those are set
+	PurchaseOrders(ctx context.Context, status OrderStatus, supplierID int) ([]PurchaseOrder, error)
+	PurchaseOrderByID(ctx context.Context, id int) (PurchaseOrder, error)
+	// SetOrderStatus moves an order whose status is one of from to status
+	// at the given time, and fails with ErrOrderStatus otherwise
+	SetOrderStatus(ctx context.Context, id int, status OrderStatus, from []OrderStatus, at time.Time) error
+	// InTx runs fn in one transaction, rolled back if fn returns an error
+	InTx(ctx context.Context, fn func(Tx) error) error
+}
+
+var (
+	_ Store = (*PostgresStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// Tx is the part of a transaction a supply or a purchase order needs.
+// Writes that take units run in one, to check the units against the
+// products'.
+type Tx interface {
+	warehouse.StockTx
+	// InsertSupply stores the supply without its lines; a supplier that
+	// does not exist is ErrUnknownSupplier
+	InsertSupply(ctx context.Context, s *Supply) error
+	InsertLine(ctx context.Context, supplyID int, line SupplyProductRelation) error
+	// LockSupply returns the supply with its lines and holds it until the
+	// transaction ends
+	LockSupply(ctx context.Context, id int) (Supply, error)
+	// MarkVoided records that the supply no longer counts
+	MarkVoided(ctx context.Context, id int, userID int, at time.Time, reason string) error
+	// VoidedSupplies returns the voided supplies that brought in the product
+	VoidedSupplies(ctx context.Context, productID int) ([]int, error)
+	// RecordPrice remembers the price a supplier's product was received at,
+	// adding the product to what the supplier carries if needed
+	RecordPrice(ctx context.Context, supplierID int, line SupplyProductRelation, at time.Time) error
+	// SetSupplierProducts replaces the products a supplier carries,
+	// keeping the last price of those it still carries in the same unit
+	SetSupplierProducts(ctx context.Context, supplierID int, products []SupplierProduct) error
+	// SavePurchaseOrder creates a draft when the order's ID is zero and
+	// replaces a draft's supplier, details and lines otherwise
+	SavePurchaseOrder(ctx context.Context, po *PurchaseOrder) error
+	// LockPurchaseOrder returns the order with its lines and holds it until
+	// the transaction ends
+	LockPurchaseOrder(ctx context.Context, id int) (PurchaseOrder, error)
+	// SaveReceived stores the received quantities of the order's lines
+	// along with its status and closing time
+	SaveReceived(ctx context.Context, po PurchaseOrder) error
+}
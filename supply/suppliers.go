@@ -0,0 +1,185 @@
+package supply
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// GetSuppliers lists the suppliers by name
+func (s *Service) GetSuppliers(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	suppliers, err := s.store.Suppliers(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suppliers)
+}
+
+// GetSupplier returns a supplier with the products it carries and the
+// prices they were last received at
+func (s *Service) GetSupplier(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid supplier id", http.StatusBadRequest)
+		return
+	}
+	s.writeSupplier(w, r, id)
+}
+
+// SaveSupplier creates a supplier (POST) or updates its details (PUT)
+func (s *Service) SaveSupplier(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var supplier Supplier
+	err := json.NewDecoder(r.Body).Decode(&supplier)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	supplier.ID = 0
+	if idParam := ps.ByName("id"); idParam != "" {
+		supplier.ID, err = strconv.Atoi(idParam)
+		if err != nil {
+			http.Error(w, "Invalid supplier id", http.StatusBadRequest)
+			return
+		}
+	}
+	if msg := normalizeSupplier(&supplier); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	created := supplier.ID == 0
+	err = s.store.SaveSupplier(r.Context(), &supplier)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if created {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(supplier)
+		return
+	}
+	s.writeSupplier(w, r, supplier.ID)
+}
+
+// SetSupplierProducts replaces the products a supplier carries and the
+// unit each is bought in
+func (s *Service) SetSupplierProducts(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid supplier id", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Products []SupplierProduct `json:"products"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	seen := map[int]bool{}
+	for i := range body.Products {
+		p := &body.Products[i]
+		if p.ProductID <= 0 {
+			http.Error(w, "Product id is required", http.StatusBadRequest)
+			return
+		}
+		if seen[p.ProductID] {
+			http.Error(w, "Each product can be listed once", http.StatusBadRequest)
+			return
+		}
+		seen[p.ProductID] = true
+		p.Unit = strings.TrimSpace(p.Unit)
+	}
+
+	err = s.store.InTx(r.Context(), func(tx Tx) error {
+		for _, p := range body.Products {
+			if err := checkUnit(r.Context(), tx, p.ProductID, p.Unit); err != nil {
+				return err
+			}
+		}
+		return tx.SetSupplierProducts(r.Context(), id, body.Products)
+	})
+	if !writePurchasingError(w, r, err) {
+		return
+	}
+	s.writeSupplier(w, r, id)
+}
+
+func (s *Service) writeSupplier(w http.ResponseWriter, r *http.Request, id int) {
+	supplier, err := s.store.SupplierByID(r.Context(), id)
+	if !writePurchasingError(w, r, err) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(supplier)
+}
+
+// normalizeSupplier trims the supplier's details and returns a message
+// for the first one that is invalid
+func normalizeSupplier(supplier *Supplier) string {
+	supplier.Name = strings.TrimSpace(supplier.Name)
+	supplier.ContactName = strings.TrimSpace(supplier.ContactName)
+	supplier.Phone = strings.TrimSpace(supplier.Phone)
+	supplier.Email = strings.TrimSpace(supplier.Email)
+	supplier.PaymentTerms = strings.TrimSpace(supplier.PaymentTerms)
+	supplier.Notes = strings.TrimSpace(supplier.Notes)
+	supplier.Products = nil
+
+	if supplier.Name == "" {
+		return "Name is required"
+	}
+	if supplier.Email != "" && !strings.Contains(supplier.Email, "@") {
+		return "Invalid email"
+	}
+	return ""
+}
+
+// checkUnit fails with ErrUnknownUnit unless the product has the unit
+func checkUnit(ctx context.Context, tx Tx, productID int, unit string) error {
+	_, ok, err := tx.Factor(ctx, productID, unit)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w %q for product %d", ErrUnknownUnit, unit, productID)
+	}
+	return nil
+}
+
+// writePurchasingError answers for a failed supplier or purchase order
+// operation and reports whether err was nil
+func writePurchasingError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, ErrUnknownUnit), errors.Is(err, ErrUnknownProduct),
+		errors.Is(err, ErrUnknownSupplier), errors.Is(err, ErrNotOnOrder):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrOrderStatus), errors.Is(err, ErrVoided), errors.Is(err, ErrNothingToOrder),
+		errors.Is(err, ErrDraftExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+package supply
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/warehouse"
+)
+
+func level(t *testing.T, stock *warehouse.MemoryStore, productID int) warehouse.WarehouseItem {
+	t.Helper()
+	items, err := stock.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		if item.ProductID == productID {
+			return item
+		}
+	}
+	t.Fatalf("product %d is not in the warehouse", productID)
+	return warehouse.WarehouseItem{}
+}
+
+func TestCreateAveragesCost(t *testing.T) {
+	ctx := context.Background()
+	stock := warehouse.NewMemoryStore(warehouse.CostingAverage)
+	stock.AddProduct(1, "chicken")
+	s := NewService(NewMemoryStore(stock), nil, nil)
+
+	deliveries := []struct {
+		quantity float64
+		price    string
+		stock    float64
+		average  string
+	}{
+		{10, "100", 10, "100.00"},
+		{20, "110", 30, "106.666667"},
+		{40, "90", 70, "97.142857"},
+	}
+	for _, d := range deliveries {
+		err := s.create(ctx, &Supply{UserID: 1, Products: []SupplyProductRelation{
+			{ProductID: 1, Quantity: d.quantity, Price: money.MustParse(d.price)},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		item := level(t, stock, 1)
+		if item.CurrentStock != d.stock {
+			t.Errorf("after %v at %s: stock %v, want %v", d.quantity, d.price, item.CurrentStock, d.stock)
+		}
+		if got := item.AverageCost.String(); got != d.average {
+			t.Errorf("after %v at %s: average cost %s, want %s", d.quantity, d.price, got, d.average)
+		}
+	}
+}
+
+func TestCreateConvertsUnits(t *testing.T) {
+	ctx := context.Background()
+	stock := warehouse.NewMemoryStore(warehouse.CostingAverage)
+	stock.AddProduct(1, "chicken")
+	err := stock.SaveUnits(ctx, warehouse.ProductUnits{ProductID: 1, Unit: "g", Units: []warehouse.Unit{{Name: "kg", Factor: 1000}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(NewMemoryStore(stock), nil, nil)
+
+	err = s.create(ctx, &Supply{UserID: 1, Products: []SupplyProductRelation{
+		{ProductID: 1, Quantity: 5, Unit: "kg", Price: money.MustParse("450")},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := level(t, stock, 1)
+	if item.CurrentStock != 5000 || item.AverageCost.String() != "0.45" {
+		t.Errorf("got %v g at %s, want 5000 g at 0.45", item.CurrentStock, item.AverageCost)
+	}
+
+	err = s.create(ctx, &Supply{UserID: 1, Products: []SupplyProductRelation{
+		{ProductID: 1, Quantity: 1, Unit: "box", Price: money.MustParse("10")},
+	}})
+	if err == nil {
+		t.Fatal("a unit the product is not bought in was accepted")
+	}
+	if item := level(t, stock, 1); item.CurrentStock != 5000 {
+		t.Errorf("a failed supply left the stock at %v", item.CurrentStock)
+	}
+}
+
+func TestSuggestCountsOpenOrders(t *testing.T) {
+	ctx := context.Background()
+	stock := warehouse.NewMemoryStore(warehouse.CostingAverage)
+	stock.AddProduct(1, "lavash")
+	point, max := 20.0, 100.0
+	if err := stock.SetReorder(ctx, 1, warehouse.Reorder{ReorderPoint: &point, MaxStock: &max}); err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStore(stock)
+	s := NewService(store, nil, warehouse.NewService(stock, nil))
+	err := s.create(ctx, &Supply{UserID: 1, Products: []SupplyProductRelation{
+		{ProductID: 1, Quantity: 5, Price: money.MustParse("0.40")},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	supplier := Supplier{Name: "bakery"}
+	if err := store.SaveSupplier(ctx, &supplier); err != nil {
+		t.Fatal(err)
+	}
+
+	suggested := func() (quantity, onOrder float64) {
+		t.Helper()
+		groups, err := s.suggest(ctx, warehouse.ReorderOptions{Days: 14, LeadDays: 2, CoverDays: 7})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, group := range groups {
+			for _, line := range group.Lines {
+				if line.ProductID == 1 {
+					return line.Quantity, line.OnOrder
+				}
+			}
+		}
+		return 0, 0
+	}
+	if quantity, _ := suggested(); quantity != 95 {
+		t.Fatalf("suggested %v, want 95 to fill 5 up to 100", quantity)
+	}
+
+	po := PurchaseOrder{SupplierID: supplier.ID, UserID: 1, Status: OrderDraft, Lines: []PurchaseOrderLine{{ProductID: 1, Quantity: 10}}}
+	if err := s.savePurchaseOrder(ctx, &po); err != nil {
+		t.Fatal(err)
+	}
+	// A draft is not ordered yet
+	if quantity, _ := suggested(); quantity != 95 {
+		t.Errorf("with a draft order suggested %v, want 95", quantity)
+	}
+
+	if err := store.SetOrderStatus(ctx, po.ID, OrderSent, []OrderStatus{OrderDraft}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if quantity, onOrder := suggested(); quantity != 85 || onOrder != 10 {
+		t.Errorf("with 10 on order suggested %v (%v on order), want 85 (10)", quantity, onOrder)
+	}
+
+	// Once what is on its way lifts the stock above the reorder point
+	// nothing more is suggested
+	more := PurchaseOrder{SupplierID: supplier.ID, UserID: 1, Status: OrderDraft, Lines: []PurchaseOrderLine{{ProductID: 1, Quantity: 10}}}
+	if err := s.savePurchaseOrder(ctx, &more); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetOrderStatus(ctx, more.ID, OrderSent, []OrderStatus{OrderDraft}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if quantity, _ := suggested(); quantity != 0 {
+		t.Errorf("with 20 on order suggested %v, want nothing", quantity)
+	}
+}
+
+func TestVoidReplaysAverageCost(t *testing.T) {
+	ctx := context.Background()
+	stock := warehouse.NewMemoryStore(warehouse.CostingAverage)
+	stock.AddProduct(1, "chicken")
+	s := NewService(NewMemoryStore(stock), nil, nil)
+
+	var ids []int
+	for _, price := range []string{"100", "110"} {
+		supply := Supply{UserID: 1, Products: []SupplyProductRelation{{ProductID: 1, Quantity: 10, Price: money.MustParse(price)}}}
+		if err := s.create(ctx, &supply); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, supply.ID)
+	}
+	err := stock.Tx(func(tx warehouse.StockTx) error {
+		return tx.Take(ctx, map[int]float64{1: 5}, warehouse.Source{Type: warehouse.SourceOrder, ID: 1, UserID: 1})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item := level(t, stock, 1); item.AverageCost.String() != "105.00" {
+		t.Fatalf("average cost %s, want 105.00", item.AverageCost)
+	}
+
+	// The second delivery never happened: its stock goes and the average
+	// is worked out again as if it had never come
+	if err := s.void(ctx, ids[1], 1, "booked twice", nil); err != nil {
+		t.Fatal(err)
+	}
+	if item := level(t, stock, 1); item.CurrentStock != 5 || item.AverageCost.String() != "100.00" {
+		t.Errorf("after the void %v at %s, want 5 at 100.00", item.CurrentStock, item.AverageCost)
+	}
+	if err := s.void(ctx, ids[1], 1, "again", nil); !errors.Is(err, ErrVoided) {
+		t.Errorf("voiding twice: got %v, want %v", err, ErrVoided)
+	}
+
+	// The first was invoiced at 90 and is corrected in place
+	replacement := Supply{UserID: 1, Products: []SupplyProductRelation{{ProductID: 1, Quantity: 10, Price: money.MustParse("90")}}}
+	if err := s.void(ctx, ids[0], 1, "wrong price", &replacement); err != nil {
+		t.Fatal(err)
+	}
+	if item := level(t, stock, 1); item.CurrentStock != 5 || item.AverageCost.String() != "90.00" {
+		t.Errorf("after the correction %v at %s, want 5 at 90.00", item.CurrentStock, item.AverageCost)
+	}
+	if replacement.Corrects == nil || *replacement.Corrects != ids[0] {
+		t.Errorf("replacement corrects %v, want %d", replacement.Corrects, ids[0])
+	}
+}
@@ -1,34 +1,51 @@
 package users
 
 import (
-	"database/sql"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"time"
 
+	"randevu-shawarma-server/config"
+
 	"github.com/julienschmidt/httprouter"
 )
 
-var db *sql.DB
+type Service struct {
+	store   Store
+	config  config.Auth
+	jwtKey  []byte
+	revoked *revocations
+}
 
-// SetDatabase sets the database connection
-func SetDatabase(database *sql.DB) {
-	db = database
+func NewService(store Store, cfg config.Auth) *Service {
+	return &Service{store: store, config: cfg, jwtKey: []byte(cfg.JWTSecret), revoked: newRevocations()}
 }
 
 // RegisterRoutes registers all user routes
-func RegisterRoutes(router *httprouter.Router) {
-	router.POST("/users/login", Login)
+func (s *Service) RegisterRoutes(router *httprouter.Router) {
+	router.POST("/users/login", s.Login)
+	router.POST("/users/refresh", s.Refresh)
+	router.POST("/users/logout", s.Authenticate(s.Logout))
+	router.POST("/users/logout-all", s.Authenticate(s.LogoutAll))
+	router.POST("/users/pin-login", s.PINLogin)
+
+	router.GET("/users", s.Authenticate(s.GetUsers))
+	router.GET("/users/:id", s.Authorize(PermUsersRead)(s.GetUser))
+	router.POST("/users", s.Authorize(PermUsersManage)(s.CreateUser))
+	router.PUT("/users/:id", s.Authorize(PermUsersManage)(s.UpdateUser))
+	router.DELETE("/users/:id", s.Authorize(PermUsersManage)(s.DeleteUser))
+	router.PUT("/users/:id/role", s.Authorize(PermRolesAssign)(s.AssignRole))
+	router.DELETE("/users/:id/sessions", s.Authorize(PermUsersManage)(s.RevokeSessions))
+	router.PUT("/users/:id/pin", s.Authenticate(s.SetPIN))
 
-	router.GET("/users", Authenticate(GetUsers))
-	router.GET("/users/:id", Authenticate(GetUser))
-	router.POST("/users", Authenticate(CreateUser))
-	router.PUT("/users/:id", Authenticate(UpdateUser))
-	router.DELETE("/users/:id", Authenticate(DeleteUser))
+	router.GET("/terminals", s.Authorize(PermTerminalsManage)(s.GetTerminals))
+	router.POST("/terminals", s.Authorize(PermTerminalsManage)(s.RegisterTerminal))
+	router.DELETE("/terminals/:id", s.Authorize(PermTerminalsManage)(s.RevokeTerminal))
 
 }
 
-func Login(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+func (s *Service) Login(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	var credentials struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
@@ -39,67 +56,57 @@ func Login(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		return
 	}
 
-	u := UserView{}
-	var hashedPassword string
-	err = db.QueryRow("SELECT id, name, email, password FROM public.\"Users\" WHERE email = $1", credentials.Email).Scan(&u.ID, &u.Name, &u.Email, &hashedPassword)
-	if err == sql.ErrNoRows || !checkPasswordHash(credentials.Password, hashedPassword) {
+	user, err := s.store.ByEmail(r.Context(), credentials.Email)
+	if err == ErrNotFound || (err == nil && !checkPasswordHash(credentials.Password, user.Password)) {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	token, err := generateJWT(credentials.Email)
+	err = s.startSession(w, r, user, nil)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    token,
-		Expires:  time.Now().Add(24 * time.Hour),
-		HttpOnly: false,
-		Secure:   true,
-		Path:     "/",
-		SameSite: http.SameSiteNoneMode,
-		//Domain:   "localhost",
-	})
-
-	json.NewEncoder(w).Encode(u)
+	json.NewEncoder(w).Encode(NewUserView(user))
 }
 
-func GetUsers(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+func (s *Service) GetUsers(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	email := r.Context().Value("email").(string)
 
-	row := db.QueryRow("SELECT id, name, email FROM public.\"Users\" WHERE email = $1", email)
-	var u UserView
-	err := row.Scan(&u.ID, &u.Name, &u.Email)
-	if err == sql.ErrNoRows {
+	u, err := s.store.ByEmail(r.Context(), email)
+	if err == ErrNotFound {
 		http.NotFound(w, r)
 		return
 	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	json.NewEncoder(w).Encode(u)
+	json.NewEncoder(w).Encode(NewUserView(u))
 }
 
-func GetUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	id := ps.ByName("id")
-	row := db.QueryRow("SELECT id, name, email FROM public.\"Users\" WHERE id = $1", id)
+func (s *Service) GetUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
 
-	var u UserView
-	err := row.Scan(&u.ID, &u.Name, &u.Email)
-	if err == sql.ErrNoRows {
+	u, err := s.store.ByID(r.Context(), id)
+	if err == ErrNotFound {
 		http.NotFound(w, r)
 		return
 	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	json.NewEncoder(w).Encode(u)
+	json.NewEncoder(w).Encode(NewUserView(u))
 }
 
-func CreateUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+func (s *Service) CreateUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	var u User
 
 	// Check Content-Type header
@@ -122,7 +129,20 @@ func CreateUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		return
 	}
 
-	hashedPassword, err := hashPassword(u.Password)
+	// New accounts are cashiers unless a role is given; only owners create owners
+	if u.Role == "" {
+		u.Role = RoleCashier
+	} else if !u.Role.Valid() {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+	claims, _ := ClaimsFromContext(r.Context())
+	if !canAssign(claims, u.Role) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	hashedPassword, err := s.hashPassword(u.Password)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -130,46 +150,164 @@ func CreateUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	u.Password = hashedPassword
 	u.CreatedAt = time.Now()
 
-	err = db.QueryRow("INSERT INTO public.\"Users\" (name, email, password, created_at) VALUES ($1, $2, $3, $4) RETURNING id",
-		u.Name, u.Email, u.Password, u.CreatedAt).Scan(&u.ID)
+	err = s.store.Create(r.Context(), &u)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	json.NewEncoder(w).Encode(u)
+	json.NewEncoder(w).Encode(NewUserView(u))
 }
 
-func UpdateUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	id := ps.ByName("id")
+func (s *Service) UpdateUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	if !s.canManage(w, r, id) {
+		return
+	}
+
 	var u User
-	err := json.NewDecoder(r.Body).Decode(&u)
+	err = json.NewDecoder(r.Body).Decode(&u)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	hashedPassword, err := hashPassword(u.Password)
+	hashedPassword, err := s.hashPassword(u.Password)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	u.Password = hashedPassword
 
-	_, err = db.Exec("UPDATE public.\"Users\" SET name = $1, email = $2, password = $3 WHERE id = $4", u.Name, u.Email, u.Password, id)
+	err = s.store.Update(r.Context(), id, u)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// A new password signs the account out of the devices using the old one
+	err = s.store.RevokeUserSessions(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.revoked.publish()
+
+	updated, err := s.store.ByID(r.Context(), id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	json.NewEncoder(w).Encode(u)
+	json.NewEncoder(w).Encode(NewUserView(updated))
 }
 
-func DeleteUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	id := ps.ByName("id")
+func (s *Service) DeleteUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	if !s.canManage(w, r, id) {
+		return
+	}
 
-	_, err := db.Exec("DELETE FROM public.\"Users\" WHERE id = $1", id)
+	err = s.store.Delete(r.Context(), id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (s *Service) AssignRole(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Role Role `json:"role"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !body.Role.Valid() {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	u, err := s.store.ByID(r.Context(), id)
+	if err == ErrNotFound {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	claims, _ := ClaimsFromContext(r.Context())
+	if !canAssign(claims, body.Role) || !canAssign(claims, u.Role) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// The shop must always keep someone who can hand out roles
+	if u.Role == RoleOwner && body.Role != RoleOwner {
+		owners, err := s.store.CountByRole(r.Context(), RoleOwner)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if owners <= 1 {
+			http.Error(w, "Cannot remove the last owner", http.StatusConflict)
+			return
+		}
+	}
+
+	err = s.store.SetRole(r.Context(), id, body.Role)
+	if err == ErrNotFound {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Tokens carry the permissions of the old role until they expire, so
+	// the user has to sign in again under the new one
+	err = s.store.RevokeUserSessions(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.revoked.publish()
+
+	u.Role = body.Role
+	json.NewEncoder(w).Encode(NewUserView(u))
+}
+
+// canManage answers 403 or 404 and returns false when the caller may not
+// change the account; only owners change owners
+func (s *Service) canManage(w http.ResponseWriter, r *http.Request, id int) bool {
+	target, err := s.store.ByID(r.Context(), id)
+	if err == ErrNotFound {
+		http.NotFound(w, r)
+		return false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	claims, _ := ClaimsFromContext(r.Context())
+	if target.Role == RoleOwner && (claims == nil || claims.Role != RoleOwner) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -49,8 +49,15 @@ func isValidPassword(password string) bool {
 }
 
 // HashPassword hashes the password using bcrypt
-func hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+func (s *Service) hashPassword(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), s.config.BcryptCost)
+	return string(bytes), err
+}
+
+// hashPIN hashes a PIN at the cheaper PIN cost; the lockout, not bcrypt,
+// is what stops a four digit PIN from being guessed
+func (s *Service) hashPIN(pin string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(pin), s.config.PINBcryptCost)
 	return string(bytes), err
 }
 
@@ -60,57 +67,85 @@ func checkPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
-var jwtKey = []byte("my_secret_key")
-
-func generateJWT(email string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+func (s *Service) generateJWT(user User, sessionID int64) (string, error) {
+	expirationTime := time.Now().Add(s.config.TokenTTL)
 	claims := &Claims{
-		Email: email,
+		SessionID:   sessionID,
+		UserID:      user.ID,
+		Email:       user.Email,
+		Role:        user.Role,
+		Permissions: user.Role.Permissions(),
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expirationTime.Unix(),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	tokenString, err := token.SignedString(s.jwtKey)
 	if err != nil {
 		return "", err
 	}
 	return tokenString, nil
 }
 
-func Authenticate(next httprouter.Handle) httprouter.Handle {
+func (s *Service) Authenticate(next httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		cookie, err := r.Cookie("token")
+		cookie, err := r.Cookie(accessCookie)
 		if err != nil {
-			if err == http.ErrNoCookie {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		tokenStr := cookie.Value
+		// Expired, malformed and forged tokens all just mean the caller has
+		// to sign in again
 		claims := &Claims{}
-
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
+		token, err := jwt.ParseWithClaims(cookie.Value, claims, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, jwt.ErrSignatureInvalid
+			}
+			return s.jwtKey, nil
 		})
+		if err != nil || !token.Valid {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// A valid signature is not enough once the session was revoked
+		active, err := s.store.SessionActive(r.Context(), claims.SessionID)
 		if err != nil {
-			if err == jwt.ErrSignatureInvalid {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		if !token.Valid {
+		if !active {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		r = r.WithContext(context.WithValue(r.Context(), "email", claims.Email))
-		next(w, r, ps)
+		ctx := context.WithValue(r.Context(), "email", claims.Email)
+		ctx = context.WithValue(ctx, claimsKey{}, claims)
+		next(w, r.WithContext(ctx), ps)
+	}
+}
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims of the authenticated request
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
+
+// Authorize authenticates the request and answers 403 unless the token
+// grants the permission
+func (s *Service) Authorize(permission Permission) func(httprouter.Handle) httprouter.Handle {
+	return func(next httprouter.Handle) httprouter.Handle {
+		return s.Authenticate(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok || !claims.Can(permission) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next(w, r, ps)
+		})
 	}
 }
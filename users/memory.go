@@ -0,0 +1,280 @@
+package users
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type MemoryStore struct {
+	mu            sync.Mutex
+	users         map[int]User
+	nextID        int
+	sessions      map[int64]Session
+	nextSessionID int64
+	terminals     map[int]Terminal
+	pins          map[int]PINState
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:         map[int]User{},
+		nextID:        1,
+		sessions:      map[int64]Session{},
+		nextSessionID: 1,
+		terminals:     map[int]Terminal{},
+		pins:          map[int]PINState{},
+	}
+}
+
+func (m *MemoryStore) ByEmail(ctx context.Context, email string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (m *MemoryStore) ByID(ctx context.Context, id int) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u, nil
+}
+
+func (m *MemoryStore) Create(ctx context.Context, u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u.ID = m.nextID
+	m.nextID++
+	m.users[u.ID] = *u
+	return nil
+}
+
+func (m *MemoryStore) Update(ctx context.Context, id int, u User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.users[id]
+	if !ok {
+		return nil
+	}
+	existing.Name = u.Name
+	existing.Email = u.Email
+	existing.Password = u.Password
+	m.users[id] = existing
+	return nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.users, id)
+	for sid, session := range m.sessions {
+		if session.UserID == id {
+			delete(m.sessions, sid)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) SetRole(ctx context.Context, id int, role Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	u.Role = role
+	m.users[id] = u
+	return nil
+}
+
+func (m *MemoryStore) CountByRole(ctx context.Context, role Role) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, u := range m.users {
+		if u.Role == role {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) CreateSession(ctx context.Context, session *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session.ID = m.nextSessionID
+	m.nextSessionID++
+	session.LastUsedAt = session.CreatedAt
+	m.sessions[session.ID] = *session
+	return nil
+}
+
+func (m *MemoryStore) SessionByRefreshHash(ctx context.Context, hash string) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, session := range m.sessions {
+		if session.RefreshTokenHash == hash {
+			return session, nil
+		}
+	}
+	return Session{}, ErrSessionNotFound
+}
+
+func (m *MemoryStore) SessionByPreviousHash(ctx context.Context, hash string) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, session := range m.sessions {
+		if session.PreviousTokenHash != "" && session.PreviousTokenHash == hash {
+			return session, nil
+		}
+	}
+	return Session{}, ErrSessionNotFound
+}
+
+func (m *MemoryStore) RotateSession(ctx context.Context, id int64, oldHash, newHash string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok || session.RevokedAt != nil || session.RefreshTokenHash != oldHash {
+		return ErrSessionNotFound
+	}
+	session.PreviousTokenHash = oldHash
+	session.RefreshTokenHash = newHash
+	session.LastUsedAt = time.Now()
+	session.ExpiresAt = expiresAt
+	m.sessions[id] = session
+	return nil
+}
+
+func (m *MemoryStore) SessionActive(ctx context.Context, id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	return ok && session.RevokedAt == nil && session.ExpiresAt.After(time.Now()), nil
+}
+
+func (m *MemoryStore) RevokeSession(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if session, ok := m.sessions[id]; ok && session.RevokedAt == nil {
+		now := time.Now()
+		session.RevokedAt = &now
+		m.sessions[id] = session
+	}
+	return nil
+}
+
+func (m *MemoryStore) RevokeUserSessions(ctx context.Context, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for id, session := range m.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			session.RevokedAt = &now
+			m.sessions[id] = session
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateTerminal(ctx context.Context, terminal *Terminal) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	terminal.ID = len(m.terminals) + 1
+	m.terminals[terminal.ID] = *terminal
+	return nil
+}
+
+func (m *MemoryStore) TerminalByTokenHash(ctx context.Context, hash string) (Terminal, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.terminals {
+		if t.TokenHash == hash {
+			return t, nil
+		}
+	}
+	return Terminal{}, ErrTerminalNotFound
+}
+
+func (m *MemoryStore) ListTerminals(ctx context.Context) ([]Terminal, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var terminals []Terminal
+	for _, t := range m.terminals {
+		terminals = append(terminals, t)
+	}
+	sort.Slice(terminals, func(i, j int) bool { return terminals[i].ID < terminals[j].ID })
+	return terminals, nil
+}
+
+func (m *MemoryStore) RevokeTerminal(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.terminals[id]
+	if !ok {
+		return ErrTerminalNotFound
+	}
+	now := time.Now()
+	if t.RevokedAt == nil {
+		t.RevokedAt = &now
+		m.terminals[id] = t
+	}
+	for sid, session := range m.sessions {
+		if session.TerminalID != nil && *session.TerminalID == id && session.RevokedAt == nil {
+			session.RevokedAt = &now
+			m.sessions[sid] = session
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) SetPIN(ctx context.Context, userID int, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[userID]; !ok {
+		return ErrNotFound
+	}
+	m.pins[userID] = PINState{Hash: hash}
+	return nil
+}
+
+func (m *MemoryStore) PINState(ctx context.Context, userID int) (PINState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[userID]; !ok {
+		return PINState{}, ErrNotFound
+	}
+	return m.pins[userID], nil
+}
+
+func (m *MemoryStore) RecordPINFailure(ctx context.Context, userID int, maxAttempts int, lockFor time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state := m.pins[userID]
+	state.FailedAttempts++
+	if state.FailedAttempts >= maxAttempts {
+		until := time.Now().Add(lockFor)
+		state.LockedUntil = &until
+	}
+	m.pins[userID] = state
+	return nil
+}
+
+func (m *MemoryStore) ResetPINFailures(ctx context.Context, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state := m.pins[userID]
+	state.FailedAttempts = 0
+	state.LockedUntil = nil
+	m.pins[userID] = state
+	return nil
+}
@@ -11,6 +11,7 @@ type User struct {
 	Name      string    `json:"name"`
 	Email     string    `json:"email"`
 	Password  string    `json:"password"`
+	Role      Role      `json:"role"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
@@ -18,18 +19,63 @@ type UserView struct {
 	ID    int    `json:"id"`
 	Name  string `json:"name"`
 	Email string `json:"email"`
+	Role  Role   `json:"role"`
+}
+
+type Session struct {
+	ID                int64      `json:"id"`
+	UserID            int        `json:"userId"`
+	RefreshTokenHash  string     `json:"-"`
+	PreviousTokenHash string     `json:"-"`
+	UserAgent         string     `json:"userAgent"`
+	TerminalID        *int       `json:"terminalId"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	LastUsedAt        time.Time  `json:"lastUsedAt"`
+	ExpiresAt         time.Time  `json:"expiresAt"`
+	RevokedAt         *time.Time `json:"revokedAt"`
+}
+
+// Terminal is a shared device registered for PIN login
+type Terminal struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	TokenHash string     `json:"-"`
+	CreatedBy int        `json:"createdBy"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+}
+
+type PINState struct {
+	Hash           string
+	FailedAttempts int
+	LockedUntil    *time.Time
 }
 
 type Claims struct {
-	Email string `json:"email"`
+	SessionID   int64        `json:"sid"`
+	UserID      int          `json:"userId"`
+	Email       string       `json:"email"`
+	Role        Role         `json:"role"`
+	Permissions []Permission `json:"permissions"`
 	jwt.StandardClaims
 }
 
+// Can reports whether the token grants the permission
+func (c *Claims) Can(permission Permission) bool {
+	for _, p := range c.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
 // Constructor
 func NewUserView(user User) UserView {
 	return UserView{
 		ID:    user.ID,
 		Name:  user.Name,
 		Email: user.Email,
+		Role:  user.Role,
 	}
 }
@@ -0,0 +1,264 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) ByEmail(ctx context.Context, email string) (User, error) {
+	var u User
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, name, email, password, role, created_at FROM public.\"Users\" WHERE email = $1", email,
+	).Scan(&u.ID, &u.Name, &u.Email, &u.Password, &u.Role, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return u, ErrNotFound
+	}
+	return u, err
+}
+
+func (s *PostgresStore) ByID(ctx context.Context, id int) (User, error) {
+	var u User
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, name, email, password, role, created_at FROM public.\"Users\" WHERE id = $1", id,
+	).Scan(&u.ID, &u.Name, &u.Email, &u.Password, &u.Role, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return u, ErrNotFound
+	}
+	return u, err
+}
+
+func (s *PostgresStore) Create(ctx context.Context, u *User) error {
+	return s.db.QueryRowContext(ctx,
+		"INSERT INTO public.\"Users\" (name, email, password, role, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		u.Name, u.Email, u.Password, u.Role, u.CreatedAt,
+	).Scan(&u.ID)
+}
+
+func (s *PostgresStore) Update(ctx context.Context, id int, u User) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE public.\"Users\" SET name = $1, email = $2, password = $3 WHERE id = $4",
+		u.Name, u.Email, u.Password, id,
+	)
+	return err
+}
+
+func (s *PostgresStore) Delete(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM public.\"Users\" WHERE id = $1", id)
+	return err
+}
+
+func (s *PostgresStore) SetRole(ctx context.Context, id int, role Role) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE public.\"Users\" SET role = $1 WHERE id = $2", role, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) CountByRole(ctx context.Context, role Role) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM public.\"Users\" WHERE role = $1", role).Scan(&count)
+	return count, err
+}
+
+func (s *PostgresStore) CreateSession(ctx context.Context, session *Session) error {
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO public."Sessions" (user_id, refresh_token_hash, user_agent, terminal_id, created_at, last_used_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $5, $6) RETURNING id`,
+		session.UserID, session.RefreshTokenHash, session.UserAgent, session.TerminalID, session.CreatedAt, session.ExpiresAt,
+	).Scan(&session.ID)
+}
+
+func (s *PostgresStore) sessionBy(ctx context.Context, column, hash string) (Session, error) {
+	var session Session
+	var previous sql.NullString
+	var terminalID sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, user_id, refresh_token_hash, previous_token_hash, user_agent, terminal_id, created_at, last_used_at, expires_at, revoked_at
+		FROM public."Sessions" WHERE `+column+` = $1`, hash,
+	).Scan(&session.ID, &session.UserID, &session.RefreshTokenHash, &previous, &session.UserAgent, &terminalID,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &session.RevokedAt)
+	if err == sql.ErrNoRows {
+		return session, ErrSessionNotFound
+	}
+	session.PreviousTokenHash = previous.String
+	if terminalID.Valid {
+		id := int(terminalID.Int64)
+		session.TerminalID = &id
+	}
+	return session, err
+}
+
+func (s *PostgresStore) SessionByRefreshHash(ctx context.Context, hash string) (Session, error) {
+	return s.sessionBy(ctx, "refresh_token_hash", hash)
+}
+
+func (s *PostgresStore) SessionByPreviousHash(ctx context.Context, hash string) (Session, error) {
+	return s.sessionBy(ctx, "previous_token_hash", hash)
+}
+
+func (s *PostgresStore) RotateSession(ctx context.Context, id int64, oldHash, newHash string, expiresAt time.Time) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE public."Sessions"
+		SET refresh_token_hash = $1, previous_token_hash = $2, last_used_at = now(), expires_at = $3
+		WHERE id = $4 AND refresh_token_hash = $2 AND revoked_at IS NULL`,
+		newHash, oldHash, expiresAt, id,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) SessionActive(ctx context.Context, id int64) (bool, error) {
+	var active bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM public."Sessions"
+			WHERE id = $1 AND revoked_at IS NULL AND expires_at > now()
+		)`, id,
+	).Scan(&active)
+	return active, err
+}
+
+func (s *PostgresStore) RevokeSession(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE public.\"Sessions\" SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL", id)
+	return err
+}
+
+func (s *PostgresStore) RevokeUserSessions(ctx context.Context, userID int) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE public.\"Sessions\" SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	return err
+}
+
+func (s *PostgresStore) CreateTerminal(ctx context.Context, terminal *Terminal) error {
+	return s.db.QueryRowContext(ctx,
+		"INSERT INTO public.\"Terminals\" (name, token_hash, created_by, created_at) VALUES ($1, $2, $3, $4) RETURNING id",
+		terminal.Name, terminal.TokenHash, terminal.CreatedBy, terminal.CreatedAt,
+	).Scan(&terminal.ID)
+}
+
+func (s *PostgresStore) TerminalByTokenHash(ctx context.Context, hash string) (Terminal, error) {
+	var t Terminal
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, name, token_hash, created_by, created_at, revoked_at FROM public.\"Terminals\" WHERE token_hash = $1", hash,
+	).Scan(&t.ID, &t.Name, &t.TokenHash, &t.CreatedBy, &t.CreatedAt, &t.RevokedAt)
+	if err == sql.ErrNoRows {
+		return t, ErrTerminalNotFound
+	}
+	return t, err
+}
+
+func (s *PostgresStore) ListTerminals(ctx context.Context) ([]Terminal, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, name, token_hash, created_by, created_at, revoked_at FROM public.\"Terminals\" ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var terminals []Terminal
+	for rows.Next() {
+		var t Terminal
+		if err := rows.Scan(&t.ID, &t.Name, &t.TokenHash, &t.CreatedBy, &t.CreatedAt, &t.RevokedAt); err != nil {
+			return nil, err
+		}
+		terminals = append(terminals, t)
+	}
+	return terminals, rows.Err()
+}
+
+func (s *PostgresStore) RevokeTerminal(ctx context.Context, id int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE public.\"Terminals\" SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrTerminalNotFound
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE public.\"Sessions\" SET revoked_at = now() WHERE terminal_id = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) SetPIN(ctx context.Context, userID int, hash string) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE public."Users" SET pin_hash = $1, pin_failed_attempts = 0, pin_locked_until = NULL
+		WHERE id = $2`, hash, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) PINState(ctx context.Context, userID int) (PINState, error) {
+	var state PINState
+	var hash sql.NullString
+	err := s.db.QueryRowContext(ctx,
+		"SELECT pin_hash, pin_failed_attempts, pin_locked_until FROM public.\"Users\" WHERE id = $1", userID,
+	).Scan(&hash, &state.FailedAttempts, &state.LockedUntil)
+	if err == sql.ErrNoRows {
+		return state, ErrNotFound
+	}
+	state.Hash = hash.String
+	return state, err
+}
+
+func (s *PostgresStore) RecordPINFailure(ctx context.Context, userID int, maxAttempts int, lockFor time.Duration) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE public."Users"
+		SET pin_failed_attempts = pin_failed_attempts + 1,
+			pin_locked_until = CASE
+				WHEN pin_failed_attempts + 1 >= $1 THEN now() + make_interval(secs => $2)
+				ELSE pin_locked_until
+			END
+		WHERE id = $3`,
+		maxAttempts, lockFor.Seconds(), userID,
+	)
+	return err
+}
+
+func (s *PostgresStore) ResetPINFailures(ctx context.Context, userID int) error {
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE public.\"Users\" SET pin_failed_attempts = 0, pin_locked_until = NULL WHERE id = $1", userID)
+	return err
+}
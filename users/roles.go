@@ -0,0 +1,93 @@
+package users
+
+type Role string
+
+const (
+	RoleOwner       Role = "owner"
+	RoleManager     Role = "manager"
+	RoleCashier     Role = "cashier"
+	RoleCook        Role = "cook"
+	RoleStorekeeper Role = "storekeeper"
+)
+
+type Permission string
+
+const (
+	PermUsersRead   Permission = "users:read"
+	PermUsersManage Permission = "users:manage"
+	PermRolesAssign Permission = "roles:assign"
+
+	PermTerminalsManage Permission = "terminals:manage"
+
+	PermOrdersRead   Permission = "orders:read"
+	PermOrdersCreate Permission = "orders:create"
+	PermOrdersUpdate Permission = "orders:update"
+	PermOrdersCancel Permission = "orders:cancel"
+	PermOrdersRefund Permission = "orders:refund"
+	PermOrdersPay    Permission = "orders:pay"
+
+	PermShiftsOperate Permission = "shifts:operate"
+	PermShiftsManage  Permission = "shifts:manage"
+
+	PermDishesRead     Permission = "dishes:read"
+	PermMenuManage     Permission = "menu:manage"
+	PermCostsRead      Permission = "costs:read"
+	PermWarehouseRead  Permission = "warehouse:read"
+	PermSupplyCreate   Permission = "supply:create"
+	PermWriteOffCreate Permission = "writeoff:create"
+	PermProductionRun  Permission = "production:run"
+	PermProductsManage Permission = "products:manage"
+	PermStocktakeCount Permission = "stocktake:count"
+	PermStocktakePost  Permission = "stocktake:post"
+	PermPurchasing     Permission = "purchasing:manage"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleOwner: {
+		PermUsersRead, PermUsersManage, PermRolesAssign, PermTerminalsManage,
+		PermOrdersRead, PermOrdersCreate, PermOrdersUpdate, PermOrdersCancel, PermOrdersRefund, PermOrdersPay,
+		PermShiftsOperate, PermShiftsManage,
+		PermDishesRead, PermMenuManage, PermCostsRead, PermWarehouseRead, PermSupplyCreate, PermWriteOffCreate, PermProductionRun,
+		PermProductsManage, PermStocktakeCount, PermStocktakePost, PermPurchasing,
+	},
+	RoleManager: {
+		PermUsersRead, PermUsersManage, PermRolesAssign, PermTerminalsManage,
+		PermOrdersRead, PermOrdersCreate, PermOrdersUpdate, PermOrdersCancel, PermOrdersRefund, PermOrdersPay,
+		PermShiftsOperate, PermShiftsManage,
+		PermDishesRead, PermMenuManage, PermCostsRead, PermWarehouseRead, PermSupplyCreate, PermWriteOffCreate, PermProductionRun,
+		PermProductsManage, PermStocktakeCount, PermStocktakePost, PermPurchasing,
+	},
+	RoleCashier: {
+		PermOrdersRead, PermOrdersCreate, PermOrdersUpdate, PermOrdersCancel, PermOrdersPay,
+		PermShiftsOperate,
+		PermDishesRead,
+	},
+	RoleCook: {
+		PermOrdersRead, PermOrdersUpdate,
+		PermDishesRead, PermWarehouseRead, PermProductionRun, PermStocktakeCount,
+	},
+	RoleStorekeeper: {
+		PermDishesRead, PermWarehouseRead, PermSupplyCreate, PermWriteOffCreate, PermProductionRun,
+		PermProductsManage, PermStocktakeCount, PermPurchasing,
+	},
+}
+
+// canAssign reports whether the caller may grant or take away role;
+// only owners manage owners
+func canAssign(claims *Claims, role Role) bool {
+	if claims == nil || !claims.Can(PermRolesAssign) {
+		return false
+	}
+	return role != RoleOwner || claims.Role == RoleOwner
+}
+
+// Valid reports whether r is one of the known roles
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Permissions returns what the role is allowed to do
+func (r Role) Permissions() []Permission {
+	return append([]Permission(nil), rolePermissions[r]...)
+}
@@ -0,0 +1,288 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	accessCookie  = "token"
+	refreshCookie = "refresh_token"
+	// the refresh token is only sent to the endpoints that consume it
+	refreshCookiePath = "/users"
+)
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// startSession records a new session for the user, optionally bound to a
+// terminal, and sets its cookies
+func (s *Service) startSession(w http.ResponseWriter, r *http.Request, user User, terminalID *int) error {
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	session := Session{
+		UserID:           user.ID,
+		RefreshTokenHash: hashToken(refreshToken),
+		UserAgent:        r.UserAgent(),
+		TerminalID:       terminalID,
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(s.config.RefreshTTL),
+	}
+	if err := s.store.CreateSession(r.Context(), &session); err != nil {
+		return err
+	}
+
+	return s.setSessionCookies(w, user, session.ID, refreshToken, session.ExpiresAt)
+}
+
+func (s *Service) setSessionCookies(w http.ResponseWriter, user User, sessionID int64, refreshToken string, refreshExpires time.Time) error {
+	token, err := s.generateJWT(user, sessionID)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessCookie,
+		Value:    token,
+		Expires:  time.Now().Add(s.config.TokenTTL),
+		HttpOnly: true,
+		Secure:   true,
+		Path:     "/",
+		SameSite: http.SameSiteNoneMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Value:    refreshToken,
+		Expires:  refreshExpires,
+		HttpOnly: true,
+		Secure:   true,
+		Path:     refreshCookiePath,
+		SameSite: http.SameSiteNoneMode,
+	})
+	return nil
+}
+
+func clearSessionCookies(w http.ResponseWriter) {
+	for _, c := range []struct{ name, path string }{
+		{accessCookie, "/"},
+		{refreshCookie, refreshCookiePath},
+	} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     c.name,
+			Value:    "",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   true,
+			Path:     c.path,
+			SameSite: http.SameSiteNoneMode,
+		})
+	}
+}
+
+// Refresh trades a refresh token for a new access token and a new refresh
+// token. Presenting a refresh token that was already rotated out means it
+// leaked, so the whole session is revoked.
+func (s *Service) Refresh(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	cookie, err := r.Cookie(refreshCookie)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	oldHash := hashToken(cookie.Value)
+
+	session, err := s.store.SessionByRefreshHash(r.Context(), oldHash)
+	if err == ErrSessionNotFound {
+		if reused, err := s.store.SessionByPreviousHash(r.Context(), oldHash); err == nil {
+			if s.store.RevokeSession(r.Context(), reused.ID) == nil {
+				s.revoked.publish()
+			}
+		}
+		clearSessionCookies(w)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if session.RevokedAt != nil || !session.ExpiresAt.After(time.Now()) {
+		clearSessionCookies(w)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Load the user again so role changes apply from the next token on
+	user, err := s.store.ByID(r.Context(), session.UserID)
+	if err == ErrNotFound {
+		clearSessionCookies(w)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	expiresAt := time.Now().Add(s.config.RefreshTTL)
+
+	err = s.store.RotateSession(r.Context(), session.ID, oldHash, hashToken(refreshToken), expiresAt)
+	if err == ErrSessionNotFound {
+		// a concurrent refresh won the race
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.setSessionCookies(w, user, session.ID, refreshToken, expiresAt); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(NewUserView(user))
+}
+
+// Logout revokes the session of the current token
+func (s *Service) Logout(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	claims, _ := ClaimsFromContext(r.Context())
+
+	err := s.store.RevokeSession(r.Context(), claims.SessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.revoked.publish()
+	clearSessionCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAll revokes every session of the current user, on all devices
+func (s *Service) LogoutAll(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	claims, _ := ClaimsFromContext(r.Context())
+
+	err := s.store.RevokeUserSessions(r.Context(), claims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.revoked.publish()
+	clearSessionCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeSessions signs another user out everywhere, e.g. when they leave
+func (s *Service) RevokeSessions(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+	if !s.canManage(w, r, id) {
+		return
+	}
+
+	err = s.store.RevokeUserSessions(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.revoked.publish()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revocations wakes the watchers of WatchSession whenever sessions are
+// revoked, so they can check whether theirs was one of them
+type revocations struct {
+	mu       sync.Mutex
+	watchers map[chan struct{}]struct{}
+}
+
+func newRevocations() *revocations {
+	return &revocations{watchers: map[chan struct{}]struct{}{}}
+}
+
+func (rv *revocations) publish() {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+
+	for ch := range rv.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// already woken and yet to check
+		}
+	}
+}
+
+func (rv *revocations) subscribe() chan struct{} {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	rv.watchers[ch] = struct{}{}
+	return ch
+}
+
+func (rv *revocations) unsubscribe(ch chan struct{}) {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+
+	delete(rv.watchers, ch)
+}
+
+// WatchSession returns a channel that is closed once the access token the
+// claims came from expires or its session is revoked. Long-lived responses
+// such as event streams close on it, as a new request would be refused.
+// Watching stops when ctx is done.
+func (s *Service) WatchSession(ctx context.Context, claims *Claims) <-chan struct{} {
+	ended := make(chan struct{})
+	wake := s.revoked.subscribe()
+	go func() {
+		defer close(ended)
+		defer s.revoked.unsubscribe(wake)
+
+		expiry := time.NewTimer(time.Until(time.Unix(claims.ExpiresAt, 0)))
+		defer expiry.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-expiry.C:
+				return
+			case <-wake:
+				active, err := s.store.SessionActive(ctx, claims.SessionID)
+				if err == nil && !active {
+					return
+				}
+			}
+		}
+	}()
+	return ended
+}
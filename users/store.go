@@ -0,0 +1,57 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotFound         = errors.New("user not found")
+	ErrSessionNotFound  = errors.New("session not found")
+	ErrTerminalNotFound = errors.New("terminal not found")
+)
+
+// Store persists staff accounts
+type Store interface {
+	// ByEmail returns the user including the password hash
+	ByEmail(ctx context.Context, email string) (User, error)
+	ByID(ctx context.Context, id int) (User, error)
+	Create(ctx context.Context, u *User) error
+	Update(ctx context.Context, id int, u User) error
+	Delete(ctx context.Context, id int) error
+	SetRole(ctx context.Context, id int, role Role) error
+	CountByRole(ctx context.Context, role Role) (int, error)
+
+	CreateSession(ctx context.Context, session *Session) error
+	// SessionByRefreshHash finds the session whose current refresh token has the hash
+	SessionByRefreshHash(ctx context.Context, hash string) (Session, error)
+	// SessionByPreviousHash finds the session whose rotated-out refresh token has the hash
+	SessionByPreviousHash(ctx context.Context, hash string) (Session, error)
+	// RotateSession replaces the refresh token of an unrevoked session,
+	// failing with ErrSessionNotFound if oldHash is no longer current
+	RotateSession(ctx context.Context, id int64, oldHash, newHash string, expiresAt time.Time) error
+	// SessionActive reports whether the session exists, is not revoked and has not expired
+	SessionActive(ctx context.Context, id int64) (bool, error)
+	RevokeSession(ctx context.Context, id int64) error
+	RevokeUserSessions(ctx context.Context, userID int) error
+
+	CreateTerminal(ctx context.Context, terminal *Terminal) error
+	TerminalByTokenHash(ctx context.Context, hash string) (Terminal, error)
+	ListTerminals(ctx context.Context) ([]Terminal, error)
+	// RevokeTerminal also revokes the sessions opened on the terminal
+	RevokeTerminal(ctx context.Context, id int) error
+
+	// SetPIN stores a new PIN hash and clears any lockout
+	SetPIN(ctx context.Context, userID int, hash string) error
+	PINState(ctx context.Context, userID int) (PINState, error)
+	// RecordPINFailure counts a wrong PIN and locks PIN login until
+	// now+lockFor once maxAttempts failures have accumulated
+	RecordPINFailure(ctx context.Context, userID int, maxAttempts int, lockFor time.Duration) error
+	ResetPINFailures(ctx context.Context, userID int) error
+}
+
+var (
+	_ Store = (*PostgresStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
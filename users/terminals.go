@@ -0,0 +1,230 @@
+package users
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	terminalHeader = "X-Terminal-Token"
+	terminalCookie = "terminal_token"
+)
+
+var pinPattern = regexp.MustCompile(`^[0-9]{4,6}$`)
+
+// RegisterTerminal registers the shared device the request comes from. The
+// device token is only returned here; it is also stored in a long-lived
+// cookie scoped to PIN login.
+func (s *Service) RegisterTerminal(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Name == "" {
+		http.Error(w, "Terminal name is required", http.StatusBadRequest)
+		return
+	}
+
+	deviceToken, err := newRefreshToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	claims, _ := ClaimsFromContext(r.Context())
+	terminal := Terminal{
+		Name:      body.Name,
+		TokenHash: hashToken(deviceToken),
+		CreatedBy: claims.UserID,
+		CreatedAt: time.Now(),
+	}
+	err = s.store.CreateTerminal(r.Context(), &terminal)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     terminalCookie,
+		Value:    deviceToken,
+		Expires:  time.Now().AddDate(5, 0, 0),
+		HttpOnly: true,
+		Secure:   true,
+		Path:     "/users/pin-login",
+		SameSite: http.SameSiteNoneMode,
+	})
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Terminal
+		DeviceToken string `json:"deviceToken"`
+	}{terminal, deviceToken})
+}
+
+func (s *Service) GetTerminals(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	terminals, err := s.store.ListTerminals(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(terminals)
+}
+
+// RevokeTerminal disables the device and signs out everyone logged in on it
+func (s *Service) RevokeTerminal(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid terminal id", http.StatusBadRequest)
+		return
+	}
+
+	err = s.store.RevokeTerminal(r.Context(), id)
+	if err == ErrTerminalNotFound {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.revoked.publish()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetPIN sets the PIN of a user; staff may set their own, managers anyone's
+func (s *Service) SetPIN(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := ClaimsFromContext(r.Context())
+	if claims.UserID != id {
+		if !claims.Can(PermUsersManage) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !s.canManage(w, r, id) {
+			return
+		}
+	}
+
+	var body struct {
+		PIN string `json:"pin"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !pinPattern.MatchString(body.PIN) {
+		http.Error(w, "PIN must be 4 to 6 digits", http.StatusBadRequest)
+		return
+	}
+
+	hashedPIN, err := s.hashPIN(body.PIN)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = s.store.SetPIN(r.Context(), id, hashedPIN)
+	if err == ErrNotFound {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PINLogin signs a user in with their PIN on a registered terminal and
+// opens the same kind of session as Login, bound to the terminal
+func (s *Service) PINLogin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	deviceToken := r.Header.Get(terminalHeader)
+	if deviceToken == "" {
+		if cookie, err := r.Cookie(terminalCookie); err == nil {
+			deviceToken = cookie.Value
+		}
+	}
+	if deviceToken == "" {
+		http.Error(w, "Unknown terminal", http.StatusUnauthorized)
+		return
+	}
+
+	terminal, err := s.store.TerminalByTokenHash(r.Context(), hashToken(deviceToken))
+	if err == ErrTerminalNotFound || (err == nil && terminal.RevokedAt != nil) {
+		http.Error(w, "Unknown terminal", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var credentials struct {
+		UserID int    `json:"userId"`
+		PIN    string `json:"pin"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&credentials)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	state, err := s.store.PINState(r.Context(), credentials.UserID)
+	if err == ErrNotFound || (err == nil && state.Hash == "") {
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if state.LockedUntil != nil && state.LockedUntil.After(time.Now()) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(*state.LockedUntil).Seconds())+1))
+		http.Error(w, "Too many failed attempts", http.StatusTooManyRequests)
+		return
+	}
+
+	if !checkPasswordHash(credentials.PIN, state.Hash) {
+		err = s.store.RecordPINFailure(r.Context(), credentials.UserID, s.config.PINMaxAttempts, s.config.PINLockout)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	err = s.store.ResetPINFailures(r.Context(), credentials.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.store.ByID(r.Context(), credentials.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = s.startSession(w, r, user, &terminal.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(NewUserView(user))
+}
@@ -0,0 +1,115 @@
+package warehouse
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// GetCategories lists the product categories by name
+func (s *Service) GetCategories(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	categories, err := s.store.Categories(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(categories)
+}
+
+// SaveCategory creates a category (POST) or renames one (PUT)
+func (s *Service) SaveCategory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var category Category
+	err := json.NewDecoder(r.Body).Decode(&category)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	category.ID = 0
+	if idParam := ps.ByName("id"); idParam != "" {
+		category.ID, err = strconv.Atoi(idParam)
+		if err != nil {
+			http.Error(w, "Invalid category id", http.StatusBadRequest)
+			return
+		}
+	}
+	category.Name = strings.TrimSpace(category.Name)
+	if category.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	created := category.ID == 0
+	err = s.store.SaveCategory(r.Context(), &category)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(category)
+}
+
+// DeleteCategory removes a category; its products stay without one
+func (s *Service) DeleteCategory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid category id", http.StatusBadRequest)
+		return
+	}
+
+	err = s.store.DeleteCategory(r.Context(), id)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetProductCategory files a product under a category; a null categoryId
+// takes it out of its category
+func (s *Service) SetProductCategory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	productID, err := strconv.Atoi(ps.ByName("productId"))
+	if err != nil {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		CategoryID *int `json:"categoryId"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.store.SetCategory(r.Context(), productID, body.CategoryID)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, ErrUnknownCategory):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.GetWarehouse(w, r, ps)
+}
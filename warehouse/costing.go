@@ -0,0 +1,55 @@
+package warehouse
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/period"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// GetLots returns the lots a product's stock is made of in the order they
+// are used up in: soonest to expire first, then oldest first
+func (s *Service) GetLots(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	productID, err := strconv.Atoi(ps.ByName("productId"))
+	if err != nil {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	lots, err := s.store.Lots(r.Context(), productID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lots)
+}
+
+// GetCOGS reports the cost of goods sold by product under the costing
+// method of the deployment. from and to work as for GetMovements.
+func (s *Service) GetCOGS(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	from, to, err := period.FromQuery(r.URL.Query(), 30)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lines, err := s.store.COGS(r.Context(), from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	report := COGSReport{Method: s.store.Costing(), From: from, To: to, Products: lines, Total: money.Zero()}
+	for _, line := range lines {
+		report.Total = report.Total.Add(line.Cost)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,75 @@
+package warehouse
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"randevu-shawarma-server/money"
+)
+
+func TestFIFODrawsOldestLotsFirst(t *testing.T) {
+	ctx := context.Background()
+	stock := NewMemoryStore(CostingFIFO)
+	stock.AddProduct(1, "chicken")
+	stock.AddProduct(2, "yogurt")
+	tomorrow := time.Now().AddDate(0, 0, 1)
+	err := stock.Tx(func(tx StockTx) error {
+		for i, in := range []struct {
+			productID int
+			quantity  float64
+			cost      string
+			expiresAt *time.Time
+		}{
+			{1, 10, "1.00", nil},
+			{1, 10, "2.00", nil},
+			{2, 5, "1.00", nil},
+			{2, 5, "3.00", &tomorrow},
+		} {
+			src := Source{Type: SourceSupply, ID: i + 1, UserID: 1}
+			if _, err := tx.Receive(ctx, in.productID, in.quantity, money.MustParseUnitCost(in.cost), src, in.expiresAt); err != nil {
+				return err
+			}
+		}
+		// 10 of the first lot at 1.00 and 5 of the second at 2.00; the
+		// yogurt that expires soonest goes first though it came in last
+		return tx.Take(ctx, map[int]float64{1: 15, 2: 5}, Source{Type: SourceOrder, ID: 1, UserID: 1})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lots, err := stock.Lots(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lots) != 1 || lots[0].Remaining != 5 || lots[0].UnitCost.String() != "2.00" {
+		t.Fatalf("chicken lots left %+v, want 5 of the lot at 2.00", lots)
+	}
+	items, err := stock.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		// What is left is valued at its lots
+		want := map[int]string{1: "2.00", 2: "1.00"}[item.ProductID]
+		if item.AverageCost.String() != want {
+			t.Errorf("%s valued at %s, want %s", item.ProductName, item.AverageCost, want)
+		}
+	}
+
+	now := time.Now()
+	cogs, err := stock.COGS(ctx, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]string{1: "20.00", 2: "15.00"}
+	if len(cogs) != 2 {
+		t.Fatalf("got %d COGS lines, want 2", len(cogs))
+	}
+	for _, line := range cogs {
+		if line.Cost.String() != want[line.ProductID] {
+			t.Errorf("%s cost %s, want %s", line.ProductName, line.Cost, want[line.ProductID])
+		}
+	}
+}
@@ -1,57 +1,174 @@
 package warehouse
 
 import (
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
+	"randevu-shawarma-server/period"
 	"randevu-shawarma-server/users"
 
 	"github.com/julienschmidt/httprouter"
 )
 
-var db *sql.DB
+type Service struct {
+	store Store
+	auth  *users.Service
+}
 
-// SetDatabase sets the database connection
-func SetDatabase(database *sql.DB) {
-	db = database
+func NewService(store Store, auth *users.Service) *Service {
+	return &Service{store: store, auth: auth}
 }
 
 // RegisterRoutes registers all warehouse routes
-func RegisterRoutes(router *httprouter.Router) {
-	router.GET("/warehouse", users.Authenticate(GetWarehouse))
+func (s *Service) RegisterRoutes(router *httprouter.Router) {
+	router.GET("/warehouse", s.auth.Authorize(users.PermWarehouseRead)(s.GetWarehouse))
+	router.GET("/warehouse/expiring", s.auth.Authorize(users.PermWarehouseRead)(s.GetExpiring))
+	router.GET("/warehouse/products/:productId", s.auth.Authorize(users.PermWarehouseRead)(s.GetWarehouseItem))
+	router.GET("/warehouse/products/:productId/movements", s.auth.Authorize(users.PermWarehouseRead)(s.GetMovements))
+	router.PUT("/warehouse/products/:productId/category", s.auth.Authorize(users.PermProductsManage)(s.SetProductCategory))
+	router.PUT("/warehouse/products/:productId/policy", s.auth.Authorize(users.PermProductsManage)(s.SetStockPolicy))
+	router.PUT("/warehouse/products/:productId/reorder", s.auth.Authorize(users.PermProductsManage)(s.SetReorder))
+	router.GET("/warehouse/products/:productId/units", s.auth.Authorize(users.PermWarehouseRead)(s.GetUnits))
+	router.PUT("/warehouse/products/:productId/units", s.auth.Authorize(users.PermProductsManage)(s.SaveUnits))
+	router.GET("/warehouse/products/:productId/lots", s.auth.Authorize(users.PermWarehouseRead)(s.GetLots))
+	router.GET("/reports/cogs", s.auth.Authorize(users.PermCostsRead)(s.GetCOGS))
+	router.GET("/product-categories", s.auth.Authorize(users.PermWarehouseRead)(s.GetCategories))
+	router.POST("/product-categories", s.auth.Authorize(users.PermProductsManage)(s.SaveCategory))
+	router.PUT("/product-categories/:id", s.auth.Authorize(users.PermProductsManage)(s.SaveCategory))
+	router.DELETE("/product-categories/:id", s.auth.Authorize(users.PermProductsManage)(s.DeleteCategory))
+}
+
+func (s *Service) GetWarehouse(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	warehouseItems, err := s.store.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(warehouseItems)
 }
 
-func GetWarehouse(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	query := `
-	SELECT w.id, w.product_id, p.name, w.current_stock, w.average_cost 
-	FROM public."Warehouse" w
-	INNER JOIN public."Products" p ON w.product_id = p.id
-	`
+// GetWarehouseItem returns the warehouse row of one product
+func (s *Service) GetWarehouseItem(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	productID, err := strconv.Atoi(ps.ByName("productId"))
+	if err != nil {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
 
-	rows, err := db.Query(query)
+	items, err := s.store.List(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
+	for _, item := range items {
+		if item.ProductID == productID {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(item)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
 
-	var warehouseItems []WarehouseItem
-	for rows.Next() {
-		var item WarehouseItem
-		err := rows.Scan(&item.ID, &item.ProductID, &item.ProductName, &item.CurrentStock, &item.AverageCost)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+// GetExpiring lists the lots with stock that expire ?within= a duration
+// from now, 48h unless given, soonest first; those already expired lead
+func (s *Service) GetExpiring(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	within := 48 * time.Hour
+	if v := r.URL.Query().Get("within"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "Invalid within", http.StatusBadRequest)
 			return
 		}
-		warehouseItems = append(warehouseItems, item)
+		within = d
 	}
 
-	if err := rows.Err(); err != nil {
+	lots, err := s.store.Expiring(r.Context(), time.Now().Add(within))
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(warehouseItems)
+	json.NewEncoder(w).Encode(lots)
+}
+
+// SetStockPolicy sets whether taking more of a product than is in stock
+// is blocked, allowed and flagged, or allowed
+func (s *Service) SetStockPolicy(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	productID, err := strconv.Atoi(ps.ByName("productId"))
+	if err != nil {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Policy Policy `json:"policy"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !body.Policy.Valid() {
+		http.Error(w, "Policy must be block, flag or allow", http.StatusBadRequest)
+		return
+	}
+
+	err = s.store.SetPolicy(r.Context(), productID, body.Policy)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.GetWarehouse(w, r, ps)
+}
+
+// WriteShortageError answers 409 with every short product as JSON if err
+// is a *ShortageError, and reports whether it was
+func WriteShortageError(w http.ResponseWriter, err error) bool {
+	var shortage *ShortageError
+	if !errors.As(err, &shortage) {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(struct {
+		Error     string     `json:"error"`
+		Shortages []Shortage `json:"shortages"`
+	}{ErrInsufficientStock.Error(), shortage.Shortages})
+	return true
+}
+
+// GetMovements returns the stock ledger of a product. from and to take a
+// date or an RFC 3339 time, a date to including that whole day, and
+// default to the last 30 days.
+func (s *Service) GetMovements(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	productID, err := strconv.Atoi(ps.ByName("productId"))
+	if err != nil {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := period.FromQuery(r.URL.Query(), 30)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	movements, err := s.store.Movements(r.Context(), productID, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(movements)
 }
@@ -0,0 +1,694 @@
+package warehouse
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"randevu-shawarma-server/money"
+)
+
+// MemoryStore keeps products and stock levels in memory. The in-memory
+// stores of packages that move stock share one MemoryStore through Tx.
+type MemoryStore struct {
+	mu                sync.Mutex
+	products          map[int]string
+	levels            map[int]Level
+	rowIDs            map[int]int
+	movements         []Movement
+	lots              []Lot
+	categories        map[int]Category
+	productCategories map[int]int
+	policies          map[int]Policy
+	reorders          map[int]Reorder
+	units             map[int]ProductUnits
+	// usedInRecipe stands in for the recipe tables; see SetRecipeLookup
+	usedInRecipe   func(productID int) bool
+	nextCategoryID int
+	costing        Costing
+}
+
+// NewMemoryStore returns an empty store that values stock leaving the
+// warehouse by costing
+func NewMemoryStore(costing Costing) *MemoryStore {
+	return &MemoryStore{
+		costing:  costing,
+		products: map[int]string{},
+		levels:   map[int]Level{},
+		rowIDs:   map[int]int{},
+
+		categories:        map[int]Category{},
+		productCategories: map[int]int{},
+		policies:          map[int]Policy{},
+		reorders:          map[int]Reorder{},
+		units:             map[int]ProductUnits{},
+		nextCategoryID:    1,
+	}
+}
+
+func (m *MemoryStore) Costing() Costing {
+	return m.costing
+}
+
+// AddProduct registers a product name so it shows up in List
+func (m *MemoryStore) AddProduct(id int, name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.products[id] = name
+}
+
+// NewProduct registers a product under the next free id, as the Products
+// sequence would, and returns the id
+func (m *MemoryStore) NewProduct(name string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := 1
+	for existing := range m.products {
+		if existing >= id {
+			id = existing + 1
+		}
+	}
+	m.products[id] = name
+	return id
+}
+
+// HasProduct reports whether the product is registered
+func (m *MemoryStore) HasProduct(id int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.products[id]
+	return ok
+}
+
+// ProductNames returns the registered products' names by id
+func (m *MemoryStore) ProductNames() map[int]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make(map[int]string, len(m.products))
+	for id, name := range m.products {
+		names[id] = name
+	}
+	return names
+}
+
+func (m *MemoryStore) Movements(ctx context.Context, productID int, from, to time.Time) ([]Movement, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movements := []Movement{}
+	for _, movement := range m.movements {
+		if movement.ProductID == productID && !movement.CreatedAt.Before(from) && movement.CreatedAt.Before(to) {
+			movements = append(movements, movement)
+		}
+	}
+	return movements, nil
+}
+
+func (m *MemoryStore) Lots(ctx context.Context, productID int) ([]Lot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lots := []Lot{}
+	for _, i := range m.openLots(productID, Source{}) {
+		lots = append(lots, m.lots[i])
+	}
+	return lots, nil
+}
+
+// openLots returns the indexes of the lots of a product that still have
+// stock, those of src first and then in the order they are used up:
+// soonest to expire first, oldest first; m.mu must be held
+func (m *MemoryStore) openLots(productID int, src Source) []int {
+	var open []int
+	for i, lot := range m.lots {
+		if lot.ProductID == productID && lot.Remaining > 0 {
+			open = append(open, i)
+		}
+	}
+	own := func(lot Lot) bool {
+		return lot.SourceType == src.Type && lot.SourceID != nil && *lot.SourceID == src.ID
+	}
+	sort.SliceStable(open, func(i, j int) bool {
+		a, b := m.lots[open[i]], m.lots[open[j]]
+		if own(a) != own(b) {
+			return own(a)
+		}
+		if a.ExpiresAt == nil || b.ExpiresAt == nil {
+			return a.ExpiresAt != nil && b.ExpiresAt == nil
+		}
+		return a.ExpiresAt.Before(*b.ExpiresAt)
+	})
+	return open
+}
+
+func (m *MemoryStore) Expiring(ctx context.Context, until time.Time) ([]Lot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.expiring(until), nil
+}
+
+// expiring is Expiring with m.mu held
+func (m *MemoryStore) expiring(until time.Time) []Lot {
+	lots := []Lot{}
+	for _, lot := range m.lots {
+		if lot.Remaining > 0 && lot.ExpiresAt != nil && lot.ExpiresAt.Before(until) {
+			lot.ProductName = m.products[lot.ProductID]
+			lots = append(lots, lot)
+		}
+	}
+	sort.SliceStable(lots, func(i, j int) bool {
+		if !lots[i].ExpiresAt.Equal(*lots[j].ExpiresAt) {
+			return lots[i].ExpiresAt.Before(*lots[j].ExpiresAt)
+		}
+		return lots[i].ProductID < lots[j].ProductID
+	})
+	return lots
+}
+
+func (m *MemoryStore) COGS(ctx context.Context, from, to time.Time) ([]COGSLine, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	byProduct := map[int]*COGSLine{}
+	for _, movement := range m.movements {
+		if movement.SourceType != SourceOrder && movement.SourceType != SourceRefund {
+			continue
+		}
+		if movement.CreatedAt.Before(from) || !movement.CreatedAt.Before(to) {
+			continue
+		}
+		line, ok := byProduct[movement.ProductID]
+		if !ok {
+			line = &COGSLine{ProductID: movement.ProductID, ProductName: m.products[movement.ProductID], Cost: money.Zero()}
+			byProduct[movement.ProductID] = line
+		}
+		line.Quantity -= movement.Delta
+		line.Cost = line.Cost.Sub(movement.UnitCost.Mul(movement.Delta, money.HalfEven))
+	}
+
+	lines := []COGSLine{}
+	for _, line := range byProduct {
+		lines = append(lines, *line)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].ProductName != lines[j].ProductName {
+			return lines[i].ProductName < lines[j].ProductName
+		}
+		return lines[i].ProductID < lines[j].ProductID
+	})
+	return lines, nil
+}
+
+func (m *MemoryStore) List(ctx context.Context) ([]WarehouseItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var items []WarehouseItem
+	for productID, level := range m.levels {
+		name, ok := m.products[productID]
+		if !ok {
+			continue
+		}
+		item := WarehouseItem{
+			ID:           m.rowIDs[productID],
+			ProductID:    productID,
+			ProductName:  name,
+			CurrentStock: level.CurrentStock,
+			AverageCost:  level.AverageCost,
+			StockPolicy:  m.policy(productID),
+			Unit:         m.productUnits(productID).Unit,
+			ReorderPoint: m.reorders[productID].ReorderPoint,
+			MaxStock:     m.reorders[productID].MaxStock,
+		}
+		if categoryID, ok := m.productCategories[productID]; ok {
+			item.CategoryID = &categoryID
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items, nil
+}
+
+func (m *MemoryStore) Categories(ctx context.Context) ([]Category, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	categories := []Category{}
+	for _, c := range m.categories {
+		categories = append(categories, c)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].Name != categories[j].Name {
+			return categories[i].Name < categories[j].Name
+		}
+		return categories[i].ID < categories[j].ID
+	})
+	return categories, nil
+}
+
+func (m *MemoryStore) SaveCategory(ctx context.Context, c *Category) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c.ID == 0 {
+		c.ID = m.nextCategoryID
+		m.nextCategoryID++
+	} else if _, ok := m.categories[c.ID]; !ok {
+		return ErrNotFound
+	}
+	m.categories[c.ID] = *c
+	return nil
+}
+
+func (m *MemoryStore) DeleteCategory(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.categories[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.categories, id)
+	for productID, categoryID := range m.productCategories {
+		if categoryID == id {
+			delete(m.productCategories, productID)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) SetCategory(ctx context.Context, productID int, categoryID *int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.products[productID]; !ok {
+		return ErrNotFound
+	}
+	if categoryID == nil {
+		delete(m.productCategories, productID)
+		return nil
+	}
+	if _, ok := m.categories[*categoryID]; !ok {
+		return ErrUnknownCategory
+	}
+	m.productCategories[productID] = *categoryID
+	return nil
+}
+
+func (m *MemoryStore) SetPolicy(ctx context.Context, productID int, policy Policy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.products[productID]; !ok {
+		return ErrNotFound
+	}
+	m.policies[productID] = policy
+	return nil
+}
+
+func (m *MemoryStore) SetReorder(ctx context.Context, productID int, reorder Reorder) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.products[productID]; !ok {
+		return ErrNotFound
+	}
+	m.reorders[productID] = reorder
+	return nil
+}
+
+func (m *MemoryStore) Consumption(ctx context.Context, from, to time.Time) (map[int]float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	used := map[int]float64{}
+	for _, movement := range m.movements {
+		taken := (movement.SourceType == SourceOrder || movement.SourceType == SourceWriteOff) && movement.Delta < 0
+		refunded := movement.SourceType == SourceRefund && movement.Delta > 0
+		if !taken && !refunded {
+			continue
+		}
+		if !movement.CreatedAt.Before(from) && movement.CreatedAt.Before(to) {
+			used[movement.ProductID] -= movement.Delta
+		}
+	}
+	for productID, quantity := range used {
+		if quantity <= 0 {
+			delete(used, productID)
+		}
+	}
+	return used, nil
+}
+
+// policy returns the stock policy of a product, flag unless set; m.mu
+// must be held
+func (m *MemoryStore) policy(productID int) Policy {
+	if policy, ok := m.policies[productID]; ok {
+		return policy
+	}
+	return PolicyFlag
+}
+
+func (m *MemoryStore) Units(ctx context.Context, productID int) (ProductUnits, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.products[productID]; !ok {
+		return ProductUnits{}, ErrNotFound
+	}
+	return m.productUnits(productID), nil
+}
+
+func (m *MemoryStore) SaveUnits(ctx context.Context, units ProductUnits) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.products[units.ProductID]; !ok {
+		return ErrNotFound
+	}
+	if m.productUnits(units.ProductID).Unit != units.Unit {
+		reorder := m.reorders[units.ProductID]
+		inRecipe := m.usedInRecipe != nil && m.usedInRecipe(units.ProductID)
+		err := unitInUse(m.levels[units.ProductID].CurrentStock, reorder.ReorderPoint != nil || reorder.MaxStock != nil, inRecipe)
+		if err != nil {
+			return err
+		}
+	}
+	units.Units = append([]Unit{}, units.Units...)
+	sort.Slice(units.Units, func(i, j int) bool {
+		if units.Units[i].Factor != units.Units[j].Factor {
+			return units.Units[i].Factor < units.Units[j].Factor
+		}
+		return units.Units[i].Name < units.Units[j].Name
+	})
+	m.units[units.ProductID] = units
+	return nil
+}
+
+// SetRecipeLookup tells the store how to find whether a recipe uses a
+// product, which lives in another package's store
+func (m *MemoryStore) SetRecipeLookup(lookup func(productID int) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usedInRecipe = lookup
+}
+
+func (m *MemoryStore) Factor(ctx context.Context, productID int, unit string) (float64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.factor(productID, unit)
+	return f, ok, nil
+}
+
+// productUnits returns the units of a product, kept in pieces unless
+// set; m.mu must be held
+func (m *MemoryStore) productUnits(productID int) ProductUnits {
+	units, ok := m.units[productID]
+	if !ok {
+		return ProductUnits{ProductID: productID, Unit: "pcs", Units: []Unit{}}
+	}
+	units.Units = append([]Unit{}, units.Units...)
+	return units
+}
+
+// factor is Factor with m.mu held
+func (m *MemoryStore) factor(productID int, unit string) (float64, bool) {
+	units := m.productUnits(productID)
+	if unit == "" || unit == units.Unit {
+		return 1, true
+	}
+	for _, u := range units.Units {
+		if u.Name == unit {
+			return u.Factor, true
+		}
+	}
+	return 0, false
+}
+
+// Tx runs fn with exclusive access to the stock and discards every change
+// it made if fn returns an error
+func (m *MemoryStore) Tx(fn func(StockTx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	levels := make(map[int]Level, len(m.levels))
+	for k, v := range m.levels {
+		levels[k] = v
+	}
+	rowIDs := make(map[int]int, len(m.rowIDs))
+	for k, v := range m.rowIDs {
+		rowIDs[k] = v
+	}
+
+	movements := len(m.movements)
+	lots := append([]Lot(nil), m.lots...)
+
+	if err := fn(&memoryStock{m}); err != nil {
+		m.levels = levels
+		m.rowIDs = rowIDs
+		m.movements = m.movements[:movements]
+		m.lots = lots
+		return err
+	}
+	return nil
+}
+
+// memoryStock is only used while MemoryStore.mu is held
+type memoryStock struct {
+	m *MemoryStore
+}
+
+func (s *memoryStock) StockLevel(ctx context.Context, productID int) (Level, bool, error) {
+	level, ok := s.m.levels[productID]
+	if !ok {
+		return Level{ProductID: productID}, false, nil
+	}
+	return level, true, nil
+}
+
+func (s *memoryStock) Factor(ctx context.Context, productID int, unit string) (float64, bool, error) {
+	f, ok := s.m.factor(productID, unit)
+	return f, ok, nil
+}
+
+func (s *memoryStock) Receive(ctx context.Context, productID int, quantity float64, unitCost money.UnitCost, src Source, expiresAt *time.Time) (Level, error) {
+	if _, ok := s.m.rowIDs[productID]; !ok {
+		s.m.rowIDs[productID] = len(s.m.rowIDs) + 1
+	}
+	level := s.m.levels[productID]
+	level.ProductID = productID
+
+	before := level.CurrentStock
+	level.AverageCost = money.WeightedAverage(level.AverageCost, before, unitCost, quantity)
+	level.CurrentStock += quantity
+	s.m.levels[productID] = level
+
+	s.record(productID, quantity, level.CurrentStock, unitCost, src, 0)
+	s.openLot(productID, quantity, before, unitCost, src, expiresAt)
+	if err := s.valueLots(productID); err != nil {
+		return level, err
+	}
+	return s.m.levels[productID], nil
+}
+
+func (s *memoryStock) AddStock(ctx context.Context, productID int, delta float64, src Source) error {
+	level, ok := s.m.levels[productID]
+	if !ok {
+		return nil
+	}
+	cost := level.AverageCost
+	if delta > 0 {
+		s.openLot(productID, delta, level.CurrentStock, cost, src, nil)
+	} else {
+		var err error
+		if cost, err = s.draw(productID, -delta, src, cost); err != nil {
+			return err
+		}
+	}
+	level.CurrentStock += delta
+	s.m.levels[productID] = level
+	s.record(productID, delta, level.CurrentStock, cost, src, 0)
+	return s.valueLots(productID)
+}
+
+func (s *memoryStock) Return(ctx context.Context, productID int, quantity float64, src, from Source) error {
+	cost, ok := takenAt(s.m.movements, productID, from)
+	if !ok {
+		cost = s.m.levels[productID].AverageCost
+	}
+	_, err := s.Receive(ctx, productID, quantity, cost, src, nil)
+	return err
+}
+
+func (s *memoryStock) Shortages(ctx context.Context, quantities map[int]float64) ([]Shortage, error) {
+	var shortages []Shortage
+	for _, productID := range sortedIDs(quantities) {
+		name, ok := s.m.products[productID]
+		if !ok {
+			continue
+		}
+		short := Shortage{
+			ProductID:   productID,
+			ProductName: name,
+			Required:    quantities[productID],
+			Available:   s.m.levels[productID].CurrentStock,
+			Policy:      s.m.policy(productID),
+		}
+		if short.Available+tolerance < short.Required {
+			shortages = append(shortages, short)
+		}
+	}
+	return shortages, nil
+}
+
+func (s *memoryStock) Take(ctx context.Context, quantities map[int]float64, src Source) error {
+	shortages, err := s.Shortages(ctx, quantities)
+	if err != nil {
+		return err
+	}
+	if err := blocked(shortages); err != nil {
+		return err
+	}
+
+	marks := flagged(shortages)
+	for _, productID := range sortedIDs(quantities) {
+		level, ok := s.m.levels[productID]
+		if !ok {
+			level = Level{ProductID: productID, AverageCost: money.ZeroCost()}
+			s.m.rowIDs[productID] = len(s.m.rowIDs) + 1
+		}
+		cost, err := s.draw(productID, quantities[productID], src, level.AverageCost)
+		if err != nil {
+			return err
+		}
+		level.CurrentStock -= quantities[productID]
+		s.m.levels[productID] = level
+		s.record(productID, -quantities[productID], level.CurrentStock, cost, src, marks[productID])
+		if err := s.valueLots(productID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStock) Expiring(ctx context.Context, until time.Time) ([]Lot, error) {
+	return s.m.expiring(until), nil
+}
+
+func (s *memoryStock) Cost(ctx context.Context, productID int, quantity float64) (money.Amount, error) {
+	var lots []Lot
+	for _, i := range s.m.openLots(productID, Source{}) {
+		lots = append(lots, s.m.lots[i])
+	}
+	return drawCost(s.m.costing, lots, quantity, s.m.levels[productID].AverageCost), nil
+}
+
+// draw takes quantity out of the product's lots and returns the unit cost
+// it leaves at, average being the product's average cost
+func (s *memoryStock) draw(productID int, quantity float64, src Source, average money.UnitCost) (money.UnitCost, error) {
+	open := s.m.openLots(productID, src)
+	lots := make([]Lot, len(open))
+	for i, j := range open {
+		lots[i] = s.m.lots[j]
+	}
+	cost, err := takeCost(s.m.costing, lots, quantity, average)
+	if err != nil {
+		return average, err
+	}
+	taken, _, _ := drawLots(lots, quantity)
+	for i, j := range open {
+		s.m.lots[j].Remaining -= taken[i]
+		if s.m.lots[j].Remaining < tolerance {
+			s.m.lots[j].Remaining = 0
+		}
+	}
+	return cost, nil
+}
+
+// openLot records stock coming in on top of before as a lot
+func (s *memoryStock) openLot(productID int, quantity, before float64, unitCost money.UnitCost, src Source, expiresAt *time.Time) {
+	lot := Lot{
+		ID:         len(s.m.lots) + 1,
+		ProductID:  productID,
+		SourceType: src.Type,
+		Quantity:   quantity,
+		Remaining:  lotRemaining(quantity, before),
+		UnitCost:   unitCost,
+		ReceivedAt: time.Now(),
+	}
+	if expiresAt != nil {
+		at := *expiresAt
+		lot.ExpiresAt = &at
+	}
+	if src.ID != 0 {
+		id := src.ID
+		lot.SourceID = &id
+	}
+	s.m.lots = append(s.m.lots, lot)
+}
+
+// valueLots sets the average cost of a product to what is left in its
+// lots under FIFO costing, where lots rather than the average decide
+func (s *memoryStock) valueLots(productID int) error {
+	level, ok := s.m.levels[productID]
+	if s.m.costing != CostingFIFO || !ok {
+		return nil
+	}
+	var lots []Lot
+	for _, i := range s.m.openLots(productID, Source{}) {
+		lots = append(lots, s.m.lots[i])
+	}
+	cost, ok, err := lotValue(lots)
+	if err != nil || !ok {
+		return err
+	}
+	level.AverageCost = cost
+	s.m.levels[productID] = level
+	return nil
+}
+
+func (s *memoryStock) Revalue(ctx context.Context, productID int, skip ...Source) error {
+	if s.m.costing == CostingFIFO {
+		return s.valueLots(productID)
+	}
+	level, ok := s.m.levels[productID]
+	if !ok {
+		return nil
+	}
+	var movements []Movement
+	for _, m := range s.m.movements {
+		if m.ProductID == productID {
+			movements = append(movements, m)
+		}
+	}
+	level.AverageCost = replayCost(movements, skip)
+	s.m.levels[productID] = level
+	return nil
+}
+
+func (s *memoryStock) record(productID int, delta, balance float64, unitCost money.UnitCost, src Source, shortage float64) {
+	if delta == 0 {
+		return
+	}
+	m := Movement{
+		ID:         int64(len(s.m.movements) + 1),
+		ProductID:  productID,
+		Delta:      delta,
+		Balance:    balance,
+		UnitCost:   unitCost,
+		SourceType: src.Type,
+		Shortage:   shortage,
+		CreatedAt:  time.Now(),
+	}
+	if src.ID != 0 {
+		id := src.ID
+		m.SourceID = &id
+	}
+	if src.UserID != 0 {
+		userID := src.UserID
+		m.UserID = &userID
+	}
+	s.m.movements = append(s.m.movements, m)
+}
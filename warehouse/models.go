@@ -1,9 +1,213 @@
 package warehouse
 
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"randevu-shawarma-server/money"
+)
+
 type WarehouseItem struct {
-	ID           int     `json:"id"`
+	ID           int            `json:"id"`
+	ProductID    int            `json:"productId"`
+	ProductName  string         `json:"productName"`
+	CategoryID   *int           `json:"categoryId"`
+	StockPolicy  Policy         `json:"stockPolicy"`
+	Unit         string         `json:"unit"`
+	CurrentStock float64        `json:"currentStock"`
+	AverageCost  money.UnitCost `json:"averageCost"`
+	ReorderPoint *float64       `json:"reorderPoint"`
+	MaxStock     *float64       `json:"maxStock"`
+}
+
+// Reorder is when a product should be bought again: once its stock falls
+// to ReorderPoint, enough to bring it up to MaxStock. Either left unset is
+// worked out from how fast the product is used.
+type Reorder struct {
+	ReorderPoint *float64 `json:"reorderPoint"`
+	MaxStock     *float64 `json:"maxStock"`
+}
+
+// ReorderSuggestion is a product that has fallen to its reorder point and
+// how much of it to buy, in the unit it is kept in
+type ReorderSuggestion struct {
 	ProductID    int     `json:"productId"`
 	ProductName  string  `json:"productName"`
+	Unit         string  `json:"unit"`
 	CurrentStock float64 `json:"currentStock"`
-	AverageCost  string  `json:"averageCost"`
+	// OnOrder is what open purchase orders are still to bring in; it
+	// counts as stock when deciding whether and how much to reorder
+	OnOrder float64 `json:"onOrder"`
+	// DailyUsage is what orders and write-offs took a day on average, net
+	// of refunds
+	DailyUsage float64 `json:"dailyUsage"`
+	// DaysLeft is how long the stock lasts at DailyUsage, if it is used
+	DaysLeft     *float64 `json:"daysLeft"`
+	ReorderPoint float64  `json:"reorderPoint"`
+	MaxStock     float64  `json:"maxStock"`
+	Quantity     float64  `json:"quantity"`
+}
+
+// Category groups products for counting, e.g. "Fridge" or "Dry store"
+type Category struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Unit is another unit a product is bought or used in, worth Factor of
+// the unit it is kept in, e.g. a 5 kg box of chicken kept in grams is 5000
+type Unit struct {
+	Name   string  `json:"name"`
+	Factor float64 `json:"factor"`
+}
+
+// ProductUnits is the unit a product's stock is kept in and the other
+// units quantities of it may be given in
+type ProductUnits struct {
+	ProductID int    `json:"productId"`
+	Unit      string `json:"unit"`
+	Units     []Unit `json:"units"`
+}
+
+type Level struct {
+	ProductID    int
+	CurrentStock float64
+	AverageCost  money.UnitCost
+}
+
+// SourceType names the kind of document that moved stock
+type SourceType string
+
+const (
+	SourceSupply     SourceType = "supply"
+	SourceWriteOff   SourceType = "write_off"
+	SourceOrder      SourceType = "order"
+	SourceRefund     SourceType = "refund"
+	SourceProduction SourceType = "production"
+	SourceAdjustment SourceType = "adjustment"
+)
+
+// Source is the document, and the user behind it, a stock change is
+// recorded against
+type Source struct {
+	Type   SourceType
+	ID     int
+	UserID int
+}
+
+// Movement is one entry of the stock ledger. Balance is the stock of the
+// product right after it; UnitCost is what the stock came in or left at.
+type Movement struct {
+	ID         int64          `json:"id"`
+	ProductID  int            `json:"productId"`
+	Delta      float64        `json:"delta"`
+	Balance    float64        `json:"balance"`
+	UnitCost   money.UnitCost `json:"unitCost"`
+	SourceType SourceType     `json:"sourceType"`
+	SourceID   *int           `json:"sourceId"`
+	UserID     *int           `json:"userId"`
+	// Shortage is how much of a take the stock did not cover, when the
+	// product's policy flags it
+	Shortage  float64   `json:"shortage,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Lot is one receipt of a product into stock, used up first expired first
+// out and oldest first among lots that do not expire. Remaining is what is
+// left of Quantity; UnitCost is what it came in at.
+type Lot struct {
+	ID          int            `json:"id"`
+	ProductID   int            `json:"productId"`
+	ProductName string         `json:"productName,omitempty"`
+	SourceType  SourceType     `json:"sourceType"`
+	SourceID    *int           `json:"sourceId"`
+	Quantity    float64        `json:"quantity"`
+	Remaining   float64        `json:"remaining"`
+	UnitCost    money.UnitCost `json:"unitCost"`
+	ReceivedAt  time.Time      `json:"receivedAt"`
+	ExpiresAt   *time.Time     `json:"expiresAt"`
+}
+
+// Costing is how stock leaving the warehouse is valued
+type Costing string
+
+const (
+	// CostingAverage values it at the moving average cost of the product
+	CostingAverage Costing = "average"
+	// CostingFIFO values it at the cost of the lots it is taken from
+	CostingFIFO Costing = "fifo"
+)
+
+func (c Costing) Valid() bool {
+	return c == CostingAverage || c == CostingFIFO
+}
+
+// COGSLine is what was sold of a product net of refunds and what it cost
+type COGSLine struct {
+	ProductID   int          `json:"productId"`
+	ProductName string       `json:"productName"`
+	Quantity    float64      `json:"quantity"`
+	Cost        money.Amount `json:"cost"`
+}
+
+// COGSReport is the cost of goods sold between From and To under the
+// costing method of the deployment
+type COGSReport struct {
+	Method   Costing      `json:"method"`
+	From     time.Time    `json:"from"`
+	To       time.Time    `json:"to"`
+	Products []COGSLine   `json:"products"`
+	Total    money.Amount `json:"total"`
+}
+
+// Policy decides what happens when more of a product is taken than the
+// warehouse holds
+type Policy string
+
+const (
+	// PolicyBlock refuses the take
+	PolicyBlock Policy = "block"
+	// PolicyFlag lets stock go negative and marks the movement
+	PolicyFlag Policy = "flag"
+	// PolicyAllow lets stock go negative
+	PolicyAllow Policy = "allow"
+)
+
+func (p Policy) Valid() bool {
+	return p == PolicyBlock || p == PolicyFlag || p == PolicyAllow
+}
+
+// Shortage is a product a take needs more of than the warehouse holds
+type Shortage struct {
+	ProductID   int     `json:"productId"`
+	ProductName string  `json:"productName"`
+	Required    float64 `json:"required"`
+	Available   float64 `json:"available"`
+	Policy      Policy  `json:"policy"`
+}
+
+// missing is how much of the take the stock does not cover
+func (s Shortage) missing() float64 {
+	if s.Available <= 0 {
+		return s.Required
+	}
+	return s.Required - s.Available
+}
+
+// ShortageError refuses a take for every product whose policy blocks it
+type ShortageError struct {
+	Shortages []Shortage `json:"shortages"`
+}
+
+func (e *ShortageError) Error() string {
+	names := make([]string, 0, len(e.Shortages))
+	for _, s := range e.Shortages {
+		names = append(names, fmt.Sprintf("%s (%g of %g)", s.ProductName, s.Available, s.Required))
+	}
+	return ErrInsufficientStock.Error() + ": " + strings.Join(names, ", ")
+}
+
+func (e *ShortageError) Unwrap() error {
+	return ErrInsufficientStock
 }
@@ -0,0 +1,721 @@
+package warehouse
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+
+	"randevu-shawarma-server/money"
+)
+
+type PostgresStore struct {
+	db      *sql.DB
+	costing Costing
+}
+
+// NewPostgresStore returns a store that values stock leaving the
+// warehouse by costing
+func NewPostgresStore(db *sql.DB, costing Costing) *PostgresStore {
+	return &PostgresStore{db: db, costing: costing}
+}
+
+func (s *PostgresStore) Costing() Costing {
+	return s.costing
+}
+
+func (s *PostgresStore) Movements(ctx context.Context, productID int, from, to time.Time) ([]Movement, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, product_id, delta, balance, unit_cost, source_type, source_id, user_id, shortage, created_at
+		FROM public."Stock_movements"
+		WHERE product_id = $1 AND created_at >= $2 AND created_at < $3
+		ORDER BY created_at, id`, productID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movements := []Movement{}
+	for rows.Next() {
+		var m Movement
+		err := rows.Scan(&m.ID, &m.ProductID, &m.Delta, &m.Balance, &m.UnitCost, &m.SourceType, &m.SourceID, &m.UserID, &m.Shortage, &m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		movements = append(movements, m)
+	}
+	return movements, rows.Err()
+}
+
+func (s *PostgresStore) Lots(ctx context.Context, productID int) ([]Lot, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, product_id, source_type, source_id, quantity, remaining, unit_cost, received_at, expires_at
+		FROM public."Stock_lots"
+		WHERE product_id = $1 AND remaining > 0
+		ORDER BY expires_at NULLS LAST, received_at, id`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lots, err := scanLots(rows)
+	if lots == nil {
+		lots = []Lot{}
+	}
+	return lots, err
+}
+
+func scanLots(rows *sql.Rows) ([]Lot, error) {
+	var lots []Lot
+	for rows.Next() {
+		var lot Lot
+		err := rows.Scan(&lot.ID, &lot.ProductID, &lot.SourceType, &lot.SourceID, &lot.Quantity, &lot.Remaining, &lot.UnitCost, &lot.ReceivedAt, &lot.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		lots = append(lots, lot)
+	}
+	return lots, rows.Err()
+}
+
+func (s *PostgresStore) Expiring(ctx context.Context, until time.Time) ([]Lot, error) {
+	return expiring(ctx, s.db, until)
+}
+
+// expiring reads the lots with stock that expire before until
+func expiring(ctx context.Context, q queryer, until time.Time) ([]Lot, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT l.id, l.product_id, p.name, l.source_type, l.source_id, l.quantity, l.remaining, l.unit_cost, l.received_at, l.expires_at
+		FROM public."Stock_lots" l
+		INNER JOIN public."Products" p ON p.id = l.product_id
+		WHERE l.remaining > 0 AND l.expires_at < $1
+		ORDER BY l.expires_at, l.product_id, l.id`, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lots := []Lot{}
+	for rows.Next() {
+		var lot Lot
+		err := rows.Scan(&lot.ID, &lot.ProductID, &lot.ProductName, &lot.SourceType, &lot.SourceID, &lot.Quantity, &lot.Remaining, &lot.UnitCost, &lot.ReceivedAt, &lot.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		lots = append(lots, lot)
+	}
+	return lots, rows.Err()
+}
+
+func (s *PostgresStore) COGS(ctx context.Context, from, to time.Time) ([]COGSLine, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT m.product_id, p.name, -SUM(m.delta), -SUM(ROUND((m.delta * m.unit_cost)::numeric, 2))
+		FROM public."Stock_movements" m
+		INNER JOIN public."Products" p ON p.id = m.product_id
+		WHERE m.source_type IN ('order', 'refund') AND m.created_at >= $1 AND m.created_at < $2
+		GROUP BY m.product_id, p.name
+		ORDER BY p.name, m.product_id`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []COGSLine{}
+	for rows.Next() {
+		var line COGSLine
+		if err := rows.Scan(&line.ProductID, &line.ProductName, &line.Quantity, &line.Cost); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+func (s *PostgresStore) List(ctx context.Context) ([]WarehouseItem, error) {
+	query := `
+	SELECT w.id, w.product_id, p.name, p.category_id, p.stock_policy, p.unit, w.current_stock, w.average_cost,
+		p.reorder_point, p.max_stock
+	FROM public."Warehouse" w
+	INNER JOIN public."Products" p ON w.product_id = p.id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var warehouseItems []WarehouseItem
+	for rows.Next() {
+		var item WarehouseItem
+		err := rows.Scan(&item.ID, &item.ProductID, &item.ProductName, &item.CategoryID, &item.StockPolicy, &item.Unit, &item.CurrentStock, &item.AverageCost,
+			&item.ReorderPoint, &item.MaxStock)
+		if err != nil {
+			return nil, err
+		}
+		warehouseItems = append(warehouseItems, item)
+	}
+	return warehouseItems, rows.Err()
+}
+
+func (s *PostgresStore) Categories(ctx context.Context) ([]Category, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name FROM public.\"Product_categories\" ORDER BY name, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.ID, &c.Name); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+func (s *PostgresStore) SaveCategory(ctx context.Context, c *Category) error {
+	if c.ID == 0 {
+		return s.db.QueryRowContext(ctx,
+			"INSERT INTO public.\"Product_categories\" (name) VALUES ($1) RETURNING id",
+			c.Name,
+		).Scan(&c.ID)
+	}
+
+	res, err := s.db.ExecContext(ctx, "UPDATE public.\"Product_categories\" SET name = $1 WHERE id = $2", c.Name, c.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) DeleteCategory(ctx context.Context, id int) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM public.\"Product_categories\" WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) SetCategory(ctx context.Context, productID int, categoryID *int) error {
+	if categoryID != nil {
+		var exists bool
+		err := s.db.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM public.\"Product_categories\" WHERE id = $1)", *categoryID,
+		).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrUnknownCategory
+		}
+	}
+
+	res, err := s.db.ExecContext(ctx, "UPDATE public.\"Products\" SET category_id = $1 WHERE id = $2", categoryID, productID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) SetPolicy(ctx context.Context, productID int, policy Policy) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE public.\"Products\" SET stock_policy = $1 WHERE id = $2", policy, productID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) SetReorder(ctx context.Context, productID int, reorder Reorder) error {
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE public.\"Products\" SET reorder_point = $1, max_stock = $2 WHERE id = $3",
+		reorder.ReorderPoint, reorder.MaxStock, productID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) Consumption(ctx context.Context, from, to time.Time) (map[int]float64, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT product_id, -SUM(delta)
+		FROM public."Stock_movements"
+		WHERE (source_type IN ('order', 'write_off') AND delta < 0 OR source_type = 'refund' AND delta > 0)
+			AND created_at >= $1 AND created_at < $2
+		GROUP BY product_id`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	used := map[int]float64{}
+	for rows.Next() {
+		var productID int
+		var quantity float64
+		if err := rows.Scan(&productID, &quantity); err != nil {
+			return nil, err
+		}
+		// Refunds of orders from before the period can outweigh its sales
+		if quantity > 0 {
+			used[productID] = quantity
+		}
+	}
+	return used, rows.Err()
+}
+
+func (s *PostgresStore) Units(ctx context.Context, productID int) (ProductUnits, error) {
+	units := ProductUnits{ProductID: productID, Units: []Unit{}}
+	err := s.db.QueryRowContext(ctx, "SELECT unit FROM public.\"Products\" WHERE id = $1", productID).Scan(&units.Unit)
+	if err == sql.ErrNoRows {
+		return units, ErrNotFound
+	} else if err != nil {
+		return units, err
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT name, factor FROM public.\"Product_units\" WHERE product_id = $1 ORDER BY factor, name", productID)
+	if err != nil {
+		return units, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u Unit
+		if err := rows.Scan(&u.Name, &u.Factor); err != nil {
+			return units, err
+		}
+		units.Units = append(units.Units, u)
+	}
+	return units, rows.Err()
+}
+
+func (s *PostgresStore) SaveUnits(ctx context.Context, units ProductUnits) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The row lock also keeps recipes from taking the product up until
+	// the new unit is in place
+	var current string
+	var stock float64
+	var reorder, inRecipe bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT p.unit, COALESCE(w.current_stock, 0),
+			p.reorder_point IS NOT NULL OR p.max_stock IS NOT NULL,
+			EXISTS (SELECT 1 FROM public."Dish_recipe" WHERE product_id = p.id)
+				OR EXISTS (SELECT 1 FROM public."Preparation_recipe" WHERE product_id = p.id)
+				OR EXISTS (SELECT 1 FROM public."Modifier_recipe" WHERE product_id = p.id)
+		FROM public."Products" p
+		LEFT JOIN public."Warehouse" w ON w.product_id = p.id
+		WHERE p.id = $1
+		FOR UPDATE OF p`, units.ProductID,
+	).Scan(&current, &stock, &reorder, &inRecipe)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	if current != units.Unit {
+		if err := unitInUse(stock, reorder, inRecipe); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE public.\"Products\" SET unit = $1 WHERE id = $2", units.Unit, units.ProductID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM public.\"Product_units\" WHERE product_id = $1", units.ProductID); err != nil {
+		return err
+	}
+	for _, u := range units.Units {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO public.\"Product_units\" (product_id, name, factor) VALUES ($1, $2, $3)",
+			units.ProductID, u.Name, u.Factor,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) Factor(ctx context.Context, productID int, unit string) (float64, bool, error) {
+	return factor(ctx, s.db, productID, unit)
+}
+
+// factor looks up what one unit of a product is worth in the unit its
+// stock is kept in
+func factor(ctx context.Context, q queryer, productID int, unit string) (float64, bool, error) {
+	if unit == "" {
+		return 1, true, nil
+	}
+	var f float64
+	err := q.QueryRowContext(ctx, `
+		SELECT 1 FROM public."Products" WHERE id = $1 AND unit = $2
+		UNION ALL
+		SELECT factor FROM public."Product_units" WHERE product_id = $1 AND name = $2
+		LIMIT 1`, productID, unit,
+	).Scan(&f)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return f, true, nil
+}
+
+// queryer is what *sql.DB and *sql.Tx have in common
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type sqlStock struct {
+	tx      *sql.Tx
+	costing Costing
+}
+
+// Stock returns a StockTx working inside tx
+func (s *PostgresStore) Stock(tx *sql.Tx) StockTx {
+	return &sqlStock{tx: tx, costing: s.costing}
+}
+
+func (s *sqlStock) StockLevel(ctx context.Context, productID int) (Level, bool, error) {
+	level := Level{ProductID: productID}
+	err := s.tx.QueryRowContext(ctx,
+		"SELECT current_stock, average_cost FROM public.\"Warehouse\" WHERE product_id = $1 FOR UPDATE",
+		productID,
+	).Scan(&level.CurrentStock, &level.AverageCost)
+	if err == sql.ErrNoRows {
+		return level, false, nil
+	} else if err != nil {
+		return level, false, err
+	}
+	return level, true, nil
+}
+
+func (s *sqlStock) Factor(ctx context.Context, productID int, unit string) (float64, bool, error) {
+	return factor(ctx, s.tx, productID, unit)
+}
+
+func (s *sqlStock) Receive(ctx context.Context, productID int, quantity float64, unitCost money.UnitCost, src Source, expiresAt *time.Time) (Level, error) {
+	// Make sure there is a row to lock; other takes and receipts of the
+	// product then wait for this one to commit
+	_, err := s.tx.ExecContext(ctx, `
+		INSERT INTO public."Warehouse" (product_id, current_stock, average_cost) VALUES ($1, 0, 0)
+		ON CONFLICT (product_id) DO NOTHING`, productID)
+	if err != nil {
+		return Level{}, err
+	}
+	level, _, err := s.StockLevel(ctx, productID)
+	if err != nil {
+		return level, err
+	}
+
+	before := level.CurrentStock
+	level.AverageCost = money.WeightedAverage(level.AverageCost, before, unitCost, quantity)
+	err = s.tx.QueryRowContext(ctx, `
+		UPDATE public."Warehouse" SET current_stock = current_stock + $1, average_cost = $2
+		WHERE product_id = $3
+		RETURNING current_stock`,
+		quantity, level.AverageCost, productID,
+	).Scan(&level.CurrentStock)
+	if err != nil {
+		return level, err
+	}
+
+	if err := s.record(ctx, productID, quantity, level.CurrentStock, unitCost, src, 0); err != nil {
+		return level, err
+	}
+	if err := s.openLot(ctx, productID, quantity, before, unitCost, src, expiresAt); err != nil {
+		return level, err
+	}
+	return level, s.valueLots(ctx, productID)
+}
+
+func (s *sqlStock) AddStock(ctx context.Context, productID int, delta float64, src Source) error {
+	var balance float64
+	var cost money.UnitCost
+	err := s.tx.QueryRowContext(ctx,
+		"UPDATE public.\"Warehouse\" SET current_stock = current_stock + $1 WHERE product_id = $2 RETURNING current_stock, average_cost",
+		delta, productID,
+	).Scan(&balance, &cost)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if delta > 0 {
+		err = s.openLot(ctx, productID, delta, balance-delta, cost, src, nil)
+	} else {
+		cost, err = s.draw(ctx, productID, -delta, src, cost)
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.record(ctx, productID, delta, balance, cost, src, 0); err != nil {
+		return err
+	}
+	return s.valueLots(ctx, productID)
+}
+
+func (s *sqlStock) Return(ctx context.Context, productID int, quantity float64, src, from Source) error {
+	movements, err := s.ledger(ctx, productID, from.Type)
+	if err != nil {
+		return err
+	}
+	cost, ok := takenAt(movements, productID, from)
+	if !ok {
+		level, _, err := s.StockLevel(ctx, productID)
+		if err != nil {
+			return err
+		}
+		cost = level.AverageCost
+	}
+	_, err = s.Receive(ctx, productID, quantity, cost, src, nil)
+	return err
+}
+
+// record appends a movement to the ledger; changes of cost alone are not
+// movements
+func (s *sqlStock) record(ctx context.Context, productID int, delta, balance float64, unitCost money.UnitCost, src Source, shortage float64) error {
+	if delta == 0 {
+		return nil
+	}
+	_, err := s.tx.ExecContext(ctx, `
+		INSERT INTO public."Stock_movements" (product_id, delta, balance, unit_cost, source_type, source_id, user_id, shortage)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), NULLIF($7, 0), $8)`,
+		productID, delta, balance, unitCost, src.Type, src.ID, src.UserID, shortage,
+	)
+	return err
+}
+
+func (s *sqlStock) Shortages(ctx context.Context, quantities map[int]float64) ([]Shortage, error) {
+	ids := sortedIDs(quantities)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	// Lock in id order so two takes never wait on each other crosswise
+	locked, err := s.tx.QueryContext(ctx,
+		"SELECT product_id FROM public.\"Warehouse\" WHERE product_id = ANY($1) ORDER BY product_id FOR UPDATE",
+		pq.Array(ids),
+	)
+	if err != nil {
+		return nil, err
+	}
+	locked.Close()
+
+	rows, err := s.tx.QueryContext(ctx, `
+		SELECT p.id, p.name, p.stock_policy, COALESCE(w.current_stock, 0)
+		FROM public."Products" p
+		LEFT JOIN public."Warehouse" w ON w.product_id = p.id
+		WHERE p.id = ANY($1)
+		ORDER BY p.id`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shortages []Shortage
+	for rows.Next() {
+		var short Shortage
+		if err := rows.Scan(&short.ProductID, &short.ProductName, &short.Policy, &short.Available); err != nil {
+			return nil, err
+		}
+		short.Required = quantities[short.ProductID]
+		if short.Available+tolerance < short.Required {
+			shortages = append(shortages, short)
+		}
+	}
+	return shortages, rows.Err()
+}
+
+func (s *sqlStock) Take(ctx context.Context, quantities map[int]float64, src Source) error {
+	shortages, err := s.Shortages(ctx, quantities)
+	if err != nil {
+		return err
+	}
+	if err := blocked(shortages); err != nil {
+		return err
+	}
+
+	marks := flagged(shortages)
+	for _, productID := range sortedIDs(quantities) {
+		// A product that was never received goes negative at no cost
+		var balance float64
+		var cost money.UnitCost
+		err := s.tx.QueryRowContext(ctx, `
+			INSERT INTO public."Warehouse" (product_id, current_stock, average_cost) VALUES ($1, -$2::double precision, 0)
+			ON CONFLICT (product_id) DO UPDATE SET current_stock = public."Warehouse".current_stock - $2
+			RETURNING current_stock, average_cost`,
+			productID, quantities[productID],
+		).Scan(&balance, &cost)
+		if err != nil {
+			return err
+		}
+		cost, err = s.draw(ctx, productID, quantities[productID], src, cost)
+		if err != nil {
+			return err
+		}
+		err = s.record(ctx, productID, -quantities[productID], balance, cost, src, marks[productID])
+		if err != nil {
+			return err
+		}
+		if err := s.valueLots(ctx, productID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStock) Cost(ctx context.Context, productID int, quantity float64) (money.Amount, error) {
+	level, _, err := s.StockLevel(ctx, productID)
+	if err != nil {
+		return money.Zero(), err
+	}
+	lots, err := s.lots(ctx, productID, Source{}, "")
+	if err != nil {
+		return money.Zero(), err
+	}
+	return drawCost(s.costing, lots, quantity, level.AverageCost), nil
+}
+
+// lots returns the lots of a product that still have stock, those of src
+// first, so that a document taking its own stock back takes it from its
+// own lot, and then soonest to expire first, oldest first
+func (s *sqlStock) lots(ctx context.Context, productID int, src Source, lock string) ([]Lot, error) {
+	rows, err := s.tx.QueryContext(ctx, `
+		SELECT id, product_id, source_type, source_id, quantity, remaining, unit_cost, received_at, expires_at
+		FROM public."Stock_lots"
+		WHERE product_id = $1 AND remaining > 0
+		ORDER BY (source_type = $2 AND source_id = $3) IS TRUE DESC, expires_at NULLS LAST, received_at, id `+lock,
+		productID, src.Type, src.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanLots(rows)
+}
+
+// draw takes quantity out of the product's lots and returns the unit cost
+// it leaves at, average being the product's average cost
+func (s *sqlStock) draw(ctx context.Context, productID int, quantity float64, src Source, average money.UnitCost) (money.UnitCost, error) {
+	lots, err := s.lots(ctx, productID, src, "FOR UPDATE")
+	if err != nil {
+		return average, err
+	}
+	cost, err := takeCost(s.costing, lots, quantity, average)
+	if err != nil {
+		return average, err
+	}
+	taken, _, _ := drawLots(lots, quantity)
+	for i, lot := range lots {
+		if taken[i] == 0 {
+			continue
+		}
+		_, err := s.tx.ExecContext(ctx,
+			"UPDATE public.\"Stock_lots\" SET remaining = GREATEST(remaining - $1, 0) WHERE id = $2",
+			taken[i], lot.ID,
+		)
+		if err != nil {
+			return average, err
+		}
+	}
+	return cost, nil
+}
+
+// openLot records stock coming in on top of before as a lot
+func (s *sqlStock) openLot(ctx context.Context, productID int, quantity, before float64, unitCost money.UnitCost, src Source, expiresAt *time.Time) error {
+	_, err := s.tx.ExecContext(ctx, `
+		INSERT INTO public."Stock_lots" (product_id, source_type, source_id, quantity, remaining, unit_cost, expires_at)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6, $7)`,
+		productID, src.Type, src.ID, quantity, lotRemaining(quantity, before), unitCost, expiresAt,
+	)
+	return err
+}
+
+func (s *sqlStock) Expiring(ctx context.Context, until time.Time) ([]Lot, error) {
+	return expiring(ctx, s.tx, until)
+}
+
+// valueLots sets the average cost of a product to what is left in its
+// lots under FIFO costing, where lots rather than the average decide
+func (s *sqlStock) valueLots(ctx context.Context, productID int) error {
+	if s.costing != CostingFIFO {
+		return nil
+	}
+	lots, err := s.lots(ctx, productID, Source{}, "")
+	if err != nil {
+		return err
+	}
+	cost, ok, err := lotValue(lots)
+	if err != nil || !ok {
+		return err
+	}
+	_, err = s.tx.ExecContext(ctx,
+		"UPDATE public.\"Warehouse\" SET average_cost = $1 WHERE product_id = $2",
+		cost, productID,
+	)
+	return err
+}
+
+func (s *sqlStock) Revalue(ctx context.Context, productID int, skip ...Source) error {
+	if s.costing == CostingFIFO {
+		return s.valueLots(ctx, productID)
+	}
+	movements, err := s.ledger(ctx, productID, "")
+	if err != nil {
+		return err
+	}
+	_, err = s.tx.ExecContext(ctx,
+		"UPDATE public.\"Warehouse\" SET average_cost = $1 WHERE product_id = $2",
+		replayCost(movements, skip), productID,
+	)
+	return err
+}
+
+// ledger reads the movements of a product, oldest first, those of a
+// source type only when sourceType is set
+func (s *sqlStock) ledger(ctx context.Context, productID int, sourceType SourceType) ([]Movement, error) {
+	rows, err := s.tx.QueryContext(ctx, `
+		SELECT delta, unit_cost, source_type, source_id
+		FROM public."Stock_movements"
+		WHERE product_id = $1 AND ($2::text = '' OR source_type = $2)
+		ORDER BY id`, productID, sourceType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var movements []Movement
+	for rows.Next() {
+		m := Movement{ProductID: productID}
+		if err := rows.Scan(&m.Delta, &m.UnitCost, &m.SourceType, &m.SourceID); err != nil {
+			return nil, err
+		}
+		movements = append(movements, m)
+	}
+	return movements, rows.Err()
+}
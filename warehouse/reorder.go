@@ -0,0 +1,165 @@
+package warehouse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// ReorderOptions is how suggestions are worked out. Usage is averaged over
+// the last Days; a product without a reorder point is reordered when it
+// has LeadDays of stock left and one without a maximum is bought up to
+// CoverDays of usage above its reorder point.
+type ReorderOptions struct {
+	Days      int
+	LeadDays  float64
+	CoverDays float64
+}
+
+// ParseReorderOptions reads ?days=, ?leadDays= and ?coverDays=, 14, 2 and
+// 7 unless given, and returns a message for the first that is invalid
+func ParseReorderOptions(query url.Values) (ReorderOptions, string) {
+	opts := ReorderOptions{Days: 14, LeadDays: 2, CoverDays: 7}
+	if v := query.Get("days"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days <= 0 {
+			return opts, "Invalid days"
+		}
+		opts.Days = days
+	}
+	if v := query.Get("leadDays"); v != "" {
+		days, err := strconv.ParseFloat(v, 64)
+		if err != nil || days < 0 {
+			return opts, "Invalid leadDays"
+		}
+		opts.LeadDays = days
+	}
+	if v := query.Get("coverDays"); v != "" {
+		days, err := strconv.ParseFloat(v, 64)
+		if err != nil || days < 0 {
+			return opts, "Invalid coverDays"
+		}
+		opts.CoverDays = days
+	}
+	return opts, ""
+}
+
+// SetReorder sets the reorder point and maximum stock of a product; null
+// leaves either to be worked out from usage
+func (s *Service) SetReorder(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	productID, err := strconv.Atoi(ps.ByName("productId"))
+	if err != nil {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	var reorder Reorder
+	err = json.NewDecoder(r.Body).Decode(&reorder)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if reorder.ReorderPoint != nil && *reorder.ReorderPoint < 0 {
+		http.Error(w, "Reorder point must not be negative", http.StatusBadRequest)
+		return
+	}
+	if reorder.MaxStock != nil && *reorder.MaxStock <= 0 {
+		http.Error(w, "Max stock must be positive", http.StatusBadRequest)
+		return
+	}
+	if reorder.ReorderPoint != nil && reorder.MaxStock != nil && *reorder.MaxStock <= *reorder.ReorderPoint {
+		http.Error(w, "Max stock must be above the reorder point", http.StatusBadRequest)
+		return
+	}
+
+	err = s.store.SetReorder(r.Context(), productID, reorder)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.GetWarehouse(w, r, ps)
+}
+
+// Suggest returns the products that have fallen to their reorder point and
+// how much of each to buy, by name. onOrder is what is already ordered of
+// each product, in the unit it is kept in.
+func (s *Service) Suggest(ctx context.Context, opts ReorderOptions, onOrder map[int]float64) ([]ReorderSuggestion, error) {
+	items, err := s.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	to := time.Now()
+	used, err := s.store.Consumption(ctx, to.AddDate(0, 0, -opts.Days), to)
+	if err != nil {
+		return nil, err
+	}
+	return suggest(items, used, onOrder, opts), nil
+}
+
+// suggest works out the reorder suggestions for the items given what was
+// used of each over opts.Days and what is already on order
+func suggest(items []WarehouseItem, used, onOrder map[int]float64, opts ReorderOptions) []ReorderSuggestion {
+	suggestions := []ReorderSuggestion{}
+	for _, item := range items {
+		usage := used[item.ProductID] / float64(opts.Days)
+
+		var point float64
+		switch {
+		case item.ReorderPoint != nil:
+			point = *item.ReorderPoint
+		case usage > 0:
+			point = usage * opts.LeadDays
+		default:
+			continue
+		}
+		target := point + usage*opts.CoverDays
+		if item.MaxStock != nil {
+			target = *item.MaxStock
+		}
+		// What is on its way is as good as in stock
+		expected := item.CurrentStock + onOrder[item.ProductID]
+		if expected > point+tolerance || target-expected <= tolerance {
+			continue
+		}
+
+		suggestion := ReorderSuggestion{
+			ProductID:    item.ProductID,
+			ProductName:  item.ProductName,
+			Unit:         item.Unit,
+			CurrentStock: item.CurrentStock,
+			OnOrder:      round(onOrder[item.ProductID]),
+			DailyUsage:   round(usage),
+			ReorderPoint: round(point),
+			MaxStock:     round(target),
+			Quantity:     round(target - expected),
+		}
+		if usage > 0 {
+			left := round(math.Max(item.CurrentStock, 0) / usage)
+			suggestion.DaysLeft = &left
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].ProductName != suggestions[j].ProductName {
+			return suggestions[i].ProductName < suggestions[j].ProductName
+		}
+		return suggestions[i].ProductID < suggestions[j].ProductID
+	})
+	return suggestions
+}
+
+// round keeps three decimals of a quantity worked out from usage
+func round(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
@@ -0,0 +1,273 @@
+package warehouse
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"randevu-shawarma-server/money"
+)
+
+var (
+	ErrNotFound        = errors.New("not found")
+	ErrUnknownCategory = errors.New("unknown category")
+	ErrUnitInUse       = errors.New("product's unit is in use")
+	// ErrInsufficientStock is what a *ShortageError unwraps to
+	ErrInsufficientStock = errors.New("insufficient stock")
+)
+
+// Store reads the warehouse and sorts its products into categories
+type Store interface {
+	// Costing returns how the store values stock leaving the warehouse
+	Costing() Costing
+	List(ctx context.Context) ([]WarehouseItem, error)
+	Categories(ctx context.Context) ([]Category, error)
+	// SaveCategory creates the category when its ID is zero and renames it
+	// otherwise
+	SaveCategory(ctx context.Context, category *Category) error
+	// DeleteCategory removes the category; its products become uncategorised
+	DeleteCategory(ctx context.Context, id int) error
+	// SetCategory moves a product into a category, or out of any when
+	// categoryID is nil
+	SetCategory(ctx context.Context, productID int, categoryID *int) error
+	// SetPolicy sets what happens when more of a product is taken than
+	// the warehouse holds
+	SetPolicy(ctx context.Context, productID int, policy Policy) error
+	// SetReorder sets when a product should be bought again
+	SetReorder(ctx context.Context, productID int, reorder Reorder) error
+	// Consumption returns how much of each product orders and write-offs
+	// took between from (inclusive) and to (exclusive), less what refunds
+	// brought back in that time
+	Consumption(ctx context.Context, from, to time.Time) (map[int]float64, error)
+	Units(ctx context.Context, productID int) (ProductUnits, error)
+	// SaveUnits replaces the units of a product. The unit its stock is kept
+	// in cannot change while it has stock, reorder settings or recipes
+	// using it, all of which are counted in that unit.
+	SaveUnits(ctx context.Context, units ProductUnits) error
+	// Factor returns how many of the product's own unit one unit is worth;
+	// an empty unit or the product's own is worth one. It reports false if
+	// the product has no such unit.
+	Factor(ctx context.Context, productID int, unit string) (float64, bool, error)
+	// Movements returns the ledger of a product between from (inclusive)
+	// and to (exclusive), oldest first
+	Movements(ctx context.Context, productID int, from, to time.Time) ([]Movement, error)
+	// Lots returns the lots of a product that still have stock in the order
+	// they are used up
+	Lots(ctx context.Context, productID int) ([]Lot, error)
+	// Expiring returns the lots of every product that still have stock and
+	// expire before until, soonest first
+	Expiring(ctx context.Context, until time.Time) ([]Lot, error)
+	// COGS returns what each product sold between from (inclusive) and to
+	// (exclusive) cost as it left the warehouse, net of refunds
+	COGS(ctx context.Context, from, to time.Time) ([]COGSLine, error)
+}
+
+var (
+	_ Store = (*PostgresStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// StockTx reads and writes stock levels inside the transaction of a
+// document that moves stock (supply, write-off, order)
+type StockTx interface {
+	// StockLevel returns the level of a product and whether it has a
+	// warehouse row, locking the row until the transaction ends
+	StockLevel(ctx context.Context, productID int) (Level, bool, error)
+	// Factor is Store.Factor inside the transaction
+	Factor(ctx context.Context, productID int, unit string) (float64, bool, error)
+	// Receive adds quantity of a product coming in at unitCost to its
+	// stock, merging it into the average cost under a lock on the
+	// product's row. It records the receipt in the ledger against src,
+	// opens a lot expiring at expiresAt, if set, and returns the new level.
+	Receive(ctx context.Context, productID int, quantity float64, unitCost money.UnitCost, src Source, expiresAt *time.Time) (Level, error)
+	// AddStock adds delta to the stock of a product that has a warehouse
+	// row and records it in the ledger against src, opening a lot at the
+	// average cost or taking from those used up first
+	AddStock(ctx context.Context, productID int, delta float64, src Source) error
+	// Return puts quantity of a product that the document from took back
+	// into stock against src, at the unit cost it left at, as Receive does
+	Return(ctx context.Context, productID int, quantity float64, src, from Source) error
+	// Shortages locks the levels of the products until the transaction
+	// ends and returns those that hold less than the quantity asked for
+	Shortages(ctx context.Context, quantities map[int]float64) ([]Shortage, error)
+	// Cost returns what taking the quantity of a product now would come to
+	// under the store's costing method
+	Cost(ctx context.Context, productID int, quantity float64) (money.Amount, error)
+	// Take removes the quantities from stock, first expired first out, as
+	// the products' policies allow and values them by the costing method. If any policy blocks it nothing is taken
+	// and the error is a *ShortageError listing every blocked product.
+	Take(ctx context.Context, quantities map[int]float64, src Source) error
+	// Expiring is Store.Expiring inside the transaction
+	Expiring(ctx context.Context, until time.Time) ([]Lot, error)
+	// Revalue sets the average cost of a product to what its ledger comes
+	// to without the movements of skip, as if those documents had never
+	// moved stock. What left in between keeps the cost it left at.
+	Revalue(ctx context.Context, productID int, skip ...Source) error
+}
+
+// tolerance keeps float noise in quantities from counting as a shortage
+const tolerance = 1e-9
+
+// blocked returns a *ShortageError for the shortages whose policy blocks
+// the take, or nil
+func blocked(shortages []Shortage) error {
+	var refused []Shortage
+	for _, s := range shortages {
+		if s.Policy == PolicyBlock {
+			refused = append(refused, s)
+		}
+	}
+	if len(refused) == 0 {
+		return nil
+	}
+	return &ShortageError{Shortages: refused}
+}
+
+// flagged returns how much of each product's take to mark as not
+// covered by stock
+func flagged(shortages []Shortage) map[int]float64 {
+	marks := map[int]float64{}
+	for _, s := range shortages {
+		if s.Policy == PolicyFlag {
+			marks[s.ProductID] = s.missing()
+		}
+	}
+	return marks
+}
+
+// sortedIDs returns the products of quantities in id order, the order
+// their rows are locked in
+func sortedIDs(quantities map[int]float64) []int {
+	ids := make([]int, 0, len(quantities))
+	for id, quantity := range quantities {
+		if quantity > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// replayCost works out the average cost a ledger ends at, oldest movement
+// first, leaving out those of skip. Supplies, production and refunds
+// coming in are averaged in the way they were booked; nothing else
+// changes the cost.
+func replayCost(movements []Movement, skip []Source) money.UnitCost {
+	var cost money.UnitCost
+	var stock float64
+	started := false
+	for _, m := range movements {
+		if skipped(m, skip) {
+			continue
+		}
+		if !started {
+			cost, started = m.UnitCost, true
+		}
+		if m.Delta > 0 && (m.SourceType == SourceSupply || m.SourceType == SourceProduction || m.SourceType == SourceRefund) {
+			cost = money.WeightedAverage(cost, stock, m.UnitCost, m.Delta)
+		}
+		stock += m.Delta
+	}
+	return cost
+}
+
+// skipped reports whether the movement was made by one of the documents
+func skipped(m Movement, skip []Source) bool {
+	if m.SourceID == nil {
+		return false
+	}
+	for _, src := range skip {
+		if m.SourceType == src.Type && *m.SourceID == src.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// drawLots takes quantity out of the lots in the order given. It returns
+// how much each lot gave, what that came to at the lots' costs and how
+// much of quantity no lot covered.
+func drawLots(lots []Lot, quantity float64) ([]float64, money.Amount, float64) {
+	taken := make([]float64, len(lots))
+	cost := money.Zero()
+	for i, lot := range lots {
+		if quantity <= tolerance {
+			break
+		}
+		take := lot.Remaining
+		if take > quantity {
+			take = quantity
+		}
+		taken[i] = take
+		cost = cost.Add(lot.UnitCost.Mul(take, money.HalfEven))
+		quantity -= take
+	}
+	if quantity < tolerance {
+		quantity = 0
+	}
+	return taken, cost, quantity
+}
+
+// drawCost is what taking quantity out of the lots, in order, comes to
+// under the costing method. What the lots do not cover is valued at the
+// average cost.
+func drawCost(method Costing, lots []Lot, quantity float64, average money.UnitCost) money.Amount {
+	if method != CostingFIFO {
+		return average.Mul(quantity, money.HalfEven)
+	}
+	_, cost, uncovered := drawLots(lots, quantity)
+	return cost.Add(average.Mul(uncovered, money.HalfEven))
+}
+
+// takeCost is drawCost per unit taken
+func takeCost(method Costing, lots []Lot, quantity float64, average money.UnitCost) (money.UnitCost, error) {
+	if method != CostingFIFO || quantity <= 0 {
+		return average, nil
+	}
+	return drawCost(method, lots, quantity, average).PerUnit(quantity)
+}
+
+// takenAt is the unit cost what the document from took of a product left
+// at on average, and false if it took none
+func takenAt(movements []Movement, productID int, from Source) (money.UnitCost, bool) {
+	total := money.ZeroCost()
+	var quantity float64
+	for _, m := range movements {
+		if m.ProductID == productID && m.Delta < 0 && skipped(m, []Source{from}) {
+			total = total.Add(m.UnitCost.Scale(-m.Delta))
+			quantity -= m.Delta
+		}
+	}
+	if quantity <= tolerance {
+		return total, false
+	}
+	return total.Scale(1 / quantity), true
+}
+
+// lotRemaining is what a lot of quantity keeps when it comes in on top of
+// stock at before; stock gone negative is covered first
+func lotRemaining(quantity, before float64) float64 {
+	if before < 0 {
+		quantity += before
+	}
+	if quantity < 0 {
+		return 0
+	}
+	return quantity
+}
+
+// lotValue is the average cost of what is left in the lots, and false if
+// nothing is
+func lotValue(lots []Lot) (money.UnitCost, bool, error) {
+	value := money.ZeroCost()
+	var quantity float64
+	for _, lot := range lots {
+		value = value.Add(lot.UnitCost.Scale(lot.Remaining))
+		quantity += lot.Remaining
+	}
+	if quantity <= tolerance {
+		return value, false, nil
+	}
+	return value.Scale(1 / quantity), true, nil
+}
@@ -0,0 +1,114 @@
+package warehouse
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// GetUnits returns the unit a product is kept in and the units it can
+// be bought or used in
+func (s *Service) GetUnits(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	productID, err := strconv.Atoi(ps.ByName("productId"))
+	if err != nil {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	units, err := s.store.Units(r.Context(), productID)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(units)
+}
+
+// SaveUnits replaces the units of a product, e.g. kept in "g" and bought
+// in a "box" of 5000
+func (s *Service) SaveUnits(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	productID, err := strconv.Atoi(ps.ByName("productId"))
+	if err != nil {
+		http.Error(w, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	var units ProductUnits
+	err = json.NewDecoder(r.Body).Decode(&units)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	units.ProductID = productID
+	if msg := normalizeUnits(&units); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	err = s.store.SaveUnits(r.Context(), units)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.NotFound(w, r)
+		return
+	case errors.Is(err, ErrUnitInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.GetUnits(w, r, ps)
+}
+
+// normalizeUnits checks the units from a request and returns what is
+// wrong with them, if anything
+func normalizeUnits(units *ProductUnits) string {
+	units.Unit = strings.TrimSpace(units.Unit)
+	if units.Unit == "" {
+		return "Unit is required"
+	}
+
+	seen := map[string]bool{units.Unit: true}
+	for i := range units.Units {
+		u := &units.Units[i]
+		u.Name = strings.TrimSpace(u.Name)
+		if u.Name == "" {
+			return "Every unit needs a name"
+		}
+		if seen[u.Name] {
+			return "Unit listed twice"
+		}
+		seen[u.Name] = true
+		if u.Factor <= 0 {
+			return "Unit factors must be positive"
+		}
+	}
+	if units.Units == nil {
+		units.Units = []Unit{}
+	}
+	return ""
+}
+
+// unitInUse says why the unit a product is kept in cannot change, if it
+// cannot. Stock, reorder settings and recipe quantities are all counted
+// in it and would silently take on the new unit.
+func unitInUse(stock float64, reorder, inRecipe bool) error {
+	switch {
+	case stock != 0:
+		return fmt.Errorf("%w: the product has %v in stock", ErrUnitInUse, stock)
+	case reorder:
+		return fmt.Errorf("%w: clear its reorder point and max stock first", ErrUnitInUse)
+	case inRecipe:
+		return fmt.Errorf("%w: take it out of the recipes using it first", ErrUnitInUse)
+	}
+	return nil
+}
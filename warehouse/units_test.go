@@ -0,0 +1,59 @@
+package warehouse
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"randevu-shawarma-server/dishes"
+)
+
+func TestSaveUnitsKeepsUnitUsedInRecipe(t *testing.T) {
+	ctx := context.Background()
+	stock := NewMemoryStore(CostingAverage)
+	stock.AddProduct(1, "chicken")
+	if err := stock.SaveUnits(ctx, ProductUnits{ProductID: 1, Unit: "kg", Units: []Unit{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	menu := dishes.NewMemoryStore()
+	menu.AddProduct(1)
+	stock.SetRecipeLookup(menu.UsesProduct)
+	dish := dishes.DishItem{Name: "shawarma", Recipe: []dishes.RecipeLine{{ProductID: 1, Quantity: 0.12}}}
+	if err := menu.SaveDish(ctx, &dish); err != nil {
+		t.Fatal(err)
+	}
+
+	err := stock.SaveUnits(ctx, ProductUnits{ProductID: 1, Unit: "g", Units: []Unit{}})
+	if !errors.Is(err, ErrUnitInUse) {
+		t.Fatalf("got %v, want %v", err, ErrUnitInUse)
+	}
+	units, err := stock.Units(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if units.Unit != "kg" {
+		t.Errorf("unit %s, want kg", units.Unit)
+	}
+
+	// Purchase units can still change, as recipes are not counted in them
+	err = stock.SaveUnits(ctx, ProductUnits{ProductID: 1, Unit: "kg", Units: []Unit{{Name: "box", Factor: 5}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaveUnitsKeepsUnitOfReorderSettings(t *testing.T) {
+	ctx := context.Background()
+	stock := NewMemoryStore(CostingAverage)
+	stock.AddProduct(1, "lavash")
+	point := 20.0
+	if err := stock.SetReorder(ctx, 1, Reorder{ReorderPoint: &point}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := stock.SaveUnits(ctx, ProductUnits{ProductID: 1, Unit: "pack", Units: []Unit{}})
+	if !errors.Is(err, ErrUnitInUse) {
+		t.Fatalf("got %v, want %v", err, ErrUnitInUse)
+	}
+}
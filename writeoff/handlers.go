@@ -1,9 +1,14 @@
 package writeoff
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"randevu-shawarma-server/users"
@@ -12,19 +17,23 @@ import (
 	"github.com/julienschmidt/httprouter"
 )
 
-var db *sql.DB
+type Service struct {
+	store     Store
+	auth      *users.Service
+	warehouse *warehouse.Service
+}
 
-// SetDatabase sets the database connection
-func SetDatabase(database *sql.DB) {
-	db = database
+func NewService(store Store, auth *users.Service, warehouse *warehouse.Service) *Service {
+	return &Service{store: store, auth: auth, warehouse: warehouse}
 }
 
 // RegisterRoutes registers all write-off routes
-func RegisterRoutes(router *httprouter.Router) {
-	router.POST("/write-off", users.Authenticate(CreateWriteOff))
+func (s *Service) RegisterRoutes(router *httprouter.Router) {
+	router.POST("/write-off", s.auth.Authorize(users.PermWriteOffCreate)(s.CreateWriteOff))
+	router.POST("/write-off/expired", s.auth.Authorize(users.PermWriteOffCreate)(s.WriteOffExpired))
 }
 
-func CreateWriteOff(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (s *Service) CreateWriteOff(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	var newWriteOff WriteOff
 	err := json.NewDecoder(r.Body).Decode(&newWriteOff)
 	if err != nil {
@@ -32,73 +41,147 @@ func CreateWriteOff(w http.ResponseWriter, r *http.Request, ps httprouter.Params
 		return
 	}
 
-	tx, err := db.Begin()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	err = s.create(r.Context(), &newWriteOff)
+	if warehouse.WriteShortageError(w, err) {
 		return
-	}
-	defer tx.Rollback()
-
-	// Insert new write off
-	err = tx.QueryRow(
-		"INSERT INTO public.\"Write_off\" (user_id, created_at, notes) VALUES ($1, $2, $3) RETURNING id",
-		newWriteOff.UserID, time.Now(), newWriteOff.Notes,
-	).Scan(&newWriteOff.ID)
-	if err != nil {
+	} else if errors.Is(err, ErrUnknownUnit) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Insert write off products and update warehouse
-	for _, product := range newWriteOff.Products {
-		// Insert into write_off_product_relations
-		_, err = tx.Exec(
-			"INSERT INTO public.\"Write_off_product_relations\" (write_off_id, product_id, quantity) VALUES ($1, $2, $3)",
-			newWriteOff.ID, product.ProductID, product.Quantity,
-		)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	s.warehouse.GetWarehouse(w, r, ps)
+}
 
-		// Update warehouse
-		var currentStock sql.NullFloat64
+// create stores the write-off and removes its products from the warehouse
+// as their stock policies allow
+func (s *Service) create(ctx context.Context, newWriteOff *WriteOff) error {
+	newWriteOff.CreatedAt = time.Now()
 
-		err = tx.QueryRow(
-			"SELECT current_stock FROM public.\"Warehouse\" WHERE product_id = $1",
-			product.ProductID,
-		).Scan(&currentStock)
+	return s.store.InTx(ctx, func(tx Tx) error {
+		// Insert new write off
+		err := tx.InsertWriteOff(ctx, newWriteOff)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return err
 		}
 
-		if currentStock.Valid {
-			newStock := currentStock.Float64 - product.Quantity
-			if newStock < 0 {
-				http.Error(w, "Insufficient stock", http.StatusBadRequest)
-				return
+		// Insert write off products and update warehouse
+		quantities := map[int]float64{}
+		for _, product := range newWriteOff.Products {
+			factor, ok, err := tx.Factor(ctx, product.ProductID, product.Unit)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("%w %q for product %d", ErrUnknownUnit, product.Unit, product.ProductID)
 			}
+			product.Factor = factor
 
-			_, err = tx.Exec(
-				"UPDATE public.\"Warehouse\" SET current_stock = $1 WHERE product_id = $2",
-				newStock, product.ProductID,
-			)
+			err = tx.InsertLine(ctx, newWriteOff.ID, product)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
+				return err
 			}
-		} else {
-			http.Error(w, "Product not found in warehouse", http.StatusBadRequest)
-			return
+			quantities[product.ProductID] += product.baseQuantity()
 		}
+		return tx.Take(ctx, quantities, warehouse.Source{
+			Type:   warehouse.SourceWriteOff,
+			ID:     newWriteOff.ID,
+			UserID: newWriteOff.UserID,
+		})
+	})
+}
+
+// WriteOffExpired writes off everything left of the lots past their expiry
+// date as one write-off, noted as expired unless the body gives notes
+func (s *Service) WriteOffExpired(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var body struct {
+		Notes string `json:"notes"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	err = tx.Commit()
-	if err != nil {
+	claims, _ := users.ClaimsFromContext(r.Context())
+	newWriteOff := WriteOff{UserID: claims.UserID, Notes: strings.TrimSpace(body.Notes)}
+	if newWriteOff.Notes == "" {
+		newWriteOff.Notes = "Expired"
+	}
+
+	err = s.writeOffExpired(r.Context(), &newWriteOff)
+	if warehouse.WriteShortageError(w, err) {
+		return
+	} else if errors.Is(err, ErrNothingExpired) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	warehouse.GetWarehouse(w, r, ps)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newWriteOff)
+}
+
+// writeOffExpired books a write-off of what is left of every expired lot.
+// Lots are used up first expired first out, so taking that much of each
+// product takes exactly the expired lots.
+func (s *Service) writeOffExpired(ctx context.Context, newWriteOff *WriteOff) error {
+	newWriteOff.CreatedAt = time.Now()
+
+	return s.store.InTx(ctx, func(tx Tx) error {
+		quantities, err := expired(ctx, tx, newWriteOff.CreatedAt)
+		if err != nil {
+			return err
+		}
+		// Hold the products so that no sale takes from their lots before
+		// they are read again and written off
+		if _, err := tx.Shortages(ctx, quantities); err != nil {
+			return err
+		}
+		if quantities, err = expired(ctx, tx, newWriteOff.CreatedAt); err != nil {
+			return err
+		}
+
+		if err := tx.InsertWriteOff(ctx, newWriteOff); err != nil {
+			return err
+		}
+		ids := make([]int, 0, len(quantities))
+		for productID := range quantities {
+			ids = append(ids, productID)
+		}
+		sort.Ints(ids)
+		for _, productID := range ids {
+			line := WriteOffProductRelation{WriteOffID: newWriteOff.ID, ProductID: productID, Quantity: quantities[productID]}
+			if err := tx.InsertLine(ctx, newWriteOff.ID, line); err != nil {
+				return err
+			}
+			newWriteOff.Products = append(newWriteOff.Products, line)
+		}
+		return tx.Take(ctx, quantities, warehouse.Source{
+			Type:   warehouse.SourceWriteOff,
+			ID:     newWriteOff.ID,
+			UserID: newWriteOff.UserID,
+		})
+	})
+}
+
+// expired sums what is left of the lots past their expiry at by product
+func expired(ctx context.Context, tx Tx, at time.Time) (map[int]float64, error) {
+	lots, err := tx.Expiring(ctx, at)
+	if err != nil {
+		return nil, err
+	}
+	if len(lots) == 0 {
+		return nil, ErrNothingExpired
+	}
+	quantities := map[int]float64{}
+	for _, lot := range lots {
+		quantities[lot.ProductID] += lot.Remaining
+	}
+	return quantities, nil
 }
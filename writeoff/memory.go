@@ -0,0 +1,59 @@
+package writeoff
+
+import (
+	"context"
+	"sync"
+
+	"randevu-shawarma-server/warehouse"
+)
+
+// MemoryStore keeps write-offs in memory and moves stock in the shared
+// warehouse.MemoryStore
+type MemoryStore struct {
+	mu        sync.Mutex
+	stock     *warehouse.MemoryStore
+	writeOffs []WriteOff
+}
+
+func NewMemoryStore(stock *warehouse.MemoryStore) *MemoryStore {
+	return &MemoryStore{stock: stock}
+}
+
+// WriteOffs returns a copy of every committed write-off
+func (m *MemoryStore) WriteOffs() []WriteOff {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]WriteOff(nil), m.writeOffs...)
+}
+
+func (m *MemoryStore) InTx(ctx context.Context, fn func(Tx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.stock.Tx(func(stock warehouse.StockTx) error {
+		tx := &memoryTx{StockTx: stock, writeOffs: append([]WriteOff(nil), m.writeOffs...)}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		m.writeOffs = tx.writeOffs
+		return nil
+	})
+}
+
+type memoryTx struct {
+	warehouse.StockTx
+	writeOffs []WriteOff
+}
+
+func (t *memoryTx) InsertWriteOff(ctx context.Context, wo *WriteOff) error {
+	wo.ID = len(t.writeOffs) + 1
+	t.writeOffs = append(t.writeOffs, WriteOff{ID: wo.ID, UserID: wo.UserID, CreatedAt: wo.CreatedAt, Notes: wo.Notes})
+	return nil
+}
+
+func (t *memoryTx) InsertLine(ctx context.Context, writeOffID int, line WriteOffProductRelation) error {
+	line.WriteOffID = writeOffID
+	wo := &t.writeOffs[writeOffID-1]
+	wo.Products = append(wo.Products, line)
+	return nil
+}
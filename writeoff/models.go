@@ -12,8 +12,21 @@ type WriteOff struct {
 	Products  []WriteOffProductRelation `json:"products"`
 }
 
+// WriteOffProductRelation is a line of a write-off. Quantity is in Unit
+// when it is set and in the unit the product is kept in otherwise.
 type WriteOffProductRelation struct {
 	WriteOffID int     `json:"writeOffId"`
 	ProductID  int     `json:"productId"`
 	Quantity   float64 `json:"quantity"`
+	Unit       string  `json:"unit,omitempty"`
+	// Factor converts Unit into the unit the product is kept in
+	Factor float64 `json:"-"`
+}
+
+// baseQuantity is the quantity in the unit the product is kept in
+func (l WriteOffProductRelation) baseQuantity() float64 {
+	if l.Factor == 0 {
+		return l.Quantity
+	}
+	return l.Quantity * l.Factor
 }
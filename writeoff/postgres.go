@@ -0,0 +1,56 @@
+package writeoff
+
+import (
+	"context"
+	"database/sql"
+
+	"randevu-shawarma-server/warehouse"
+)
+
+type PostgresStore struct {
+	db    *sql.DB
+	stock *warehouse.PostgresStore
+}
+
+func NewPostgresStore(db *sql.DB, stock *warehouse.PostgresStore) *PostgresStore {
+	return &PostgresStore{db: db, stock: stock}
+}
+
+func (s *PostgresStore) InTx(ctx context.Context, fn func(Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&postgresTx{StockTx: s.stock.Stock(tx), tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type postgresTx struct {
+	warehouse.StockTx
+	tx *sql.Tx
+}
+
+func (t *postgresTx) InsertWriteOff(ctx context.Context, wo *WriteOff) error {
+	return t.tx.QueryRowContext(ctx,
+		"INSERT INTO public.\"Write_off\" (user_id, created_at, notes) VALUES ($1, $2, $3) RETURNING id",
+		wo.UserID, wo.CreatedAt, wo.Notes,
+	).Scan(&wo.ID)
+}
+
+// InsertLine stores the line in the unit the product is kept in, and as
+// entered when it was given in another unit
+func (t *postgresTx) InsertLine(ctx context.Context, writeOffID int, line WriteOffProductRelation) error {
+	var unitQuantity interface{}
+	if line.Unit != "" {
+		unitQuantity = line.Quantity
+	}
+	_, err := t.tx.ExecContext(ctx,
+		"INSERT INTO public.\"Write_off_product_relations\" (write_off_id, product_id, quantity, unit, unit_quantity) VALUES ($1, $2, $3, $4, $5)",
+		writeOffID, line.ProductID, line.baseQuantity(), line.Unit, unitQuantity,
+	)
+	return err
+}
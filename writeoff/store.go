@@ -0,0 +1,32 @@
+package writeoff
+
+import (
+	"context"
+	"errors"
+
+	"randevu-shawarma-server/warehouse"
+)
+
+var (
+	ErrUnknownUnit = errors.New("unknown unit")
+	// ErrNothingExpired is returned when no lot has stock past its expiry
+	ErrNothingExpired = errors.New("nothing has expired")
+)
+
+// Store persists write-offs
+type Store interface {
+	// InTx runs fn in one transaction, rolled back if fn returns an error
+	InTx(ctx context.Context, fn func(Tx) error) error
+}
+
+var (
+	_ Store = (*PostgresStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// Tx is the part of a transaction a write-off needs
+type Tx interface {
+	warehouse.StockTx
+	InsertWriteOff(ctx context.Context, wo *WriteOff) error
+	InsertLine(ctx context.Context, writeOffID int, line WriteOffProductRelation) error
+}
@@ -0,0 +1,98 @@
+package writeoff
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"randevu-shawarma-server/money"
+	"randevu-shawarma-server/warehouse"
+)
+
+// stocked returns a warehouse with 10 of product 1 at 2.50
+func stocked(t *testing.T) *warehouse.MemoryStore {
+	t.Helper()
+	stock := warehouse.NewMemoryStore(warehouse.CostingAverage)
+	stock.AddProduct(1, "chicken")
+	err := stock.Tx(func(tx warehouse.StockTx) error {
+		_, err := tx.Receive(context.Background(), 1, 10, money.MustParseUnitCost("2.50"),
+			warehouse.Source{Type: warehouse.SourceSupply, ID: 1, UserID: 1}, nil)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stock
+}
+
+func currentStock(t *testing.T, stock *warehouse.MemoryStore, productID int) float64 {
+	t.Helper()
+	items, err := stock.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		if item.ProductID == productID {
+			return item.CurrentStock
+		}
+	}
+	t.Fatalf("product %d is not in the warehouse", productID)
+	return 0
+}
+
+func TestCreateTakesStock(t *testing.T) {
+	ctx := context.Background()
+	stock := stocked(t)
+	store := NewMemoryStore(stock)
+	s := NewService(store, nil, nil)
+
+	wo := WriteOff{UserID: 1, Notes: "dropped", Products: []WriteOffProductRelation{{ProductID: 1, Quantity: 4}}}
+	if err := s.create(ctx, &wo); err != nil {
+		t.Fatal(err)
+	}
+	if got := currentStock(t, stock, 1); got != 6 {
+		t.Errorf("stock %v, want 6", got)
+	}
+	if got := len(store.WriteOffs()); got != 1 {
+		t.Fatalf("%d write-offs stored, want 1", got)
+	}
+
+	movements, err := stock.Movements(ctx, 1, wo.CreatedAt.AddDate(0, 0, -1), wo.CreatedAt.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var taken *warehouse.Movement
+	for i := range movements {
+		if movements[i].SourceType == warehouse.SourceWriteOff {
+			taken = &movements[i]
+		}
+	}
+	if taken == nil {
+		t.Fatal("no write-off movement recorded")
+	}
+	if taken.Delta != -4 || taken.UnitCost.String() != "2.50" {
+		t.Errorf("movement of %v at %s, want -4 at 2.50", taken.Delta, taken.UnitCost)
+	}
+}
+
+func TestCreateBlockedRollsBack(t *testing.T) {
+	ctx := context.Background()
+	stock := stocked(t)
+	if err := stock.SetPolicy(ctx, 1, warehouse.PolicyBlock); err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStore(stock)
+	s := NewService(store, nil, nil)
+
+	wo := WriteOff{UserID: 1, Products: []WriteOffProductRelation{{ProductID: 1, Quantity: 11}}}
+	err := s.create(ctx, &wo)
+	if !errors.Is(err, warehouse.ErrInsufficientStock) {
+		t.Fatalf("got %v, want %v", err, warehouse.ErrInsufficientStock)
+	}
+	if got := currentStock(t, stock, 1); got != 10 {
+		t.Errorf("stock %v, want 10", got)
+	}
+	if got := len(store.WriteOffs()); got != 0 {
+		t.Errorf("%d write-offs stored, want none", got)
+	}
+}